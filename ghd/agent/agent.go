@@ -4,8 +4,10 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -13,8 +15,11 @@ import (
 
 	"github.com/oklog/ulid/v2"
 
+	"github.com/biztos/greenhead/ghd/policy"
 	"github.com/biztos/greenhead/ghd/registry"
 	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/schema"
+	"github.com/biztos/greenhead/ghd/tools"
 	"github.com/biztos/greenhead/ghd/utils"
 )
 
@@ -28,6 +33,8 @@ var ErrMaxCompletions = fmt.Errorf("%w: max completions reached", ErrStopped)
 
 var ErrMatchStopped = fmt.Errorf("%w: content match", ErrStopped)
 
+var ErrMaxTokens = fmt.Errorf("%w: max tokens reached", ErrStopped)
+
 // Config describes the configuration of an Agent, and is usually supplied in
 // a file.
 //
@@ -43,14 +50,36 @@ type Config struct {
 
 	Context []ContextItem `toml:"context"` // Context window for client.
 
+	// Templates:  (Go text/template format; see BuiltinVars.)
+	PromptTemplate string            `toml:"prompt_template"` // Template for prompts, which are in the "prompt" var.
+	Vars           map[string]string `toml:"vars"`            // Variables for prompt and context templates.
+
+	// Structured output:  (Responses must be JSON satisfying the schema.)
+	ResponseSchema     string `toml:"response_schema"`      // JSON Schema for final responses.
+	ResponseSchemaFile string `toml:"response_schema_file"` // File containing the JSON Schema, instead of ResponseSchema.
+	ResponseRepairs    int    `toml:"response_repairs"`     // Max requests to repair invalid responses.
+
 	// Safety and limits:  (Zero generally means "no limit.")
 	MaxCompletionTokens int          `toml:"max_completion_tokens"` // Max completion tokens *per completion* (may truncate responses).
 	MaxCompletions      int          `toml:"max_completions"`       // Max number of completions to run.
 	MaxTokens           int          `toml:"max_tokens"`            // Max number of total tokens for all operations.
 	MaxToolChain        int          `toml:"max_toolchain"`         // Max number of tool call responses allowed in a row.
+	MaxToolResultBytes  int          `toml:"max_tool_result_bytes"` // Truncate tool result data (or errors) as JSON beyond this.
 	AbortOnRefusal      bool         `toml:"abort_on_refusal"`      // Abort if a completion is refused by an LLM.
 	StopMatches         []*rgxp.Rgxp `toml:"stop_matches"`          // Abort if any content matches any regexp set here.
 
+	// Approval:  (By name, regexp or metadata selector, e.g. "risk>=write".)
+	RequireApproval []*rgxp.OptionalRgxp `toml:"require_approval"` // Tools whose calls must be approved first.
+
+	// Policy:  (Rules on the args of tool calls; see the policy package.)
+	Policy []*policy.Rule `toml:"policy"` // Rules that tool calls must satisfy before they are run.
+
+	// Runtime tool changes:  (Requires the ghd_tool_factories build tag.)
+	ToolChanges bool `toml:"tool_changes"` // Allow tools to register and remove tools for this agent.
+
+	// Context from tools:  (Run with each prompt; see ContextToolInput.)
+	ContextTools []string `toml:"context_tools"` // Read-only tools whose output is added to prompts, e.g. memory_context.
+
 	// Output control:
 	Color     string `toml:"color"`      // Color for console output.
 	BgColor   string `toml:"bg_color"`   // Background color for console output.
@@ -77,15 +106,25 @@ func (c *Config) Copy() *Config {
 	copy(n.Context, c.Context)
 	n.StopMatches = make([]*rgxp.Rgxp, len(c.StopMatches))
 	copy(n.StopMatches, c.StopMatches)
+	n.RequireApproval = make([]*rgxp.OptionalRgxp, len(c.RequireApproval))
+	copy(n.RequireApproval, c.RequireApproval)
+	n.Policy = make([]*policy.Rule, len(c.Policy))
+	copy(n.Policy, c.Policy)
+	n.ContextTools = slices.Clone(c.ContextTools)
+	n.Vars = maps.Clone(c.Vars)
 	return &n
 }
 
 // ContextItem is a high-level representation of a message to add to the
 // context window.  Note that it does *not* at this point include ToolCall or
 // ToolResult.
+//
+// If Template is true, Content is rendered as a template when the Agent is
+// created.
 type ContextItem struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	Template bool   `json:"template,omitempty"`
 }
 
 // ToolCall is a high-level representation of a tool call from the LLM.
@@ -95,7 +134,8 @@ type ToolCall struct {
 	Args string `json:"args"`
 }
 
-// ToolResult holds the result of a tool call.
+// ToolResult holds the result of a tool call.  The Agent always sets Output
+// to a *tools.Result, which clients should send as JSON.
 type ToolResult struct {
 	Id     string `json:"id"`
 	Output any    `json:"output"`
@@ -113,9 +153,13 @@ type RawCompletion struct {
 // CompletionRequest is a high-level representation of a message to the LLM
 // from the "user."  If ToolResults are included, it is normal for Content to
 // be empty.
+//
+// Vars apply to the Agent's PromptTemplate, if any, and are otherwise
+// ignored.
 type CompletionRequest struct {
-	Content     string        `json:"content"`
-	ToolResults []*ToolResult `json:"tool_results"`
+	Content     string            `json:"content"`
+	ToolResults []*ToolResult     `json:"tool_results"`
+	Vars        map[string]string `json:"vars,omitempty"`
 }
 
 // CompletionResponse is a high-level representation of a single-choice
@@ -131,6 +175,7 @@ type CompletionResponse struct {
 	ToolCalls      []*ToolCall      `json:"tool_calls"`
 	Usage          *Usage           `json:"usage"`
 	RawCompletions []*RawCompletion `json:"raw_completions"`
+	Data           any              `json:"data,omitempty"` // Parsed Content, if there is a ResponseSchema.
 }
 
 // Usage is a high-level representation of token usage.  Note that the meaning
@@ -149,6 +194,30 @@ type Usage struct {
 	Total       int `json:"total"` // nb: Total is just whatever was reported as total.
 }
 
+// Add adds the values of o to u.  A nil o is ignored.
+func (u *Usage) Add(o *Usage) {
+	if o == nil {
+		return
+	}
+	u.Input += o.Input
+	u.CachedInput += o.CachedInput
+	u.Output += o.Output
+	u.Reasoning += o.Reasoning
+	u.Total += o.Total
+}
+
+// Sub returns the difference of u and o, i.e. the usage incurred since o was
+// taken from the same source.
+func (u Usage) Sub(o Usage) Usage {
+	return Usage{
+		Input:       u.Input - o.Input,
+		CachedInput: u.CachedInput - o.CachedInput,
+		Output:      u.Output - o.Output,
+		Reasoning:   u.Reasoning - o.Reasoning,
+		Total:       u.Total - o.Total,
+	}
+}
+
 var newApiClientFunc = map[string]func() (ApiClient, error){}
 
 // RegisterNewApiClientFunc registers an agent type with a function returning
@@ -168,20 +237,29 @@ type Agent struct {
 	Type        string    `json:"type"`
 	Model       string    `json:"model"`
 
-	client    ApiClient
-	toolnames []string
-	mutex     *sync.Mutex
-
-	completed int
-	config    *Config
-	printFunc func(a ...any)
-	logger    *slog.Logger
-	dumpdir   string
+	client        ApiClient
+	registry      *registry.Registry
+	toolnames     []string
+	approvalnames []string
+	approver      Approver
+	policy        *policy.Policy
+	schema        *schema.Schema
+	mutex         *sync.Mutex
+	subAgents     *persistentAgents
+
+	completed  int
+	usage      Usage
+	usageMutex *sync.Mutex
+	config     *Config
+	printFunc  func(a ...any)
+	logger     *slog.Logger
+	dumpdir    string
 }
 
 var ErrSpawnFailed = fmt.Errorf("spawn failed for agent")
 
-// Spawn returns a new Agent created from the config that created a.
+// Spawn returns a new Agent created from the config that created a, with
+// the same Approver.
 func (a *Agent) Spawn() (*Agent, error) {
 	// NOTE: because we do not control the underlying ApiClient, it is
 	// possible to get an error here even though it should be ~~ impossible
@@ -189,13 +267,30 @@ func (a *Agent) Spawn() (*Agent, error) {
 	//
 	// TODO: make sure this takes the same logger with it as the original,
 	// after logging is at runner level.
-	spawn, err := NewAgent(a.config)
+	spawn, err := NewAgentWithRegistry(a.config, a.registry)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s: %w", ErrSpawnFailed, a.Name, ErrSpawnFailed)
+		return nil, fmt.Errorf("%w: %s: %w", ErrSpawnFailed, a.Name, err)
 	}
+	spawn.approver = a.approver
 	return spawn, err
 }
 
+// SpawnWithVars is Spawn but with vars overriding the configured Vars, for
+// instance to render context templates for a particular user.
+func (a *Agent) SpawnWithVars(vars map[string]string) (*Agent, error) {
+	cfg := a.config.Copy()
+	if cfg.Vars == nil {
+		cfg.Vars = map[string]string{}
+	}
+	maps.Copy(cfg.Vars, vars)
+	spawn, err := NewAgentWithRegistry(cfg, a.registry)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrSpawnFailed, a.Name, err)
+	}
+	spawn.approver = a.approver
+	return spawn, nil
+}
+
 // SpawnSilent calls Spawn and sets the new Agent to print nothing.
 func (a *Agent) SpawnSilent() (*Agent, error) {
 	spawn, err := a.Spawn()
@@ -261,17 +356,90 @@ func (a *Agent) Check(ctx context.Context) error {
 	return a.client.Check(ctx)
 }
 
+// Usage returns the total usage of the Agent over its lifetime, including
+// any usage added by sub-agents.
+func (a *Agent) Usage() Usage {
+	a.usageMutex.Lock()
+	defer a.usageMutex.Unlock()
+	return a.usage
+}
+
+// Completed returns the number of completions run by the Agent, including
+// any added by sub-agents.
+func (a *Agent) Completed() int {
+	a.usageMutex.Lock()
+	defer a.usageMutex.Unlock()
+	return a.completed
+}
+
+// AddUsage adds usage and completions to the Agent's totals, so that they
+// count toward its configured limits.
+//
+// This is normally called for sub-agents run as tools, in which case the
+// caller pays for the work of its delegates.
+func (a *Agent) AddUsage(u *Usage, completions int) {
+	a.usageMutex.Lock()
+	defer a.usageMutex.Unlock()
+	a.usage.Add(u)
+	a.completed += completions
+}
+
+// CheckLimits returns an ErrStopped error if the Agent has reached its
+// MaxCompletions or MaxTokens limit.
+func (a *Agent) CheckLimits() error {
+	if err := a.checkCompletions(); err != nil {
+		return err
+	}
+	return a.checkTokens()
+}
+
+func (a *Agent) checkCompletions() error {
+	a.usageMutex.Lock()
+	defer a.usageMutex.Unlock()
+	if a.config.MaxCompletions > 0 && a.completed >= a.config.MaxCompletions {
+		return fmt.Errorf("%w: %d", ErrMaxCompletions, a.completed)
+	}
+	return nil
+}
+
+func (a *Agent) checkTokens() error {
+	a.usageMutex.Lock()
+	defer a.usageMutex.Unlock()
+	if a.config.MaxTokens > 0 && a.usage.Total >= a.config.MaxTokens {
+		return fmt.Errorf("%w: %d", ErrMaxTokens, a.usage.Total)
+	}
+	return nil
+}
+
 // AddContextItem calls the ApiClient's AddContextItem.
 func (a *Agent) AddContextItem(item ContextItem) {
 	a.client.AddContextItem(item)
 }
 
-// NewAgent returns an agent initialized for use based on cfg.  If any of the
-// configured Tools are not registered, an error is returned.
+// NewAgent returns an agent initialized for use based on cfg, with tools from
+// the default registry.  If any of the configured Tools are not registered,
+// an error is returned.
 //
 // TODO: consider the possibility of runtime tool registrations, in which case
 // what do we do to keep the agent up to date?
 func NewAgent(cfg *Config) (*Agent, error) {
+	return NewAgentWithRegistry(cfg, registry.Default)
+}
+
+// NewAgentWithRegistry is NewAgent with tools from reg.  The agent, its
+// ApiClient (if a RegistryClient) and any agents spawned from it all use
+// reg, which can be a Snapshot to keep the tools from changing under it.
+//
+// If cfg allows ToolChanges, the agent instead uses a Fork of reg, so that
+// tools registered by its tools do not affect other agents.
+func NewAgentWithRegistry(cfg *Config, reg *registry.Registry) (*Agent, error) {
+
+	if cfg.ToolChanges {
+		if !ToolFactoriesEnabled() {
+			return nil, fmt.Errorf("tool_changes: %w", ErrToolFactoriesDisabled)
+		}
+		reg = reg.Fork()
+	}
 
 	// Start with basics:
 	a := &Agent{
@@ -280,8 +448,11 @@ func NewAgent(cfg *Config) (*Agent, error) {
 		Description: cfg.Description,
 		Type:        cfg.Type,
 		Model:       cfg.Model,
+		registry:    reg,
 		config:      cfg.Copy(),
 		mutex:       &sync.Mutex{},
+		usageMutex:  &sync.Mutex{},
+		subAgents:   &persistentAgents{},
 	}
 
 	// Get an ApiClient to set up:
@@ -300,17 +471,53 @@ func NewAgent(cfg *Config) (*Agent, error) {
 	client.SetShowCalls(cfg.ShowCalls)
 	client.SetMaxCompletionTokens(cfg.MaxCompletionTokens)
 
+	// Set up tools, checking for validity.
+	if err := a.SetTools(cfg.Tools); err != nil {
+		return nil, err
+	}
+
+	// Context tools need not be among the agent's tools, but must exist, and
+	// must be read-only as they run with every prompt, without approval.
+	for _, name := range cfg.ContextTools {
+		tool, err := reg.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("error with context tool: %w", err)
+		}
+		if risk := tools.MetadataOf(tool).Risk; risk != tools.RiskReadOnly {
+			return nil, fmt.Errorf("error with context tool: %q has risk %q, not %q",
+				name, risk, tools.RiskReadOnly)
+		}
+	}
+
+	// Set up the policy for tool calls, checking the rules.
+	a.policy, err = policy.New(cfg.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("error with policy: %w", err)
+	}
+
+	// Set up structured output, natively if the client can do it.
+	a.schema, err = loadResponseSchema(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error with response schema: %w", err)
+	}
+	if sc, ok := client.(SchemaClient); ok && a.schema != nil {
+		if err := sc.SetResponseSchema(a.schema); err != nil {
+			return nil, fmt.Errorf("error setting response schema: %w", err)
+		}
+	}
+
 	// Add any configured context to the ApiClient.  Note that we do *not*
 	// clear the context here: if the newClientFunc wants to include premade
 	// context, we leave that alone.
-	for _, c := range cfg.Context {
-		client.AddContextItem(c)
-	}
-
-	// Set up tools, checking for validity.
-	if err := a.SetTools(cfg.Tools); err != nil {
+	//
+	// Templates are rendered (and checked) once we know our tools.
+	context_items, err := a.renderTemplates()
+	if err != nil {
 		return nil, err
 	}
+	for _, c := range context_items {
+		client.AddContextItem(c)
+	}
 
 	// Set up the streaming and color printing:
 	if cfg.Silent {
@@ -347,31 +554,132 @@ func NewAgent(cfg *Config) (*Agent, error) {
 }
 
 // SetClient sets the internal ApiClient to c, overriding anything set on
-// initialization.
+// initialization.  If c is a RegistryClient it is given the Agent's
+// registry.
 //
 // This allows the use of arbitrary ApiClients that are not registered in
 // this package.
 func (a *Agent) SetClient(c ApiClient) {
+	if rc, ok := c.(RegistryClient); ok {
+		rc.SetRegistry(a.registry)
+	}
 	a.client = c
 }
 
+// Registry returns the registry from which the Agent's tools are taken.
+func (a *Agent) Registry() *registry.Registry {
+	return a.registry
+}
+
 // SetTools sets the interal tools list for the agent and its ApiClient,
 // handling regexp selection and checking for validity.
+//
+// The tools requiring approval are selected from the same list, per the
+// configured RequireApproval.
+//
+// If the agent allows ToolChanges, tools in want that match nothing are
+// ignored, as they may be registered later.
 func (a *Agent) SetTools(want []*rgxp.OptionalRgxp) error {
-	valid_names, err := registry.MatchingNames(want)
+	valid_names, err := a.matchingTools(want)
 	if err != nil {
 		return err
 	}
+	var approval_names []string
+	if len(a.config.RequireApproval) > 0 {
+		names, err := a.registry.MatchingNames(a.config.RequireApproval)
+		if err != nil {
+			return fmt.Errorf("error with require_approval: %w", err)
+		}
+		for _, n := range names {
+			if slices.Contains(valid_names, n) {
+				approval_names = append(approval_names, n)
+			}
+		}
+	}
 	a.toolnames = valid_names
+	a.approvalnames = approval_names
 	a.client.SetTools(valid_names)
 	return nil
 }
 
+func (a *Agent) matchingTools(want []*rgxp.OptionalRgxp) ([]string, error) {
+	if !a.config.ToolChanges {
+		return a.registry.MatchingNames(want)
+	}
+	names := []string{}
+	for _, re := range want {
+		matched, err := a.registry.MatchingNames([]*rgxp.OptionalRgxp{re})
+		if errors.Is(err, registry.ErrNoMatch) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range matched {
+			if !slices.Contains(names, n) {
+				names = append(names, n)
+			}
+		}
+	}
+	return names, nil
+}
+
+// RefreshTools sets the agent's tools again from its configured Tools,
+// picking up any tools registered or removed since they were last set, e.g.
+// by a tool factory.
+//
+// This waits for any running completion to finish, so it must not be called
+// by tools.
+func (a *Agent) RefreshTools() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.SetTools(a.config.Tools)
+}
+
+// AllowsToolChanges returns true if the agent's tools may change at runtime,
+// per its ToolChanges config.
+func (a *Agent) AllowsToolChanges() bool {
+	return a.config.ToolChanges
+}
+
 // Tools returns the list of tools available to the agent.
 func (a *Agent) Tools() []string {
 	return slices.Clone(a.toolnames)
 }
 
+// RequiresApproval returns true if calls to the named tool must be approved
+// before they are run.
+func (a *Agent) RequiresApproval(name string) bool {
+	return slices.Contains(a.approvalnames, name)
+}
+
+// SetApprover sets the Approver for tool calls requiring approval.  Without
+// an Approver, all such calls are denied.
+func (a *Agent) SetApprover(ap Approver) {
+	a.approver = ap
+}
+
+// Approver returns the Approver set with SetApprover, if any.
+func (a *Agent) Approver() Approver {
+	return a.approver
+}
+
+// approve gets the approval for call, which is denied if there is no
+// Approver or the Approver gives no Approval.
+func (a *Agent) approve(ctx context.Context, call *ToolCall) (*Approval, error) {
+	if a.approver == nil {
+		return &Approval{Reason: "no approver available"}, nil
+	}
+	approval, err := a.approver.Approve(ctx, a, call)
+	if err != nil {
+		return nil, fmt.Errorf("error getting approval: %w", err)
+	}
+	if approval == nil {
+		return &Approval{Reason: "no approval given"}, nil
+	}
+	return approval, nil
+}
+
 // RunCompletionPrompt calls RunCompletion with background context and the
 // provided prompt, returning the content of the response.
 func (a *Agent) RunCompletionPrompt(prompt string) (string, error) {
@@ -407,8 +715,25 @@ func (a *Agent) RunCompletionPromptCtx(ctx context.Context, prompt string) (stri
 // normally happen, as the caller should not try to confuse the context).
 func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 
-	if a.config.MaxCompletions > 0 && a.completed >= a.config.MaxCompletions {
-		return nil, fmt.Errorf("%w: %d", ErrMaxCompletions, a.completed)
+	if err := a.CheckLimits(); err != nil {
+		return nil, err
+	}
+
+	// The client only ever sees the rendered prompt.
+	prompt := req.Content
+	if a.config.PromptTemplate != "" && len(req.ToolResults) == 0 {
+		content, err := a.RenderPrompt(req.Content, req.Vars)
+		if err != nil {
+			return nil, err
+		}
+		req = &CompletionRequest{Content: content, Vars: req.Vars}
+	}
+
+	// Context tools add what they know about the prompt, e.g. memories.
+	if len(a.config.ContextTools) > 0 && len(req.ToolResults) == 0 {
+		if found := a.toolContext(ctx, prompt); found != "" {
+			req = &CompletionRequest{Content: found + "\n\n" + req.Content, Vars: req.Vars}
+		}
 	}
 
 	// Only reason for this to fail is bad client logic, or hacking.
@@ -418,16 +743,107 @@ func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*Com
 	}
 	defer a.mutex.Unlock()
 
-	raws := []*RawCompletion{}
-	all_calls := []*ToolCall{}
+	chain := &completionChain{usage: &Usage{}}
+	res, err := a.runChain(ctx, req, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Structured output must be valid, and the LLM gets a limited number of
+	// chances to repair it.
+	var data any
+	for attempt := 1; a.schema != nil; attempt++ {
+		data, err = a.validateResponse(res.Content)
+		if err == nil {
+			break
+		}
+		if attempt > a.config.ResponseRepairs {
+			return nil, &ResponseValidationError{
+				Content:  res.Content,
+				Attempts: attempt,
+				Err:      err,
+			}
+		}
+		a.logger.Warn("invalid response, requesting repair",
+			"attempt", attempt, "error", err)
+		if err := a.checkTokens(); err != nil {
+			return nil, err
+		}
+		res, err = a.runChain(ctx, &CompletionRequest{Content: a.repairPrompt(err)}, chain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Print output, if desired.
+	if !a.config.Stream && !a.config.Silent {
+		a.printFunc(res.Content)
+		a.printFunc("\n")
+
+	}
+
+	final_res := &CompletionResponse{
+		Content:        res.Content,
+		ToolCalls:      chain.calls,
+		Usage:          chain.usage,
+		RawCompletions: chain.raws,
+		Data:           data,
+	}
+
+	// Dump the full round-trip if desired.
+	a.AddUsage(nil, 1)
+	if a.config.DumpDir != "" {
+		a.DumpCompletion(req, final_res)
+	}
+
+	// Now that we have our debug info, apply any controls that could end the
+	// completion cycle.
+	for _, re := range a.config.StopMatches {
+		if re.MatchString(res.Content) {
+			return nil, fmt.Errorf("%w: %q", ErrMatchStopped, re.String())
+		}
+	}
+
+	// TODO: limits
+	// TODO: bail on refusal
+
+	// Any tool calls have completed and we have a result plus a set of raw
+	// completions that override the current one.
+	return final_res, nil
+
+}
+
+// completionChain accumulates the results of the completions in a single
+// RunCompletion, including tool-result completions and repairs.
+type completionChain struct {
+	raws  []*RawCompletion
+	calls []*ToolCall
+	usage *Usage
+}
+
+// runChain runs the completion for req and then any tool calls it produces,
+// until there is a response without tool calls, which is returned.
+func (a *Agent) runChain(ctx context.Context, req *CompletionRequest, chain *completionChain) (*CompletionResponse, error) {
+
 	res, err := a.client.RunCompletion(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("error running completion: %w", err)
 	}
-	raws = append(raws, res.RawCompletions...)
+	chain.raws = append(chain.raws, res.RawCompletions...)
+	chain.usage.Add(res.Usage)
+	a.AddUsage(res.Usage, 0)
+
+	// Tools get to know who is calling them, which matters for sub-agents.
+	tool_ctx := WithCaller(ctx, a)
 	tool_call_responses := 0
 	for len(res.ToolCalls) > 0 {
 
+		// Token limits apply across the whole chain, so a runaway tool loop
+		// (or an expensive sub-agent) stops here.
+		if err := a.checkTokens(); err != nil {
+			return nil, err
+		}
+
 		// We can in theory get multiple tool calls in succession, in which
 		// case we watch for the tool chain.
 		tool_call_responses++
@@ -448,7 +864,7 @@ func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*Com
 		for idx, call := range res.ToolCalls {
 
 			// Keep the calls for the response.
-			all_calls = append(all_calls, call)
+			chain.calls = append(chain.calls, call)
 
 			// Print tools as they arrive, if requested.
 			// (Printing at the end will be confusing if tools take longer to run.)
@@ -458,17 +874,50 @@ func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*Com
 				a.printFunc(line)
 			}
 
-			// NB: we have no actual guarantee that the registered tools have
-			// not changed since the last call; nor that the LLM is not trying
-			// to call a disallowed tool. Thus we need to check that the tool
-			// is both allowed, and currently registered.
+			// NB: unless the registry is a Snapshot, we have no actual
+			// guarantee that the registered tools have not changed since the
+			// last call; nor that the LLM is not trying to call a disallowed
+			// tool. Thus we need to check that the tool is both allowed, and
+			// currently registered.
 			if !slices.Contains(a.toolnames, call.Name) {
 				return nil, fmt.Errorf("no such tool for agent: %s", call.Name)
 			}
-			tool, err := registry.Get(call.Name)
+			tool, err := a.registry.Get(call.Name)
 			if err != nil {
 				return nil, err
 			}
+			// Policy violations go back to the LLM as errors, just like
+			// denials; there is no point asking for approval of those.
+			if v := a.policy.Check(call.Name, call.Args); v != nil {
+				a.logger.Warn("policy violation", "tool", call.Name,
+					"rule", v.RuleId, "reason", v.Reason)
+				results[idx] = &ToolResult{
+					Id:     call.Id,
+					Output: tools.NewResult(nil, v),
+				}
+				continue
+			}
+			// Calls requiring approval wait for it here, and denials go back
+			// to the LLM as errors so it can try something else.
+			if a.RequiresApproval(call.Name) {
+				approval, err := a.approve(tool_ctx, call)
+				if err != nil {
+					return nil, err
+				}
+				if !approval.Approved {
+					a.logger.Info("tool call denied", "tool", call.Name,
+						"reason", approval.Reason)
+					err := ErrToolCallDenied
+					if approval.Reason != "" {
+						err = fmt.Errorf("%w: %s", ErrToolCallDenied, approval.Reason)
+					}
+					results[idx] = &ToolResult{
+						Id:     call.Id,
+						Output: tools.NewResult(nil, err),
+					}
+					continue
+				}
+			}
 			// Only log the tool args if that's configured, which by default
 			// it's not.
 			if a.config.LogToolArgs {
@@ -476,13 +925,12 @@ func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*Com
 			} else {
 				a.logger.Info("calling tool", "tool", call.Name)
 			}
-			output, err := tool.Exec(context.Background(), call.Args)
-			if err != nil {
-				output = map[string]string{"error": err.Error()}
-			}
+			// Results are always in the standard envelope, so every
+			// client sends the LLM the same shape.
+			output, err := tool.Exec(tool_ctx, call.Args)
 			results[idx] = &ToolResult{
 				Id:     call.Id,
-				Output: output,
+				Output: tools.NewResult(output, err).Limit(a.config.MaxToolResultBytes),
 			}
 		}
 		// Get a new reponse from that.
@@ -491,44 +939,14 @@ func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*Com
 		if err != nil {
 			return nil, fmt.Errorf("error running tool-result completion: %w", err)
 		}
-		raws = append(raws, res.RawCompletions...)
+		chain.raws = append(chain.raws, res.RawCompletions...)
+		chain.usage.Add(res.Usage)
+		a.AddUsage(res.Usage, 0)
 
 		// TODO: limit loops on tools!
 	}
 
-	// Print output, if desired.
-	if !a.config.Stream && !a.config.Silent {
-		a.printFunc(res.Content)
-		a.printFunc("\n")
-
-	}
-
-	final_res := &CompletionResponse{
-		Content:        res.Content,
-		ToolCalls:      all_calls,
-		RawCompletions: raws,
-	}
-
-	// Dump the full round-trip if desired.
-	a.completed++
-	if a.config.DumpDir != "" {
-		a.DumpCompletion(req, final_res)
-	}
-
-	// Now that we have our debug info, apply any controls that could end the
-	// completion cycle.
-	for _, re := range a.config.StopMatches {
-		if re.MatchString(res.Content) {
-			return nil, fmt.Errorf("%w: %q", ErrMatchStopped, re.String())
-		}
-	}
-
-	// TODO: limits
-	// TODO: bail on refusal
-
-	// Any tool calls have completed and we have a result plus a set of raw
-	// completions that override the current one.
-	return final_res, nil
+	return res, nil
 
 }
 
@@ -536,7 +954,7 @@ func (a *Agent) RunCompletion(ctx context.Context, req *CompletionRequest) (*Com
 // configured DumpDir, or the local directory if not set.
 func (a *Agent) DumpCompletion(req *CompletionRequest, res *CompletionResponse) error {
 
-	name := fmt.Sprintf("%s-%04d.json", a.ULID, a.completed)
+	name := fmt.Sprintf("%s-%04d.json", a.ULID, a.Completed())
 	path := filepath.Join(a.dumpdir, name)
 	v := map[string]any{"request": req, "response": res}
 	if err := utils.JsonFilePretty(v, path); err != nil {
@@ -0,0 +1,419 @@
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/policy"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// fakeClient is an ApiClient whose completions are scripted by respond.
+type fakeClient struct {
+	agent.BasicApiClient
+	respond func(req *agent.CompletionRequest) (*agent.CompletionResponse, error)
+}
+
+// RunCompletion implements ApiClient.
+func (c *fakeClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	return c.respond(req)
+}
+
+// registerFake registers agent type name with the respond function.
+func registerFake(name string, respond func(req *agent.CompletionRequest) (*agent.CompletionResponse, error)) {
+	agent.RegisterNewApiClientFunc(name, func() (agent.ApiClient, error) {
+		return &fakeClient{respond: respond}, nil
+	})
+}
+
+// callToolOnce responds to a prompt by calling tool with args, and to the
+// tool results by returning their output data, or error, as content.  Every completion
+// reports one token used.
+func callToolOnce(tool, args string) func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	return func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+		usage := &agent.Usage{Total: 1}
+		if len(req.ToolResults) == 0 {
+			return &agent.CompletionResponse{
+				ToolCalls: []*agent.ToolCall{{Id: "1", Name: tool, Args: args}},
+				Usage:     usage,
+			}, nil
+		}
+		res := req.ToolResults[0].Output.(*tools.Result)
+		content := fmt.Sprint(res.Data)
+		if !res.Ok {
+			content = res.Error
+		}
+		return &agent.CompletionResponse{
+			Content: content,
+			Usage:   usage,
+		}, nil
+	}
+}
+
+func init() {
+	registerFake("fake-helper", func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+		return &agent.CompletionResponse{
+			Content: "helped: " + req.Content,
+			Usage:   &agent.Usage{Input: 4, Output: 6, Total: 10},
+		}, nil
+	})
+	registerFake("fake-caller", callToolOnce("ask_helper", `{"prompt":"hi"}`))
+	registerFake("fake-recurse", callToolOnce("ask_recurse", `{"prompt":"again"}`))
+	registerFake("fake-private", callToolOnce("private", `{"val":"x"}`))
+}
+
+func silentConfig(name, agent_type string, tool_names ...string) *agent.Config {
+	cfg := &agent.Config{
+		Name:        name,
+		Description: "The " + name + " agent.",
+		Type:        agent_type,
+		Silent:      true,
+	}
+	for _, n := range tool_names {
+		cfg.Tools = append(cfg.Tools, rgxp.MustParseOptional(n))
+	}
+	return cfg
+}
+
+func TestAgentToolConfigValidateFails(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &agent.AgentToolConfig{}
+	require.ErrorIs(cfg.Validate(), agent.ErrAgentToolConfigInvalid)
+	require.ErrorContains(cfg.Validate(), "empty name")
+
+	cfg.Name = "foo"
+	require.ErrorContains(cfg.Validate(), `no agent config for "foo"`)
+
+	cfg.Config = &agent.Config{}
+	require.ErrorContains(cfg.Validate(), `empty description for "foo"`)
+
+	cfg.Description = "Foo."
+	cfg.MaxDepth = -1
+	require.ErrorContains(cfg.Validate(), `negative max_depth for "foo"`)
+
+	cfg.MaxDepth = 0
+	cfg.Metadata = &tools.Metadata{Risk: "scary"}
+	require.ErrorIs(cfg.Validate(), tools.ErrMetadataInvalid)
+	require.ErrorContains(cfg.Validate(), `metadata for "foo"`)
+
+}
+
+func TestAgentToolConfigValidateDefaultsOK(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &agent.AgentToolConfig{
+		Name:   "foo",
+		Config: &agent.Config{Description: "Foo agent."},
+	}
+	require.NoError(cfg.Validate())
+	require.Equal("Foo agent.", cfg.Description)
+	require.Equal(agent.DefaultAgentToolMaxDepth, cfg.MaxDepth)
+
+}
+
+func TestAgentToolUsageCountsForCaller(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	tool, err := agent.NewAgentTool(&agent.AgentToolConfig{
+		Name:   "ask_helper",
+		Config: silentConfig("helper", "fake-helper"),
+	})
+	require.NoError(err)
+	require.NoError(registry.Register(tool))
+
+	caller, err := agent.NewAgent(silentConfig("caller", "fake-caller", "ask_helper"))
+	require.NoError(err)
+
+	res, err := caller.RunCompletion(context.Background(),
+		&agent.CompletionRequest{Content: "go"})
+	require.NoError(err)
+	require.Equal("helped: hi", res.Content)
+	require.Equal(2, res.Usage.Total, "own usage in response")
+	require.Equal(12, caller.Usage().Total, "sub-agent usage added")
+	require.Equal(2, caller.Completed(), "sub-agent completion added")
+
+}
+
+func TestAgentToolUsageStopsCallerAtMaxTokens(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	tool, err := agent.NewAgentTool(&agent.AgentToolConfig{
+		Name:   "ask_helper",
+		Config: silentConfig("helper", "fake-helper"),
+	})
+	require.NoError(err)
+	require.NoError(registry.Register(tool))
+
+	cfg := silentConfig("caller", "fake-caller", "ask_helper")
+	cfg.MaxTokens = 5
+	caller, err := agent.NewAgent(cfg)
+	require.NoError(err)
+
+	_, err = caller.RunCompletionPrompt("go")
+	require.NoError(err)
+	_, err = caller.RunCompletionPrompt("go again")
+	require.ErrorIs(err, agent.ErrMaxTokens)
+	require.ErrorIs(err, agent.ErrStopped)
+
+}
+
+func TestAgentToolDepthLimited(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	cfg := silentConfig("recurse", "fake-recurse", "ask_recurse")
+	tool, err := agent.NewAgentTool(&agent.AgentToolConfig{
+		Name:     "ask_recurse",
+		Config:   cfg,
+		MaxDepth: 2,
+	})
+	require.NoError(err)
+	require.NoError(registry.Register(tool))
+
+	a, err := agent.NewAgent(cfg)
+	require.NoError(err)
+
+	content, err := a.RunCompletionPrompt("start")
+	require.NoError(err)
+	require.Contains(content, "agent tool depth exceeded: 2")
+	require.Equal(2, a.Completed(), "own plus one nested")
+
+}
+
+func TestAgentToolPersistentSelfCallDoesNotBlock(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	cfg := silentConfig("recurse", "fake-recurse", "ask_recurse")
+	tool, err := agent.NewAgentTool(&agent.AgentToolConfig{
+		Name:       "ask_recurse",
+		Config:     cfg,
+		MaxDepth:   3,
+		Persistent: true,
+	})
+	require.NoError(err)
+	require.NoError(registry.Register(tool))
+
+	a, err := agent.NewAgent(cfg)
+	require.NoError(err)
+
+	done := make(chan string)
+	go func() {
+		content, err := a.RunCompletionPrompt("start")
+		if err != nil {
+			content = err.Error()
+		}
+		done <- content
+	}()
+	select {
+	case content := <-done:
+		require.Contains(content, "agent tool depth exceeded: 3")
+		require.Equal(3, a.Completed(), "own plus two nested")
+	case <-time.After(5 * time.Second):
+		t.Fatal("persistent agent tool blocked on itself")
+	}
+
+}
+
+func TestAgentToolPersistentKeepsAgent(t *testing.T) {
+
+	require := require.New(t)
+
+	created := 0
+	agent.RegisterNewApiClientFunc("fake-counter", func() (agent.ApiClient, error) {
+		created++
+		return &fakeClient{
+			respond: func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+				return &agent.CompletionResponse{Content: "ok"}, nil
+			},
+		}, nil
+	})
+
+	for _, persistent := range []bool{true, false} {
+		created = 0
+		tool, err := agent.NewAgentTool(&agent.AgentToolConfig{
+			Name:       "count",
+			Config:     silentConfig("counter", "fake-counter"),
+			Persistent: persistent,
+		})
+		require.NoError(err)
+		for i := 0; i < 3; i++ {
+			out, err := tool.Exec(context.Background(), `{"prompt":"x"}`)
+			require.NoError(err)
+			require.Equal("ok", out)
+		}
+		if persistent {
+			require.Equal(1, created, "one agent when persistent")
+		} else {
+			require.Equal(3, created, "fresh agent per call")
+		}
+	}
+
+}
+
+func TestAgentToolPersistentPerCaller(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	// Each sub-agent counts the prompts in its own history.
+	agent.RegisterNewApiClientFunc("fake-history", func() (agent.ApiClient, error) {
+		prompts := 0
+		return &fakeClient{
+			respond: func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+				prompts++
+				return &agent.CompletionResponse{Content: fmt.Sprint("prompts: ", prompts)}, nil
+			},
+		}, nil
+	})
+	tool, err := agent.NewAgentTool(&agent.AgentToolConfig{
+		Name:       "ask_helper",
+		Config:     silentConfig("history", "fake-history"),
+		Persistent: true,
+	})
+	require.NoError(err)
+	require.NoError(registry.Register(tool))
+
+	first, err := agent.NewAgent(silentConfig("first", "fake-caller", "ask_helper"))
+	require.NoError(err)
+	second, err := agent.NewAgent(silentConfig("second", "fake-caller", "ask_helper"))
+	require.NoError(err)
+
+	for _, exp := range []struct {
+		caller  *agent.Agent
+		access  string
+		content string
+	}{
+		{first, "", "prompts: 1"},
+		{first, "", "prompts: 2"},
+		{second, "", "prompts: 1"},
+		{first, "other", "prompts: 1"},
+		{second, "", "prompts: 2"},
+		{first, "", "prompts: 3"},
+	} {
+		ctx := agent.WithAccessName(context.Background(), exp.access)
+		content, err := exp.caller.RunCompletionPromptCtx(ctx, "go")
+		require.NoError(err)
+		require.Equal(exp.content, content, exp.caller.Name+" "+exp.access)
+	}
+
+}
+
+func TestCallersChain(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	require.Nil(agent.Callers(ctx))
+
+	a, err := agent.NewAgent(silentConfig("a", "fake-helper"))
+	require.NoError(err)
+	b, err := agent.NewAgent(silentConfig("b", "fake-helper"))
+	require.NoError(err)
+
+	ctx_a := agent.WithCaller(ctx, a)
+	ctx_ab := agent.WithCaller(ctx_a, b)
+	require.Equal([]*agent.Agent{a}, agent.Callers(ctx_a))
+	require.Equal([]*agent.Agent{a, b}, agent.Callers(ctx_ab))
+
+}
+
+func TestNewAgentWithRegistry(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	reg := registry.New()
+	require.NoError(reg.Register(testTool("private")))
+	cfg := silentConfig("private", "fake-private", "private")
+
+	_, err := agent.NewAgent(cfg)
+	require.ErrorContains(err, `no match for tool "private"`, "not in default")
+
+	a, err := agent.NewAgentWithRegistry(cfg, reg.Snapshot())
+	require.NoError(err)
+	require.Equal([]string{"private"}, a.Tools())
+	require.NotSame(reg, a.Registry())
+
+	// Changes to the source do not affect the snapshot.
+	require.NoError(reg.Remove("private"))
+	res, err := a.RunCompletion(context.Background(),
+		&agent.CompletionRequest{Content: "go"})
+	require.NoError(err)
+	require.Equal("private x", res.Content)
+
+	spawn, err := a.Spawn()
+	require.NoError(err)
+	require.Same(a.Registry(), spawn.Registry(), "spawn keeps registry")
+
+}
+
+type testInput struct {
+	Val string `json:"val"`
+}
+
+func testTool(name string) tools.Tooler {
+	return tools.NewTool[testInput, string](name, name+" tool.",
+		func(ctx context.Context, in testInput) (string, error) {
+			return name + " " + in.Val, nil
+		})
+}
+
+func TestPolicyViolationSentToLlm(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.NoError(reg.Register(testTool("private")))
+	cfg := silentConfig("private", "fake-private", "private")
+	cfg.Policy = []*policy.Rule{{
+		Id:    "no-x",
+		Tools: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("/^priv/")},
+		Conditions: []*policy.Condition{
+			{Path: "$.val", NotMatch: rgxp.MustParse("/x/")},
+		},
+	}}
+	a, err := agent.NewAgentWithRegistry(cfg, reg)
+	require.NoError(err)
+	a.SetApprover(agent.ApproverFunc(func(ctx context.Context, a *agent.Agent, call *agent.ToolCall) (*agent.Approval, error) {
+		t.Fatal("approver called")
+		return nil, nil
+	}))
+
+	content, err := a.RunCompletionPrompt("go")
+	require.NoError(err)
+	require.Equal(`policy violation: rule "no-x": $.val: "x" matches /x/`, content)
+
+	cfg.Policy[0].Conditions[0].Path = "$.["
+	_, err = agent.NewAgentWithRegistry(cfg, reg)
+	require.ErrorIs(err, policy.ErrInvalidRule)
+
+}
@@ -0,0 +1,204 @@
+// agent/approval.go
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+var ErrToolCallDenied = fmt.Errorf("tool call denied")
+
+var ErrApprovalNotFound = fmt.Errorf("approval not found")
+
+// Approval is the decision on a ToolCall that requires approval.
+//
+// If a call is denied, the Reason is sent to the LLM as the tool's error.
+type Approval struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Approver decides whether an Agent may run a ToolCall, for tools that
+// require approval per the agent's RequireApproval config.
+//
+// Approve may block, e.g. while waiting for a human, but should give up when
+// ctx is done.  An error aborts the completion; a denial does not.
+type Approver interface {
+	Approve(ctx context.Context, a *Agent, call *ToolCall) (*Approval, error)
+}
+
+// ApproverFunc is a function implementing Approver.
+type ApproverFunc func(ctx context.Context, a *Agent, call *ToolCall) (*Approval, error)
+
+// Approve calls f.
+func (f ApproverFunc) Approve(ctx context.Context, a *Agent, call *ToolCall) (*Approval, error) {
+	return f(ctx, a, call)
+}
+
+// TerminalApprover asks for approval on a terminal, showing the tool name
+// and args.  An answer of "y" or "yes" approves the call; anything else
+// denies it, and answers other than "n" or "no" are sent to the LLM as the
+// reason.
+//
+// Prompts are serialized, so concurrent agents can share a TerminalApprover.
+type TerminalApprover struct {
+	ReadLine func(prompt string) (string, error) // Shows prompt and returns a line of input.
+
+	mutex sync.Mutex
+}
+
+// NewTerminalApprover returns a TerminalApprover prompting on out and
+// reading answers from in, e.g. os.Stderr and os.Stdin.
+func NewTerminalApprover(in io.Reader, out io.Writer) *TerminalApprover {
+	scanner := bufio.NewScanner(in)
+	return &TerminalApprover{
+		ReadLine: func(prompt string) (string, error) {
+			fmt.Fprint(out, prompt)
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		},
+	}
+}
+
+// Approve prompts for approval of call.  No input, e.g. at EOF, is a denial.
+func (ta *TerminalApprover) Approve(ctx context.Context, a *Agent, call *ToolCall) (*Approval, error) {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	prompt := fmt.Sprintf("%s wants to call tool: %s %s\nApprove? [y/N] ",
+		a.Ident(), call.Name, call.Args)
+	line, err := ta.ReadLine(prompt)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading approval: %w", err)
+	}
+	answer := strings.TrimSpace(line)
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return &Approval{Approved: true}, nil
+	case "", "n", "no":
+		return &Approval{Reason: "denied by user"}, nil
+	}
+	return &Approval{Reason: answer}, nil
+}
+
+// PendingApproval is a ToolCall awaiting a decision in a QueueApprover.
+//
+// AgentId is the Id of the outermost calling agent, so that calls made by
+// sub-agents are pending for the agent the user is talking to.
+type PendingApproval struct {
+	Id      string    `json:"id"`
+	AgentId string    `json:"agent_id"`
+	Agent   string    `json:"agent"` // Ident of the agent making the call.
+	Call    *ToolCall `json:"call"`
+	Created time.Time `json:"created"`
+
+	decision chan *Approval
+}
+
+// QueueApprover holds tool calls pending until they are decided by another
+// party, e.g. through the HTTP API.  Calls not decided within Timeout are
+// denied; a zero Timeout waits as long as the context allows.
+type QueueApprover struct {
+	Timeout time.Duration
+
+	mutex   sync.Mutex
+	pending map[string]*PendingApproval
+}
+
+// NewQueueApprover returns a QueueApprover with the given timeout.
+func NewQueueApprover(timeout time.Duration) *QueueApprover {
+	return &QueueApprover{
+		Timeout: timeout,
+		pending: map[string]*PendingApproval{},
+	}
+}
+
+// Approve adds call to the queue and waits for a decision from Decide.
+func (q *QueueApprover) Approve(ctx context.Context, a *Agent, call *ToolCall) (*Approval, error) {
+
+	agent_id := a.Id()
+	if callers := Callers(ctx); len(callers) > 0 {
+		agent_id = callers[0].Id()
+	}
+	p := &PendingApproval{
+		Id:       ulid.Make().String(),
+		AgentId:  agent_id,
+		Agent:    a.Ident(),
+		Call:     call,
+		Created:  time.Now(),
+		decision: make(chan *Approval, 1),
+	}
+	q.mutex.Lock()
+	q.pending[p.Id] = p
+	q.mutex.Unlock()
+	defer q.remove(p.Id)
+	a.Logger().Info("awaiting approval", "tool", call.Name, "approval_id", p.Id)
+
+	var timeout <-chan time.Time
+	if q.Timeout > 0 {
+		timer := time.NewTimer(q.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case approval := <-p.decision:
+		return approval, nil
+	case <-timeout:
+		return &Approval{Reason: "approval timed out"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *QueueApprover) remove(id string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.pending, id)
+}
+
+// Pending returns the approvals pending for agent_id, or for all agents if
+// agent_id is empty, oldest first.
+func (q *QueueApprover) Pending(agent_id string) []*PendingApproval {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	list := []*PendingApproval{}
+	for _, p := range q.pending {
+		if agent_id == "" || p.AgentId == agent_id {
+			list = append(list, p)
+		}
+	}
+	slices.SortFunc(list, func(a, b *PendingApproval) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	return list
+}
+
+// Decide sends approval to the pending call with the given id, which is then
+// no longer pending.
+func (q *QueueApprover) Decide(id string, approval *Approval) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	p := q.pending[id]
+	if p == nil {
+		return fmt.Errorf("%w: %s", ErrApprovalNotFound, id)
+	}
+	delete(q.pending, id)
+	p.decision <- approval
+	return nil
+}
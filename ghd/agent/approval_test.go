@@ -0,0 +1,233 @@
+package agent_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+func init() {
+	registerFake("fake-guarded", callToolOnce("guarded", `{"val":"x"}`))
+}
+
+// guardedAgent returns an agent whose prompts call the "guarded" tool, which
+// is a write tool, with approval required per want.
+func guardedAgent(t *testing.T, want ...string) *agent.Agent {
+	reg := registry.New()
+	guarded := testTool("guarded").(*tools.Tool[testInput, string])
+	require.NoError(t, reg.Register(guarded.WithMetadata(&tools.Metadata{Risk: tools.RiskWrite})))
+	require.NoError(t, reg.Register(testTool("harmless")))
+	cfg := silentConfig("guarded", "fake-guarded", "guarded", "harmless")
+	for _, n := range want {
+		cfg.RequireApproval = append(cfg.RequireApproval, rgxp.MustParseOptional(n))
+	}
+	a, err := agent.NewAgentWithRegistry(cfg, reg)
+	require.NoError(t, err)
+	return a
+}
+
+func runGuarded(ctx context.Context, a *agent.Agent) (string, error) {
+	return a.RunCompletionPromptCtx(ctx, "go")
+}
+
+func TestRequireApprovalSelected(t *testing.T) {
+
+	require := require.New(t)
+
+	a := guardedAgent(t, "risk:write")
+	require.True(a.RequiresApproval("guarded"))
+	require.False(a.RequiresApproval("harmless"))
+
+	// Undeclared risk is the riskiest of all.
+	a = guardedAgent(t, "risk>=write")
+	require.True(a.RequiresApproval("guarded"))
+	require.True(a.RequiresApproval("harmless"))
+
+	a = guardedAgent(t, "/^harm/")
+	require.False(a.RequiresApproval("guarded"))
+	require.True(a.RequiresApproval("harmless"))
+
+	a = guardedAgent(t, "tag:nope")
+	require.False(a.RequiresApproval("guarded"))
+
+	reg := registry.New()
+	require.NoError(reg.Register(testTool("guarded")))
+	cfg := silentConfig("guarded", "fake-guarded", "guarded")
+	cfg.RequireApproval = []*rgxp.OptionalRgxp{rgxp.MustParseOptional("nonesuch")}
+	_, err := agent.NewAgentWithRegistry(cfg, reg)
+	require.ErrorContains(err, `require_approval: no match for tool "nonesuch"`)
+
+}
+
+func TestApprovalDeniedWithoutApprover(t *testing.T) {
+
+	require := require.New(t)
+
+	a := guardedAgent(t, "guarded")
+	content, err := runGuarded(context.Background(), a)
+	require.NoError(err)
+	require.Equal("tool call denied: no approver available", content)
+
+}
+
+func TestApprovalNotRequired(t *testing.T) {
+
+	require := require.New(t)
+
+	a := guardedAgent(t)
+	a.SetApprover(agent.ApproverFunc(func(ctx context.Context, a *agent.Agent, call *agent.ToolCall) (*agent.Approval, error) {
+		t.Fatal("approver called")
+		return nil, nil
+	}))
+	content, err := runGuarded(context.Background(), a)
+	require.NoError(err)
+	require.Equal("guarded x", content)
+
+}
+
+func TestApproverFunc(t *testing.T) {
+
+	require := require.New(t)
+
+	var got *agent.ToolCall
+	approved := false
+	a := guardedAgent(t, "guarded")
+	a.SetApprover(agent.ApproverFunc(func(ctx context.Context, a *agent.Agent, call *agent.ToolCall) (*agent.Approval, error) {
+		got = call
+		return &agent.Approval{Approved: approved, Reason: "not today"}, nil
+	}))
+
+	content, err := runGuarded(context.Background(), a)
+	require.NoError(err)
+	require.Equal("tool call denied: not today", content)
+	require.Equal("guarded", got.Name)
+	require.Equal(`{"val":"x"}`, got.Args)
+
+	approved = true
+	content, err = runGuarded(context.Background(), a)
+	require.NoError(err)
+	require.Equal("guarded x", content)
+
+	spawn, err := a.Spawn()
+	require.NoError(err)
+	require.NotNil(spawn.Approver(), "spawn keeps approver")
+
+	a.SetApprover(agent.ApproverFunc(func(ctx context.Context, a *agent.Agent, call *agent.ToolCall) (*agent.Approval, error) {
+		return nil, context.Canceled
+	}))
+	_, err = runGuarded(context.Background(), a)
+	require.ErrorIs(err, context.Canceled, "approver errors abort")
+
+}
+
+func TestTerminalApprover(t *testing.T) {
+
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		input string
+		exp   string
+	}{
+		{"y\n", "guarded x"},
+		{" YES \n", "guarded x"},
+		{"n\n", "tool call denied: denied by user"},
+		{"", "tool call denied: denied by user"},
+		{"use the other one\n", "tool call denied: use the other one"},
+	} {
+		out := &strings.Builder{}
+		a := guardedAgent(t, "guarded")
+		a.SetApprover(agent.NewTerminalApprover(strings.NewReader(tc.input), out))
+		content, err := runGuarded(context.Background(), a)
+		require.NoError(err)
+		require.Equal(tc.exp, content, tc.input)
+		require.Contains(out.String(), "wants to call tool: guarded {\"val\":\"x\"}\nApprove? [y/N] ")
+	}
+
+}
+
+// awaitPending waits for one approval to be pending in q.
+func awaitPending(t *testing.T, q *agent.QueueApprover, agent_id string) *agent.PendingApproval {
+	for range 200 {
+		if pending := q.Pending(agent_id); len(pending) > 0 {
+			return pending[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("no pending approval")
+	return nil
+}
+
+func TestQueueApprover(t *testing.T) {
+
+	require := require.New(t)
+
+	q := agent.NewQueueApprover(0)
+	a := guardedAgent(t, "guarded")
+	a.SetApprover(q)
+
+	for _, approval := range []*agent.Approval{
+		{Approved: true},
+		{Reason: "nope"},
+	} {
+		done := make(chan string)
+		go func() {
+			content, _ := runGuarded(context.Background(), a)
+			done <- content
+		}()
+		p := awaitPending(t, q, a.Id())
+		require.Equal(a.Id(), p.AgentId)
+		require.Equal(a.Ident(), p.Agent)
+		require.Equal("guarded", p.Call.Name)
+		require.Empty(q.Pending("other"))
+		require.NoError(q.Decide(p.Id, approval))
+		content := <-done
+		if approval.Approved {
+			require.Equal("guarded x", content)
+		} else {
+			require.Equal("tool call denied: nope", content)
+		}
+		require.Empty(q.Pending(""), "no longer pending")
+		require.ErrorIs(q.Decide(p.Id, approval), agent.ErrApprovalNotFound)
+	}
+
+}
+
+func TestQueueApproverTimeout(t *testing.T) {
+
+	require := require.New(t)
+
+	a := guardedAgent(t, "guarded")
+	a.SetApprover(agent.NewQueueApprover(time.Millisecond))
+	content, err := runGuarded(context.Background(), a)
+	require.NoError(err)
+	require.Equal("tool call denied: approval timed out", content)
+
+}
+
+func TestQueueApproverContextDone(t *testing.T) {
+
+	require := require.New(t)
+
+	q := agent.NewQueueApprover(0)
+	a := guardedAgent(t, "guarded")
+	a.SetApprover(q)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := runGuarded(ctx, a)
+		done <- err
+	}()
+	awaitPending(t, q, "")
+	cancel()
+	require.ErrorIs(<-done, context.Canceled)
+	require.Empty(q.Pending(""))
+
+}
@@ -6,6 +6,8 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+
+	"github.com/biztos/greenhead/ghd/registry"
 )
 
 // ApiClient abstracts the API client itself, allowing the use of different
@@ -63,7 +65,8 @@ type ApiClient interface {
 
 	// SetTools sets the tools that will be described to the LLM as callable.
 	//
-	// These must be available in the registry when SetTools is called.
+	// These must be available in the Agent's registry when SetTools is
+	// called.
 	SetTools([]string) error
 
 	// SetMaxCompletionTokens sets the maximum number of tokens the LLM should
@@ -86,6 +89,13 @@ type ApiClient interface {
 	Check(context.Context) error
 }
 
+// RegistryClient may be implemented by ApiClients that look up their tools
+// by name, so they use the same registry as their Agent.  The Agent calls
+// SetRegistry before SetTools.
+type RegistryClient interface {
+	SetRegistry(*registry.Registry)
+}
+
 var ErrPlaceholder = errors.New("Placeholder function.")
 
 // BasicApiClient satisfies the ApiClient interface, with placeholder
@@ -102,6 +112,7 @@ type BasicApiClient struct {
 
 	ContextItems        []ContextItem
 	Tools               []string
+	Registry            *registry.Registry
 	Model               string
 	MaxCompletionTokens int
 
@@ -154,6 +165,20 @@ func (c *BasicApiClient) SetTools(tools []string) error {
 	return nil
 }
 
+// SetRegistry implements RegistryClient.
+func (c *BasicApiClient) SetRegistry(reg *registry.Registry) {
+	c.Registry = reg
+}
+
+// ToolRegistry returns the Registry set by SetRegistry, or the default
+// registry if none was set.
+func (c *BasicApiClient) ToolRegistry() *registry.Registry {
+	if c.Registry == nil {
+		return registry.Default
+	}
+	return c.Registry
+}
+
 // SetStreaming implements ApiClient.
 func (c *BasicApiClient) SetStreaming(streaming bool) {
 	c.Streaming = streaming
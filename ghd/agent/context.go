@@ -0,0 +1,71 @@
+// agent/context.go
+
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+type accessNameKey struct{}
+
+// WithAccessName returns a copy of ctx with the name of the access key on
+// whose behalf agents are run, e.g. the API key used to call the agent.
+//
+// Tools can use this to keep data per user; see AccessName.
+func WithAccessName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, accessNameKey{}, name)
+}
+
+// AccessName returns the access key name in ctx, or an empty string if there
+// is none, e.g. for agents run on the command line.
+func AccessName(ctx context.Context) string {
+	name, _ := ctx.Value(accessNameKey{}).(string)
+	return name
+}
+
+// ContextToolInput is the input to the agent's ContextTools, which are run
+// before each completion with the prompt.  Their output, if not empty, is
+// added before the prompt: strings as they are, and anything else as JSON.
+//
+// Context tools are run with the agent as the caller, but are not subject
+// to approval or policy, so they must have the read-only risk level.
+type ContextToolInput struct {
+	Prompt string `json:"prompt"`
+}
+
+// toolContext returns the output of the context tools for prompt, or an
+// empty string if there is none.  Failing tools are logged and ignored, as
+// the prompt can still be answered without them.
+func (a *Agent) toolContext(ctx context.Context, prompt string) string {
+	if strings.TrimSpace(prompt) == "" {
+		return ""
+	}
+	args := utils.MustJsonString(ContextToolInput{Prompt: prompt})
+	tool_ctx := WithCaller(ctx, a)
+	parts := []string{}
+	for _, name := range a.config.ContextTools {
+		tool, err := a.registry.Get(name)
+		if err != nil {
+			a.logger.Warn("context tool not found", "tool", name, "error", err)
+			continue
+		}
+		out, err := tool.Exec(tool_ctx, args)
+		if err != nil {
+			a.logger.Warn("context tool failed", "tool", name, "error", err)
+			continue
+		}
+		s, ok := out.(string)
+		if !ok && out != nil {
+			s = utils.MustJsonString(out)
+		}
+		if strings.TrimSpace(s) == "" || s == "null" {
+			continue
+		}
+		a.logger.Debug("context tool output added", "tool", name, "bytes", len(s))
+		parts = append(parts, strings.TrimSpace(s))
+	}
+	return strings.Join(parts, "\n\n")
+}
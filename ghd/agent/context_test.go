@@ -0,0 +1,142 @@
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+func TestAccessName(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	require.Equal("", agent.AccessName(ctx))
+	require.Equal("alice", agent.AccessName(agent.WithAccessName(ctx, "alice")))
+
+}
+
+type contextNote struct {
+	Note string `json:"note"`
+}
+
+func contextRegistry(t *testing.T) *registry.Registry {
+	t.Helper()
+	reg := registry.New()
+	read_only := &tools.Metadata{Risk: tools.RiskReadOnly}
+	for _, tool := range []tools.Tooler{
+		tools.NewTool[agent.ContextToolInput, string]("ctx_who", "Who.",
+			func(ctx context.Context, in agent.ContextToolInput) (string, error) {
+				callers := agent.Callers(ctx)
+				return fmt.Sprintf("%s asks %q as %q.", callers[len(callers)-1].Name,
+					in.Prompt, agent.AccessName(ctx)), nil
+			}).WithMetadata(read_only),
+		tools.NewTool[agent.ContextToolInput, *contextNote]("ctx_note", "Note.",
+			func(ctx context.Context, in agent.ContextToolInput) (*contextNote, error) {
+				return &contextNote{Note: "noted"}, nil
+			}).WithMetadata(read_only),
+		tools.NewTool[agent.ContextToolInput, string]("ctx_empty", "Empty.",
+			func(ctx context.Context, in agent.ContextToolInput) (string, error) {
+				return " \n", nil
+			}).WithMetadata(read_only),
+		tools.NewTool[agent.ContextToolInput, string]("ctx_fail", "Fails.",
+			func(ctx context.Context, in agent.ContextToolInput) (string, error) {
+				return "", fmt.Errorf("oops")
+			}).WithMetadata(read_only),
+	} {
+		require.NoError(t, reg.Register(tool))
+	}
+	require.NoError(t, reg.Register(tools.NewTool[agent.ContextToolInput, string](
+		"ctx_write", "Writes.",
+		func(ctx context.Context, in agent.ContextToolInput) (string, error) {
+			return "written", nil
+		}).WithMetadata(&tools.Metadata{Risk: tools.RiskWrite})))
+	require.NoError(t, reg.Register(tools.NewTool[agent.ContextToolInput, string](
+		"ctx_undeclared", "Says nothing of its risk.",
+		func(ctx context.Context, in agent.ContextToolInput) (string, error) {
+			return "unknown", nil
+		})))
+	return reg
+}
+
+func TestContextToolsAddedToPrompt(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := silentConfig("remembering", "fake-context-echo")
+	cfg.PromptTemplate = "Be nice: {{.prompt}}"
+	cfg.ContextTools = []string{"ctx_fail", "ctx_who", "ctx_empty", "ctx_note"}
+	a, err := agent.NewAgentWithRegistry(cfg, contextRegistry(t))
+	require.NoError(err)
+	require.Empty(a.Tools(), "context tools are not offered")
+
+	ctx := agent.WithAccessName(context.Background(), "alice")
+	res, err := a.RunCompletion(ctx, &agent.CompletionRequest{Content: "hi"})
+	require.NoError(err)
+	exp := `user: remembering asks "hi" as "alice".
+
+{"note":"noted"}
+
+Be nice: hi`
+	require.Equal(exp, res.Content)
+
+	// Nothing is added for empty prompts.
+	res, err = a.RunCompletion(ctx, &agent.CompletionRequest{Content: " "})
+	require.NoError(err)
+	require.Equal("user: Be nice:  ", res.Content)
+
+}
+
+func TestContextToolsOnlyFailing(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := silentConfig("forgetful", "fake-context-echo")
+	cfg.ContextTools = []string{"ctx_fail", "ctx_empty"}
+	a, err := agent.NewAgentWithRegistry(cfg, contextRegistry(t))
+	require.NoError(err)
+
+	content, err := a.RunCompletionPrompt("hi")
+	require.NoError(err)
+	require.Equal("user: hi", content)
+
+}
+
+func TestContextToolsMissingFails(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := silentConfig("forgetful", "fake-context-echo")
+	cfg.ContextTools = []string{"ctx_who", "ctx_nope"}
+	_, err := agent.NewAgentWithRegistry(cfg, contextRegistry(t))
+	require.ErrorContains(err, "error with context tool")
+	require.ErrorContains(err, "ctx_nope")
+
+	cp := cfg.Copy()
+	cp.ContextTools[0] = "changed"
+	require.Equal("ctx_who", cfg.ContextTools[0])
+
+}
+
+func TestContextToolsNotReadOnlyFails(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := silentConfig("forgetful", "fake-context-echo")
+	cfg.ContextTools = []string{"ctx_who", "ctx_write"}
+	_, err := agent.NewAgentWithRegistry(cfg, contextRegistry(t))
+	require.EqualError(err,
+		`error with context tool: "ctx_write" has risk "write", not "read-only"`)
+
+	cfg.ContextTools = []string{"ctx_undeclared"}
+	_, err = agent.NewAgentWithRegistry(cfg, contextRegistry(t))
+	require.EqualError(err,
+		`error with context tool: "ctx_undeclared" has risk "unknown", not "read-only"`)
+
+}
@@ -0,0 +1,81 @@
+// agent/factory.go
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrToolFactoriesDisabled = fmt.Errorf("tool factories are not enabled in this build")
+
+var ErrToolChangesNotAllowed = fmt.Errorf("tool changes are not allowed")
+
+// ToolFactoriesEnabled returns true if the program was built with the
+// ghd_tool_factories tag, which is required for tools to register or
+// remove tools at runtime:
+//
+//	go build -tags ghd_tool_factories ./ghd/cmd/ghd
+//
+// This is deliberately not a runtime setting.  A tool that makes tools can
+// grow the abilities of an agent beyond anything in its config, so it must
+// be an explicit choice for the whole program.
+func ToolFactoriesEnabled() bool {
+	return toolFactoriesEnabled
+}
+
+// Registrar registers and removes tools at runtime on behalf of a tool,
+// in the registry of the agent calling that tool.  Agents allowing tool
+// changes have a Fork of their registry, so the changes are scoped to the
+// agent (and any agents spawned from it later), and all registry locks
+// still apply.
+//
+// The agent does not see the changes until its RefreshTools is called, and
+// then only if its configured Tools include the new tools.
+type Registrar struct {
+	agent *Agent
+}
+
+// ToolRegistrar returns the Registrar for the agent calling a tool with
+// ctx.  It is an error if tool factories are not enabled, there is no
+// calling agent, or the agent is not configured with ToolChanges.
+func ToolRegistrar(ctx context.Context) (*Registrar, error) {
+	if !ToolFactoriesEnabled() {
+		return nil, ErrToolFactoriesDisabled
+	}
+	callers := Callers(ctx)
+	if len(callers) == 0 {
+		return nil, fmt.Errorf("%w: no calling agent", ErrToolChangesNotAllowed)
+	}
+	caller := callers[len(callers)-1]
+	if !caller.config.ToolChanges {
+		return nil, fmt.Errorf("%w: for agent %s", ErrToolChangesNotAllowed, caller.Ident())
+	}
+	return &Registrar{agent: caller}, nil
+}
+
+// Agent returns the agent whose registry is changed by r.
+func (r *Registrar) Agent() *Agent {
+	return r.agent
+}
+
+// Register registers t in the agent's registry, subject to its locks.
+func (r *Registrar) Register(t tools.Tooler) error {
+	if err := r.agent.registry.Register(t); err != nil {
+		return err
+	}
+	r.agent.logger.Warn("tool registered at runtime", "tool", t.Name())
+	return nil
+}
+
+// Remove removes the named tool from the agent's registry, subject to its
+// locks.
+func (r *Registrar) Remove(name string) error {
+	if err := r.agent.registry.Remove(name); err != nil {
+		return err
+	}
+	r.agent.logger.Warn("tool removed at runtime", "tool", name)
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build !ghd_tool_factories
+
+package agent
+
+// Tool factories are enabled by the ghd_tool_factories build tag.
+const toolFactoriesEnabled = false
@@ -0,0 +1,122 @@
+//go:build ghd_tool_factories
+
+package agent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// makerTool registers a tool named by its input, or removes it.
+func makerTool() tools.Tooler {
+	return tools.NewTool[testInput, string]("maker", "Makes tools.",
+		func(ctx context.Context, in testInput) (string, error) {
+			registrar, err := agent.ToolRegistrar(ctx)
+			if err != nil {
+				return "", err
+			}
+			if in.Val == "private" {
+				return "removed", registrar.Remove(in.Val)
+			}
+			return "made", registrar.Register(testTool(in.Val))
+		})
+}
+
+func init() {
+	registerFake("fake-make-made", callToolOnce("maker", `{"val":"made_x"}`))
+	registerFake("fake-make-other", callToolOnce("maker", `{"val":"other"}`))
+	registerFake("fake-make-remove", callToolOnce("maker", `{"val":"private"}`))
+}
+
+func TestToolFactoriesEnabled(t *testing.T) {
+
+	require := require.New(t)
+
+	require.True(agent.ToolFactoriesEnabled())
+
+	reg := registry.New()
+	require.NoError(reg.Register(makerTool()))
+	require.NoError(reg.Register(testTool("private")))
+
+	cfg := silentConfig("maker", "fake-make-made", "maker", "private", "/^made_/")
+	cfg.ToolChanges = true
+	a, err := agent.NewAgentWithRegistry(cfg, reg)
+	require.NoError(err)
+	require.True(a.AllowsToolChanges())
+	require.NotSame(reg, a.Registry(), "forked")
+
+	content, err := a.RunCompletionPrompt("go")
+	require.NoError(err)
+	require.Equal("made", content)
+	require.Equal([]string{"maker", "private"}, a.Tools(), "not refreshed")
+	require.Equal([]string{"maker", "private"}, reg.Names(), "source unchanged")
+
+	require.NoError(a.RefreshTools())
+	require.Equal([]string{"maker", "private", "made_x"}, a.Tools(), "refreshed")
+
+	// Tools not in the agent's config are registered, but not used.
+	cfg = silentConfig("maker", "fake-make-other", "maker", "/^made_/")
+	cfg.ToolChanges = true
+	a, err = agent.NewAgentWithRegistry(cfg, reg)
+	require.NoError(err)
+	_, err = a.RunCompletionPrompt("go")
+	require.NoError(err)
+	require.NoError(a.RefreshTools())
+	require.Equal([]string{"maker"}, a.Tools())
+	require.Contains(a.Registry().Names(), "other")
+
+}
+
+func TestToolFactoriesRespectLocks(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.NoError(reg.Register(makerTool()))
+	require.NoError(reg.Register(testTool("private")))
+	reg.LockForRemove()
+
+	cfg := silentConfig("maker", "fake-make-remove", "maker")
+	cfg.ToolChanges = true
+	a, err := agent.NewAgentWithRegistry(cfg, reg)
+	require.NoError(err)
+	content, err := a.RunCompletionPrompt("go")
+	require.NoError(err)
+	require.Equal(registry.ErrRemoveLocked.Error(), content)
+
+	reg.Lock()
+	cfg = silentConfig("maker", "fake-make-made", "maker")
+	cfg.ToolChanges = true
+	a, err = agent.NewAgentWithRegistry(cfg, reg)
+	require.NoError(err)
+	content, err = a.RunCompletionPrompt("go")
+	require.NoError(err)
+	require.Equal(registry.ErrNewLocked.Error(), content)
+
+}
+
+func TestToolFactoriesNotAllowed(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.NoError(reg.Register(makerTool()))
+
+	a, err := agent.NewAgentWithRegistry(silentConfig("maker", "fake-make-made", "maker"), reg)
+	require.NoError(err)
+	require.False(a.AllowsToolChanges())
+	content, err := a.RunCompletionPrompt("go")
+	require.NoError(err)
+	require.Contains(content, agent.ErrToolChangesNotAllowed.Error())
+	require.Equal([]string{"maker"}, reg.Names())
+
+	_, err = agent.ToolRegistrar(context.Background())
+	require.ErrorIs(err, agent.ErrToolChangesNotAllowed)
+
+}
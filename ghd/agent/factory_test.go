@@ -0,0 +1,31 @@
+//go:build !ghd_tool_factories
+
+package agent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+)
+
+func TestToolFactoriesDisabled(t *testing.T) {
+
+	require := require.New(t)
+
+	require.False(agent.ToolFactoriesEnabled())
+
+	a, err := agent.NewAgent(silentConfig("plain", "fake-helper"))
+	require.NoError(err)
+	_, err = agent.ToolRegistrar(agent.WithCaller(context.Background(), a))
+	require.ErrorIs(err, agent.ErrToolFactoriesDisabled)
+
+	cfg := silentConfig("changes", "fake-helper")
+	cfg.ToolChanges = true
+	_, err = agent.NewAgent(cfg)
+	require.ErrorIs(err, agent.ErrToolFactoriesDisabled)
+	require.ErrorContains(err, "tool_changes")
+
+}
@@ -16,7 +16,8 @@ import (
 
 	"github.com/sashabaranov/go-openai"
 
-	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/schema"
+	"github.com/biztos/greenhead/ghd/tools"
 	"github.com/biztos/greenhead/ghd/utils"
 )
 
@@ -25,8 +26,9 @@ var OpenAiClientDefaultModel = openai.GPT4o
 // OpenAiClient is an ApiClient that builds on BasicApiClient.
 type OpenAiClient struct {
 	BasicApiClient
-	Client  *openai.Client
-	History []openai.ChatCompletionMessage
+	Client         *openai.Client
+	History        []openai.ChatCompletionMessage
+	ResponseSchema *schema.Schema
 }
 
 // NewOpenAiClient returns a client initialized for the OpenAI API.
@@ -43,6 +45,7 @@ func NewOpenAiClient() (ApiClient, error) {
 		},
 		client,
 		nil,
+		nil,
 	}, nil
 
 }
@@ -54,6 +57,14 @@ func (c *OpenAiClient) ClearContext() {
 	c.History = nil
 }
 
+// SetResponseSchema implements SchemaClient, using the OpenAI structured
+// output support.  The schema is not "strict" as that requires conformance
+// to a subset of JSON Schema.
+func (c *OpenAiClient) SetResponseSchema(s *schema.Schema) error {
+	c.ResponseSchema = s
+	return nil
+}
+
 // Check implements ApiClient by querying the model list.
 func (c *OpenAiClient) Check(ctx context.Context) error {
 
@@ -116,19 +127,19 @@ func (c *OpenAiClient) RunCompletion(ctx context.Context, req *CompletionRequest
 	msgs = append(msgs, new_msgs...)
 
 	// Get the tools in openai format.
-	tools := make([]openai.Tool, 0, len(c.Tools))
+	oai_tools := make([]openai.Tool, 0, len(c.Tools))
 	for _, name := range c.Tools {
-		t, err := registry.Get(name)
+		t, err := c.ToolRegistry().Get(name)
 		if err != nil {
 			return nil, err
 		}
-		tools = append(tools, t.OpenAiTool())
+		oai_tools = append(oai_tools, OpenAiTool(t))
 	}
 
 	// Create openai-specific request.
 	oai_req := openai.ChatCompletionRequest{
 		Model:               c.Model,
-		Tools:               tools,
+		Tools:               oai_tools,
 		Messages:            msgs,
 		Stream:              c.Streaming,
 		MaxCompletionTokens: c.MaxCompletionTokens,
@@ -136,6 +147,15 @@ func (c *OpenAiClient) RunCompletion(ctx context.Context, req *CompletionRequest
 		// ...and so on...
 
 	}
+	if c.ResponseSchema != nil {
+		oai_req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   "response",
+				Schema: c.ResponseSchema,
+			},
+		}
+	}
 	if c.PreFunc != nil {
 		if err := c.PreFunc(c, &oai_req); err != nil {
 			err = fmt.Errorf("error from preprocessor: %w", err)
@@ -418,3 +438,29 @@ func (c *OpenAiClient) CreateChatCompletion(ctx context.Context, r openai.ChatCo
 func init() {
 	RegisterNewApiClientFunc("openai", NewOpenAiClient)
 }
+
+// OpenAiTooler is implemented by Toolers written before tools.Definition,
+// which define their own OpenAI tools.
+type OpenAiTooler interface {
+	OpenAiTool() openai.Tool
+}
+
+// OpenAiTool returns the OpenAI function tool for t, from its Definition
+// unless it is an OpenAiTooler, in which case its own tool is used as-is.
+//
+// The description includes any output schema, as OpenAI has no field for it.
+func OpenAiTool(t tools.Tooler) openai.Tool {
+	if ot, ok := t.(OpenAiTooler); ok {
+		return ot.OpenAiTool()
+	}
+	d := tools.Define(t)
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        d.Name,
+			Description: d.Description,
+			Strict:      d.Strict,
+			Parameters:  d.InputSchema,
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package agent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+)
+
+// legacyTool defines its own OpenAI tool, as Toolers did before Definition.
+type legacyTool struct{}
+
+func (legacyTool) Name() string        { return "legacy" }
+func (legacyTool) Description() string { return "Legacy tool." }
+func (legacyTool) InputSchema() any    { return map[string]any{"type": "object"} }
+func (legacyTool) Help() string        { return "legacy" }
+func (legacyTool) Exec(ctx context.Context, input string) (any, error) {
+	return "legacy", nil
+}
+func (legacyTool) OpenAiTool() openai.Tool {
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        "legacy",
+			Description: "Legacy OpenAI tool.",
+		},
+	}
+}
+
+func TestOpenAiToolFromDefinition(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := testTool("def")
+	oai_tool := agent.OpenAiTool(tool)
+	require.Equal(openai.ToolTypeFunction, oai_tool.Type)
+	require.Equal("def", oai_tool.Function.Name)
+	require.Contains(oai_tool.Function.Description, "def tool.")
+	require.True(oai_tool.Function.Strict)
+	require.Equal(tool.InputSchema(), oai_tool.Function.Parameters)
+
+}
+
+func TestOpenAiToolLegacy(t *testing.T) {
+
+	require := require.New(t)
+
+	oai_tool := agent.OpenAiTool(legacyTool{})
+	require.Equal("legacy", oai_tool.Function.Name)
+	require.Equal("Legacy OpenAI tool.", oai_tool.Function.Description)
+
+}
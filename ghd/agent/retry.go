@@ -0,0 +1,59 @@
+// agent/retry.go
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Retryable may be implemented by errors from ApiClients to declare whether
+// the failed operation is worth retrying.
+type Retryable interface {
+	Retryable() bool
+}
+
+// IsRetryable returns true if err, or an error it wraps, appears to be
+// transient: rate limits, server errors, and network timeouts.  Errors
+// implementing Retryable decide for themselves.
+//
+// Limits such as ErrMaxCompletions, and context cancellation, are never
+// retryable.
+func IsRetryable(err error) bool {
+
+	if err == nil || errors.Is(err, ErrStopped) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var r Retryable
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+
+	var api_err *openai.APIError
+	if errors.As(err, &api_err) {
+		return retryableStatus(api_err.HTTPStatusCode)
+	}
+	var req_err *openai.RequestError
+	if errors.As(err, &req_err) {
+		return retryableStatus(req_err.HTTPStatusCode)
+	}
+
+	var net_err net.Error
+	if errors.As(err, &net_err) {
+		return net_err.Timeout()
+	}
+
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
@@ -0,0 +1,46 @@
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+)
+
+type retryableErr bool
+
+func (e retryableErr) Error() string   { return "retryable?" }
+func (e retryableErr) Retryable() bool { return bool(e) }
+
+func TestIsRetryable(t *testing.T) {
+
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		err error
+		exp bool
+	}{
+		{nil, false},
+		{fmt.Errorf("plain"), false},
+		{agent.ErrMaxCompletions, false},
+		{context.Canceled, false},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), false},
+		{retryableErr(true), true},
+		{fmt.Errorf("wrapped: %w", retryableErr(false)), false},
+		{&openai.APIError{HTTPStatusCode: 429}, true},
+		{&openai.APIError{HTTPStatusCode: 503}, true},
+		{&openai.APIError{HTTPStatusCode: 400}, false},
+		{fmt.Errorf("x: %w", &openai.RequestError{HTTPStatusCode: 502}), true},
+		{&openai.RequestError{HTTPStatusCode: 401}, false},
+		{&net.DNSError{IsTimeout: true}, true},
+		{&net.DNSError{IsNotFound: true}, false},
+	} {
+		require.Equal(tc.exp, agent.IsRetryable(tc.err), "%v", tc.err)
+	}
+
+}
@@ -0,0 +1,85 @@
+// agent/structured.go
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/biztos/greenhead/ghd/schema"
+)
+
+var ErrResponseValidation = fmt.Errorf("response validation failed")
+
+// SchemaClient may be implemented by ApiClients that support native
+// structured output, i.e. constraining the LLM's final responses to a JSON
+// Schema.  The Agent validates responses whether or not this is supported.
+type SchemaClient interface {
+	SetResponseSchema(*schema.Schema) error
+}
+
+// ResponseValidationError is returned by RunCompletion when the final
+// response does not satisfy the configured ResponseSchema, even after any
+// repair attempts.  It wraps ErrResponseValidation and the last validation
+// error.
+type ResponseValidationError struct {
+	Content  string // The last invalid response.
+	Attempts int    // Number of responses validated.
+	Err      error  // The last validation error.
+}
+
+// Error implements error.
+func (e *ResponseValidationError) Error() string {
+	return fmt.Sprintf("%s after %d attempts: %s", ErrResponseValidation, e.Attempts, e.Err)
+}
+
+// Unwrap supports errors.Is and errors.As for both ErrResponseValidation and
+// the validation error.
+func (e *ResponseValidationError) Unwrap() []error {
+	return []error{ErrResponseValidation, e.Err}
+}
+
+// loadResponseSchema returns the schema configured in cfg, or nil if there
+// is none.
+func loadResponseSchema(cfg *Config) (*schema.Schema, error) {
+	switch {
+	case cfg.ResponseSchema != "" && cfg.ResponseSchemaFile != "":
+		return nil, fmt.Errorf("response_schema and response_schema_file both set")
+	case cfg.ResponseSchema != "":
+		return schema.Compile(cfg.ResponseSchema)
+	case cfg.ResponseSchemaFile != "":
+		return schema.CompileFile(cfg.ResponseSchemaFile)
+	}
+	return nil, nil
+}
+
+// ResponseSchema returns the configured response schema, or nil.
+func (a *Agent) ResponseSchema() *schema.Schema {
+	return a.schema
+}
+
+// validateResponse validates content against the response schema, returning
+// the parsed data.  Markdown code fences around the JSON are ignored, since
+// LLMs are fond of them.
+func (a *Agent) validateResponse(content string) (any, error) {
+	return a.schema.ValidateJson(unfence(content))
+}
+
+func unfence(content string) string {
+	s := strings.TrimSpace(content)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") {
+		return content
+	}
+	s = strings.TrimSuffix(s, "```")
+	_, s, _ = strings.Cut(s, "\n") // drop the opening fence and any language
+	return s
+}
+
+// repairPrompt returns the prompt asking the LLM to fix its response.
+func (a *Agent) repairPrompt(err error) string {
+	return fmt.Sprintf(`Your response was not valid: %s
+
+Respond again with only JSON satisfying this JSON Schema:
+
+%s`, err, a.schema)
+}
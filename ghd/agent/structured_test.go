@@ -0,0 +1,149 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/schema"
+)
+
+const answerSchema = `{
+	"type": "object",
+	"required": ["answer"],
+	"properties": {"answer": {"type": "integer"}}
+}`
+
+// schemaClient is a fakeClient that supports native structured output.
+type schemaClient struct {
+	fakeClient
+	schema *schema.Schema
+}
+
+// SetResponseSchema implements SchemaClient.
+func (c *schemaClient) SetResponseSchema(s *schema.Schema) error {
+	c.schema = s
+	return nil
+}
+
+// scriptedResponses returns a respond function giving the responses in order,
+// and recording the prompts.
+func scriptedResponses(prompts *[]string, responses ...string) func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	return func(req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+		*prompts = append(*prompts, req.Content)
+		content := responses[0]
+		if len(responses) > 1 {
+			responses = responses[1:]
+		}
+		return &agent.CompletionResponse{
+			Content: content,
+			Usage:   &agent.Usage{Total: 1},
+		}, nil
+	}
+}
+
+func structuredAgent(t *testing.T, repairs int, prompts *[]string, responses ...string) *agent.Agent {
+	name := "fake-" + strings.ToLower(t.Name())
+	registerFake(name, scriptedResponses(prompts, responses...))
+	cfg := silentConfig("structured", name)
+	cfg.ResponseSchema = answerSchema
+	cfg.ResponseRepairs = repairs
+	a, err := agent.NewAgent(cfg)
+	require.NoError(t, err)
+	return a
+}
+
+func TestResponseSchemaValidOK(t *testing.T) {
+
+	require := require.New(t)
+
+	prompts := []string{}
+	a := structuredAgent(t, 0, &prompts, "```json\n{\"answer\": 42}\n```")
+	res, err := a.RunCompletion(context.Background(), &agent.CompletionRequest{Content: "?"})
+	require.NoError(err)
+	require.Equal(map[string]any{"answer": json.Number("42")}, res.Data)
+	require.Equal([]string{"?"}, prompts)
+	require.NotNil(a.ResponseSchema())
+
+}
+
+func TestResponseSchemaRepairedOK(t *testing.T) {
+
+	require := require.New(t)
+
+	prompts := []string{}
+	a := structuredAgent(t, 2, &prompts, "forty-two", `{"answer":"42"}`, `{"answer":42}`)
+	res, err := a.RunCompletion(context.Background(), &agent.CompletionRequest{Content: "?"})
+	require.NoError(err)
+	require.Equal(`{"answer":42}`, res.Content)
+	require.Equal(map[string]any{"answer": json.Number("42")}, res.Data)
+	require.Equal(3, res.Usage.Total, "repairs count toward usage")
+	require.Equal(1, a.Completed(), "but not as completions")
+	require.Len(prompts, 3)
+	require.Contains(prompts[1], "invalid JSON")
+	require.Contains(prompts[2], "at /answer: got string, want integer")
+	require.Contains(prompts[2], answerSchema)
+
+}
+
+func TestResponseSchemaInvalidFails(t *testing.T) {
+
+	require := require.New(t)
+
+	prompts := []string{}
+	a := structuredAgent(t, 1, &prompts, `{"answer":true}`)
+	_, err := a.RunCompletion(context.Background(), &agent.CompletionRequest{Content: "?"})
+	require.ErrorIs(err, agent.ErrResponseValidation)
+	require.ErrorIs(err, schema.ErrValidation)
+	var verr *agent.ResponseValidationError
+	require.True(errors.As(err, &verr))
+	require.Equal(2, verr.Attempts)
+	require.Equal(`{"answer":true}`, verr.Content)
+	require.ErrorContains(err, "response validation failed after 2 attempts")
+	require.Len(prompts, 2)
+
+}
+
+func TestResponseSchemaNative(t *testing.T) {
+
+	require := require.New(t)
+
+	client := &schemaClient{}
+	client.respond = scriptedResponses(&[]string{}, `{"answer":1}`)
+	agent.RegisterNewApiClientFunc("fake-native-schema", func() (agent.ApiClient, error) {
+		return client, nil
+	})
+	file := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(os.WriteFile(file, []byte(answerSchema), 0644))
+	cfg := silentConfig("native", "fake-native-schema")
+	cfg.ResponseSchemaFile = file
+	a, err := agent.NewAgent(cfg)
+	require.NoError(err)
+	require.Equal(answerSchema, client.schema.String())
+	_, err = a.RunCompletion(context.Background(), &agent.CompletionRequest{Content: "?"})
+	require.NoError(err)
+
+}
+
+func TestResponseSchemaConfigErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := silentConfig("bad", "fake-helper")
+	cfg.ResponseSchema = `{"type":"nope"}`
+	_, err := agent.NewAgent(cfg)
+	require.ErrorIs(err, schema.ErrInvalidSchema)
+
+	cfg.ResponseSchema = answerSchema
+	cfg.ResponseSchemaFile = "schema.json"
+	_, err = agent.NewAgent(cfg)
+	require.ErrorContains(err, "response_schema and response_schema_file both set")
+
+}
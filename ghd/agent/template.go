@@ -0,0 +1,127 @@
+// agent/template.go
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var ErrTemplate = fmt.Errorf("template error")
+
+// PromptVar is the variable holding the user's prompt in a PromptTemplate.
+const PromptVar = "prompt"
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// BuiltinVars returns the variables available to all templates of a:
+//
+//	date              current date, e.g. 2025-04-01
+//	datetime          current date and time in RFC3339 format
+//	agent_name        name of the agent
+//	agent_description description of the agent
+//	model             model of the agent
+//	tools             list of tool names available to the agent
+//
+// Configured variables of the same names take precedence.
+func (a *Agent) BuiltinVars() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"date":              now.Format(time.DateOnly),
+		"datetime":          now.Format(time.RFC3339),
+		"agent_name":        a.Name,
+		"agent_description": a.Description,
+		"model":             a.Model,
+		"tools":             a.Tools(),
+	}
+}
+
+// TemplateVars returns the builtin variables of a, overridden by the
+// configured Vars, overridden in turn by vars.
+func (a *Agent) TemplateVars(vars map[string]string) map[string]any {
+	data := a.BuiltinVars()
+	for k, v := range a.config.Vars {
+		data[k] = v
+	}
+	for k, v := range vars {
+		data[k] = v
+	}
+	return data
+}
+
+// HasPromptTemplate returns true if a has a PromptTemplate configured, in
+// which case a prompt is not necessarily required.
+func (a *Agent) HasPromptTemplate() bool {
+	return a.config.PromptTemplate != ""
+}
+
+// RenderPrompt renders the configured PromptTemplate with vars and the prompt
+// as the "prompt" variable, or if there is no template returns prompt as-is.
+//
+// The prompt variable is only set if prompt is not empty, so templates using
+// it can not be rendered without one.
+func (a *Agent) RenderPrompt(prompt string, vars map[string]string) (string, error) {
+	if a.config.PromptTemplate == "" {
+		return prompt, nil
+	}
+	data := a.TemplateVars(vars)
+	if prompt != "" {
+		data[PromptVar] = prompt
+	}
+	return RenderTemplate("prompt_template", a.config.PromptTemplate, data)
+}
+
+// RenderTemplate renders the Go text/template in src with data.  Missing
+// variables are errors.
+func RenderTemplate(name, src string, data map[string]any) (string, error) {
+	t, err := template.New(name).
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("%w: parsing %s: %w", ErrTemplate, name, err)
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("%w: rendering %s: %w", ErrTemplate, name, err)
+	}
+	return buf.String(), nil
+}
+
+// renderTemplates renders any context templates and checks that the prompt
+// template can be rendered, i.e. that all its variables are available except
+// possibly the prompt itself.  It returns the context to use.
+func (a *Agent) renderTemplates() ([]ContextItem, error) {
+	data := a.TemplateVars(nil)
+	items := make([]ContextItem, len(a.config.Context))
+	for i, c := range a.config.Context {
+		items[i] = c
+		if !c.Template {
+			continue
+		}
+		name := fmt.Sprintf("context item %d", i+1)
+		content, err := RenderTemplate(name, c.Content, data)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Content = content
+		items[i].Template = false
+	}
+	if a.config.PromptTemplate != "" {
+		check := maps.Clone(data)
+		check[PromptVar] = ""
+		if _, err := RenderTemplate("prompt_template", a.config.PromptTemplate, check); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
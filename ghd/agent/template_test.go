@@ -0,0 +1,166 @@
+package agent_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+)
+
+// contextEchoClient responds with its context and the prompt.
+type contextEchoClient struct {
+	agent.BasicApiClient
+}
+
+func (c *contextEchoClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	parts := []string{}
+	for _, item := range c.ContextItems {
+		parts = append(parts, item.Role+": "+item.Content)
+	}
+	parts = append(parts, "user: "+req.Content)
+	return &agent.CompletionResponse{Content: strings.Join(parts, "\n")}, nil
+}
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-context-echo", func() (agent.ApiClient, error) {
+		return &contextEchoClient{}, nil
+	})
+}
+
+func templateConfig() *agent.Config {
+	cfg := silentConfig("templated", "fake-context-echo")
+	cfg.Model = "m1"
+	cfg.Vars = map[string]string{"company": "ACME", "tone": "polite"}
+	cfg.Context = []agent.ContextItem{
+		{Role: "system", Content: "You are {{.agent_name}} at {{.company}}.", Template: true},
+		{Role: "system", Content: "Literal {{.company}}."},
+	}
+	cfg.PromptTemplate = "Be {{.tone}}: {{.prompt}}"
+	return cfg
+}
+
+func TestTemplatesRenderOK(t *testing.T) {
+
+	require := require.New(t)
+
+	a, err := agent.NewAgent(templateConfig())
+	require.NoError(err)
+
+	res, err := a.RunCompletion(context.Background(), &agent.CompletionRequest{
+		Content: "hello",
+		Vars:    map[string]string{"tone": "brief"},
+	})
+	require.NoError(err)
+	exp := `system: You are templated at ACME.
+system: Literal {{.company}}.
+user: Be brief: hello`
+	require.Equal(exp, res.Content)
+
+	content, err := a.RunCompletionPrompt("again")
+	require.NoError(err)
+	require.True(strings.HasSuffix(content, "user: Be polite: again"), content)
+
+}
+
+func TestTemplatesMissingVarsFail(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := templateConfig()
+	delete(cfg.Vars, "company")
+	_, err := agent.NewAgent(cfg)
+	require.ErrorIs(err, agent.ErrTemplate)
+	require.ErrorContains(err, "rendering context item 1")
+	require.ErrorContains(err, `"company"`)
+
+	cfg = templateConfig()
+	delete(cfg.Vars, "tone")
+	_, err = agent.NewAgent(cfg)
+	require.ErrorIs(err, agent.ErrTemplate)
+	require.ErrorContains(err, "rendering prompt_template")
+
+	cfg = templateConfig()
+	cfg.PromptTemplate = "{{.prompt"
+	_, err = agent.NewAgent(cfg)
+	require.ErrorContains(err, "parsing prompt_template")
+
+	// No prompt when the template needs one.
+	a, err := agent.NewAgent(templateConfig())
+	require.NoError(err)
+	_, err = a.RunCompletionPrompt("")
+	require.ErrorIs(err, agent.ErrTemplate)
+	require.ErrorContains(err, `"prompt"`)
+
+}
+
+func TestSpawnWithVars(t *testing.T) {
+
+	require := require.New(t)
+
+	a, err := agent.NewAgent(templateConfig())
+	require.NoError(err)
+
+	spawn, err := a.SpawnWithVars(map[string]string{"company": "Initech"})
+	require.NoError(err)
+	content, err := spawn.RunCompletionPrompt("hi")
+	require.NoError(err)
+	require.True(strings.HasPrefix(content, "system: You are templated at Initech."), content)
+
+	// Original is unaffected.
+	content, err = a.RunCompletionPrompt("hi")
+	require.NoError(err)
+	require.True(strings.HasPrefix(content, "system: You are templated at ACME."), content)
+
+	c, err := agent.NewAgent(silentConfig("c", "fake-context-echo"))
+	require.NoError(err)
+	_, err = c.SpawnWithVars(map[string]string{"x": "y"})
+	require.NoError(err, "vars without any configured")
+
+}
+
+func TestBuiltinVars(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+	require.NoError(registry.Register(testTool("t_one")))
+	require.NoError(registry.Register(testTool("t_two")))
+
+	cfg := silentConfig("builtins", "fake-context-echo", "/^t_/")
+	cfg.Model = "m1"
+	cfg.PromptTemplate = `{{.agent_name}}|{{.agent_description}}|{{.model}}|{{join .tools ","}}|{{.date}}`
+	a, err := agent.NewAgent(cfg)
+	require.NoError(err)
+
+	content, err := a.RunCompletionPrompt("")
+	require.NoError(err)
+	exp := "user: builtins|The builtins agent.|m1|t_one,t_two|" +
+		time.Now().Format(time.DateOnly)
+	require.Equal(exp, content)
+
+	vars := a.BuiltinVars()
+	_, err = time.Parse(time.RFC3339, vars["datetime"].(string))
+	require.NoError(err)
+	require.True(a.HasPromptTemplate())
+
+}
+
+func TestRenderPromptNoTemplate(t *testing.T) {
+
+	require := require.New(t)
+
+	a, err := agent.NewAgent(silentConfig("plain", "fake-context-echo"))
+	require.NoError(err)
+	require.False(a.HasPromptTemplate())
+
+	out, err := a.RenderPrompt("{{.literal}}", map[string]string{"x": "y"})
+	require.NoError(err)
+	require.Equal("{{.literal}}", out)
+
+}
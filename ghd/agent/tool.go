@@ -0,0 +1,246 @@
+// agent/tool.go
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// DefaultAgentToolMaxDepth is the default limit on nested agent tool calls.
+var DefaultAgentToolMaxDepth = 3
+
+var ErrAgentToolConfigInvalid = fmt.Errorf("invalid agent tool config")
+
+var ErrAgentToolDepth = fmt.Errorf("agent tool depth exceeded")
+
+// AgentToolConfig describes an Agent that can be called by other agents as a
+// tool, e.g. a coordinator delegating to specialists.
+//
+// The Agent field is resolved to a Config by the runner; if you are setting
+// up agent tools yourself you must set Config.
+type AgentToolConfig struct {
+	Name        string          `toml:"name"`        // Tool name, required.
+	Description string          `toml:"description"` // Tool description; defaults to the agent's description.
+	Agent       string          `toml:"agent"`       // Named agent or agent config file.
+	Config      *Config         `toml:"config"`      // Agent config; takes precedence over Agent.
+	Persistent  bool            `toml:"persistent"`  // Keep one sub-agent, and its context, for all calls by the same agent and key.
+	MaxDepth    int             `toml:"max_depth"`   // Max nesting of agent tool calls; zero means the default.
+	Metadata    *tools.Metadata `toml:"metadata"`    // Optional metadata for selecting the tool.
+}
+
+// Validate checks that c has the required values, setting defaults as
+// needed.
+func (c *AgentToolConfig) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("%w: empty name", ErrAgentToolConfigInvalid)
+	}
+	if c.Config == nil {
+		return fmt.Errorf("%w: no agent config for %q",
+			ErrAgentToolConfigInvalid, c.Name)
+	}
+	if c.Description == "" {
+		c.Description = c.Config.Description
+	}
+	if strings.TrimSpace(c.Description) == "" {
+		return fmt.Errorf("%w: empty description for %q",
+			ErrAgentToolConfigInvalid, c.Name)
+	}
+	if c.MaxDepth < 0 {
+		return fmt.Errorf("%w: negative max_depth for %q",
+			ErrAgentToolConfigInvalid, c.Name)
+	}
+	if c.MaxDepth == 0 {
+		c.MaxDepth = DefaultAgentToolMaxDepth
+	}
+	if c.Metadata != nil {
+		if err := c.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%w: metadata for %q: %w",
+				ErrAgentToolConfigInvalid, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// AgentToolInput is the input to an agent tool.
+type AgentToolInput struct {
+	Prompt string `json:"prompt" description:"The prompt for the agent."`
+}
+
+// NewAgentTool returns a Tooler that runs a completion with a sub-agent
+// created from cfg, returning the content of its response.
+//
+// Sub-agents are silent, do not stream, and are created on first use: this
+// allows an agent to have itself as a tool, within the depth limit.  All
+// usage of the sub-agent is added to the calling agent, if any, and thus
+// counts toward the caller's limits.  Sub-agents take their tools from the
+// registry of the calling agent, or the default registry if there is none.
+// The same goes for the Approver, so tool calls by sub-agents are approved in
+// the same way as the caller's own.
+//
+// A persistent sub-agent is kept by the calling agent for each access name
+// (see WithAccessName), so its history is never shared between callers, nor
+// between users of the same caller.  While it is busy, e.g. calling itself,
+// other calls get fresh sub-agents.
+func NewAgentTool(cfg *AgentToolConfig) (tools.Tooler, error) {
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	sub_cfg := cfg.Config.Copy()
+	sub_cfg.Silent = true
+	sub_cfg.Stream = false
+
+	newAgent := func(reg *registry.Registry, approver Approver) (*Agent, error) {
+		a, err := NewAgentWithRegistry(sub_cfg, reg)
+		if err != nil {
+			return nil, err
+		}
+		a.SetApprover(approver)
+		return a, nil
+	}
+	// Called without an agent, e.g. directly, persistent sub-agents are kept
+	// here instead.
+	uncalled := &persistentAgents{}
+
+	tool := tools.NewTool[AgentToolInput, string](
+		cfg.Name,
+		cfg.Description,
+		func(ctx context.Context, in AgentToolInput) (string, error) {
+
+			callers := Callers(ctx)
+			if len(callers) >= cfg.MaxDepth {
+				return "", fmt.Errorf("%w: %d", ErrAgentToolDepth, cfg.MaxDepth)
+			}
+			var caller *Agent
+			var approver Approver
+			reg := registry.Default
+			kept := uncalled
+			if len(callers) > 0 {
+				caller = callers[len(callers)-1]
+				if err := caller.CheckLimits(); err != nil {
+					return "", err
+				}
+				reg = caller.Registry()
+				approver = caller.Approver()
+				kept = caller.subAgents
+			}
+
+			var sub *Agent
+			var err error
+			done := func() {}
+			if cfg.Persistent {
+				sub, done, err = kept.get(sub_cfg, AccessName(ctx), func() (*Agent, error) {
+					return newAgent(reg, approver)
+				})
+			} else {
+				sub, err = newAgent(reg, approver)
+			}
+			if err != nil {
+				return "", fmt.Errorf("error creating sub-agent: %w", err)
+			}
+			defer done()
+			if caller != nil {
+				caller.Logger().Info("calling sub-agent", "sub_agent", sub.Ident())
+			}
+
+			// The caller pays for everything the sub-agent does in this call,
+			// including the work of its own sub-agents.
+			usage_before := sub.Usage()
+			completed_before := sub.Completed()
+			res, err := sub.RunCompletion(ctx, &CompletionRequest{Content: in.Prompt})
+			if caller != nil {
+				usage := sub.Usage().Sub(usage_before)
+				caller.AddUsage(&usage, sub.Completed()-completed_before)
+			}
+			if err != nil {
+				return "", err
+			}
+			return res.Content, nil
+		},
+	)
+	if cfg.Metadata != nil {
+		tool.WithMetadata(cfg.Metadata)
+	}
+	return tool, nil
+
+}
+
+// persistentAgents holds the persistent sub-agents of an agent.
+type persistentAgents struct {
+	mutex  sync.Mutex
+	agents map[persistentKey]*persistentAgent
+}
+
+// persistentKey identifies a persistent sub-agent by the config of its tool,
+// which is unique to the tool, and the access name of the call.
+type persistentKey struct {
+	config *Config
+	access string
+}
+
+type persistentAgent struct {
+	agent *Agent
+	busy  bool
+}
+
+// get returns the sub-agent for cfg and access, using create if there is
+// none yet, or if it is busy, along with a function to call when done with
+// it.
+//
+// A persistent sub-agent can only run one completion at a time, so if it is
+// busy -- perhaps calling itself -- a fresh one is used instead.
+func (p *persistentAgents) get(cfg *Config, access string, create func() (*Agent, error)) (*Agent, func(), error) {
+	done := func() {}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := persistentKey{cfg, access}
+	kept := p.agents[key]
+	if kept != nil && kept.busy {
+		a, err := create()
+		return a, done, err
+	}
+	if kept == nil {
+		a, err := create()
+		if err != nil {
+			return nil, done, err
+		}
+		if p.agents == nil {
+			p.agents = map[persistentKey]*persistentAgent{}
+		}
+		kept = &persistentAgent{agent: a}
+		p.agents[key] = kept
+	}
+	kept.busy = true
+	return kept.agent, func() {
+		p.mutex.Lock()
+		kept.busy = false
+		p.mutex.Unlock()
+	}, nil
+}
+
+type callersKey struct{}
+
+// WithCaller returns a copy of ctx with a added to the chain of calling
+// agents.
+//
+// Agents add themselves to the context passed to tools, so that tools (and
+// especially sub-agents) know who is calling them.
+func WithCaller(ctx context.Context, a *Agent) context.Context {
+	callers := Callers(ctx)
+	chain := make([]*Agent, len(callers), len(callers)+1)
+	copy(chain, callers)
+	return context.WithValue(ctx, callersKey{}, append(chain, a))
+}
+
+// Callers returns the chain of calling agents in ctx, outermost first, or
+// nil if there are none.
+func Callers(ctx context.Context) []*Agent {
+	callers, _ := ctx.Value(callersKey{}).([]*Agent)
+	return callers
+}
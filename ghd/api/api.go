@@ -4,6 +4,7 @@ package api
 import (
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -23,11 +24,24 @@ type API struct {
 	logger       *slog.Logger
 	sourceAgents map[string]*agent.Agent
 	activeAgents map[string]*agent.Agent
+	activeMutex  sync.RWMutex // guards activeAgents
 	access       *Access
 	defaultKey   string
+	approvals    *agent.QueueApprover
 }
 
+// DefaultApprovalTimeout is the time tool calls wait for approval through the
+// API, unless configured otherwise, before they are denied.
+var DefaultApprovalTimeout = 5 * time.Minute
+
 // NewAPI creates an API instance.
+//
+// Agents are spawned from agents for each session, and keep their registry:
+// an API whose agents have their own registry (see NewAgentWithRegistry)
+// serves those tools, regardless of the default registry.
+//
+// Tool calls requiring approval are held in a queue for approval through
+// the API, unless the agents have their own Approver.
 func NewAPI(cfg *Config, agents []*agent.Agent) (*API, error) {
 
 	// You really *should* have agents, but you don't *have* to have them.
@@ -73,6 +87,10 @@ func NewAPI(cfg *Config, agents []*agent.Agent) (*API, error) {
 		}
 		sourceAgents[a.Name] = a
 	}
+	approval_timeout := cfg.ApprovalTimeout
+	if approval_timeout == 0 {
+		approval_timeout = DefaultApprovalTimeout
+	}
 	api := &API{
 		ident:        ident,
 		config:       cfg,
@@ -82,6 +100,7 @@ func NewAPI(cfg *Config, agents []*agent.Agent) (*API, error) {
 		activeAgents: map[string]*agent.Agent{},
 		access:       access,
 		defaultKey:   default_auth_key,
+		approvals:    agent.NewQueueApprover(approval_timeout),
 	}
 	// Set up app routes and middleware. NB: ORDER MATTERS.
 	if cfg.LogFiber {
@@ -129,6 +148,13 @@ func (api *API) Listen() error {
 	return api.app.Listen(adrs)
 }
 
+// activeAgent returns the active agent with the given id, or nil.
+func (api *API) activeAgent(id string) *agent.Agent {
+	api.activeMutex.RLock()
+	defer api.activeMutex.RUnlock()
+	return api.activeAgents[id]
+}
+
 // GetKey calls GetKey on the underlying Access of the API.
 func (api *API) GetKey(auth_key string) *Key {
 	return api.access.GetKey(auth_key)
@@ -25,6 +25,9 @@ type Config struct {
 	NoKeys     bool    `toml:"no_keys"`     // DO NOT require API keys.
 	NoUI       bool    `toml:"no_ui"`       // DO NOT expose the web UI.
 
+	// Tool call approval:
+	ApprovalTimeout time.Duration `toml:"approval_timeout"` // Deny pending approvals after this; zero means the default.
+
 	// Fiber app config; see Fiber docs for specifics:
 	AppPrefork                 bool          `toml:"app_prefork"`
 	AppServerHeader            string        `toml:"app_server_header"`
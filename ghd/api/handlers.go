@@ -4,7 +4,9 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -16,11 +18,17 @@ import (
 )
 
 type RequestPayloadAgent struct {
-	Agent string `json:"agent"`
+	Agent string            `json:"agent"`
+	Vars  map[string]string `json:"vars"` // Template variables for the agent's context.
+}
+
+type RequestPayloadDeny struct {
+	Reason string `json:"reason"` // Reason for denial, sent to the LLM.
 }
 
 type RequestPayloadChat struct {
-	Prompt string `json:"prompt"`
+	Prompt string            `json:"prompt"`
+	Vars   map[string]string `json:"vars"` // Template variables for the agent's prompt template.
 }
 
 // HandleRoot is a handler for the root ("/") response.
@@ -80,15 +88,25 @@ func (api *API) HandleAgentsNew(c *fiber.Ctx) error {
 		})
 	}
 
-	spawn, err := src_agent.SpawnSilent()
+	spawn, err := src_agent.SpawnWithVars(payload.Vars)
 	if err != nil {
-		// TODO: log error here!
+		// Template errors are the caller's to fix, e.g. missing vars.
 		api.logger.Error("failed to spawn agent", "error", err)
+		msg := "failed to spawn agent"
+		if errors.Is(err, agent.ErrTemplate) {
+			msg = err.Error()
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "failed to spawn agent",
+			"error": msg,
 		})
 	}
+	spawn.SetPrintFunc(agent.NullPrintFunc)
+	if spawn.Approver() == nil {
+		spawn.SetApprover(api.approvals)
+	}
+	api.activeMutex.Lock()
 	api.activeAgents[spawn.ULID.String()] = spawn
+	api.activeMutex.Unlock()
 	api.logger.Info("spawned new agent", "agent", spawn.Ident())
 	res := fiber.Map{
 		"id":          spawn.ULID,
@@ -104,6 +122,7 @@ func (api *API) HandleAgentsNew(c *fiber.Ctx) error {
 type ChatResponse struct {
 	Content   string            `json:"content"`
 	ToolCalls []*agent.ToolCall `json:"tool_calls"`
+	Data      any               `json:"data,omitempty"`
 }
 
 // HandleAgentsChat is a handler for executing a simple chat request.
@@ -120,6 +139,7 @@ func (api *API) HandleAgentsChat(c *fiber.Ctx) error {
 	chat_res := &ChatResponse{
 		Content:   res.Content,
 		ToolCalls: res.ToolCalls,
+		Data:      res.Data,
 	}
 	return c.JSON(chat_res)
 
@@ -139,24 +159,60 @@ func (api *API) HandleAgentsCompletion(c *fiber.Ctx) error {
 
 }
 
+// requestAgent returns the active agent for the agent_id of the request,
+// or an error if there is none, or the key may not use that agent.
+//
+// Why not bind the agent to the key?  First, it seems overkill, since you
+// could also just "steal" the AuthKey if security is the concern.  But you
+// might also have some workflow in which one key creates agents for another
+// key to use, that is less exotic than it sounds at first: imagine you have
+// a set of workers and they are allowed one agent each, but you have a
+// master worker assigning them.
+//
+// In any case, here we check that the type (name) of agent is allowed and
+// thus also handle the (even farther-fetched?) case of revoking access to a
+// type of agent for a key.  Once reloading keys works.
+func (api *API) requestAgent(c *fiber.Ctx) (*agent.Agent, error) {
+
+	a := api.activeAgent(c.Params("agent_id"))
+	if a == nil {
+		return nil, fiber.ErrNotFound
+	}
+	key, _ := c.Locals("access_key").(*Key)
+	if key != nil && !api.access.AgentAllowed(key, a.Name) {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Agent not allowed")
+	}
+	return a, nil
+
+}
+
 // shared logic for chat handlers.
 func (api *API) runAgentCompletion(c *fiber.Ctx) (*agent.CompletionResponse, error) {
 
-	id := c.Params("agent_id")
-	active_agent := api.activeAgents[id]
-	if active_agent == nil {
-		return nil, fiber.ErrNotFound
+	active_agent, err := api.requestAgent(c)
+	if err != nil {
+		return nil, err
 	}
 
 	// Set up a context that *should* (depending on the underlying client
 	// setup) cancel the LLM request when Fiber times out or detects that
 	// the client has disconnected.
+	// (The Fiber ctx is reused after the handler returns, so only its done
+	// channel is used by the goroutine.)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go func() {
-		<-c.Context().Done() // fasthttp.RequestCtx Done()
-		cancel()
-	}()
+	go func(req_done, done <-chan struct{}) {
+		select {
+		case <-req_done:
+			cancel()
+		case <-done:
+		}
+	}(c.Context().Done(), ctx.Done()) // fasthttp.RequestCtx Done()
+
+	// Tools may keep data per key, e.g. memories.
+	if key, ok := c.Locals("access_key").(*Key); ok && key != nil {
+		ctx = agent.WithAccessName(ctx, key.Name)
+	}
 
 	var payload RequestPayloadChat
 	if err := c.BodyParser(&payload); err != nil {
@@ -164,14 +220,33 @@ func (api *API) runAgentCompletion(c *fiber.Ctx) (*agent.CompletionResponse, err
 			"error": "invalid JSON payload",
 		})
 	}
-	if strings.TrimSpace(payload.Prompt) == "" {
+	if strings.TrimSpace(payload.Prompt) == "" && !active_agent.HasPromptTemplate() {
 		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "empty prompt",
 		})
 	}
 
-	req := &agent.CompletionRequest{Content: payload.Prompt}
+	// Tools made by tools in earlier completions are available now.
+	if active_agent.AllowsToolChanges() {
+		if err := active_agent.RefreshTools(); err != nil {
+			return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	req := &agent.CompletionRequest{Content: payload.Prompt, Vars: payload.Vars}
 	res, err := active_agent.RunCompletion(ctx, req)
+	if errors.Is(err, agent.ErrTemplate) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, agent.ErrResponseValidation) {
+		return nil, c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	if err != nil {
 		// TODO: sniff out user vs agent vs llm errors
 		// TODO: handle non-error errors appropriately e.g. finished...
@@ -264,13 +339,83 @@ func (api *API) HandleUI(c *fiber.Ctx) error {
 // TODO: prove that completion requests in flight are unaffected.
 func (api *API) HandleAgentsEnd(c *fiber.Ctx) error {
 
+	if _, err := api.requestAgent(c); err != nil {
+		return err
+	}
+
+	api.activeMutex.Lock()
+	delete(api.activeAgents, c.Params("agent_id"))
+	api.activeMutex.Unlock()
+
+	return c.JSON(fiber.Map{"success": true})
+
+}
+
+// HandleAgentsApprovals is a handler for listing the tool calls pending
+// approval for an agent, including calls by its sub-agents.
+//
+// Completions with pending calls are paused until the calls are approved or
+// denied, or they time out and are denied.
+func (api *API) HandleAgentsApprovals(c *fiber.Ctx) error {
+
+	if _, err := api.requestAgent(c); err != nil {
+		return err
+	}
+
 	id := c.Params("agent_id")
-	active_agent := api.activeAgents[id]
-	if active_agent == nil {
-		return fiber.ErrNotFound
+	return c.JSON(fiber.Map{"approvals": api.approvals.Pending(id)})
+
+}
+
+// HandleAgentsApprove is a handler for approving a pending tool call.
+func (api *API) HandleAgentsApprove(c *fiber.Ctx) error {
+
+	return api.decideApproval(c, &agent.Approval{Approved: true})
+
+}
+
+// HandleAgentsDeny is a handler for denying a pending tool call.  The reason
+// in the payload, if any, is sent to the LLM.
+func (api *API) HandleAgentsDeny(c *fiber.Ctx) error {
+
+	var payload RequestPayloadDeny
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid JSON payload",
+			})
+		}
+	}
+	reason := strings.TrimSpace(payload.Reason)
+	if reason == "" {
+		reason = "denied by user"
+	}
+	return api.decideApproval(c, &agent.Approval{Reason: reason})
+
+}
+
+// shared logic for approval handlers.
+func (api *API) decideApproval(c *fiber.Ctx, approval *agent.Approval) error {
+
+	if _, err := api.requestAgent(c); err != nil {
+		return err
 	}
 
-	delete(api.activeAgents, id)
+	// The approval must be pending for this agent, not just any agent.
+	id := c.Params("agent_id")
+	approval_id := c.Params("approval_id")
+	pending := api.approvals.Pending(id)
+	if !slices.ContainsFunc(pending, func(p *agent.PendingApproval) bool {
+		return p.Id == approval_id
+	}) {
+		return fiber.ErrNotFound
+	}
+	if err := api.approvals.Decide(approval_id, approval); err != nil {
+		// Timed out or decided since we looked.
+		return fiber.ErrNotFound
+	}
+	api.logger.Info("tool call decided", "agent_id", id,
+		"approval_id", approval_id, "approved", approval.Approved)
 
 	return c.JSON(fiber.Map{"success": true})
 
@@ -0,0 +1,208 @@
+// Note that these tests use the api package, for the Fiber app, which is
+// not otherwise exposed.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// guardedClient calls the guarded tool for a prompt, and returns its output
+// data, or error, as content.
+type guardedClient struct {
+	agent.BasicApiClient
+}
+
+// RunCompletion implements ApiClient.
+func (c *guardedClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	if len(req.ToolResults) == 0 {
+		return &agent.CompletionResponse{
+			ToolCalls: []*agent.ToolCall{{Id: "1", Name: "guarded", Args: `{"val":"x"}`}},
+		}, nil
+	}
+	res := req.ToolResults[0].Output.(*tools.Result)
+	content := fmt.Sprint(res.Data)
+	if !res.Ok {
+		content = res.Error
+	}
+	return &agent.CompletionResponse{Content: content}, nil
+}
+
+type guardedInput struct {
+	Val string `json:"val"`
+}
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-api-guarded", func() (agent.ApiClient, error) {
+		return &guardedClient{}, nil
+	})
+}
+
+// Return an API with agents "guarded", whose tool calls need approval, and
+// "other"; the key "admin" may use both, "limited" only "other".
+func testAPI(t *testing.T) *API {
+	reg := registry.New()
+	require.NoError(t, reg.Register(tools.NewTool[guardedInput, string](
+		"guarded", "Guarded tool.",
+		func(ctx context.Context, in guardedInput) (string, error) {
+			return "guarded " + in.Val, nil
+		})))
+	agents := []*agent.Agent{}
+	for _, name := range []string{"guarded", "other"} {
+		a, err := agent.NewAgentWithRegistry(&agent.Config{
+			Name:            name,
+			Description:     "The " + name + " agent.",
+			Type:            "fake-api-guarded",
+			Silent:          true,
+			Tools:           []*rgxp.OptionalRgxp{rgxp.MustParseOptional("guarded")},
+			RequireApproval: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("guarded")},
+		}, reg)
+		require.NoError(t, err)
+		agents = append(agents, a)
+	}
+	all := []*rgxp.OptionalRgxp{rgxp.MustParseOptional("/.*/")}
+	api, err := NewAPI(&Config{
+		RawKeys: true,
+		NoUI:    true,
+		Roles: []*Role{
+			{Name: "admin", Endpoints: all, Agents: all},
+			{Name: "limited", Endpoints: all,
+				Agents: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("other")}},
+		},
+		Keys: []*Key{
+			{AuthKey: "admin", Name: "Admin", RoleNames: []string{"admin"}},
+			{AuthKey: "limited", Name: "Limited", RoleNames: []string{"limited"}},
+		},
+	}, agents)
+	require.NoError(t, err)
+	return api
+}
+
+// Send a request to api with key, returning the status and the decoded body.
+func testRequest(t *testing.T, api *API, method, path, key, body string) (int, map[string]any) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	res, err := api.app.Test(req, -1)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	data := map[string]any{}
+	json.NewDecoder(res.Body).Decode(&data) // errors are plain text
+	return res.StatusCode, data
+}
+
+// Spawn a new agent by name, returning its id.
+func testSpawn(t *testing.T, api *API, name string) string {
+	status, data := testRequest(t, api, "POST", "/v1/agents/new", "admin",
+		`{"agent":"`+name+`"}`)
+	require.Equal(t, http.StatusOK, status, data)
+	return data["id"].(string)
+}
+
+// Wait for an approval pending for agent id, returning its id.
+func testAwaitApproval(t *testing.T, api *API, id string) string {
+	for range 200 {
+		status, data := testRequest(t, api, "GET", "/v1/agents/"+id+"/approvals", "admin", "")
+		require.Equal(t, http.StatusOK, status, data)
+		if list := data["approvals"].([]any); len(list) > 0 {
+			return list[0].(map[string]any)["id"].(string)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("no pending approval")
+	return ""
+}
+
+func TestHandleAgentsApprovals(t *testing.T) {
+
+	require := require.New(t)
+
+	api := testAPI(t)
+	id := testSpawn(t, api, "guarded")
+	other_id := testSpawn(t, api, "other")
+
+	for _, decision := range []struct {
+		action, body, content string
+	}{
+		{"approve", "", "guarded x"},
+		{"deny", `{"reason":"nope"}`, "tool call denied: nope"},
+		{"deny", "", "tool call denied: denied by user"},
+	} {
+		done := make(chan map[string]any)
+		go func() {
+			_, data := testRequest(t, api, "POST", "/v1/agents/"+id+"/chat", "admin",
+				`{"prompt":"go"}`)
+			done <- data
+		}()
+		approval_id := testAwaitApproval(t, api, id)
+
+		status, _ := testRequest(t, api, "GET", "/v1/agents/nonesuch/approvals", "admin", "")
+		require.Equal(http.StatusNotFound, status, "unknown agent")
+		status, data := testRequest(t, api, "GET", "/v1/agents/"+other_id+"/approvals", "admin", "")
+		require.Equal(http.StatusOK, status)
+		require.Empty(data["approvals"], "other agent")
+		status, _ = testRequest(t, api, "GET", "/v1/agents/"+id+"/approvals", "limited", "")
+		require.Equal(http.StatusUnauthorized, status, "wrong key")
+
+		for path, exp := range map[string]int{
+			"/v1/agents/nonesuch/approvals/" + approval_id:         http.StatusNotFound,
+			"/v1/agents/" + id + "/approvals/nonesuch":             http.StatusNotFound,
+			"/v1/agents/" + other_id + "/approvals/" + approval_id: http.StatusNotFound,
+		} {
+			status, _ = testRequest(t, api, "POST", path+"/"+decision.action, "admin", decision.body)
+			require.Equal(exp, status, path)
+		}
+		path := "/v1/agents/" + id + "/approvals/" + approval_id + "/" + decision.action
+		status, _ = testRequest(t, api, "POST", path, "limited", decision.body)
+		require.Equal(http.StatusUnauthorized, status, "wrong key")
+
+		status, data = testRequest(t, api, "POST", path, "admin", decision.body)
+		require.Equal(http.StatusOK, status, data)
+		require.Equal(true, data["success"])
+		require.Equal(decision.content, (<-done)["content"])
+
+		status, _ = testRequest(t, api, "POST", path, "admin", decision.body)
+		require.Equal(http.StatusNotFound, status, "already decided")
+	}
+
+	status, _ := testRequest(t, api, "POST", "/v1/agents/"+id+"/approvals/x/deny", "admin", "{")
+	require.Equal(http.StatusBadRequest, status, "bad payload")
+
+}
+
+func TestHandleAgentsConcurrent(t *testing.T) {
+
+	require := require.New(t)
+
+	api := testAPI(t)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := testSpawn(t, api, "other")
+			status, _ := testRequest(t, api, "GET", "/v1/agents/"+id+"/approvals", "limited", "")
+			require.Equal(http.StatusOK, status)
+			status, _ = testRequest(t, api, "POST", "/v1/agents/"+id+"/end", "admin", "")
+			require.Equal(http.StatusOK, status)
+			status, _ = testRequest(t, api, "GET", "/v1/agents/"+id+"/approvals", "admin", "")
+			require.Equal(http.StatusNotFound, status)
+		}()
+	}
+	wg.Wait()
+
+}
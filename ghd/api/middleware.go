@@ -51,26 +51,8 @@ func (api *API) KeyAccess() fiber.Handler {
 			})
 		}
 
-		// If we have an agent ID, authz for that too.
-		//
-		// Why not bind the agent to the key?  First, it seems overkill, since
-		// you could also just "steal" the AuthKey if security is the concern.
-		// But you might also have some workflow in which one key creates
-		// agents for another key to use, that is less exotic than it sounds
-		// at first: imagine you have a set of workers and they are allowed
-		// one agent each, but you have a master worker assigning them.
-		//
-		// In any case, here we check that the type (name) of agent is allowed
-		// and thus also handle the (even farther-fetched?) case of revoking
-		// access to a type of agent for a key.  Once reloading keys works.
-		id := c.Params("agent_id")
-		a := api.activeAgents[id]
-		if a != nil && !api.access.AgentAllowed(key, a.Name) {
-			// NB: nil-agent will get caught downstream
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Agent not allowed",
-			})
-		}
+		// Access to agents by ID is checked by the handlers, as the route
+		// params are not known here: see requestAgent.
 
 		// All good!
 		slogfiber.AddCustomAttributes(c, slog.String("access", key.Name))
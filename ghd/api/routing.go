@@ -34,6 +34,18 @@ func (api *API) setRoutes() {
 		return api.HandleAgentsEnd(c)
 	})
 
+	api.app.Get("/v1/agents/:agent_id/approvals", func(c *fiber.Ctx) error {
+		return api.HandleAgentsApprovals(c)
+	})
+
+	api.app.Post("/v1/agents/:agent_id/approvals/:approval_id/approve", func(c *fiber.Ctx) error {
+		return api.HandleAgentsApprove(c)
+	})
+
+	api.app.Post("/v1/agents/:agent_id/approvals/:approval_id/deny", func(c *fiber.Ctx) error {
+		return api.HandleAgentsDeny(c)
+	})
+
 	if !api.config.NoUI {
 		api.app.Get("/v1/ui", func(c *fiber.Ctx) error {
 			return api.HandleUI(c)
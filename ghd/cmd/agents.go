@@ -3,6 +3,9 @@
 package cmd
 
 import (
+	"os"
+	"os/signal"
+
 	"github.com/spf13/cobra"
 
 	"github.com/biztos/greenhead/ghd/runner"
@@ -10,7 +13,7 @@ import (
 
 // AgentsCmd represents the "agents" command set.
 var AgentsCmd = &cobra.Command{
-	Use:   "agents [list|check|run]",
+	Use:   "agents [list|check|run|batch]",
 	Short: "Work with agents.",
 	Long: `The agents commands help manage the configured agents.
 
@@ -33,6 +36,7 @@ If no agents are configured, shows the available named agents, if any.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.ListAgents(Stdout)
 	},
 }
@@ -57,6 +61,7 @@ Output is logged, and on success only the message "OK" is printed.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.CheckAgents(Stdout)
 
 	},
@@ -66,27 +71,81 @@ var agentsRunJson = false
 
 // AgentsRunCmd represents the "agents run" subcommand.
 var AgentsRunCmd = &cobra.Command{
-	Use:   "run [--json] $MY_PROMPT",
+	Use:   "run [--json] [$MY_PROMPT]",
 	Short: "Run a completion with the configured agents.",
 	Long: `Runs a single completion with each configured agent, sequentially.
 
 The completion may include tool calls, which will be executed.
 
-If --json is specified, the output will be in JSON format.
+If --json is specified, the output will be in JSON format.  For agents with a
+response_schema, this includes the validated response as "data".
 
 If the prompt begins with '@' then it will be read from a file, e.g. @foo.txt.
 
+Agents with a prompt_template render it with any template variables, e.g.
+those set with --var, and the prompt as the "prompt" variable.  For such
+agents the prompt may be omitted, unless the template uses it.
+
 In order to pipe output, e.g. to jq, it is advisable to use the --silent flag
 and not use the --stream flag.  This is the recommended way to capture output
 for multiple agents in a single run.
 `,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r, err := runner.NewRunner(Config)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		prompt := ""
+		if len(args) > 0 {
+			prompt = args[0]
+		}
+		return r.RunAgents(Stdout, prompt, agentsRunJson)
+	},
+}
+
+var agentsBatchOptions = &runner.BatchOptions{}
+
+// AgentsBatchCmd represents the "agents batch" subcommand.
+var AgentsBatchCmd = &cobra.Command{
+	Use:   "batch --input FILE --output FILE",
+	Short: "Run an agent over a file of prompts.",
+	Long: `Runs the configured agent over each item in the input file, with a fresh
+agent for each item, writing the results to the output file in JSONL format.
+
+Exactly one agent must be configured.
+
+The input file may be JSONL, with records like:
+
+	{"id": "a1", "prompt": "Tell me about tigers.", "vars": {"tone": "dry"}}
+
+Or CSV with a header row, in which case columns other than "id" and "prompt"
+are taken as template vars.  Items without ids are numbered.  As usual, vars
+used by the agent's templates must have defaults.
+
+Output records include the content, tool calls, usage and any error.  Items
+are written as they complete, so the output is also a checkpoint: with
+--resume, items already completed without error are skipped.  Without it, the
+output file must not exist.
+
+Transient errors such as rate limits are retried with exponential backoff.
+
+A summary is printed at the end.  If any items failed, the exit code is
+nonzero.
+`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		r, err := runner.NewRunner(Config)
 		if err != nil {
 			return err
 		}
-		return r.RunAgents(Stdout, args[0], agentsRunJson)
+		defer r.Close()
+		// Interrupted batches can be resumed, so stop cleanly.
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		_, err = r.RunBatch(ctx, Stdout, agentsBatchOptions)
+		return err
 	},
 }
 
@@ -108,6 +167,7 @@ For named colors, a foreground/background pair can be specified as "fg/bg":
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.PrintColors(Stdout, args...)
 	},
 }
@@ -117,11 +177,26 @@ func init() {
 	// Flags:
 	AgentsRunCmd.Flags().BoolVar(&agentsRunJson, "json", false,
 		"Print the whole completion as JSON.")
+	AgentsBatchCmd.Flags().StringVar(&agentsBatchOptions.Input, "input", "",
+		"Input file (JSONL or CSV).")
+	AgentsBatchCmd.Flags().StringVar(&agentsBatchOptions.Output, "output", "",
+		"Output file (JSONL).")
+	AgentsBatchCmd.Flags().IntVar(&agentsBatchOptions.Concurrency, "concurrency", 4,
+		"Number of items to run at once.")
+	AgentsBatchCmd.Flags().IntVar(&agentsBatchOptions.Retries, "retries", 3,
+		"Retries of transient errors per item.")
+	AgentsBatchCmd.Flags().DurationVar(&agentsBatchOptions.RetryDelay, "retry-delay", runner.DefaultBatchRetryDelay,
+		"Delay before the first retry, doubling for each retry.")
+	AgentsBatchCmd.Flags().BoolVar(&agentsBatchOptions.Resume, "resume", false,
+		"Resume from the output file, skipping items done.")
+	AgentsBatchCmd.MarkFlagRequired("input")
+	AgentsBatchCmd.MarkFlagRequired("output")
 
 	// Registration:
 	AgentsCmd.AddCommand(AgentsListCmd)
 	AgentsCmd.AddCommand(AgentsCheckCmd)
 	AgentsCmd.AddCommand(AgentsRunCmd)
+	AgentsCmd.AddCommand(AgentsBatchCmd)
 	AgentsCmd.AddCommand(AgentsColorCmd)
 	RootCmd.AddCommand(AgentsCmd)
 }
@@ -30,6 +30,7 @@ If --raw-keys is set then the key should not be encoded.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.CheckKey(Stdout, args[0])
 	},
 }
@@ -59,6 +60,7 @@ the output difficult to parse.
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		r.EncodeKeys(Stdout, args)
 		return nil
 	},
@@ -78,6 +80,7 @@ Does NOT begin listening for requests.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.CheckAPI(Stdout)
 	},
 }
@@ -102,6 +105,7 @@ equivalent, be used for testing only.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		// TODO: make sure no error message from this return style if we
 		// cleanly kill the server.  Or we want an error?
 		return r.ServeAPI(Stdout)
@@ -25,6 +25,7 @@ Logs will be written to "chat.log" by default.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.RunChat()
 
 	},
@@ -37,6 +37,7 @@ Comments are *not* preserved at this time.
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		if ConfigDumpJson {
 			r.Config.DumpJson(Stdout)
 		} else {
@@ -55,8 +56,11 @@ var ConfigCheckCmd = &cobra.Command{
 Succeeds silently if there are no errors.`,
 	Args: cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		_, err := runner.NewRunner(Config)
-		return err
+		r, err := runner.NewRunner(Config)
+		if err != nil {
+			return err
+		}
+		return r.Close()
 	},
 }
 
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/biztos/greenhead/ghd/tools/search"
+)
+
+// IndexCmd represents the "index" command set.
+var IndexCmd = &cobra.Command{
+	Use:   "index [build|status]",
+	Short: "Manage the document search index.",
+	Long: `The index commands manage the index used by the search_docs tool.
+
+The index and the documents to put in it are set in the search_tools section
+of the runner config, e.g.:
+
+    [search_tools]
+      index = "/var/lib/ghd/docs.index"
+      dirs = ["docs"]
+
+The index is not updated automatically: rebuild it when the documents change.
+Running agents use the new index as soon as it is built.`,
+}
+
+// IndexBuildCmd represents the "index build" subcommand.
+var IndexBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Build the document search index.",
+	Long: `Indexes the configured documents, replacing any existing index.
+
+Hidden files and directories are skipped, as are files too large or not
+looking like text.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Config.SearchTools == nil {
+			return fmt.Errorf("no search_tools in config")
+		}
+		start := time.Now()
+		idx, err := Config.SearchTools.BuildIndex()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "Indexed %d files, %d passages, %d terms in %s.\n",
+			len(idx.Files), len(idx.Chunks), idx.Terms(), time.Since(start).Round(time.Millisecond))
+		fmt.Fprintf(Stdout, "Saved to %s\n", Config.SearchTools.Index)
+		return nil
+	},
+}
+
+// IndexStatusCmd represents the "index status" subcommand.
+var IndexStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the document search index.",
+	Long: `Shows the size of the index and whether it is up to date, listing any
+documents added, changed or removed since it was built.
+
+Documents are compared by size and modification time.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Config.SearchTools == nil {
+			return fmt.Errorf("no search_tools in config")
+		}
+		s, err := search.GetStatus(Config.SearchTools)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "Index:     %s\n", s.Index)
+		fmt.Fprintf(Stdout, "Built:     %s\n", s.Built.Format(time.RFC3339))
+		fmt.Fprintf(Stdout, "Files:     %d\n", s.Files)
+		fmt.Fprintf(Stdout, "Passages:  %d\n", s.Chunks)
+		fmt.Fprintf(Stdout, "Terms:     %d\n", s.Terms)
+		if !s.Stale() {
+			fmt.Fprintln(Stdout, "Status:    up to date")
+			return nil
+		}
+		fmt.Fprintln(Stdout, "Status:    stale (run: ghd index build)")
+		if s.ConfigChanged {
+			fmt.Fprintln(Stdout, "Config changed since the build.")
+		}
+		for _, list := range []struct {
+			label string
+			paths []string
+		}{{"Added", s.Added}, {"Changed", s.Changed}, {"Removed", s.Removed}} {
+			if len(list.paths) == 0 {
+				continue
+			}
+			fmt.Fprintf(Stdout, "%s:\n", list.label)
+			for _, p := range list.paths {
+				fmt.Fprintf(Stdout, "  %s\n", p)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	IndexCmd.AddCommand(IndexBuildCmd)
+	IndexCmd.AddCommand(IndexStatusCmd)
+	RootCmd.AddCommand(IndexCmd)
+}
@@ -44,6 +44,7 @@ run can be very costly.  Likewise, if output is not monitored it may be lost.
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.RunPair(args[0], Stdout)
 	},
 }
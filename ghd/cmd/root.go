@@ -41,6 +41,8 @@ var Stderr io.Writer = os.Stderr
 
 var runnerConfigFile string
 var agentConfigFiles []string
+var varsFile string
+var varArgs []string
 
 // RootCmd is the Cobra Root and may be changed to suit after initialization.
 var RootCmd = &cobra.Command{
@@ -50,6 +52,12 @@ var RootCmd = &cobra.Command{
 	SilenceUsage: true,        // Do not spam usage all the time!
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 
+		// Template variables come from flags, env and file, and likewise
+		// override those in config files.
+		if err := Config.LoadVars(varsFile, os.Environ(), varArgs); err != nil {
+			return fmt.Errorf("error loading vars: %w", err)
+		}
+
 		// We already have a config with flags; load any config files, letting
 		// the flags override.
 		if err := Config.LoadConfigs(runnerConfigFile, agentConfigFiles...); err != nil {
@@ -90,6 +98,12 @@ func init() {
 	RootCmd.PersistentFlags().StringArrayVar(&agentConfigFiles, "agent", []string{},
 		"Config file from which to read the agent configuration.")
 
+	// Template variables:
+	RootCmd.PersistentFlags().StringArrayVar(&varArgs, "var", []string{},
+		"Set a template variable as KEY=VALUE.")
+	RootCmd.PersistentFlags().StringVar(&varsFile, "vars-file", "",
+		"File (TOML or JSON) from which to read template variables.")
+
 	// Output control:
 	RootCmd.PersistentFlags().BoolVarP(&Config.Debug, "debug", "d", false,
 		"Log at DEBUG level (default is INFO).")
@@ -123,6 +137,9 @@ func init() {
 	RootCmd.PersistentFlags().Var(&rgxp.RgxpArrayValue{Rgxps: &Config.StopMatches},
 		"stop-match",
 		"Stop running if any completion content matches.")
+	RootCmd.PersistentFlags().Var(&rgxp.OptionalRgxpArrayValue{OptionalRgxps: &Config.RequireApproval},
+		"require-approval",
+		"Require approval for tool calls by name, regexp or selector.")
 
 	// Tools:
 	// Note: tool selection is a bit complicated and should be covered in the
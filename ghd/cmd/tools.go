@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/rgxp"
 	"github.com/biztos/greenhead/ghd/runner"
 	"github.com/biztos/greenhead/ghd/utils"
 )
@@ -15,6 +16,8 @@ const ExitCodeToolsError = 2
 
 var toolsListNames bool
 
+var toolsListSelect []*rgxp.OptionalRgxp
+
 // ToolsCmd represents the "tools" command set.
 var ToolsCmd = &cobra.Command{
 	Use:   "tools [list|show NAME]",
@@ -31,18 +34,27 @@ explicitly enabled.`,
 
 // ToolsListCmd represents the "tools list" subcommand.
 var ToolsListCmd = &cobra.Command{
-	Use:     "list [--names]",
+	Use:     "list [--names] [--select SELECTOR]",
 	Aliases: []string{"ls"},
 	Short:   "List all registered tools which can be enabled for agents.",
 	Long: `Lists all the registered tools, optionally only listing names.
 
+Tools can be selected by name, /regexp/ or metadata, as in agent configs:
+
+    ghd tools list --select tag:fs --select 'risk<=write'
+
+Metadata selectors are tag:TAG, owner:OWNER, version:VERSION, and risk with
+any of : = < <= > >= and a level: read-only, write, network, destructive or
+unknown.
+
 For important caveats, see the parent command's help text.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		r, err := runner.NewRunner(Config)
 		if err != nil {
 			return err
 		}
-		return r.ListTools(Stdout, toolsListNames)
+		defer r.Close()
+		return r.ListTools(Stdout, toolsListNames, toolsListSelect...)
 	},
 }
 
@@ -62,6 +74,7 @@ For important caveats, see the parent command's help text.`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		return r.ShowTool(Stdout, args[0])
 	},
 }
@@ -99,6 +112,8 @@ func init() {
 	// Flags:
 	ToolsListCmd.Flags().BoolVar(&toolsListNames, "names", false,
 		"Show only tool names.")
+	ToolsListCmd.Flags().Var(&rgxp.OptionalRgxpArrayValue{OptionalRgxps: &toolsListSelect},
+		"select", "Show only tools matching name, /regexp/ or metadata selector (repeatable).")
 
 	// Registration:
 	ToolsCmd.AddCommand(ToolsListCmd)
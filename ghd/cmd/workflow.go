@@ -0,0 +1,75 @@
+// cmd/workflow.go
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/biztos/greenhead/ghd/runner"
+	"github.com/biztos/greenhead/ghd/workflow"
+)
+
+var workflowRunJson = false
+
+// WorkflowCmd represents the "workflow" command set.
+var WorkflowCmd = &cobra.Command{
+	Use:   "workflow [run]",
+	Short: "Work with workflows.",
+	Long: `The workflow commands run declarative pipelines of agents and tools.
+
+A workflow is a TOML (or JSON) file with a list of steps, each of which may
+run an agent prompt, call a tool directly, and/or branch to another step based
+on the outputs of previous steps.
+
+Workflows use the regular runner config, and may also name their own runner
+and agent configs.`,
+}
+
+// WorkflowRunCmd represents the "workflow run" subcommand.
+var WorkflowRunCmd = &cobra.Command{
+	Use:   "run [--json] FILE",
+	Short: "Run a workflow.",
+	Long: `Runs the workflow defined in FILE.
+
+Template variables, e.g. those set with --var, override the defaults in the
+workflow.
+
+The final output is printed on success.  If --json is specified, the whole run
+record is printed instead, including on failure.
+
+As with "agents run," it is advisable to use the --silent flag and not use the
+--stream flag in order to capture output.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wf, err := workflow.LoadConfig(args[0])
+		if err != nil {
+			return err
+		}
+		if wf.RunnerConfig != "" || len(wf.AgentConfigs) > 0 {
+			err := Config.LoadConfigs(wf.RunnerConfig, wf.AgentConfigs...)
+			if err != nil {
+				return fmt.Errorf("error loading workflow config: %w", err)
+			}
+		}
+		r, err := runner.NewRunner(Config)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return r.RunWorkflow(Stdout, wf, Config.Vars, workflowRunJson)
+	},
+}
+
+func init() {
+
+	// Flags:
+	WorkflowRunCmd.Flags().BoolVar(&workflowRunJson, "json", false,
+		"Print the whole run record as JSON.")
+
+	// Registration:
+	WorkflowCmd.AddCommand(WorkflowRunCmd)
+	RootCmd.AddCommand(WorkflowCmd)
+}
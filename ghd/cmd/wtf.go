@@ -30,6 +30,7 @@ Maybe just hook into pre/postrun funcs?`,
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		r.Logger.Info("WTF info")
 		r.Logger.Debug("bugggggin'")
 		r.Logger.Warn("Open your eyes!", "vision", 1.245)
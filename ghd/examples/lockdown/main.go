@@ -96,6 +96,7 @@ If the prompt begins with '@' then it will be read from a file, e.g. @foo.txt.
 			if err != nil {
 				return err
 			}
+			defer r.Close()
 			return r.RunAgents(Stdout, args[0], false)
 		},
 	}
@@ -112,6 +113,7 @@ If the prompt begins with '@' then it will be read from a file, e.g. @foo.txt.
 			if err != nil {
 				return err
 			}
+			defer r.Close()
 			return r.ListTools(Stdout, false)
 		},
 	}
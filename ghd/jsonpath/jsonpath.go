@@ -0,0 +1,196 @@
+// Package jsonpath provides a simple subset of the usual JSON path syntax,
+// for finding values in decoded JSON, as used by policy rules and workflow
+// cases:
+//
+//	$.name        the "name" key of the root object
+//	$.a.b         nested keys
+//	$['a b']      a key that is not a simple name; also $["a b"]
+//	$.values[0]   an array element
+//	$.values[*]   all array elements; also .* for all object values
+//	$             the root itself
+//
+// The leading "$" may be omitted, so "name" is the same as "$.name", and
+// "[0]" the same as "$[0]".
+//
+// Find returns all values found, skipping missing ones, while Lookup
+// returns the single value found, and an error if there is none:
+//
+//	p, err := jsonpath.Parse("$.items[*].name")
+//	if err != nil {
+//		return err
+//	}
+//	names := p.Find(decoded)
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalid = fmt.Errorf("invalid JSON path")
+
+var ErrNoValue = fmt.Errorf("no value at JSON path")
+
+// step is one step of a Path: an object key, an array index or a wildcard.
+type step struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Path is a parsed JSON path.
+type Path struct {
+	src   string
+	steps []step
+}
+
+// Parse parses src as a Path.
+func Parse(src string) (*Path, error) {
+	s := strings.TrimSpace(src)
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalid)
+	}
+	if strings.HasPrefix(s, "$") {
+		s = s[1:]
+	} else if !strings.HasPrefix(s, "[") {
+		s = "." + s
+	}
+	p := &Path{src: src}
+	for s != "" {
+		switch s[0] {
+		case '.':
+			end := strings.IndexAny(s[1:], ".[")
+			if end < 0 {
+				end = len(s) - 1
+			}
+			key := s[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("%w: %q: empty key", ErrInvalid, src)
+			}
+			if strings.Contains(key, "]") {
+				return nil, fmt.Errorf("%w: %q: unexpected ']'", ErrInvalid, src)
+			}
+			p.steps = append(p.steps, step{key: key, wildcard: key == "*"})
+			s = s[end+1:]
+		case '[':
+			st, rest, err := bracketStep(s)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q: %w", ErrInvalid, src, err)
+			}
+			p.steps = append(p.steps, st)
+			s = rest
+		default:
+			return nil, fmt.Errorf("%w: %q: unexpected %q", ErrInvalid, src, s[0])
+		}
+	}
+	return p, nil
+}
+
+// bracketStep parses the bracketed step at the start of s, returning it and
+// the rest of s.  Quoted keys may contain brackets.
+func bracketStep(s string) (step, string, error) {
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		end := strings.Index(s[2:], string(s[1])+"]")
+		if end < 0 {
+			return step{}, "", fmt.Errorf("unclosed bracket")
+		}
+		return step{key: s[2 : end+2]}, s[end+4:], nil
+	}
+	end := strings.Index(s, "]")
+	if end < 0 {
+		return step{}, "", fmt.Errorf("unclosed bracket")
+	}
+	inner := s[1:end]
+	if inner == "*" {
+		return step{wildcard: true}, s[end+1:], nil
+	}
+	idx, err := strconv.Atoi(inner)
+	if err != nil || idx < 0 || strings.HasPrefix(inner, "+") {
+		return step{}, "", fmt.Errorf("bad index %q", inner)
+	}
+	return step{index: idx, isIndex: true}, s[end+1:], nil
+}
+
+// String returns the source of p.
+func (p *Path) String() string {
+	return p.src
+}
+
+// Single returns true if p has no wildcards, so it finds at most one value.
+func (p *Path) Single() bool {
+	for _, st := range p.steps {
+		if st.wildcard {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns all values in v, as decoded from JSON, found at p.  Missing
+// values are not included, so the result may be empty.
+func (p *Path) Find(v any) []any {
+	values := []any{v}
+	for _, st := range p.steps {
+		next := []any{}
+		for _, val := range values {
+			switch tv := val.(type) {
+			case map[string]any:
+				if st.wildcard {
+					for _, item := range tv {
+						next = append(next, item)
+					}
+				} else if item, ok := tv[st.key]; ok && !st.isIndex {
+					next = append(next, item)
+				}
+			case []any:
+				if st.wildcard {
+					next = append(next, tv...)
+				} else if st.isIndex && st.index < len(tv) {
+					next = append(next, tv[st.index])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// Lookup returns the value in v, as decoded from JSON, found at p, which
+// must be Single.  It is an error if there is no such value,
+// explaining why.
+func (p *Path) Lookup(v any) (any, error) {
+	cur := v
+	for _, st := range p.steps {
+		if st.wildcard {
+			return nil, fmt.Errorf("%w: wildcard in %q", ErrNoValue, p.src)
+		}
+		switch tv := cur.(type) {
+		case map[string]any:
+			if st.isIndex {
+				return nil, fmt.Errorf("%w: index %d on object in %q",
+					ErrNoValue, st.index, p.src)
+			}
+			val, ok := tv[st.key]
+			if !ok {
+				return nil, fmt.Errorf("%w: no key %q in %q", ErrNoValue, st.key, p.src)
+			}
+			cur = val
+		case []any:
+			if !st.isIndex {
+				return nil, fmt.Errorf("%w: key %q on array in %q",
+					ErrNoValue, st.key, p.src)
+			}
+			if st.index >= len(tv) {
+				return nil, fmt.Errorf("%w: index %d out of range in %q",
+					ErrNoValue, st.index, p.src)
+			}
+			cur = tv[st.index]
+		default:
+			return nil, fmt.Errorf("%w: can not descend into %T in %q",
+				ErrNoValue, cur, p.src)
+		}
+	}
+	return cur, nil
+}
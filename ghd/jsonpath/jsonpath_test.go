@@ -0,0 +1,86 @@
+package jsonpath_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/jsonpath"
+)
+
+func TestFind(t *testing.T) {
+
+	require := require.New(t)
+
+	doc := map[string]any{
+		"a":   map[string]any{"b": "ab"},
+		"x y": 1.0,
+		"a]b": true,
+		"list": []any{
+			map[string]any{"n": 1.0},
+			map[string]any{"n": 2.0},
+		},
+	}
+	for _, tc := range []struct {
+		path string
+		exp  []any
+	}{
+		{"$", []any{doc}},
+		{"a.b", []any{"ab"}},
+		{"$.a.b", []any{"ab"}},
+		{"$['x y']", []any{1.0}},
+		{`$["a"].b`, []any{"ab"}},
+		{`$["a]b"]`, []any{true}},
+		{"[0]", []any{}},
+		{"$.list[1].n", []any{2.0}},
+		{"$.list[*].n", []any{1.0, 2.0}},
+		{"$.list[9].n", []any{}},
+		{"$.a.*", []any{"ab"}},
+		{"$.nope.b", []any{}},
+		{"$.a[0]", []any{}},
+	} {
+		p, err := jsonpath.Parse(tc.path)
+		require.NoError(err, tc.path)
+		require.Equal(tc.path, p.String())
+		require.Equal(tc.exp, p.Find(doc), tc.path)
+	}
+
+	for _, bad := range []string{"", "$.", "$.a..b", "$x", "$.a[x]", "$.a[-1]", "$.a]", "$['a]"} {
+		_, err := jsonpath.Parse(bad)
+		require.ErrorIs(err, jsonpath.ErrInvalid, bad)
+	}
+
+}
+
+func TestLookup(t *testing.T) {
+
+	require := require.New(t)
+
+	doc := []any{map[string]any{"a": []any{1.0, "two"}}}
+	p, err := jsonpath.Parse("[0].a[1]")
+	require.NoError(err)
+	require.True(p.Single())
+	v, err := p.Lookup(doc)
+	require.NoError(err)
+	require.Equal("two", v)
+
+	for path, exp := range map[string]string{
+		"[0].a.b":    `key "b" on array`,
+		"[0][1]":     "index 1 on object",
+		"[0].a[9]":   "index 9 out of range",
+		"[0].nope":   `no key "nope"`,
+		"[0].a[0].b": "can not descend into float64",
+		"[*].a":      "wildcard",
+		"$[0].a[*]":  "wildcard",
+	} {
+		p, err := jsonpath.Parse(path)
+		require.NoError(err, path)
+		_, err = p.Lookup(doc)
+		require.ErrorIs(err, jsonpath.ErrNoValue, path)
+		require.ErrorContains(err, exp, path)
+	}
+	p, err = jsonpath.Parse("$.a.*")
+	require.NoError(err)
+	require.False(p.Single())
+
+}
@@ -0,0 +1,338 @@
+// Package policy provides declarative rules on the arguments of tool calls,
+// going beyond allowing or denying tools by name.
+//
+// Rules apply to tools by name or regexp, and have conditions on the values
+// found at JSON paths in the args.  Every condition must hold for every
+// value found, else the call is in violation of the rule:
+//
+//	[[policy]]
+//	  id = "data-only"
+//	  description = "Files may only be read under /srv/data."
+//	  tools = ["file_read"]
+//	  [[policy.conditions]]
+//	    path = "$.path"
+//	    match = "/^\\/srv\\/data\\//"
+//	    not_match = "/\\.\\./"
+//
+//	[[policy]]
+//	  id = "small-sums"
+//	  tools = ["/^sum/"]
+//	  [[policy.conditions]]
+//	    path = "$.values"
+//	    max_len = 100
+//	  [[policy.conditions]]
+//	    path = "$.values[*]"
+//	    min = 0
+//	    max = 1000
+//
+// Values that are absent are not checked, unless they are Required.  Values
+// of the wrong type for a check, e.g. a number where a string must match a
+// regexp, are violations.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/biztos/greenhead/ghd/jsonpath"
+	"github.com/biztos/greenhead/ghd/rgxp"
+)
+
+var ErrInvalidRule = fmt.Errorf("invalid policy rule")
+
+var ErrViolation = fmt.Errorf("policy violation")
+
+// Condition is a check on the values at a JSON path in the args of a tool
+// call.  At least one check must be set.
+type Condition struct {
+	Path     string     `toml:"path"`      // JSON path to the values, e.g. "$.path"; required.
+	Required bool       `toml:"required"`  // The path must be present.
+	Match    *rgxp.Rgxp `toml:"match"`     // Strings must match this.
+	NotMatch *rgxp.Rgxp `toml:"not_match"` // Strings must not match this.
+	Min      *float64   `toml:"min"`       // Numbers must be at least this.
+	Max      *float64   `toml:"max"`       // Numbers must be at most this.
+	MaxLen   *int       `toml:"max_len"`   // Strings, arrays and objects must be at most this long.
+	In       []any      `toml:"in"`        // Values must be one of these strings, numbers or bools.
+	NotIn    []any      `toml:"not_in"`    // Values must not be any of these.
+
+	path *jsonpath.Path
+}
+
+// Validate checks that c has a valid path and at least one check.
+func (c *Condition) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+// compile returns a copy of c with its path parsed, leaving c untouched so
+// that configs can be shared by agents created concurrently.
+func (c *Condition) compile() (*Condition, error) {
+	p, err := jsonpath.Parse(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Required && c.Match == nil && c.NotMatch == nil && c.Min == nil &&
+		c.Max == nil && c.MaxLen == nil && c.In == nil && c.NotIn == nil {
+		return nil, fmt.Errorf("no checks for path %q", c.Path)
+	}
+	for _, v := range slices.Concat(c.In, c.NotIn) {
+		switch v.(type) {
+		case string, bool, int64, float64, int:
+		default:
+			return nil, fmt.Errorf("list value %v for path %q is not a string, number or bool",
+				v, c.Path)
+		}
+	}
+	n := *c
+	n.path = p
+	return &n, nil
+}
+
+// check returns the reason v, found at c's path, violates c, or an empty
+// string if it does not.
+func (c *Condition) check(v any) string {
+	if c.Match != nil || c.NotMatch != nil {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Sprintf("%s is not a string", show(v))
+		}
+		if c.Match != nil && !c.Match.MatchString(s) {
+			return fmt.Sprintf("%s does not match %s", show(v), c.Match)
+		}
+		if c.NotMatch != nil && c.NotMatch.MatchString(s) {
+			return fmt.Sprintf("%s matches %s", show(v), c.NotMatch)
+		}
+	}
+	if c.Min != nil || c.Max != nil {
+		n, ok := v.(float64)
+		if !ok {
+			return fmt.Sprintf("%s is not a number", show(v))
+		}
+		if c.Min != nil && n < *c.Min {
+			return fmt.Sprintf("%v is less than %v", n, *c.Min)
+		}
+		if c.Max != nil && n > *c.Max {
+			return fmt.Sprintf("%v is more than %v", n, *c.Max)
+		}
+	}
+	if c.MaxLen != nil {
+		var length int
+		switch tv := v.(type) {
+		case string:
+			length = utf8.RuneCountInString(tv)
+		case []any:
+			length = len(tv)
+		case map[string]any:
+			length = len(tv)
+		default:
+			return fmt.Sprintf("%s has no length", show(v))
+		}
+		if length > *c.MaxLen {
+			return fmt.Sprintf("length %d is more than %d", length, *c.MaxLen)
+		}
+	}
+	if c.In != nil && !containsValue(c.In, v) {
+		return fmt.Sprintf("%s is not one of the allowed values", show(v))
+	}
+	if containsValue(c.NotIn, v) {
+		return fmt.Sprintf("%s is not allowed", show(v))
+	}
+	return ""
+}
+
+// containsValue returns true if list contains v, treating all numbers as
+// float64 as they are in JSON.
+func containsValue(list []any, v any) bool {
+	for _, item := range list {
+		switch n := item.(type) {
+		case int64:
+			item = float64(n)
+		case int:
+			item = float64(n)
+		}
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// show returns v as JSON, shortened if it is long.
+func show(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := string(b)
+	if len(s) > 80 {
+		s = s[:77] + "..."
+	}
+	return s
+}
+
+// Rule is a named set of Conditions on the args of calls to Tools.
+type Rule struct {
+	Id          string               `toml:"id"`          // Identifier for logs and violations; required.
+	Description string               `toml:"description"` // Description, which is included in violations.
+	Tools       []*rgxp.OptionalRgxp `toml:"tools"`       // Tools to which the rule applies, by name or regexp; required.
+	Conditions  []*Condition         `toml:"conditions"`  // Conditions which must all hold; required.
+}
+
+// Validate checks that r has an Id, Tools and valid Conditions.
+func (r *Rule) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+// compile returns a copy of r with compiled copies of its Conditions.
+func (r *Rule) compile() (*Rule, error) {
+	if strings.TrimSpace(r.Id) == "" {
+		return nil, fmt.Errorf("%w: empty id", ErrInvalidRule)
+	}
+	if len(r.Tools) == 0 {
+		return nil, fmt.Errorf("%w: %q: no tools", ErrInvalidRule, r.Id)
+	}
+	if len(r.Conditions) == 0 {
+		return nil, fmt.Errorf("%w: %q: no conditions", ErrInvalidRule, r.Id)
+	}
+	n := *r
+	n.Conditions = make([]*Condition, len(r.Conditions))
+	for i, c := range r.Conditions {
+		compiled, err := c.compile()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidRule, r.Id, err)
+		}
+		n.Conditions[i] = compiled
+	}
+	return &n, nil
+}
+
+// AppliesTo returns true if r applies to the named tool.
+func (r *Rule) AppliesTo(tool string) bool {
+	for _, re := range r.Tools {
+		if re.MatchOrEqualString(tool) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the first Violation of r by args, or nil.
+func (r *Rule) check(tool string, args any) *Violation {
+	for _, c := range r.Conditions {
+		values := c.path.Find(args)
+		if len(values) == 0 && c.Required {
+			return r.violation(tool, c.Path, "required value is missing")
+		}
+		for _, v := range values {
+			if reason := c.check(v); reason != "" {
+				return r.violation(tool, c.Path, reason)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) violation(tool, path, reason string) *Violation {
+	return &Violation{
+		RuleId:      r.Id,
+		Description: r.Description,
+		Tool:        tool,
+		Path:        path,
+		Reason:      reason,
+	}
+}
+
+// Violation describes the violation of a Rule by a tool call.  It is an
+// error wrapping ErrViolation, and is meant to be sent to the LLM.
+type Violation struct {
+	RuleId      string `json:"rule_id"`
+	Description string `json:"description,omitempty"`
+	Tool        string `json:"tool"`
+	Path        string `json:"path,omitempty"`
+	Reason      string `json:"reason"`
+}
+
+// Error implements error.
+func (v *Violation) Error() string {
+	s := fmt.Sprintf("%s: rule %q: ", ErrViolation, v.RuleId)
+	if v.Path != "" {
+		s += v.Path + ": "
+	}
+	s += v.Reason
+	if v.Description != "" {
+		s += ": " + v.Description
+	}
+	return s
+}
+
+// Unwrap returns ErrViolation.
+func (v *Violation) Unwrap() error {
+	return ErrViolation
+}
+
+// Policy is a validated set of Rules.  The nil Policy allows everything.
+type Policy struct {
+	rules []*Rule
+}
+
+// New returns a Policy of rules, which must be valid and have unique Ids.
+// The Policy keeps its own copies, so rules are not modified and can be
+// shared.
+func New(rules []*Rule) (*Policy, error) {
+	have := map[string]bool{}
+	compiled := make([]*Rule, len(rules))
+	for i, r := range rules {
+		c, err := r.compile()
+		if err != nil {
+			return nil, err
+		}
+		if have[r.Id] {
+			return nil, fmt.Errorf("%w: duplicate id %q", ErrInvalidRule, r.Id)
+		}
+		have[r.Id] = true
+		compiled[i] = c
+	}
+	return &Policy{rules: compiled}, nil
+}
+
+// Rules returns the rules of p, which are copies of those given to New.
+func (p *Policy) Rules() []*Rule {
+	if p == nil {
+		return nil
+	}
+	return slices.Clone(p.rules)
+}
+
+// Check returns the first Violation of the rules applying to the named tool
+// by args, which are the JSON args of a call, or nil if there is none.
+//
+// Args that are not valid JSON violate any rule that applies.
+func (p *Policy) Check(tool string, args string) *Violation {
+	if p == nil {
+		return nil
+	}
+	var decoded any
+	var decode_err error
+	decoded_once := false
+	for _, r := range p.rules {
+		if !r.AppliesTo(tool) {
+			continue
+		}
+		if !decoded_once {
+			decode_err = json.Unmarshal([]byte(args), &decoded)
+			decoded_once = true
+		}
+		if decode_err != nil {
+			return r.violation(tool, "", "args are not valid JSON")
+		}
+		if v := r.check(tool, decoded); v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package policy_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/policy"
+)
+
+const testPolicyToml = `
+[[policy]]
+  id = "data-only"
+  description = "Files may only be read under /srv/data."
+  tools = ["file_read"]
+  [[policy.conditions]]
+    path = "$.path"
+    required = true
+    match = "/^\\/srv\\/data\\//"
+    not_match = "/\\.\\./"
+
+[[policy]]
+  id = "small-sums"
+  tools = ["/^sum/"]
+  [[policy.conditions]]
+    path = "values"
+    max_len = 3
+  [[policy.conditions]]
+    path = "$.values[*]"
+    min = 0
+    max = 100.5
+
+[[policy]]
+  id = "hosts"
+  tools = ["http_get"]
+  [[policy.conditions]]
+    path = "$.host"
+    in = ["example.com", "example.org"]
+  [[policy.conditions]]
+    path = "$.port"
+    not_in = [22, 25]
+`
+
+func testPolicy(t *testing.T) *policy.Policy {
+	var cfg struct {
+		Policy []*policy.Rule `toml:"policy"`
+	}
+	_, err := toml.Decode(testPolicyToml, &cfg)
+	require.NoError(t, err)
+	p, err := policy.New(cfg.Policy)
+	require.NoError(t, err)
+	return p
+}
+
+func TestPolicyCheck(t *testing.T) {
+
+	require := require.New(t)
+
+	p := testPolicy(t)
+	for _, tc := range []struct {
+		tool string
+		args string
+		exp  string
+	}{
+		{"file_read", `{"path":"/srv/data/x.txt"}`, ""},
+		{"file_read", `{"path":"/etc/passwd"}`,
+			`policy violation: rule "data-only": $.path: "/etc/passwd" does not match /^\/srv\/data\//: Files may only be read under /srv/data.`},
+		{"file_read", `{"path":"/srv/data/../x"}`,
+			`policy violation: rule "data-only": $.path: "/srv/data/../x" matches /\.\./: Files may only be read under /srv/data.`},
+		{"file_read", `{"path":1}`,
+			`policy violation: rule "data-only": $.path: 1 is not a string: Files may only be read under /srv/data.`},
+		{"file_read", `{}`,
+			`policy violation: rule "data-only": $.path: required value is missing: Files may only be read under /srv/data.`},
+		{"file_read", `{nope`,
+			`policy violation: rule "data-only": args are not valid JSON: Files may only be read under /srv/data.`},
+		{"file_write", `{"path":"/etc/passwd"}`, ""},
+		{"sum", `{"values":[1,2,3]}`, ""},
+		{"sum_more", `{"values":[1,2,3,4]}`,
+			`policy violation: rule "small-sums": values: length 4 is more than 3`},
+		{"sum", `{"values":[1,-2]}`,
+			`policy violation: rule "small-sums": $.values[*]: -2 is less than 0`},
+		{"sum", `{"values":[100.6]}`,
+			`policy violation: rule "small-sums": $.values[*]: 100.6 is more than 100.5`},
+		{"sum", `{"values":["1"]}`,
+			`policy violation: rule "small-sums": $.values[*]: "1" is not a number`},
+		{"sum", `{}`, ""},
+		{"http_get", `{"host":"example.org","port":443}`, ""},
+		{"http_get", `{"host":"evil.com"}`,
+			`policy violation: rule "hosts": $.host: "evil.com" is not one of the allowed values`},
+		{"http_get", `{"host":"example.com","port":22}`,
+			`policy violation: rule "hosts": $.port: 22 is not allowed`},
+	} {
+		v := p.Check(tc.tool, tc.args)
+		if tc.exp == "" {
+			require.Nil(v, "%s %s", tc.tool, tc.args)
+			continue
+		}
+		require.NotNil(v, "%s %s", tc.tool, tc.args)
+		require.ErrorIs(v, policy.ErrViolation)
+		require.Equal(tc.exp, v.Error())
+		require.Equal(tc.tool, v.Tool)
+	}
+
+}
+
+func TestPolicyNilAllows(t *testing.T) {
+
+	require := require.New(t)
+
+	var p *policy.Policy
+	require.Nil(p.Check("anything", `{nope`))
+	require.Nil(p.Rules())
+
+}
+
+func TestPolicyNewFails(t *testing.T) {
+
+	require := require.New(t)
+
+	rule := func(id string, tools []string, conditions string) string {
+		return "[[policy]]\n" +
+			"  id = \"" + id + "\"\n" +
+			"  tools = " + tomlList(tools) + "\n" +
+			conditions
+	}
+	for _, tc := range []struct {
+		toml string
+		exp  string
+	}{
+		{rule(" ", []string{"foo"}, ""), "invalid policy rule: empty id"},
+		{rule("x", nil, ""), `invalid policy rule: "x": no tools`},
+		{rule("x", []string{"foo"}, ""), `invalid policy rule: "x": no conditions`},
+		{rule("x", []string{"foo"}, "  [[policy.conditions]]\n    path = \"$.a\"\n"),
+			`invalid policy rule: "x": no checks for path "$.a"`},
+		{rule("x", []string{"foo"}, "  [[policy.conditions]]\n    path = \"$.a[\"\n    required = true\n"),
+			`invalid policy rule: "x": invalid JSON path: "$.a[": unclosed bracket`},
+		{rule("x", []string{"foo"}, "  [[policy.conditions]]\n    path = \"$.a\"\n    in = [[1]]\n"),
+			`invalid policy rule: "x": list value [1] for path "$.a" is not a string, number or bool`},
+		{rule("x", []string{"foo"}, "  [[policy.conditions]]\n    path = \"a\"\n    required = true\n") +
+			rule("x", []string{"bar"}, "  [[policy.conditions]]\n    path = \"b\"\n    required = true\n"),
+			`invalid policy rule: duplicate id "x"`},
+	} {
+		var cfg struct {
+			Policy []*policy.Rule `toml:"policy"`
+		}
+		_, err := toml.Decode(tc.toml, &cfg)
+		require.NoError(err, tc.toml)
+		_, err = policy.New(cfg.Policy)
+		require.ErrorIs(err, policy.ErrInvalidRule)
+		require.EqualError(err, tc.exp)
+	}
+
+}
+
+func TestPolicyNewSharedRules(t *testing.T) {
+
+	require := require.New(t)
+
+	var cfg struct {
+		Policy []*policy.Rule `toml:"policy"`
+	}
+	_, err := toml.Decode(testPolicyToml, &cfg)
+	require.NoError(err)
+
+	// Agents sharing rules are created concurrently, e.g. in the API.
+	var wg sync.WaitGroup
+	policies := make([]*policy.Policy, 10)
+	errs := make([]error, 10)
+	for i := range policies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			policies[i], errs[i] = policy.New(cfg.Policy)
+		}()
+	}
+	wg.Wait()
+	for i, p := range policies {
+		require.NoError(errs[i])
+		require.NotNil(p.Check("file_read", `{"path":"/etc/passwd"}`))
+		require.Nil(p.Check("file_read", `{"path":"/srv/data/x"}`))
+		require.NotSame(cfg.Policy[0], p.Rules()[0], "rules copied")
+		require.Equal(cfg.Policy[0].Id, p.Rules()[0].Id)
+	}
+
+}
+
+func tomlList(list []string) string {
+	s := "["
+	for i, v := range list {
+		if i > 0 {
+			s += ", "
+		}
+		s += `"` + v + `"`
+	}
+	return s + "]"
+}
@@ -1,13 +1,20 @@
 // Package registry holds the registered tools.
 //
-// All public functions are safe to use concurrently.  However, keep in mind
-// that it is possible to replace a Tooler at runtime, in which case the next
-// call to Get will return a different value.
+// A Registry is a set of tools by name.  The package-level functions operate
+// on the Default registry, into which tools are normally registered at init
+// time; Agents and Runners use it unless given another, e.g. a Clone with
+// different tools for a particular tenant, or a frozen Snapshot.
+//
+// All public functions and methods are safe to use concurrently.  However,
+// keep in mind that it is possible to replace a Tooler at runtime, in which
+// case the next call to Get will return a different value.
 package registry
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"slices"
 	"strings"
 	"sync"
@@ -16,101 +23,161 @@ import (
 	"github.com/biztos/greenhead/ghd/tools"
 )
 
-var mutex = sync.Mutex{}
+var ErrNotRegistered = errors.New("tool is not registered")
+var ErrNewLocked = errors.New("registry is locked for new tools")
+var ErrReplaceLocked = errors.New("registry is locked for replacement tools")
+var ErrRemoveLocked = errors.New("registry is locked for removal")
+var ErrNoMatch = errors.New("no match for tool")
 
-var lockedForNew = false
+// Registry is a set of registered tools, in order of registration.
+//
+// The zero value is not usable; create a Registry with New.
+type Registry struct {
+	mutex            sync.Mutex
+	lockedForNew     bool
+	lockedForReplace bool
+	lockedForRemove  bool
+	registered       map[string]tools.Tooler
+	orderedNames     []string
+}
 
-var lockedForReplace = false
+// Default is the registry used by the package-level functions.
+var Default = New()
 
-var lockedForRemove = false
+// New returns a new, empty and unlocked Registry.
+func New() *Registry {
+	return &Registry{
+		registered:   map[string]tools.Tooler{},
+		orderedNames: []string{},
+	}
+}
 
-var registered = map[string]tools.Tooler{}
+// Clone returns a new Registry with the same tools as r, in the same order,
+// but without any locks.
+//
+// The tools themselves are shared, not copied.
+func (r *Registry) Clone() *Registry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return &Registry{
+		registered:   maps.Clone(r.registered),
+		orderedNames: slices.Clone(r.orderedNames),
+	}
+}
 
-var ordered_names = []string{}
+// Fork returns a Clone of r with the same locks, for changes that must not
+// affect r but must still respect its locks.
+func (r *Registry) Fork() *Registry {
+	c := r.Clone()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c.lockedForNew = r.lockedForNew
+	c.lockedForReplace = r.lockedForReplace
+	c.lockedForRemove = r.lockedForRemove
+	return c
+}
 
-var ErrNotRegistered = errors.New("tool is not registered")
-var ErrNewLocked = errors.New("registry is locked for new tools")
-var ErrReplaceLocked = errors.New("registry is locked for replacement tools")
-var ErrRemoveLocked = errors.New("registry is locked for removal")
+// Snapshot returns a Clone of r that is fully locked, and thus will never
+// change regardless of what happens to r.
+func (r *Registry) Snapshot() *Registry {
+	s := r.Clone()
+	s.Lock()
+	return s
+}
 
 // Register adds a tool, replacing any same-named tool if allowed.  For any
 // non-nil return value, the tool will not have been registered.
 //
-// Take note of the one-way Lock* functions for controlling the registry.
-func Register(t tools.Tooler) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+// Take note of the one-way Lock* methods for controlling the registry.
+func (r *Registry) Register(t tools.Tooler) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	// Must have a non-blanco name.
 	name := t.Name()
 	if strings.TrimSpace(name) == "" {
 		return fmt.Errorf("empty name for tool")
 	}
-	if registered[name] != nil {
+	if r.registered[name] != nil {
 		// We are replacing, if allowed.
-		if lockedForReplace {
+		if r.lockedForReplace {
 			return ErrReplaceLocked
 		}
-		idx := slices.Index(ordered_names, name)
-		ordered_names = slices.Delete(ordered_names, idx, idx+1)
-	} else if lockedForNew {
+		idx := slices.Index(r.orderedNames, name)
+		r.orderedNames = slices.Delete(r.orderedNames, idx, idx+1)
+	} else if r.lockedForNew {
 		return ErrNewLocked
 	}
 
 	// The names now have the new tool at the end in all cases.
-	ordered_names = append(ordered_names, name)
-	registered[name] = t
+	r.orderedNames = append(r.orderedNames, name)
+	r.registered[name] = t
 	return nil
 
 }
 
 // Remove removes a tool registration if it is registered.
-func Remove(name string) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-	if registered[name] == nil {
+func (r *Registry) Remove(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.registered[name] == nil {
 		return fmt.Errorf("%w: %q", ErrNotRegistered, name)
 	}
-	if lockedForRemove {
+	if r.lockedForRemove {
 		return ErrRemoveLocked
 	}
-	delete(registered, name)
-	idx := slices.Index(ordered_names, name)
-	ordered_names = slices.Delete(ordered_names, idx, idx+1)
+	delete(r.registered, name)
+	idx := slices.Index(r.orderedNames, name)
+	r.orderedNames = slices.Delete(r.orderedNames, idx, idx+1)
 	return nil
 }
 
 // Get returns a Tooler by name, or an ErrNotRegistered error if none is found.
-func Get(name string) (tools.Tooler, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	if registered[name] == nil {
+func (r *Registry) Get(name string) (tools.Tooler, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.registered[name] == nil {
 		return nil, fmt.Errorf("%w: %q", ErrNotRegistered, name)
 	}
-	return registered[name], nil
+	return r.registered[name], nil
 }
 
 // Names returns all the registered Tooler names, in order of registration.
-func Names() []string {
-	mutex.Lock()
-	defer mutex.Unlock()
-	return slices.Clone(ordered_names)
+func (r *Registry) Names() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.orderedNames)
 
 }
 
-// MatchingNames checks returns a deduplicated and (in the case of regexps)
-// expanded set of valid, registered tool names.
+// MatchingNames checks returns a deduplicated and (in the case of regexps
+// and Selectors) expanded set of valid, registered tool names.
 //
-// If any item in want has no corresponding registered tool, an error is
-// returned.
-func MatchingNames(want []*rgxp.OptionalRgxp) ([]string, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	matched_names := make([]string, 0, len(ordered_names))
+// If any name or regexp in want has no corresponding registered tool, an
+// error is returned.  Selectors, e.g. "tag:fs" or "risk<=write", may match
+// no tools, but must be valid.
+func (r *Registry) MatchingNames(want []*rgxp.OptionalRgxp) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	matched_names := make([]string, 0, len(r.orderedNames))
 	have := map[string]bool{}
-	for _, r := range want {
+	for _, re := range want {
+		var sel *Selector
+		if !re.IsRegexp() && IsSelector(re.String()) {
+			var err error
+			sel, err = ParseSelector(re.String())
+			if err != nil {
+				return nil, err
+			}
+		}
 		got_any := false
-		for _, n := range ordered_names {
-			if (r.IsRegexp() && r.MatchString(n)) || n == r.String() {
+		for _, n := range r.orderedNames {
+			var match bool
+			if sel != nil {
+				match = sel.Match(r.registered[n])
+			} else {
+				match = (re.IsRegexp() && re.MatchString(n)) || n == re.String()
+			}
+			if match {
 				got_any = true
 				if !have[n] {
 					matched_names = append(matched_names, n)
@@ -118,8 +185,8 @@ func MatchingNames(want []*rgxp.OptionalRgxp) ([]string, error) {
 				}
 			}
 		}
-		if !got_any {
-			return nil, fmt.Errorf("no match for tool %q", r.String())
+		if !got_any && sel == nil {
+			return nil, fmt.Errorf("%w %q", ErrNoMatch, re.String())
 		}
 	}
 	return matched_names, nil
@@ -129,22 +196,35 @@ func MatchingNames(want []*rgxp.OptionalRgxp) ([]string, error) {
 // with formatting, in order of registration.  Sorting the return strings
 // alphabetically will sort by tool name.
 //
-// If the description is multi-line, the first line is used.
-func DisplayLines() []string {
-	mutex.Lock()
-	defer mutex.Unlock()
-	lines := make([]string, len(ordered_names))
+// If the description is multi-line, the first line is used.  If the tool
+// declares Metadata, its Summary follows in brackets.
+//
+// If names are given, only those tools are included, in that order.
+func (r *Registry) DisplayLines(names ...string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(names) == 0 {
+		names = r.orderedNames
+	}
+	names = slices.DeleteFunc(slices.Clone(names), func(n string) bool {
+		return r.registered[n] == nil
+	})
+	lines := make([]string, len(names))
 	max_name := 0
-	descs := make([]string, len(ordered_names))
-	for i, name := range ordered_names {
+	descs := make([]string, len(names))
+	for i, name := range names {
 		if len(name) > max_name {
 			max_name = len(name)
 		}
-		desc_lines := strings.Split(registered[name].Description(), "\n")
+		t := r.registered[name]
+		desc_lines := strings.Split(t.Description(), "\n")
 		descs[i] = desc_lines[0]
+		if summary := tools.MetadataOf(t).Summary(); summary != "" {
+			descs[i] += " [" + summary + "]"
+		}
 	}
 	fmt_str := fmt.Sprintf("%%-%ds - %%s", max_name)
-	for i, name := range ordered_names {
+	for i, name := range names {
 		lines[i] = fmt.Sprintf(fmt_str, name, descs[i])
 	}
 	return lines
@@ -152,44 +232,38 @@ func DisplayLines() []string {
 
 // Clear clears all registered extensions.  Note that Clear does *not* unlock
 // the registry.
-//
-// Clear is intended for the use-case of having runtime tool registration only
-// but starting with compiled-in tools you do not want.  Calling Clear in the
-// init phase may not do what you expect.
-//
-// TODO: prove it works in init phase of an *external* package; it should!
-func Clear() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	registered = map[string]tools.Tooler{}
-	ordered_names = []string{}
+func (r *Registry) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.registered = map[string]tools.Tooler{}
+	r.orderedNames = []string{}
 }
 
 // Lock locks the registry for both new and replacement tools and also for
 // removals.
 //
-// There is no corresponding Unlock function.
+// There is no corresponding Unlock method.
 //
 // Use Lock as a guard against accidentally changing the toolset at runtime.
 //
 // Keep in mind that a tool could call system functions; a tool could create
 // and register new tools; and an AI could (theoretically) do a "gain of
 // function" without your knowledge.
-func Lock() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	lockedForNew = true
-	lockedForReplace = true
-	lockedForRemove = true
+func (r *Registry) Lock() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lockedForNew = true
+	r.lockedForReplace = true
+	r.lockedForRemove = true
 }
 
 // LockForNew applies a selective lock, preventing only new registrations.
 //
 // Previously-set locks are unaffected.
-func LockForNew() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	lockedForNew = true
+func (r *Registry) LockForNew() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lockedForNew = true
 }
 
 // LockForReplace applies a selective lock, preventing only replacements.
@@ -199,17 +273,94 @@ func LockForNew() {
 // just use Lock.
 //
 // Previously-set locks are unaffected.
-func LockForReplace() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	lockedForReplace = true
+func (r *Registry) LockForReplace() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lockedForReplace = true
 }
 
 // LockForRemove applies a selective lock, preventing only removals.
 //
 // Previously-set locks are unaffected.
+func (r *Registry) LockForRemove() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lockedForRemove = true
+}
+
+type contextKey struct{}
+
+// WithRegistry returns a copy of ctx carrying r, for code that looks up
+// tools by name on behalf of a caller, such as a workflow.
+func WithRegistry(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the Registry carried by ctx, or Default if none.
+func FromContext(ctx context.Context) *Registry {
+	if r, ok := ctx.Value(contextKey{}).(*Registry); ok && r != nil {
+		return r
+	}
+	return Default
+}
+
+// Register calls Register on the Default registry.
+func Register(t tools.Tooler) error {
+	return Default.Register(t)
+}
+
+// Remove calls Remove on the Default registry.
+func Remove(name string) error {
+	return Default.Remove(name)
+}
+
+// Get calls Get on the Default registry.
+func Get(name string) (tools.Tooler, error) {
+	return Default.Get(name)
+}
+
+// Names calls Names on the Default registry.
+func Names() []string {
+	return Default.Names()
+}
+
+// MatchingNames calls MatchingNames on the Default registry.
+func MatchingNames(want []*rgxp.OptionalRgxp) ([]string, error) {
+	return Default.MatchingNames(want)
+}
+
+// DisplayLines calls DisplayLines on the Default registry.
+func DisplayLines(names ...string) []string {
+	return Default.DisplayLines(names...)
+}
+
+// Clear calls Clear on the Default registry.
+//
+// Clear is intended for the use-case of having runtime tool registration only
+// but starting with compiled-in tools you do not want.  Calling Clear in the
+// init phase may not do what you expect.
+//
+// TODO: prove it works in init phase of an *external* package; it should!
+func Clear() {
+	Default.Clear()
+}
+
+// Lock calls Lock on the Default registry.
+func Lock() {
+	Default.Lock()
+}
+
+// LockForNew calls LockForNew on the Default registry.
+func LockForNew() {
+	Default.LockForNew()
+}
+
+// LockForReplace calls LockForReplace on the Default registry.
+func LockForReplace() {
+	Default.LockForReplace()
+}
+
+// LockForRemove calls LockForRemove on the Default registry.
 func LockForRemove() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	lockedForRemove = true
+	Default.LockForRemove()
 }
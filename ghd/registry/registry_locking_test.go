@@ -20,9 +20,9 @@ func testTool(name string) tools.Tooler {
 }
 
 func resetLocksAndClear() {
-	lockedForNew = false
-	lockedForReplace = false
-	lockedForRemove = false
+	Default.lockedForNew = false
+	Default.lockedForReplace = false
+	Default.lockedForRemove = false
 	Clear()
 }
 
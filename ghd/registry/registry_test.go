@@ -153,3 +153,77 @@ func TestDisplayLines(t *testing.T) {
 		"display lines formatted nice-like")
 
 }
+
+func TestRegistriesIndependent(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	one := registry.New()
+	two := registry.New()
+	require.NoError(one.Register(testTool("foo")), "one foo ok")
+	require.NoError(two.Register(testTool("bar")), "two bar ok")
+	two.Lock()
+
+	require.Equal([]string{"foo"}, one.Names())
+	require.Equal([]string{"bar"}, two.Names())
+	require.Empty(registry.Names(), "default untouched")
+	require.NoError(one.Register(testTool("baz")), "one not locked")
+
+}
+
+func TestCloneAndSnapshot(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.NoError(reg.Register(testTool("foo")), "new foo ok")
+	require.NoError(reg.Register(testTool("bar")), "new bar ok")
+
+	clone := reg.Clone()
+	snap := reg.Snapshot()
+	require.NoError(reg.Remove("foo"), "remove foo ok")
+	require.Equal([]string{"foo", "bar"}, clone.Names(), "clone unchanged")
+	require.Equal([]string{"foo", "bar"}, snap.Names(), "snapshot unchanged")
+
+	require.NoError(clone.Register(testTool("baz")), "clone not locked")
+	require.ErrorIs(snap.Register(testTool("baz")), registry.ErrNewLocked)
+	require.ErrorIs(snap.Register(testTool("foo")), registry.ErrReplaceLocked)
+	require.ErrorIs(snap.Remove("foo"), registry.ErrRemoveLocked)
+
+	got, err := snap.Get("foo")
+	require.NoError(err, "got ok")
+	clone_got, err := clone.Get("foo")
+	require.NoError(err, "got ok")
+	require.Same(got, clone_got, "tools shared")
+
+}
+
+func TestFromContext(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.Same(registry.Default, registry.FromContext(context.Background()))
+	ctx := registry.WithRegistry(context.Background(), reg)
+	require.Same(reg, registry.FromContext(ctx))
+
+}
+
+func TestFork(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.NoError(reg.Register(testTool("foo")), "new foo ok")
+	reg.LockForRemove()
+
+	fork := reg.Fork()
+	require.NoError(fork.Register(testTool("bar")), "fork not locked for new")
+	require.Equal([]string{"foo"}, reg.Names(), "source unchanged")
+	require.Equal([]string{"foo", "bar"}, fork.Names())
+	require.ErrorIs(fork.Remove("foo"), registry.ErrRemoveLocked, "lock kept")
+
+}
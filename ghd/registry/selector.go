@@ -0,0 +1,99 @@
+// registry/selector.go
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrInvalidSelector = errors.New("invalid tool selector")
+
+// Selector selects tools by their Metadata rather than their names.
+//
+// Selectors are written as KEY:VALUE, or for risk also with a comparison:
+//
+//	tag:fs          tools tagged "fs"
+//	owner:security  tools owned by "security"
+//	version:1.2     tools at version "1.2"
+//	risk:read-only  tools with exactly that risk; also risk=read-only
+//	risk<=write     tools that are read-only or write; also <, >= and >
+//
+// Tools that do not declare a risk level are treated as the riskiest of
+// all, per tools.RiskLevels.
+type Selector struct {
+	src   string
+	key   string
+	op    string
+	value string
+}
+
+var selectorRegexp = regexp.MustCompile(`^([a-z]+)(:|=|<=|>=|<|>)(.+)$`)
+
+// IsSelector returns true if s looks like a Selector rather than a tool
+// name, which can not have the characters ":", "=", "<" or ">".
+func IsSelector(s string) bool {
+	return selectorRegexp.MatchString(s)
+}
+
+// ParseSelector parses s as a Selector.
+func ParseSelector(s string) (*Selector, error) {
+	m := selectorRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSelector, s)
+	}
+	sel := &Selector{src: s, key: m[1], op: m[2], value: m[3]}
+	if sel.op == "=" {
+		sel.op = ":"
+	}
+	switch sel.key {
+	case "tag", "owner", "version":
+		if sel.op != ":" {
+			return nil, fmt.Errorf("%w: %q: %s can not be compared",
+				ErrInvalidSelector, s, sel.key)
+		}
+	case "risk":
+		if tools.Risk(sel.value).Level() == 0 {
+			return nil, fmt.Errorf("%w: %q: unknown risk %q",
+				ErrInvalidSelector, s, sel.value)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q: unknown key %q",
+			ErrInvalidSelector, s, sel.key)
+	}
+	return sel, nil
+}
+
+// String returns the source of s.
+func (s *Selector) String() string {
+	return s.src
+}
+
+// Match returns true if the Metadata of t satisfies s.
+func (s *Selector) Match(t tools.Tooler) bool {
+	m := tools.MetadataOf(t)
+	switch s.key {
+	case "tag":
+		return m.HasTag(s.value)
+	case "owner":
+		return m.Owner == s.value
+	case "version":
+		return m.Version == s.value
+	}
+	have := m.Risk.Level()
+	want := tools.Risk(s.value).Level()
+	switch s.op {
+	case "<=":
+		return have <= want
+	case "<":
+		return have < want
+	case ">=":
+		return have >= want
+	case ">":
+		return have > want
+	}
+	return have == want
+}
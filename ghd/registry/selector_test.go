@@ -0,0 +1,103 @@
+package registry_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// metaRegistry returns a registry with tools of various metadata.
+func metaRegistry() *registry.Registry {
+	reg := registry.New()
+	for _, tc := range []struct {
+		name string
+		meta *tools.Metadata
+	}{
+		{"read", &tools.Metadata{Tags: []string{"fs"}, Risk: tools.RiskReadOnly, Owner: "ops"}},
+		{"write", &tools.Metadata{Tags: []string{"fs"}, Risk: tools.RiskWrite, Version: "2"}},
+		{"fetch", &tools.Metadata{Tags: []string{"web"}, Risk: tools.RiskNetwork}},
+		{"nuke", &tools.Metadata{Risk: tools.RiskDestructive}},
+		{"plain", nil},
+	} {
+		tool := tools.NewTool[TestInput, string](tc.name, tc.name+" ok", nil)
+		if tc.meta != nil {
+			tool.WithMetadata(tc.meta)
+		}
+		if err := reg.Register(tool); err != nil {
+			panic(err)
+		}
+	}
+	return reg
+}
+
+func TestSelectorMatch(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := metaRegistry()
+	for src, exp := range map[string][]string{
+		"tag:fs":            {"read", "write"},
+		"owner:ops":         {"read"},
+		"version:2":         {"write"},
+		"risk:network":      {"fetch"},
+		"risk=network":      {"fetch"},
+		"risk<=write":       {"read", "write"},
+		"risk<network":      {"read", "write"},
+		"risk>=destructive": {"nuke", "plain"},
+		"risk>destructive":  {"plain"},
+		"risk:unknown":      {"plain"},
+		"tag:nonesuch":      {},
+		"risk<read-only":    {},
+	} {
+		got, err := reg.MatchingNames([]*rgxp.OptionalRgxp{rgxp.MustParseOptional(src)})
+		require.NoError(err, src)
+		require.Equal(exp, got, src)
+	}
+
+	// Mixed with names and regexps, deduplicated.
+	got, err := reg.MatchingNames([]*rgxp.OptionalRgxp{
+		rgxp.MustParseOptional("nuke"),
+		rgxp.MustParseOptional("tag:fs"),
+		rgxp.MustParseOptional("/^wr/"),
+	})
+	require.NoError(err)
+	require.Equal([]string{"nuke", "read", "write"}, got)
+
+}
+
+func TestSelectorErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := metaRegistry()
+	for src, exp := range map[string]string{
+		"color:red":   `unknown key "color"`,
+		"tag<=fs":     "tag can not be compared",
+		"risk<=scary": `unknown risk "scary"`,
+	} {
+		_, err := reg.MatchingNames([]*rgxp.OptionalRgxp{rgxp.MustParseOptional(src)})
+		require.ErrorIs(err, registry.ErrInvalidSelector, src)
+		require.ErrorContains(err, exp, src)
+	}
+	_, err := registry.ParseSelector("nope")
+	require.ErrorIs(err, registry.ErrInvalidSelector)
+	require.False(registry.IsSelector("tictactoe_move"))
+
+}
+
+func TestDisplayLinesMetadata(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := metaRegistry()
+	exp := []string{
+		"plain - plain ok",
+		"read  - read ok [risk: read-only; tags: fs; owner: ops]",
+	}
+	require.Equal(exp, reg.DisplayLines("plain", "nonesuch", "read"))
+
+}
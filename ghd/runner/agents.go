@@ -32,7 +32,7 @@ func (r *Runner) ListAgents(w io.Writer) error {
 		}
 		sort.Strings(names)
 		for _, name := range names {
-			agent, err := agent.NewAgent(NamedAgentConfigs[name])
+			agent, err := agent.NewAgentWithRegistry(NamedAgentConfigs[name], r.Registry)
 			if err != nil {
 				// TODO: trigger bad config to prove this works.
 				return fmt.Errorf("bad named config for %s: %w", name, err)
@@ -99,6 +99,8 @@ func (r *Runner) CheckAgents(w io.Writer) error {
 // RunAgents runs the single-prompt completion on all agents, in order.
 //
 // If prompt starts with @ then it is read from a file, e.g. `@file.txt`.
+//
+// The prompt may be empty only if all agents have prompt templates.
 func (r *Runner) RunAgents(w io.Writer, prompt string, json bool) error {
 	if len(r.Agents) == 0 {
 		return fmt.Errorf("no agents")
@@ -111,12 +113,29 @@ func (r *Runner) RunAgents(w io.Writer, prompt string, json bool) error {
 		}
 		prompt = string(b)
 	}
+	if prompt == "" {
+		for _, a := range r.Agents {
+			if !a.HasPromptTemplate() {
+				return fmt.Errorf("no prompt for agent without prompt template: %s", a.Name)
+			}
+		}
+	}
+
+	// Tool calls requiring approval are approved on the terminal, unless the
+	// agents already have an Approver.
+	approver := agent.NewTerminalApprover(os.Stdin, os.Stderr)
+	for _, a := range r.Agents {
+		if a.Approver() == nil {
+			a.SetApprover(approver)
+		}
+	}
 
 	for _, a := range r.Agents {
 		if !json {
 			a.Print(a.Ident() + "\n")
 		}
-		res_content, err := a.RunCompletionPrompt(prompt)
+		req := &agent.CompletionRequest{Content: prompt}
+		res, err := a.RunCompletion(context.Background(), req)
 		if err != nil {
 			return fmt.Errorf("error running completion: %w", err)
 		}
@@ -124,7 +143,11 @@ func (r *Runner) RunAgents(w io.Writer, prompt string, json bool) error {
 			v := map[string]any{
 				"agent":    a.Ident(),
 				"prompt":   prompt,
-				"response": res_content,
+				"response": res.Content,
+			}
+			// Structured output is included as data, not just text.
+			if res.Data != nil {
+				v["data"] = res.Data
 			}
 			fmt.Fprintln(w, utils.MustJsonString(v))
 
@@ -0,0 +1,61 @@
+package runner_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/runner"
+)
+
+// echoClient responds with the prompt.
+type echoClient struct {
+	agent.BasicApiClient
+}
+
+func (c *echoClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	return &agent.CompletionResponse{Content: req.Content}, nil
+}
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-echo", func() (agent.ApiClient, error) {
+		return &echoClient{}, nil
+	})
+}
+
+func TestRunAgentsJsonIncludesData(t *testing.T) {
+
+	require := require.New(t)
+
+	r, err := runner.NewRunner(&runner.Config{
+		NoLog: true,
+		Agents: []*agent.Config{
+			{Name: "plain", Type: "fake-echo", Silent: true},
+			{Name: "structured", Type: "fake-echo", Silent: true,
+				ResponseSchema: `{"type":"object"}`},
+		},
+	})
+	require.NoError(err)
+
+	buf := new(strings.Builder)
+	require.NoError(r.RunAgents(buf, `{"a":1}`, true))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(lines, 2)
+	res := []map[string]any{}
+	for _, line := range lines {
+		v := map[string]any{}
+		require.NoError(json.Unmarshal([]byte(line), &v))
+		res = append(res, v)
+	}
+	require.Equal(`{"a":1}`, res[0]["response"])
+	require.NotContains(res[0], "data")
+	require.Equal(map[string]any{"a": float64(1)}, res[1]["data"])
+
+	err = r.RunAgents(buf, "not json", true)
+	require.ErrorIs(err, agent.ErrResponseValidation)
+
+}
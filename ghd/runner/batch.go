@@ -0,0 +1,395 @@
+// runner/batch.go
+
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// DefaultBatchRetryDelay is the default delay before the first retry of a
+// batch item; it doubles with each attempt.
+var DefaultBatchRetryDelay = time.Second
+
+var ErrBatchInput = fmt.Errorf("batch input error")
+
+var ErrBatchFailed = fmt.Errorf("batch items failed")
+
+// BatchItem is a single prompt to run in a batch.
+type BatchItem struct {
+	Id     string            `json:"id"`
+	Prompt string            `json:"prompt"`
+	Vars   map[string]string `json:"vars,omitempty"` // Template variables for this item.
+}
+
+// BatchResult is the output record of a single batch item.
+type BatchResult struct {
+	Id        string            `json:"id"`
+	Agent     string            `json:"agent"`
+	Content   string            `json:"content"`
+	ToolCalls []*agent.ToolCall `json:"tool_calls"`
+	Usage     *agent.Usage      `json:"usage"`
+	Attempts  int               `json:"attempts"`
+	Duration  string            `json:"duration"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// BatchOptions control the running of a batch.
+type BatchOptions struct {
+	Input       string        // Input file, JSONL or CSV.
+	Output      string        // Output file, JSONL.
+	Concurrency int           // Items to run at once; at least one.
+	Retries     int           // Retries of transient errors per item.
+	RetryDelay  time.Duration // Delay before first retry; zero means the default.
+	Resume      bool          // Resume from the output file, skipping items done.
+}
+
+// BatchSummary summarizes a batch run.
+type BatchSummary struct {
+	Total     int `json:"total"`
+	Skipped   int `json:"skipped"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// ReadBatchItems reads batch items from file, which must be either JSONL
+// with BatchItem records, or CSV with a header row.  CSV columns other than
+// "id" and "prompt" are used as vars, so with a prompt template the prompt
+// column may be omitted.
+//
+// Blank lines in JSONL are ignored.  Items without ids are given their
+// 1-based item number as id.  Duplicate ids are errors.
+func ReadBatchItems(file string) ([]*BatchItem, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBatchInput, err)
+	}
+	defer f.Close()
+
+	var items []*BatchItem
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jsonl", ".ndjson":
+		items, err = readBatchJsonl(f)
+	case ".csv":
+		items, err = readBatchCsv(f)
+	default:
+		return nil, fmt.Errorf("%w: unsupported extension: %q", ErrBatchInput, file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrBatchInput, file, err)
+	}
+
+	have := map[string]bool{}
+	for i, item := range items {
+		if item.Id == "" {
+			item.Id = strconv.Itoa(i + 1)
+		}
+		if have[item.Id] {
+			return nil, fmt.Errorf("%w: %q: duplicate id %q", ErrBatchInput, file, item.Id)
+		}
+		have[item.Id] = true
+	}
+	return items, nil
+}
+
+func readBatchJsonl(r io.Reader) ([]*BatchItem, error) {
+
+	items := []*BatchItem{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // prompts can be big.
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := scanner.Bytes()
+		if strings.TrimSpace(string(b)) == "" {
+			continue
+		}
+		// Ids may be numbers, and vars may be any scalars.
+		var raw struct {
+			Id     any            `json:"id"`
+			Prompt string         `json:"prompt"`
+			Vars   map[string]any `json:"vars"`
+		}
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		item := &BatchItem{Prompt: raw.Prompt}
+		if raw.Id != nil {
+			item.Id = fmt.Sprint(raw.Id)
+		}
+		if len(raw.Vars) > 0 {
+			item.Vars = map[string]string{}
+			for k, v := range raw.Vars {
+				item.Vars[k] = fmt.Sprint(v)
+			}
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func readBatchCsv(r io.Reader) ([]*BatchItem, error) {
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []*BatchItem{}, nil
+	}
+	header := records[0]
+	items := make([]*BatchItem, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		item := &BatchItem{}
+		for i, val := range rec {
+			switch header[i] {
+			case "id":
+				item.Id = val
+			case "prompt":
+				item.Prompt = val
+			default:
+				if item.Vars == nil {
+					item.Vars = map[string]string{}
+				}
+				item.Vars[header[i]] = val
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readBatchDone returns the ids of items completed without error in the
+// output file, which need not exist.
+func readBatchDone(file string) (map[string]bool, error) {
+	done := map[string]bool{}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		res := &BatchResult{}
+		// A partial last line, say after a crash, is simply not done.
+		if err := json.Unmarshal(scanner.Bytes(), res); err != nil {
+			continue
+		}
+		done[res.Id] = res.Error == ""
+	}
+	return done, scanner.Err()
+}
+
+// ensureNewline writes a newline to out if the file is not empty and does not
+// end in one, e.g. after a crash mid-write.
+func ensureNewline(file string, out io.Writer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = fmt.Fprintln(out)
+	}
+	return err
+}
+
+// RunBatch runs the single configured agent over the items in the input
+// file, writing a BatchResult for each item to the output file as it
+// completes, and a summary to w.
+//
+// Each item gets a fresh, silent agent spawned with the item's vars.  Items
+// are run concurrently as set in opts, and transient errors are retried (see
+// agent.IsRetryable) with exponential backoff.
+//
+// The output file doubles as a checkpoint: when resuming, items already
+// completed without error are skipped, and new results are appended.
+// Without Resume, the output file must not exist.
+//
+// If any item fails, ErrBatchFailed is returned after all items are run.
+func (r *Runner) RunBatch(ctx context.Context, w io.Writer, opts *BatchOptions) (*BatchSummary, error) {
+
+	if len(r.Agents) != 1 {
+		return nil, fmt.Errorf("batch requires exactly one agent, have %d", len(r.Agents))
+	}
+	src := r.Agents[0]
+	if opts.Concurrency < 1 {
+		return nil, fmt.Errorf("batch concurrency must be at least one")
+	}
+	if opts.Retries < 0 {
+		return nil, fmt.Errorf("batch retries must not be negative")
+	}
+	delay := opts.RetryDelay
+	if delay == 0 {
+		delay = DefaultBatchRetryDelay
+	}
+
+	items, err := ReadBatchItems(opts.Input)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.Prompt == "" && !src.HasPromptTemplate() {
+			return nil, fmt.Errorf("%w: no prompt for item %q", ErrBatchInput, item.Id)
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	done := map[string]bool{}
+	if opts.Resume {
+		if done, err = readBatchDone(opts.Output); err != nil {
+			return nil, fmt.Errorf("error reading batch output: %w", err)
+		}
+	} else {
+		flags |= os.O_EXCL
+	}
+	out, err := os.OpenFile(opts.Output, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening batch output: %w", err)
+	}
+	defer out.Close()
+	if opts.Resume {
+		if err := ensureNewline(opts.Output, out); err != nil {
+			return nil, fmt.Errorf("error preparing batch output: %w", err)
+		}
+	}
+
+	summary := &BatchSummary{Total: len(items)}
+	todo := make(chan *BatchItem)
+	var mutex sync.Mutex
+	var write_err error
+	var wg sync.WaitGroup
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range todo {
+				res := r.runBatchItem(ctx, src, item, opts.Retries, delay)
+				mutex.Lock()
+				if res.Error == "" {
+					summary.Succeeded++
+				} else {
+					summary.Failed++
+				}
+				// One write per line keeps the checkpoint consistent.
+				if _, err := fmt.Fprintln(out, utils.MustJsonString(res)); err != nil && write_err == nil {
+					write_err = err
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, item := range items {
+		if done[item.Id] {
+			summary.Skipped++
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		todo <- item
+	}
+	close(todo)
+	wg.Wait()
+
+	fmt.Fprintf(w, "total: %d, skipped: %d, succeeded: %d, failed: %d\n",
+		summary.Total, summary.Skipped, summary.Succeeded, summary.Failed)
+	if write_err != nil {
+		return summary, fmt.Errorf("error writing batch output: %w", write_err)
+	}
+	if err := ctx.Err(); err != nil {
+		return summary, err
+	}
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%w: %d of %d", ErrBatchFailed,
+			summary.Failed, summary.Total-summary.Skipped)
+	}
+	return summary, nil
+
+}
+
+// runBatchItem runs item with a fresh agent per attempt, so that a failed
+// attempt leaves no trace in the context.
+func (r *Runner) runBatchItem(ctx context.Context, src *agent.Agent, item *BatchItem, retries int, delay time.Duration) *BatchResult {
+
+	start := time.Now()
+	res := &BatchResult{Id: item.Id}
+	for attempt := 0; ; attempt++ {
+		res.Attempts = attempt + 1
+		err := runBatchAttempt(ctx, src, item, res)
+		if err == nil {
+			res.Error = ""
+			break
+		}
+		res.Error = err.Error()
+		if attempt >= retries || !agent.IsRetryable(err) {
+			r.Logger.Error("batch item failed", "id", item.Id,
+				"attempts", res.Attempts, "error", err)
+			break
+		}
+		wait := delay << attempt
+		r.Logger.Warn("retrying batch item", "id", item.Id,
+			"attempt", res.Attempts, "wait", wait.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			res.Error = ctx.Err().Error()
+			res.Duration = utils.Dur(start)
+			return res
+		case <-time.After(wait):
+		}
+	}
+	res.Duration = utils.Dur(start)
+	return res
+
+}
+
+func runBatchAttempt(ctx context.Context, src *agent.Agent, item *BatchItem, res *BatchResult) error {
+	a, err := src.SpawnWithVars(item.Vars)
+	if err != nil {
+		return err
+	}
+	a.SetPrintFunc(agent.NullPrintFunc)
+	res.Agent = a.Ident()
+	c_res, err := a.RunCompletion(ctx, &agent.CompletionRequest{
+		Content: item.Prompt,
+		Vars:    item.Vars,
+	})
+	if err != nil {
+		return err
+	}
+	res.Content = c_res.Content
+	res.ToolCalls = c_res.ToolCalls
+	res.Usage = c_res.Usage
+	return nil
+}
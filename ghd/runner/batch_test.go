@@ -0,0 +1,298 @@
+package runner_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/runner"
+)
+
+type retryableErr struct{}
+
+func (e retryableErr) Error() string   { return "try again" }
+func (e retryableErr) Retryable() bool { return true }
+
+// batchClient answers prompts by echo, except:
+//
+//	"fail"  -> permanent error
+//	"flaky" -> retryable error on the first two attempts
+type batchClient struct {
+	agent.BasicApiClient
+}
+
+var batchFlakes = map[string]int{}
+var batchMutex sync.Mutex
+
+func (c *batchClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	switch req.Content {
+	case "fail":
+		return nil, fmt.Errorf("permanent failure")
+	case "flaky":
+		batchMutex.Lock()
+		defer batchMutex.Unlock()
+		batchFlakes[req.Content]++
+		if batchFlakes[req.Content] < 3 {
+			return nil, retryableErr{}
+		}
+	}
+	return &agent.CompletionResponse{
+		Content: "done: " + req.Content,
+		Usage:   &agent.Usage{Total: 1},
+	}, nil
+}
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-batch", func() (agent.ApiClient, error) {
+		return &batchClient{}, nil
+	})
+}
+
+func batchRunner(t *testing.T, prompt_template string) *runner.Runner {
+	r, err := runner.NewRunner(&runner.Config{
+		NoLog: true,
+		Agents: []*agent.Config{{
+			Name:           "batcher",
+			Type:           "fake-batch",
+			Silent:         true,
+			PromptTemplate: prompt_template,
+			// Templates are checked at creation, so need defaults.
+			Vars: map[string]string{"thing": "stuff"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func readResults(t *testing.T, file string) map[string]*runner.BatchResult {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]*runner.BatchResult{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		res := &runner.BatchResult{}
+		if err := json.Unmarshal([]byte(line), res); err != nil {
+			continue // partial line from a simulated crash
+		}
+		results[res.Id] = res
+	}
+	return results
+}
+
+func TestReadBatchItemsJsonlOK(t *testing.T) {
+
+	require := require.New(t)
+
+	file := writeFile(t, "in.jsonl", `{"id":"a","prompt":"one"}
+
+{"prompt":"two","vars":{"n":3,"s":"x"}}
+{"id":7,"prompt":"three"}
+`)
+	items, err := runner.ReadBatchItems(file)
+	require.NoError(err)
+	require.Equal([]*runner.BatchItem{
+		{Id: "a", Prompt: "one"},
+		{Id: "2", Prompt: "two", Vars: map[string]string{"n": "3", "s": "x"}},
+		{Id: "7", Prompt: "three"},
+	}, items)
+
+}
+
+func TestReadBatchItemsCsvOK(t *testing.T) {
+
+	require := require.New(t)
+
+	file := writeFile(t, "in.csv", "prompt,id,tone\none,a,dry\n\"two, too\",,wet\n")
+	items, err := runner.ReadBatchItems(file)
+	require.NoError(err)
+	require.Equal([]*runner.BatchItem{
+		{Id: "a", Prompt: "one", Vars: map[string]string{"tone": "dry"}},
+		{Id: "2", Prompt: "two, too", Vars: map[string]string{"tone": "wet"}},
+	}, items)
+
+	items, err = runner.ReadBatchItems(writeFile(t, "empty.csv", ""))
+	require.NoError(err)
+	require.Empty(items)
+
+}
+
+func TestReadBatchItemsErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	for name, tc := range map[string][2]string{
+		"bad.txt":   {"x", "unsupported extension"},
+		"bad.jsonl": {"{\n", "line 1"},
+		"dupe.jsonl": {`{"id":"a","prompt":"x"}` + "\n" + `{"id":"a","prompt":"y"}`,
+			`duplicate id "a"`},
+		"ragged.csv": {"id,prompt\n1,x,y\n", "wrong number of fields"},
+	} {
+		_, err := runner.ReadBatchItems(writeFile(t, name, tc[0]))
+		require.ErrorIs(err, runner.ErrBatchInput, name)
+		require.ErrorContains(err, tc[1], name)
+	}
+	_, err := runner.ReadBatchItems(filepath.Join(t.TempDir(), "nope.jsonl"))
+	require.ErrorIs(err, runner.ErrBatchInput)
+
+}
+
+func TestRunBatchOK(t *testing.T) {
+
+	require := require.New(t)
+
+	batchFlakes = map[string]int{}
+	in := writeFile(t, "in.jsonl", `{"id":"a","prompt":"one"}
+{"id":"b","prompt":"two"}
+{"id":"c","prompt":"flaky"}
+`)
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+
+	buf := new(strings.Builder)
+	summary, err := batchRunner(t, "").RunBatch(context.Background(), buf,
+		&runner.BatchOptions{
+			Input:       in,
+			Output:      out,
+			Concurrency: 2,
+			Retries:     2,
+			RetryDelay:  1,
+		})
+	require.NoError(err)
+	require.Equal(&runner.BatchSummary{Total: 3, Succeeded: 3}, summary)
+	require.Equal("total: 3, skipped: 0, succeeded: 3, failed: 0\n", buf.String())
+
+	results := readResults(t, out)
+	require.Len(results, 3)
+	require.Equal("done: two", results["b"].Content)
+	require.Equal(1, results["b"].Usage.Total)
+	require.Equal(1, results["b"].Attempts)
+	require.Contains(results["b"].Agent, ":fake-batch:batcher")
+	require.Equal(3, results["c"].Attempts)
+	require.Empty(results["c"].Error)
+
+	// Not without resume!
+	_, err = batchRunner(t, "").RunBatch(context.Background(), buf,
+		&runner.BatchOptions{Input: in, Output: out, Concurrency: 1})
+	require.ErrorIs(err, os.ErrExist)
+
+}
+
+func TestRunBatchFailuresAndResume(t *testing.T) {
+
+	require := require.New(t)
+
+	batchFlakes = map[string]int{}
+	in := writeFile(t, "in.jsonl", `{"id":"a","prompt":"one"}
+{"id":"b","prompt":"fail"}
+{"id":"c","prompt":"flaky"}
+`)
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+	opts := &runner.BatchOptions{
+		Input:       in,
+		Output:      out,
+		Concurrency: 1,
+		Retries:     1,
+		RetryDelay:  1,
+	}
+
+	buf := new(strings.Builder)
+	summary, err := batchRunner(t, "").RunBatch(context.Background(), buf, opts)
+	require.ErrorIs(err, runner.ErrBatchFailed)
+	require.ErrorContains(err, "2 of 3")
+	require.Equal(&runner.BatchSummary{Total: 3, Succeeded: 1, Failed: 2}, summary)
+	results := readResults(t, out)
+	require.Equal("error running completion: permanent failure", results["b"].Error)
+	require.Equal(1, results["b"].Attempts, "no retry of permanent errors")
+	require.Equal("error running completion: try again", results["c"].Error)
+	require.Equal(2, results["c"].Attempts)
+
+	// Simulate a crash mid-write, which must not break resumption.
+	f, err := os.OpenFile(out, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(err)
+	_, err = f.WriteString(`{"id":"c","con`)
+	require.NoError(err)
+	f.Close()
+
+	// The flaky one now succeeds, the failure still fails.
+	opts.Resume = true
+	buf.Reset()
+	summary, err = batchRunner(t, "").RunBatch(context.Background(), buf, opts)
+	require.ErrorIs(err, runner.ErrBatchFailed)
+	require.ErrorContains(err, "1 of 2")
+	require.Equal(&runner.BatchSummary{Total: 3, Skipped: 1, Succeeded: 1, Failed: 1}, summary)
+	results = readResults(t, out)
+	require.Equal("done: flaky", results["c"].Content, "last record wins")
+	require.Empty(results["c"].Error)
+
+	// And now only the failure is left.
+	buf.Reset()
+	summary, err = batchRunner(t, "").RunBatch(context.Background(), buf, opts)
+	require.ErrorContains(err, "1 of 1")
+	require.Equal(&runner.BatchSummary{Total: 3, Skipped: 2, Failed: 1}, summary)
+
+}
+
+func TestRunBatchPromptTemplate(t *testing.T) {
+
+	require := require.New(t)
+
+	in := writeFile(t, "in.csv", "id,thing\n1,cats\n2,dogs\n")
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+	_, err := batchRunner(t, "about {{.thing}}").RunBatch(context.Background(),
+		new(strings.Builder),
+		&runner.BatchOptions{Input: in, Output: out, Concurrency: 3})
+	require.NoError(err)
+	results := readResults(t, out)
+	require.Equal("done: about cats", results["1"].Content)
+	require.Equal("done: about dogs", results["2"].Content)
+
+}
+
+func TestRunBatchErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	in := writeFile(t, "in.jsonl", `{"id":"a"}`)
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+	ctx := context.Background()
+	w := new(strings.Builder)
+
+	_, err := blankRunner().RunBatch(ctx, w, &runner.BatchOptions{})
+	require.ErrorContains(err, "exactly one agent, have 0")
+
+	r := batchRunner(t, "")
+	_, err = r.RunBatch(ctx, w, &runner.BatchOptions{Input: in, Output: out})
+	require.ErrorContains(err, "concurrency must be at least one")
+	_, err = r.RunBatch(ctx, w, &runner.BatchOptions{Concurrency: 1, Retries: -1})
+	require.ErrorContains(err, "retries must not be negative")
+	_, err = r.RunBatch(ctx, w, &runner.BatchOptions{Input: in, Output: out, Concurrency: 1})
+	require.ErrorIs(err, runner.ErrBatchInput)
+	require.ErrorContains(err, `no prompt for item "a"`)
+	_, err = r.RunBatch(ctx, w, &runner.BatchOptions{
+		Input:       writeFile(t, "ok.jsonl", `{"prompt":"x"}`),
+		Output:      filepath.Join(t.TempDir(), "nope", "out.jsonl"),
+		Concurrency: 1,
+	})
+	require.ErrorContains(err, "error opening batch output")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	summary, err := r.RunBatch(cancelled, w, &runner.BatchOptions{
+		Input:       writeFile(t, "ok.jsonl", `{"prompt":"x"}`),
+		Output:      out,
+		Concurrency: 1,
+	})
+	require.ErrorIs(err, context.Canceled)
+	require.Equal(0, summary.Succeeded+summary.Failed, "nothing run")
+
+}
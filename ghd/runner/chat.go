@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/chzyer/readline"
+
+	"github.com/biztos/greenhead/ghd/agent"
 )
 
 // TODO: (long-term) - by default log internally to the chat and allow the
@@ -41,6 +43,11 @@ func (r *Runner) RunChat() error {
 	}
 	defer rl.Close()
 
+	// Tool calls requiring approval are approved in the chat itself.
+	if agent.Approver() == nil {
+		agent.SetApprover(readlineApprover(rl, "> "))
+	}
+
 	prompt := ""
 	for {
 		line, err := rl.Readline()
@@ -53,6 +60,12 @@ func (r *Runner) RunChat() error {
 			if prompt == "" {
 				break
 			}
+			// Tools made by tools in the last turn are available now.
+			if agent.AllowsToolChanges() {
+				if err := agent.RefreshTools(); err != nil {
+					return err
+				}
+			}
 			_, err = agent.RunCompletionPrompt(prompt)
 			if err != nil {
 				return err
@@ -66,3 +79,20 @@ func (r *Runner) RunChat() error {
 
 	return nil
 }
+
+// readlineApprover returns a TerminalApprover that reads answers from rl,
+// restoring the chat prompt afterwards.  Only the last line of the approval
+// prompt is used as the readline prompt.
+func readlineApprover(rl *readline.Instance, chat_prompt string) agent.Approver {
+	return &agent.TerminalApprover{
+		ReadLine: func(prompt string) (string, error) {
+			defer rl.SetPrompt(chat_prompt)
+			if idx := strings.LastIndex(prompt, "\n"); idx >= 0 {
+				fmt.Println(prompt[:idx])
+				prompt = prompt[idx+1:]
+			}
+			rl.SetPrompt(prompt)
+			return rl.Readline()
+		},
+	}
+}
@@ -4,15 +4,24 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"path/filepath"
+	"slices"
 
 	"github.com/BurntSushi/toml"
 
 	"github.com/biztos/greenhead/ghd/agent"
 	"github.com/biztos/greenhead/ghd/api"
 	"github.com/biztos/greenhead/ghd/assets"
+	"github.com/biztos/greenhead/ghd/policy"
 	"github.com/biztos/greenhead/ghd/rgxp"
 	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/factory"
+	"github.com/biztos/greenhead/ghd/tools/fs"
+	"github.com/biztos/greenhead/ghd/tools/memory"
+	"github.com/biztos/greenhead/ghd/tools/search"
+	"github.com/biztos/greenhead/ghd/tools/sql"
+	"github.com/biztos/greenhead/ghd/tools/web"
 	"github.com/biztos/greenhead/ghd/utils"
 )
 
@@ -40,6 +49,28 @@ type Config struct {
 	// External tool definitions:
 	ExternalTools []*tools.ExternalToolConfig `toml:"external_tools"` // External tools to expose.
 
+	// Starlark script tool definitions:
+	ScriptTools []*tools.ScriptToolConfig `toml:"script_tools"` // Script tools to expose.
+
+	// WebAssembly tool modules:
+	WasmTools []*tools.WasmToolConfig `toml:"wasm_tools"` // WASM tools to expose.
+
+	// Persistent commands providing tools over JSON-RPC:
+	RpcServers []*tools.RpcServerConfig `toml:"rpc_servers"` // RPC tool servers to run.
+
+	// Agents callable by other agents as tools:
+	AgentsAsTools []*agent.AgentToolConfig `toml:"agents_as_tools"` // Agents to expose as tools.
+
+	// Tools creating tools at runtime:  (Requires the ghd_tool_factories build tag.)
+	ExternalFactories []*factory.ExternalFactoryConfig `toml:"external_factories"` // External tool factories to expose.
+
+	// Built-in tools needing config:
+	FsTools     *fs.Config     `toml:"fs_tools"`     // Filesystem tools and their root directories.
+	WebTools    *web.Config    `toml:"web_tools"`    // Web fetch tool and its allowed URLs.
+	SqlTools    *sql.Config    `toml:"sql_tools"`    // SQL query tools and their databases.
+	SearchTools *search.Config `toml:"search_tools"` // Document search tool and its index.
+	MemoryTools *memory.Config `toml:"memory_tools"` // Agent memory tools and their database.
+
 	// Tool access control:
 	// (Can use /regexp/ syntax.)
 	NoTools     bool                 `toml:"no_tools"`     // Unregister all tools and remove from agents.
@@ -48,7 +79,12 @@ type Config struct {
 	AgentTools  []*rgxp.OptionalRgxp `toml:"agent_tools"`  // Override all agent Tools with this if set.
 
 	// Safety:
-	StopMatches []*rgxp.Rgxp `toml:"stop_matches"` // Stop if any output matches any of these.
+	StopMatches     []*rgxp.Rgxp         `toml:"stop_matches"`     // Stop if any output matches any of these.
+	RequireApproval []*rgxp.OptionalRgxp `toml:"require_approval"` // Tools whose calls need approval, added to all agents.
+	Policy          []*policy.Rule       `toml:"policy"`           // Rules on tool call args, added to all agents; ids must differ from theirs.
+
+	// Template variables:
+	Vars map[string]string `toml:"vars"` // Variables for agent templates, overriding agent configs.
 
 	// Agent configs:
 	Agents []*agent.Config `toml:"agents"` // Multiple Agents.
@@ -120,6 +156,37 @@ func (c *Config) LoadConfigs(runnerFile string, agentFiles ...string) error {
 		if c.StopMatches == nil {
 			c.StopMatches = r.StopMatches
 		}
+		if c.RequireApproval == nil {
+			c.RequireApproval = r.RequireApproval
+		}
+		if c.Policy == nil {
+			c.Policy = r.Policy
+		}
+		if c.FsTools == nil {
+			c.FsTools = r.FsTools
+		}
+		if c.WebTools == nil {
+			c.WebTools = r.WebTools
+		}
+		if c.SqlTools == nil {
+			c.SqlTools = r.SqlTools
+		}
+		if c.SearchTools == nil {
+			c.SearchTools = r.SearchTools
+		}
+		if c.MemoryTools == nil {
+			c.MemoryTools = r.MemoryTools
+		}
+
+		// Vars are merged, with ours winning.
+		for k, v := range r.Vars {
+			if _, ok := c.Vars[k]; !ok {
+				if c.Vars == nil {
+					c.Vars = map[string]string{}
+				}
+				c.Vars[k] = v
+			}
+		}
 
 		// API config is its own ball of fun-loving wax...
 		//
@@ -133,20 +200,19 @@ func (c *Config) LoadConfigs(runnerFile string, agentFiles ...string) error {
 
 		// We keep all arrays!
 		c.ExternalTools = append(c.ExternalTools, r.ExternalTools...)
+		c.ScriptTools = append(c.ScriptTools, r.ScriptTools...)
+		c.WasmTools = append(c.WasmTools, r.WasmTools...)
+		c.RpcServers = append(c.RpcServers, r.RpcServers...)
+		c.AgentsAsTools = append(c.AgentsAsTools, r.AgentsAsTools...)
+		c.ExternalFactories = append(c.ExternalFactories, r.ExternalFactories...)
 		c.Agents = append(c.Agents, r.Agents...)
 
 	}
 
 	for _, file := range agentFiles {
-
-		a := &agent.Config{}
-		if filepath.Ext(file) == "" {
-			a = NamedAgentConfigs[file]
-			if a == nil {
-				return fmt.Errorf("%w: %q", ErrNamedAgentNotAvailable, file)
-			}
-		} else if err := utils.UnmarshalFile(file, a); err != nil {
-			return fmt.Errorf("%w: %q: %w", ErrBadAgentConfig, file, err)
+		a, err := LoadAgentConfig(file)
+		if err != nil {
+			return err
 		}
 		c.Agents = append(c.Agents, a)
 	}
@@ -156,6 +222,23 @@ func (c *Config) LoadConfigs(runnerFile string, agentFiles ...string) error {
 
 }
 
+// LoadAgentConfig returns the agent config for a built-in agent name, or
+// loads it from file if name has an extension.
+func LoadAgentConfig(name string) (*agent.Config, error) {
+	if filepath.Ext(name) == "" {
+		a := NamedAgentConfigs[name]
+		if a == nil {
+			return nil, fmt.Errorf("%w: %q", ErrNamedAgentNotAvailable, name)
+		}
+		return a, nil
+	}
+	a := &agent.Config{}
+	if err := utils.UnmarshalFile(name, a); err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrBadAgentConfig, name, err)
+	}
+	return a, nil
+}
+
 var ErrExternalToolDupeName = fmt.Errorf("duplicate name for external tool")
 var ErrExternalToolBlankName = fmt.Errorf("blank name for external tool")
 
@@ -167,6 +250,17 @@ func (c *Config) Validate() error {
 	if (c.LogFile != "" || c.Debug) && c.NoLog {
 		return fmt.Errorf("Logging can not be both specified and disabled.")
 	}
+	// Runner rules can not be replaced by agent rules with the same id.
+	for _, a := range c.Agents {
+		for _, rule := range a.Policy {
+			if slices.ContainsFunc(c.Policy, func(have *policy.Rule) bool {
+				return have != rule && have.Id == rule.Id
+			}) {
+				return fmt.Errorf("Agent %q policy rule %q has the id of a runner rule.",
+					a.Name, rule.Id)
+			}
+		}
+	}
 	// TODO: other things perhaps!
 	return nil
 }
@@ -178,6 +272,8 @@ func (c *Config) Validate() error {
 // Special cases:
 //
 // - MaxCompletions and MaxToolChain only override if nonzero.
+// - Vars are merged into the agent Vars, overriding same-named ones.
+// - RequireApproval and Policy are added to the agent's, never replacing.
 //
 // This is not strictly necessary, but one would expect havoc to ensue if the
 // values differ.  If you find a compelling use-case for that, please open
@@ -203,6 +299,24 @@ func (c *Config) ConformAgents() {
 		if len(c.StopMatches) > 0 {
 			a.StopMatches = c.StopMatches
 		}
+		for _, re := range c.RequireApproval {
+			if !slices.ContainsFunc(a.RequireApproval, func(have *rgxp.OptionalRgxp) bool {
+				return have.String() == re.String()
+			}) {
+				a.RequireApproval = append(a.RequireApproval, re)
+			}
+		}
+		for _, rule := range c.Policy {
+			if !slices.Contains(a.Policy, rule) {
+				a.Policy = append(a.Policy, rule)
+			}
+		}
+		if len(c.Vars) > 0 {
+			if a.Vars == nil {
+				a.Vars = map[string]string{}
+			}
+			maps.Copy(a.Vars, c.Vars)
+		}
 	}
 }
 
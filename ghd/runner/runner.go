@@ -2,6 +2,7 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,72 +13,178 @@ import (
 	"github.com/biztos/greenhead/ghd/agent"
 	"github.com/biztos/greenhead/ghd/registry"
 	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/factory"
+	"github.com/biztos/greenhead/ghd/tools/fs"
+	"github.com/biztos/greenhead/ghd/tools/memory"
+	"github.com/biztos/greenhead/ghd/tools/search"
+	"github.com/biztos/greenhead/ghd/tools/sql"
+	"github.com/biztos/greenhead/ghd/tools/web"
 )
 
 // Runner is the runner of commands.
 type Runner struct {
-	Config *Config
-	Agents []*agent.Agent
-	Logger *slog.Logger
+	Config   *Config
+	Agents   []*agent.Agent
+	Logger   *slog.Logger
+	Servers  []*tools.RpcServer
+	Closers  []io.Closer // Tools holding resources, e.g. open databases.
+	Registry *registry.Registry
 }
 
-// NewRunner returns a new runner with the configuration processed.
+// NewRunner returns a new runner with the configuration processed, using the
+// default registry.
 //
-// It is a thin wrapper around SetupTools, CreateLogger and CreateAgents.
+// It is a thin wrapper around NewRunnerWithRegistry.
+func NewRunner(cfg *Config) (*Runner, error) {
+	return NewRunnerWithRegistry(cfg, registry.Default)
+}
+
+// NewRunnerWithRegistry returns a new runner with the configuration
+// processed, and its tools set up in reg.  To leave the default registry
+// untouched, pass a Clone of it.
+//
+// It is a thin wrapper around CreateLogger, StartRpcServers, SetupTools and
+// CreateAgents.
 //
 // Logger is set as the slog default.
-func NewRunner(cfg *Config) (*Runner, error) {
+//
+// If any RPC servers are configured, they are running and must be stopped
+// with Close, which also closes tools holding resources such as databases.
+func NewRunnerWithRegistry(cfg *Config, reg *registry.Registry) (*Runner, error) {
 
-	if err := SetupTools(cfg); err != nil {
-		return nil, err
-	}
 	logger, err := CreateLogger(cfg)
 	if err != nil {
 		return nil, err
 	}
 	slog.SetDefault(logger)
-	agents, err := CreateAgents(cfg)
+	r := &Runner{
+		Config:   cfg,
+		Logger:   logger,
+		Registry: reg,
+	}
+	if !cfg.NoTools {
+		r.Servers, err = StartRpcServers(reg, cfg.RpcServers, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.Closers, err = SetupTools(reg, cfg)
 	if err != nil {
+		r.Close()
 		return nil, err
 	}
-	return &Runner{
-		Config: cfg,
-		Agents: agents,
-		Logger: logger,
-	}, nil
+	r.Agents, err = CreateAgents(reg, cfg)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+
+}
 
+// Close closes any tools holding resources and stops any RPC servers,
+// returning any errors joined.
+func (r *Runner) Close() error {
+	errs := []error{}
+	for _, c := range r.Closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, s := range r.Servers {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// SetupTools creates, registers, and/or deregisters tools based on cfg.
+// SetupTools creates, registers, and/or deregisters tools in reg based on
+// cfg, then locks reg.  It returns the tools holding resources, such as WASM
+// runtimes and open databases, which must be closed when no longer needed.
+// On error, they are closed.
 //
 // Note that there is no concept of "allow nothing" -- set NoTools to achieve
 // that result.
-func SetupTools(cfg *Config) error {
+func SetupTools(reg *registry.Registry, cfg *Config) (closers []io.Closer, err error) {
 
 	// NoTools is the easiest thing!
 	if cfg.NoTools {
-		registry.Clear()
-		return nil
+		reg.Clear()
+		return nil, nil
 	}
+	defer func() {
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			closers = nil
+		}
+	}()
 
 	// Register any external tools before dealing with other limits.
-	if err := RegisterExternalTools(cfg.ExternalTools); err != nil {
-		return err
+	if err := RegisterExternalTools(reg, cfg.ExternalTools); err != nil {
+		return closers, err
+	}
+	if err := RegisterScriptTools(reg, cfg.ScriptTools); err != nil {
+		return closers, err
+	}
+	wasm_tools, err := RegisterWasmTools(reg, cfg.WasmTools)
+	if err != nil {
+		return closers, err
+	}
+	for _, tool := range wasm_tools {
+		closers = append(closers, tool)
+	}
+	if err := RegisterAgentTools(reg, cfg.AgentsAsTools); err != nil {
+		return closers, err
+	}
+	if err := RegisterExternalFactories(reg, cfg.ExternalFactories); err != nil {
+		return closers, err
+	}
+	if cfg.FsTools != nil {
+		if err := fs.Register(reg, cfg.FsTools); err != nil {
+			return closers, err
+		}
+	}
+	if cfg.WebTools != nil {
+		if err := web.Register(reg, cfg.WebTools); err != nil {
+			return closers, err
+		}
+	}
+	if cfg.SqlTools != nil {
+		databases, err := sql.Register(reg, cfg.SqlTools)
+		if err != nil {
+			return closers, err
+		}
+		closers = append(closers, databases)
+	}
+	if cfg.SearchTools != nil {
+		if err := search.Register(reg, cfg.SearchTools); err != nil {
+			return closers, err
+		}
+	}
+	if cfg.MemoryTools != nil {
+		store, err := memory.Register(reg, cfg.MemoryTools)
+		if err != nil {
+			return closers, err
+		}
+		closers = append(closers, store)
 	}
 
 	// Save mutexes if nothing to see here.
 	if len(cfg.AllowTools) == 0 && len(cfg.RemoveTools) == 0 {
-		return nil
+		return closers, nil
 	}
 
 	// Get allow and remove lists.
-	allow, err := registry.MatchingNames(cfg.AllowTools)
+	allow, err := reg.MatchingNames(cfg.AllowTools)
 	if err != nil {
-		return fmt.Errorf("error in allowed tools: %w", err)
+		return closers, fmt.Errorf("error in allowed tools: %w", err)
 	}
-	remove, err := registry.MatchingNames(cfg.RemoveTools)
+	remove, err := reg.MatchingNames(cfg.RemoveTools)
 	if err != nil {
-		return fmt.Errorf("error in remove tools: %w", err)
+		return closers, fmt.Errorf("error in remove tools: %w", err)
 	}
 
 	// No overlap allowed.
@@ -87,14 +194,15 @@ func SetupTools(cfg *Config) error {
 	}
 	for _, n := range remove {
 		if allowed[n] {
-			return fmt.Errorf("can not both allow and remove tool: %q", n)
+			return closers, fmt.Errorf("can not both allow and remove tool: %q", n)
 		}
 	}
 
-	// Allow is actually removal-based.
-	if len(allow) > 0 {
+	// Allow is actually removal-based.  Selectors can match nothing, in
+	// which case nothing is allowed.
+	if len(cfg.AllowTools) > 0 {
 		remove = []string{}
-		for _, n := range registry.Names() {
+		for _, n := range reg.Names() {
 			if !allowed[n] {
 				remove = append(remove, n)
 			}
@@ -102,25 +210,26 @@ func SetupTools(cfg *Config) error {
 	}
 
 	for _, n := range remove {
-		if err := registry.Remove(n); err != nil {
-			return fmt.Errorf("error removing tools: %w", err)
+		if err := reg.Remove(n); err != nil {
+			return closers, fmt.Errorf("error removing tools: %w", err)
 		}
 	}
 
 	// TODO: config to leave it unlocked for managing custom runtime tools.
 	// (need to define that better first)
-	registry.Lock()
+	reg.Lock()
 
-	return nil
+	return closers, nil
 }
 
-// RegisterExternalTools registers all the external tools defined in configs,
-// and should be called before setting available tools for a runner or agent.
+// RegisterExternalTools registers all the external tools defined in configs
+// in reg, and should be called before setting available tools for a runner
+// or agent.
 //
 // Note that overriding same-named built-in tools is explicitly allowed
-// unless disabled with registry.LockForReplace() -- but duplicate names
-// within the same call to this function are not allowed.
-func RegisterExternalTools(configs []*tools.ExternalToolConfig) error {
+// unless disabled with LockForReplace -- but duplicate names within the same
+// call to this function are not allowed.
+func RegisterExternalTools(reg *registry.Registry, configs []*tools.ExternalToolConfig) error {
 
 	if len(configs) == 0 {
 		return nil
@@ -143,18 +252,206 @@ func RegisterExternalTools(configs []*tools.ExternalToolConfig) error {
 
 	// Now register them.
 	for _, tool := range ext_tools {
-		if err := registry.Register(tool); err != nil {
+		if err := reg.Register(tool); err != nil {
+			return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+		}
+	}
+	return nil
+}
+
+var ErrScriptToolDupeName = fmt.Errorf("duplicate name for script tool")
+
+// RegisterScriptTools registers the Starlark script tools defined in
+// configs in reg.  As with external tools, duplicate names within the same
+// call to this function are not allowed.
+func RegisterScriptTools(reg *registry.Registry, configs []*tools.ScriptToolConfig) error {
+
+	script_tools := make([]*tools.ScriptTool, 0, len(configs))
+	have := map[string]bool{}
+	for _, cfg := range configs {
+		tool, err := tools.NewScriptTool(cfg)
+		if err != nil {
+			return err
+		}
+		if have[cfg.Name] {
+			return fmt.Errorf("%w: %q", ErrScriptToolDupeName, cfg.Name)
+		}
+		have[cfg.Name] = true
+		script_tools = append(script_tools, tool)
+	}
+
+	for _, tool := range script_tools {
+		if err := reg.Register(tool); err != nil {
+			return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+		}
+	}
+	return nil
+}
+
+var ErrWasmToolDupeName = fmt.Errorf("duplicate name for wasm tool")
+
+// RegisterWasmTools registers the WebAssembly tools defined in configs in
+// reg, returning the tools, which must be closed when no longer needed.  On
+// error, any created tools are closed.
+//
+// As with external tools, duplicate names within the same call to this
+// function are not allowed.
+func RegisterWasmTools(reg *registry.Registry, configs []*tools.WasmToolConfig) ([]*tools.WasmTool, error) {
+
+	wasm_tools := make([]*tools.WasmTool, 0, len(configs))
+	fail := func(err error) ([]*tools.WasmTool, error) {
+		for _, tool := range wasm_tools {
+			tool.Close()
+		}
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, cfg := range configs {
+		tool, err := tools.NewWasmTool(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		wasm_tools = append(wasm_tools, tool)
+		if have[tool.Name()] {
+			return fail(fmt.Errorf("%w: %q", ErrWasmToolDupeName, tool.Name()))
+		}
+		have[tool.Name()] = true
+	}
+
+	for _, tool := range wasm_tools {
+		if err := reg.Register(tool); err != nil {
+			return fail(fmt.Errorf("failed to register %q: %s", tool.Name(), err))
+		}
+	}
+	return wasm_tools, nil
+}
+
+var ErrRpcToolDupeName = fmt.Errorf("duplicate name for rpc tool")
+
+// StartRpcServers starts the RPC servers defined in configs and registers
+// their tools in reg, returning the servers, which must be closed when no
+// longer needed.  On error, any started servers are closed.
+//
+// As with external tools, duplicate tool names within the same call to this
+// function are not allowed.
+func StartRpcServers(reg *registry.Registry, configs []*tools.RpcServerConfig, logger *slog.Logger) ([]*tools.RpcServer, error) {
+
+	servers := make([]*tools.RpcServer, 0, len(configs))
+	fail := func(err error) ([]*tools.RpcServer, error) {
+		for _, s := range servers {
+			s.Close()
+		}
+		return nil, err
+	}
+	rpc_tools := []tools.Tooler{}
+	have := map[string]bool{}
+	for _, cfg := range configs {
+		s, err := tools.NewRpcServer(cfg, logger)
+		if err != nil {
+			return fail(err)
+		}
+		if err := s.Start(); err != nil {
+			return fail(err)
+		}
+		servers = append(servers, s)
+		for _, tool := range s.Tools() {
+			if have[tool.Name()] {
+				return fail(fmt.Errorf("%w: %q", ErrRpcToolDupeName, tool.Name()))
+			}
+			have[tool.Name()] = true
+			rpc_tools = append(rpc_tools, tool)
+		}
+	}
+
+	for _, tool := range rpc_tools {
+		if err := reg.Register(tool); err != nil {
+			return fail(fmt.Errorf("failed to register %q: %s", tool.Name(), err))
+		}
+	}
+	return servers, nil
+}
+
+var ErrExternalFactoryDupeName = fmt.Errorf("duplicate name for external factory")
+
+// RegisterExternalFactories registers the tool factories defined in configs
+// in reg.  As with external tools, duplicate names within the same call to
+// this function are not allowed.
+//
+// The factories are registered in any build, but only work in builds with
+// tool factories enabled; see agent.ToolFactoriesEnabled.
+func RegisterExternalFactories(reg *registry.Registry, configs []*factory.ExternalFactoryConfig) error {
+
+	factories := make([]tools.Tooler, 0, len(configs))
+	have := map[string]bool{}
+	for _, cfg := range configs {
+		tool, err := factory.NewExternalFactory(cfg)
+		if err != nil {
+			return err
+		}
+		if have[cfg.Name] {
+			return fmt.Errorf("%w: %q", ErrExternalFactoryDupeName, cfg.Name)
+		}
+		have[cfg.Name] = true
+		factories = append(factories, tool)
+	}
+
+	for _, tool := range factories {
+		if err := reg.Register(tool); err != nil {
+			return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+		}
+	}
+	return nil
+}
+
+var ErrAgentToolDupeName = fmt.Errorf("duplicate name for agent tool")
+
+// RegisterAgentTools registers agents as tools callable by other agents, in
+// reg.
+//
+// Configs without an agent Config have it loaded by name or file from the
+// Agent field, as with the --agent flag.  As with external tools, duplicate
+// names within the same call are not allowed.
+func RegisterAgentTools(reg *registry.Registry, configs []*agent.AgentToolConfig) error {
+
+	if len(configs) == 0 {
+		return nil
+	}
+
+	agent_tools := make([]tools.Tooler, 0, len(configs))
+	have := map[string]bool{}
+	for _, cfg := range configs {
+		if cfg.Config == nil && cfg.Agent != "" {
+			a, err := LoadAgentConfig(cfg.Agent)
+			if err != nil {
+				return fmt.Errorf("error loading agent for tool %q: %w",
+					cfg.Name, err)
+			}
+			cfg.Config = a
+		}
+		tool, err := agent.NewAgentTool(cfg)
+		if err != nil {
+			return err
+		}
+		if have[cfg.Name] {
+			return fmt.Errorf("%w: %q", ErrAgentToolDupeName, cfg.Name)
+		}
+		have[cfg.Name] = true
+		agent_tools = append(agent_tools, tool)
+	}
+
+	for _, tool := range agent_tools {
+		if err := reg.Register(tool); err != nil {
 			return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
 		}
 	}
 	return nil
 }
 
-// CreateAgents creates agents from cfg.
-func CreateAgents(cfg *Config) ([]*agent.Agent, error) {
+// CreateAgents creates agents from cfg, with tools from reg.
+func CreateAgents(reg *registry.Registry, cfg *Config) ([]*agent.Agent, error) {
 	agents := make([]*agent.Agent, 0, len(cfg.Agents))
 	for i, c := range cfg.Agents {
-		a, err := agent.NewAgent(c)
+		a, err := agent.NewAgentWithRegistry(c, reg)
 		if err != nil {
 			return nil, fmt.Errorf("error creating agent %d: %w", i+1, err)
 		}
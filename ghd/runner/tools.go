@@ -6,16 +6,28 @@ import (
 	"io"
 
 	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
 )
 
-// ListTools prints all registered tools to w.
-func (r *Runner) ListTools(w io.Writer, names_only bool) error {
+// ListTools prints all tools registered in the runner's registry to w, or
+// only those matching any of want, which may include metadata selectors such
+// as "tag:fs" or "risk<=write".
+func (r *Runner) ListTools(w io.Writer, names_only bool, want ...*rgxp.OptionalRgxp) error {
 
+	names := r.Registry.Names()
+	if len(want) > 0 {
+		var err error
+		names, err = r.Registry.MatchingNames(want)
+		if err != nil {
+			return err
+		}
+	}
 	var lines []string
 	if names_only {
-		lines = registry.Names()
-	} else {
-		lines = registry.DisplayLines()
+		lines = names
+	} else if len(names) > 0 {
+		lines = r.Registry.DisplayLines(names...)
 	}
 	if len(lines) == 0 {
 		fmt.Fprintln(w, "<no tools>")
@@ -28,18 +40,21 @@ func (r *Runner) ListTools(w io.Writer, names_only bool) error {
 }
 
 // ShowTool prints tool help to w, or returns an error if no tool is
-// registered for name.
+// registered for name in the runner's registry.
 func (r *Runner) ShowTool(w io.Writer, name string) error {
-	t, err := registry.Get(name)
+	t, err := r.Registry.Get(name)
 	if err != nil {
 		return err
 	}
 	fmt.Fprintln(w, t.Help())
+	if summary := tools.MetadataOf(t).Summary(); summary != "" {
+		fmt.Fprintf(w, "Metadata: %s\n", summary)
+	}
 	return nil
 }
 
-// RunTool runs a tool with args as a string to be converted to the input
-// type of the tool.
+// RunTool runs a tool from the default registry with args as a string to be
+// converted to the input type of the tool.
 func RunTool(name, args string) (any, error) {
 	t, err := registry.Get(name)
 	if err != nil {
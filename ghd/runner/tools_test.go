@@ -3,13 +3,24 @@ package runner_test
 import (
 	"bytes"
 	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/biztos/greenhead/ghd/agent"
 	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
 	"github.com/biztos/greenhead/ghd/runner"
 	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/factory"
+	"github.com/biztos/greenhead/ghd/tools/fs"
+	"github.com/biztos/greenhead/ghd/tools/memory"
+	"github.com/biztos/greenhead/ghd/tools/search"
+	"github.com/biztos/greenhead/ghd/tools/sql"
+	"github.com/biztos/greenhead/ghd/tools/web"
 )
 
 type TestInput struct {
@@ -91,6 +102,51 @@ func TestListToolsLong(t *testing.T) {
 
 }
 
+func TestListToolsSelected(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	require.NoError(reg.Register(testTool("foo")), "reg foo")
+	bar := tools.NewTool[TestInput, string]("bar", "bar ok", nil).
+		WithMetadata(&tools.Metadata{Tags: []string{"x"}, Risk: tools.RiskReadOnly})
+	require.NoError(reg.Register(bar), "reg bar")
+
+	r, err := runner.NewRunnerWithRegistry(&runner.Config{NoLog: true}, reg.Clone())
+	require.NoError(err, "NewRunner errs not")
+
+	buf := new(bytes.Buffer)
+	require.NoError(r.ListTools(buf, false, rgxp.MustParseOptional("risk<=write")))
+	require.Equal("bar - bar ok [risk: read-only; tags: x]\n", buf.String())
+
+	buf.Reset()
+	require.NoError(r.ListTools(buf, true, rgxp.MustParseOptional("tag:nonesuch")))
+	require.Equal("<no tools>\n", buf.String())
+
+	buf.Reset()
+	require.NoError(r.ShowTool(buf, "bar"))
+	require.Contains(buf.String(), "Metadata: risk: read-only; tags: x\n")
+
+	require.ErrorIs(r.ListTools(buf, true, rgxp.MustParseOptional("nope:x")),
+		registry.ErrInvalidSelector)
+
+	// Selectors matching nothing allow nothing.
+	r, err = runner.NewRunnerWithRegistry(&runner.Config{
+		NoLog:      true,
+		AllowTools: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("tag:nonesuch")},
+	}, reg.Clone())
+	require.NoError(err)
+	require.Empty(r.Registry.Names())
+
+	r, err = runner.NewRunnerWithRegistry(&runner.Config{
+		NoLog:       true,
+		RemoveTools: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("risk:unknown")},
+	}, reg.Clone())
+	require.NoError(err)
+	require.Equal([]string{"bar"}, r.Registry.Names())
+
+}
+
 func TestShowToolError(t *testing.T) {
 
 	require := require.New(t)
@@ -137,6 +193,12 @@ Input Schema:
   ]
 }
 
+Output Schema:
+
+{
+  "type": "string"
+}
+
 Return Type: string, error
 
 `
@@ -168,8 +230,13 @@ func TestRunToolErrorArgs(t *testing.T) {
 	require.NoError(registry.Register(testTool("foo")), "reg foo")
 
 	out, err := runner.RunTool("foo", "[")
-	require.Error(err, "got error")
-	require.Regexp("error parsing json", err, "error as expected")
+	require.ErrorIs(err, tools.ErrInvalidInput)
+	require.ErrorContains(err, "invalid JSON")
+	require.Nil(out, "output")
+
+	out, err = runner.RunTool("foo", `{"val":1}`)
+	require.ErrorIs(err, tools.ErrInvalidInput)
+	require.ErrorContains(err, "at /val: got number, want string")
 	require.Nil(out, "output")
 
 }
@@ -188,3 +255,318 @@ func TestRunToolOK(t *testing.T) {
 	require.Equal(out, "foo boo", "output")
 
 }
+
+func TestRegisterAgentToolsNamedAgentOK(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	err := runner.RegisterAgentTools(registry.Default, []*agent.AgentToolConfig{
+		{Name: "ask_chatty", Agent: "chatty"},
+	})
+	require.NoError(err)
+
+	tool, err := registry.Get("ask_chatty")
+	require.NoError(err)
+	require.Equal(runner.NamedAgentConfigs["chatty"].Description,
+		tool.Description(), "description from agent")
+
+}
+
+func TestRegisterAgentToolsErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	err := runner.RegisterAgentTools(registry.Default, []*agent.AgentToolConfig{
+		{Name: "nope", Agent: "no-such-agent"},
+	})
+	require.ErrorIs(err, runner.ErrNamedAgentNotAvailable)
+
+	err = runner.RegisterAgentTools(registry.Default, []*agent.AgentToolConfig{
+		{Name: "ask", Agent: "chatty"},
+		{Name: "ask", Agent: "chatty"},
+	})
+	require.ErrorIs(err, runner.ErrAgentToolDupeName)
+	require.Empty(registry.Names(), "nothing registered")
+
+}
+
+func rpcServerConfig(t *testing.T, name string) *tools.RpcServerConfig {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not available for the toy rpc server")
+	}
+	path, err := filepath.Abs(filepath.Join("..", "testdata", "rpc_server.pl"))
+	require.NoError(t, err)
+	return &tools.RpcServerConfig{Name: name, Command: path}
+}
+
+func TestNewRunnerRpcServers(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	r, err := runner.NewRunner(&runner.Config{
+		NoLog:      true,
+		RpcServers: []*tools.RpcServerConfig{rpcServerConfig(t, "toy")},
+	})
+	require.NoError(err)
+	require.Len(r.Servers, 1)
+	require.Equal([]string{"echo", "fail", "pid", "crash", "sleep"}, registry.Names())
+
+	out, err := runner.RunTool("echo", `{"text":"hi"}`)
+	require.NoError(err)
+	require.Equal(map[string]any{"text": "hi"}, out)
+
+	require.NoError(r.Close())
+	_, err = runner.RunTool("echo", `{"text":"hi"}`)
+	require.ErrorIs(err, tools.ErrRpcServerDown)
+
+}
+
+func TestRunnerCloseClosesTools(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	r, err := runner.NewRunnerWithRegistry(&runner.Config{
+		NoLog: true,
+		SqlTools: &sql.Config{Databases: []*sql.DatabaseConfig{
+			{Name: "test", DSN: filepath.Join(t.TempDir(), "test.db"), ReadWrite: true},
+		}},
+		MemoryTools: &memory.Config{Path: filepath.Join(t.TempDir(), "memory.db")},
+	}, reg)
+	require.NoError(err)
+	require.Len(r.Closers, 2)
+
+	tool, err := reg.Get("sql_list_tables")
+	require.NoError(err)
+	_, err = tool.Exec(context.Background(), `{}`)
+	require.NoError(err)
+
+	require.NoError(r.Close())
+	_, err = tool.Exec(context.Background(), `{}`)
+	require.ErrorContains(err, "database is closed")
+
+}
+
+func TestStartRpcServersErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	_, err := runner.StartRpcServers(registry.Default, []*tools.RpcServerConfig{
+		rpcServerConfig(t, "one"),
+		rpcServerConfig(t, "two"),
+	}, nil)
+	require.ErrorIs(err, runner.ErrRpcToolDupeName)
+	require.Empty(registry.Names(), "nothing registered")
+
+	cfg := rpcServerConfig(t, "bad")
+	cfg.Args = []string{"--no-list"}
+	_, err = runner.StartRpcServers(registry.Default, []*tools.RpcServerConfig{cfg}, nil)
+	require.ErrorIs(err, tools.ErrRpcServerStart)
+
+}
+
+func TestRegisterWasmToolsErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	file := filepath.Join(t.TempDir(), "empty.wasm")
+	require.NoError(os.WriteFile(file, []byte{}, 0644))
+	_, err := runner.RegisterWasmTools(registry.Default, []*tools.WasmToolConfig{{Module: file}})
+	require.ErrorIs(err, tools.ErrWasmToolConfigInvalid)
+	require.Empty(registry.Names(), "nothing registered")
+
+}
+
+func TestRegisterScriptTools(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	cfg := func(name string) *tools.ScriptToolConfig {
+		return &tools.ScriptToolConfig{
+			Name:        name,
+			Description: "Count words.",
+			Script:      "def run(args):\n    return len(args['text'].split())\n",
+			Args:        []*tools.ExternalToolArg{{Key: "text"}},
+		}
+	}
+	err := runner.RegisterScriptTools(registry.Default, []*tools.ScriptToolConfig{cfg("wc"), cfg("wc")})
+	require.ErrorIs(err, runner.ErrScriptToolDupeName)
+	require.Empty(registry.Names(), "nothing registered")
+
+	bad := cfg("bad")
+	bad.Script = "nope("
+	err = runner.RegisterScriptTools(registry.Default, []*tools.ScriptToolConfig{bad})
+	require.ErrorIs(err, tools.ErrScriptToolConfigInvalid)
+
+	require.NoError(runner.RegisterScriptTools(registry.Default, []*tools.ScriptToolConfig{cfg("wc")}))
+	out, err := runner.RunTool("wc", `{"text":"one two three"}`)
+	require.NoError(err)
+	require.Equal(int64(3), out)
+
+}
+
+func TestRegisterExternalFactories(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	cfg := func(name string) *factory.ExternalFactoryConfig {
+		return &factory.ExternalFactoryConfig{
+			Name:     name,
+			Template: &tools.ExternalToolConfig{Command: "/bin/sh"},
+		}
+	}
+	err := runner.RegisterExternalFactories(reg, []*factory.ExternalFactoryConfig{cfg("mk"), cfg("mk")})
+	require.ErrorIs(err, runner.ErrExternalFactoryDupeName)
+	require.Empty(reg.Names(), "nothing registered")
+
+	err = runner.RegisterExternalFactories(reg, []*factory.ExternalFactoryConfig{cfg("m k")})
+	require.ErrorIs(err, factory.ErrExternalFactoryConfigInvalid)
+
+	require.NoError(runner.RegisterExternalFactories(reg, []*factory.ExternalFactoryConfig{cfg("mk")}))
+	require.Equal([]string{"mk"}, reg.Names())
+
+}
+
+func TestSetupToolsFsTools(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	closers, err := runner.SetupTools(reg, &runner.Config{
+		FsTools:     &fs.Config{Roots: []string{t.TempDir()}, Write: true},
+		RemoveTools: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("risk:write")},
+	})
+	require.NoError(err)
+	require.Equal([]string{"fs_read_file", "fs_list_dir", "fs_search", "fs_stat"}, reg.Names())
+	require.Empty(closers)
+
+	_, err = runner.SetupTools(registry.New(), &runner.Config{FsTools: &fs.Config{}})
+	require.ErrorIs(err, fs.ErrConfigInvalid)
+
+}
+
+func TestSetupToolsWebTools(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	closers, err := runner.SetupTools(reg, &runner.Config{
+		WebTools: &web.Config{Allow: []string{"example.com"}},
+	})
+	require.NoError(err)
+	require.Equal([]string{"web_http_fetch"}, reg.Names())
+	require.Empty(closers)
+
+	_, err = runner.SetupTools(registry.New(), &runner.Config{WebTools: &web.Config{}})
+	require.ErrorIs(err, web.ErrConfigInvalid)
+
+}
+
+func TestSetupToolsSqlTools(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	closers, err := runner.SetupTools(reg, &runner.Config{
+		SqlTools: &sql.Config{Databases: []*sql.DatabaseConfig{
+			{Name: "test", DSN: filepath.Join(t.TempDir(), "test.db")},
+		}},
+	})
+	require.NoError(err)
+	require.Equal([]string{"sql_list_tables", "sql_describe_table", "sql_query"}, reg.Names())
+	require.Len(closers, 1)
+	require.NoError(closers[0].Close())
+
+	_, err = runner.SetupTools(registry.New(), &runner.Config{SqlTools: &sql.Config{}})
+	require.ErrorIs(err, sql.ErrConfigInvalid)
+
+}
+
+func TestSetupToolsSearchTools(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	closers, err := runner.SetupTools(reg, &runner.Config{
+		SearchTools: &search.Config{Index: filepath.Join(t.TempDir(), "docs.index"), Dirs: []string{"docs"}},
+	})
+	require.NoError(err)
+	require.Equal([]string{"search_docs"}, reg.Names())
+	require.Empty(closers)
+
+	_, err = runner.SetupTools(registry.New(), &runner.Config{SearchTools: &search.Config{}})
+	require.ErrorIs(err, search.ErrConfigInvalid)
+
+}
+
+func TestSetupToolsMemoryTools(t *testing.T) {
+
+	require := require.New(t)
+
+	reg := registry.New()
+	closers, err := runner.SetupTools(reg, &runner.Config{
+		MemoryTools: &memory.Config{Path: filepath.Join(t.TempDir(), "memory.db")},
+	})
+	require.NoError(err)
+	require.Contains(reg.Names(), "memory_remember")
+	require.Contains(reg.Names(), "memory_context")
+	require.Len(closers, 1)
+	require.NoError(closers[0].Close())
+
+	_, err = runner.SetupTools(registry.New(), &runner.Config{MemoryTools: &memory.Config{}})
+	require.ErrorIs(err, memory.ErrConfigInvalid)
+
+}
+
+func TestNewRunnerWithRegistry(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	require.NoError(registry.Register(testTool("foo")), "reg foo")
+	reg := registry.Default.Clone()
+	r, err := runner.NewRunnerWithRegistry(&runner.Config{
+		NoLog:       true,
+		RemoveTools: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("foo")},
+		ScriptTools: []*tools.ScriptToolConfig{{
+			Name:        "wc",
+			Description: "Count words.",
+			Script:      "def run(args):\n    return len(args['text'].split())\n",
+			Args:        []*tools.ExternalToolArg{{Key: "text"}},
+		}},
+	}, reg)
+	require.NoError(err)
+	require.Same(reg, r.Registry)
+	require.Equal([]string{"wc"}, reg.Names())
+	require.ErrorIs(reg.Register(testTool("bar")), registry.ErrNewLocked)
+
+	// The default registry is untouched, and unlocked.
+	require.Equal([]string{"foo"}, registry.Names())
+	require.NoError(registry.Register(testTool("bar")), "reg bar")
+
+	buf := new(bytes.Buffer)
+	require.NoError(r.ShowTool(buf, "wc"))
+	require.ErrorIs(r.ShowTool(buf, "foo"), registry.ErrNotRegistered)
+
+}
@@ -0,0 +1,92 @@
+// runner/vars.go
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// VarEnvPrefix is the prefix of environment variables that set template
+// variables, e.g. GHD_VAR_topic=tigers sets "topic".
+const VarEnvPrefix = "GHD_VAR_"
+
+var ErrBadVar = fmt.Errorf("invalid variable")
+
+// ParseVars parses args in the format "key=value" into a map.  Later keys
+// take precedence; keys must not be blank.
+func ParseVars(args []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("%w: %q: must be key=value", ErrBadVar, arg)
+		}
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// ReadVarsFile reads variables from a TOML or JSON file holding a single
+// table (object) of scalar values.  Numbers and booleans are converted to
+// strings.
+func ReadVarsFile(file string) (map[string]string, error) {
+	raw := map[string]any{}
+	if err := utils.UnmarshalFile(file, &raw); err != nil {
+		return nil, fmt.Errorf("error reading vars file: %w", err)
+	}
+	vars := map[string]string{}
+	for k, v := range raw {
+		switch v.(type) {
+		case string, bool, int64, float64:
+			vars[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%w: %q in %q: must be a scalar value",
+				ErrBadVar, k, file)
+		}
+	}
+	return vars, nil
+}
+
+// LoadVars sets variables in c from, in increasing order of precedence: any
+// existing Vars, the vars file if not empty, environment variables (in
+// os.Environ format) with VarEnvPrefix, and args in "key=value" format.
+//
+// Vars from runner config files are merged later by LoadConfigs, and do not
+// override those set here.
+func (c *Config) LoadVars(file string, environ []string, args []string) error {
+
+	sets := []map[string]string{}
+	if file != "" {
+		vars, err := ReadVarsFile(file)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, vars)
+	}
+	env := []string{}
+	for _, e := range environ {
+		if strings.HasPrefix(e, VarEnvPrefix) {
+			env = append(env, strings.TrimPrefix(e, VarEnvPrefix))
+		}
+	}
+	for _, list := range [][]string{env, args} {
+		vars, err := ParseVars(list)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, vars)
+	}
+
+	for _, vars := range sets {
+		for k, v := range vars {
+			if c.Vars == nil {
+				c.Vars = map[string]string{}
+			}
+			c.Vars[k] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package runner_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/policy"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/runner"
+)
+
+func TestParseVars(t *testing.T) {
+
+	require := require.New(t)
+
+	vars, err := runner.ParseVars([]string{"a=1", "b=x=y", "a=2", "c="})
+	require.NoError(err)
+	require.Equal(map[string]string{"a": "2", "b": "x=y", "c": ""}, vars)
+
+	_, err = runner.ParseVars([]string{"a"})
+	require.ErrorIs(err, runner.ErrBadVar)
+	_, err = runner.ParseVars([]string{" =a"})
+	require.ErrorIs(err, runner.ErrBadVar)
+
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadVarsFileOK(t *testing.T) {
+
+	require := require.New(t)
+
+	file := writeFile(t, "vars.toml", "s = \"str\"\ni = 3\nf = 1.5\nb = true\n")
+	vars, err := runner.ReadVarsFile(file)
+	require.NoError(err)
+	require.Equal(map[string]string{"s": "str", "i": "3", "f": "1.5", "b": "true"}, vars)
+
+	file = writeFile(t, "vars.json", `{"s":"str","n":2}`)
+	vars, err = runner.ReadVarsFile(file)
+	require.NoError(err)
+	require.Equal(map[string]string{"s": "str", "n": "2"}, vars)
+
+}
+
+func TestReadVarsFileErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	_, err := runner.ReadVarsFile(filepath.Join(t.TempDir(), "nope.toml"))
+	require.ErrorContains(err, "error reading vars file")
+
+	file := writeFile(t, "vars.toml", "[t]\na = 1\n")
+	_, err = runner.ReadVarsFile(file)
+	require.ErrorIs(err, runner.ErrBadVar)
+	require.ErrorContains(err, `"t"`)
+
+}
+
+func TestLoadVarsPrecedence(t *testing.T) {
+
+	require := require.New(t)
+
+	file := writeFile(t, "vars.toml", "a = \"file\"\nb = \"file\"\nc = \"file\"\n")
+	cfg := &runner.Config{Vars: map[string]string{"a": "orig", "z": "orig"}}
+	err := cfg.LoadVars(file,
+		[]string{"HOME=/x", "GHD_VAR_b=env", "GHD_VAR_c=env"},
+		[]string{"c=arg"})
+	require.NoError(err)
+	require.Equal(map[string]string{
+		"a": "file",
+		"b": "env",
+		"c": "arg",
+		"z": "orig",
+	}, cfg.Vars)
+
+	require.ErrorIs(cfg.LoadVars("", []string{"GHD_VAR_=x"}, nil), runner.ErrBadVar)
+	require.ErrorIs(cfg.LoadVars("", nil, []string{"nope"}), runner.ErrBadVar)
+	require.Error(cfg.LoadVars(filepath.Join(t.TempDir(), "nope.json"), nil, nil))
+
+	cfg = &runner.Config{}
+	require.NoError(cfg.LoadVars("", nil, nil))
+	require.Nil(cfg.Vars, "no vars, no map")
+
+}
+
+func TestVarsMergeIntoAgents(t *testing.T) {
+
+	require := require.New(t)
+
+	file := writeFile(t, "runner.toml", `
+[vars]
+  a = "runner"
+  b = "runner"
+[[agents]]
+  name = "x"
+  [agents.vars]
+    b = "agent"
+    c = "agent"
+`)
+	cfg := &runner.Config{Vars: map[string]string{"a": "flag"}}
+	require.NoError(cfg.LoadConfigs(file))
+	require.Equal(map[string]string{"a": "flag", "b": "runner"}, cfg.Vars)
+	require.Equal(map[string]string{"a": "flag", "b": "runner", "c": "agent"},
+		cfg.Agents[0].Vars)
+
+	cfg = &runner.Config{Agents: []*agent.Config{{}}}
+	cfg.ConformAgents()
+	require.Nil(cfg.Agents[0].Vars, "no vars, no map")
+
+}
+
+func TestConformAgentsRequireApprovalAndPolicy(t *testing.T) {
+
+	require := require.New(t)
+
+	agent_rule := &policy.Rule{Id: "agent"}
+	runner_rule := &policy.Rule{Id: "runner"}
+	cfg := &runner.Config{
+		RequireApproval: []*rgxp.OptionalRgxp{
+			rgxp.MustParseOptional("risk>=write"),
+		},
+		Policy: []*policy.Rule{runner_rule},
+		Agents: []*agent.Config{
+			{
+				RequireApproval: []*rgxp.OptionalRgxp{rgxp.MustParseOptional("foo")},
+				Policy:          []*policy.Rule{agent_rule},
+			},
+		},
+	}
+	cfg.ConformAgents()
+	cfg.ConformAgents()
+	have := cfg.Agents[0].RequireApproval
+	require.Len(have, 2, "added once")
+	require.Equal("foo", have[0].String(), "never replaced")
+	require.Equal("risk>=write", have[1].String())
+	require.Equal([]*policy.Rule{agent_rule, runner_rule}, cfg.Agents[0].Policy)
+	require.NoError(cfg.Validate())
+
+	// An agent rule can not have the id of a runner rule.
+	cfg.Agents[0].Name = "foo"
+	cfg.Agents[0].Policy = []*policy.Rule{{Id: "runner"}}
+	cfg.ConformAgents()
+	require.EqualError(cfg.Validate(),
+		`Agent "foo" policy rule "runner" has the id of a runner rule.`)
+
+}
@@ -0,0 +1,58 @@
+// runner/workflow.go
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/utils"
+	"github.com/biztos/greenhead/ghd/workflow"
+)
+
+// RunWorkflow runs the workflow described by cfg, with vars overriding its
+// defaults, and prints the final output to w; or if json is true, the whole
+// run record.
+//
+// Agent steps use fresh agents: spawned from the runner's agents if one has
+// the step's agent name, or else created from the named agent configs.
+func (r *Runner) RunWorkflow(w io.Writer, cfg *workflow.Config, vars map[string]string, json bool) error {
+
+	ctx := registry.WithRegistry(context.Background(), r.Registry)
+	rec, err := workflow.Run(ctx, cfg, r.WorkflowAgent, vars)
+	if json && rec != nil {
+		fmt.Fprintln(w, utils.MustJsonString(rec))
+	}
+	if err != nil {
+		return err
+	}
+	if !json && rec.Output != nil {
+		if s, ok := rec.Output.(string); ok {
+			fmt.Fprintln(w, s)
+		} else {
+			fmt.Fprintln(w, utils.MustJsonStringPretty(rec.Output))
+		}
+	}
+	return nil
+}
+
+// WorkflowAgent returns a new agent for name, suitable for a workflow step.
+func (r *Runner) WorkflowAgent(name string) (*agent.Agent, error) {
+	for _, a := range r.Agents {
+		if a.Name == name {
+			return a.Spawn()
+		}
+	}
+	c := NamedAgentConfigs[name]
+	if c == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNamedAgentNotAvailable, name)
+	}
+	// Runner settings apply to named agents just as to configured ones.
+	tmp := *r.Config
+	tmp.Agents = []*agent.Config{c.Copy()}
+	tmp.ConformAgents()
+	return agent.NewAgentWithRegistry(tmp.Agents[0], r.Registry)
+}
@@ -0,0 +1,61 @@
+package runner_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/runner"
+	"github.com/biztos/greenhead/ghd/workflow"
+)
+
+func TestRunWorkflowOK(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	require.NoError(registry.Register(testTool("foo")), "reg foo")
+
+	cfg := &workflow.Config{
+		Vars: map[string]string{"val": "nope"},
+		Steps: []*workflow.Step{
+			{Id: "one", Tool: "foo", Input: `{"val":"{{.val}}"}`},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	err := blankRunner().RunWorkflow(buf, cfg, map[string]string{"val": "bar"}, false)
+	require.NoError(err)
+	require.Equal("foo bar\n", buf.String())
+
+	buf.Reset()
+	err = blankRunner().RunWorkflow(buf, cfg, nil, true)
+	require.NoError(err)
+	require.Contains(buf.String(), `"output":"foo nope"`)
+	require.Contains(buf.String(), `"steps":[{"id":"one","kind":"tool"`)
+
+}
+
+func TestRunWorkflowError(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	cfg := &workflow.Config{
+		Steps: []*workflow.Step{
+			{Id: "one", Agent: "nonesuch", Prompt: "hi"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	err := blankRunner().RunWorkflow(buf, cfg, nil, true)
+	require.ErrorIs(err, runner.ErrNamedAgentNotAvailable)
+	require.Contains(buf.String(), `"error":"workflow step failed: \"one\": `)
+
+}
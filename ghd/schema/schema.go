@@ -0,0 +1,145 @@
+// Package schema provides JSON Schema compilation and validation, for
+// checking structured data from LLMs and tools.
+//
+// Schemas are usually supplied as JSON strings or files in configs:
+//
+//	s, err := schema.Compile(`{"type":"object","required":["name"]}`)
+//	if err != nil {
+//		return err
+//	}
+//	data, err := s.ValidateJson(llm_response)
+//
+// All drafts supported by jsonschema/v6 are available; the default is
+// Draft 2020-12.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var ErrInvalidSchema = fmt.Errorf("invalid schema")
+
+var ErrInvalidJson = fmt.Errorf("invalid JSON")
+
+var ErrValidation = fmt.Errorf("schema validation failed")
+
+var printer = message.NewPrinter(language.English)
+
+// resourceUrl is the nominal location of all compiled schemas.
+const resourceUrl = "schema.json"
+
+// Schema is a compiled JSON Schema that remembers its source.
+type Schema struct {
+	source   json.RawMessage
+	compiled *jsonschema.Schema
+}
+
+// Compile returns the compiled Schema for the JSON in src.
+func Compile(src string) (*Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(resourceUrl, doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	compiled, err := c.Compile(resourceUrl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	return &Schema{source: json.RawMessage(src), compiled: compiled}, nil
+}
+
+// CompileFile returns the compiled Schema from the JSON file.
+func CompileFile(file string) (*Schema, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	s, err := Compile(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, file)
+	}
+	return s, nil
+}
+
+// MustCompile returns the compiled Schema for src, or panics on error.
+func MustCompile(src string) *Schema {
+	s, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// String returns the source of s.
+func (s *Schema) String() string {
+	return string(s.source)
+}
+
+// MarshalJSON implements json.Marshaler by returning the source of s.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.source, nil
+}
+
+// Validate validates v, which should be of the types produced by
+// encoding/json, against s.  Validation errors wrap ErrValidation.
+func (s *Schema) Validate(v any) error {
+	// The validator wants json.Number for numbers, so round-trip.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidJson, err)
+	}
+	_, err = s.ValidateJson(string(b))
+	return err
+}
+
+// ValidateJson validates the JSON in src against s, returning the decoded
+// data, in which numbers are json.Number.  Parse errors wrap ErrInvalidJson
+// and validation errors wrap ErrValidation.
+func (s *Schema) ValidateJson(src string) (any, error) {
+	v, err := jsonschema.UnmarshalJSON(strings.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidJson, err)
+	}
+	if err := s.compiled.Validate(v); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrValidation, Describe(err))
+	}
+	return v, nil
+}
+
+// Describe returns a compact description of a validation error, listing the
+// leaf causes with their locations, e.g.:
+//
+//	at /: missing property 'name'; at /age: got string, want integer
+//
+// Errors of other types are described by their Error method.
+func Describe(err error) string {
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err.Error()
+	}
+	msgs := []string{}
+	describeLeaves(verr, &msgs)
+	return strings.Join(msgs, "; ")
+}
+
+func describeLeaves(verr *jsonschema.ValidationError, msgs *[]string) {
+	if len(verr.Causes) == 0 {
+		loc := "/" + strings.Join(verr.InstanceLocation, "/")
+		*msgs = append(*msgs, fmt.Sprintf("at %s: %s", loc, verr.ErrorKind.LocalizedString(printer)))
+		return
+	}
+	for _, c := range verr.Causes {
+		describeLeaves(c, msgs)
+	}
+}
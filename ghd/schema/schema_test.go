@@ -0,0 +1,95 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/schema"
+)
+
+const personSchema = `{
+	"type": "object",
+	"required": ["name"],
+	"properties": {
+		"name": {"type": "string"},
+		"age": {"type": "integer", "minimum": 0}
+	},
+	"additionalProperties": false
+}`
+
+func TestCompileErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	_, err := schema.Compile(`{"type":`)
+	require.ErrorIs(err, schema.ErrInvalidSchema)
+	_, err = schema.Compile(`{"type":"nope"}`)
+	require.ErrorIs(err, schema.ErrInvalidSchema)
+	require.Panics(func() { schema.MustCompile(`[`) })
+
+}
+
+func TestCompileFile(t *testing.T) {
+
+	require := require.New(t)
+
+	file := filepath.Join(t.TempDir(), "person.json")
+	require.NoError(os.WriteFile(file, []byte(personSchema), 0644))
+	s, err := schema.CompileFile(file)
+	require.NoError(err)
+	require.Equal(personSchema, s.String())
+
+	_, err = schema.CompileFile(file + ".nope")
+	require.ErrorIs(err, schema.ErrInvalidSchema)
+
+	require.NoError(os.WriteFile(file, []byte(`{"type":1}`), 0644))
+	_, err = schema.CompileFile(file)
+	require.ErrorIs(err, schema.ErrInvalidSchema)
+	require.ErrorContains(err, file)
+
+}
+
+func TestValidateJson(t *testing.T) {
+
+	require := require.New(t)
+
+	s := schema.MustCompile(personSchema)
+	v, err := s.ValidateJson(`{"name":"Sam","age":33}`)
+	require.NoError(err)
+	require.Equal(map[string]any{"name": "Sam", "age": json.Number("33")}, v)
+
+	_, err = s.ValidateJson(`{"name":`)
+	require.ErrorIs(err, schema.ErrInvalidJson)
+
+	_, err = s.ValidateJson(`{"age":"old","extra":1}`)
+	require.ErrorIs(err, schema.ErrValidation)
+	require.ErrorContains(err, "at /: missing property 'name'")
+	require.ErrorContains(err, "at /age: got string, want integer")
+	require.ErrorContains(err, "additional properties 'extra' not allowed")
+
+}
+
+func TestValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	s := schema.MustCompile(personSchema)
+	require.NoError(s.Validate(map[string]any{"name": "Sam", "age": 33}))
+	require.ErrorIs(s.Validate(map[string]any{"age": -1}), schema.ErrValidation)
+	require.ErrorIs(s.Validate(func() {}), schema.ErrInvalidJson)
+
+}
+
+func TestMarshalJSON(t *testing.T) {
+
+	require := require.New(t)
+
+	b, err := json.Marshal(map[string]any{"schema": schema.MustCompile(`{"type":"string"}`)})
+	require.NoError(err)
+	require.Equal(`{"schema":{"type":"string"}}`, string(b))
+
+}
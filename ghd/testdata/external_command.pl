@@ -10,12 +10,15 @@
 # --prefix=P - print P after indent and before args
 # --reverse  - reverse text of each arg
 # --stdin    - echo standard input after args
+# --env=E    - print E=value of environment variable E (can specify multiple)
+# --pwd      - print the working directory after headers
+# --no-id    - do not print the ID line
 # --stderr   - echo to standard error instead of standard output
 # --sleep=W  - sleep for W fractional seconds after printing each arg line.
 # --exit=C   - exit with code C after operation
 #
-# Headers always go to standard output. The first line is always the ID and
-# always goes to STDOUT.
+# Headers always go to standard output. The first line is the ID, unless
+# --no-id is given, and always goes to STDOUT.
 #
 # Short flags can be used.
 #
@@ -32,6 +35,7 @@ use warnings;
 
 use feature 'say';
 use Getopt::Long;
+use Cwd qw(getcwd);
 use Digest::MD5 qw(md5_hex);
 use IO::File;
 use Time::HiRes qw(sleep);
@@ -42,6 +46,9 @@ my $prefix    = "";
 my $exit_code = 0;
 my $sleep     = 0;
 my @headers;
+my @envs;
+my $pwd;
+my $no_id;
 my $reverse;
 my $stderr;
 my $stdin;
@@ -59,6 +66,9 @@ MAIN: {
         "reverse"  => \$reverse,      # flag
         "stderr"   => \$stderr,       # flag
         "stdin"    => \$stdin,        # flag
+        "env=s"    => \@envs,         # array
+        "pwd"      => \$pwd,          # flag
+        "no-id"    => \$no_id,        # flag
         "sleep=f"  => \$sleep,        # numeric (float)
         "exit=i"   => \$exit_code,    # numeric (integer)
         "help"     => \$help,         # flag (special)
@@ -67,8 +77,10 @@ MAIN: {
     print_help_and_exit() if $help;
 
     srand( int($seed) ) if $seed;
-    say md5_hex( rand $seed );
+    say md5_hex( rand $seed ) unless $no_id;
     say $_ for @headers;
+    say getcwd() if $pwd;
+    say "$_=", ( $ENV{$_} // "<unset>" ) for @envs;
     say_what($_) for @ARGV;
 
     if ($stdin) {
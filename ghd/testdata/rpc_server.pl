@@ -0,0 +1,128 @@
+#!/usr/bin/env perl
+#
+# rpc_server.pl -- toy JSON-RPC tool server for testing RPC servers.
+# -------------
+# Reads line-delimited JSON-RPC requests on standard input and writes the
+# responses on standard output, one per line.  Exits when input is closed.
+#
+# Methods:
+#
+#   tools/list - lists the tools below
+#   tools/call - calls a tool by name with arguments
+#
+# Tools:
+#
+#   echo  - returns its arguments
+#   fail  - returns a JSON-RPC error with the given message
+#   pid   - returns the process ID, for checking restarts
+#   crash - exits with code 3 without responding
+#   sleep - sleeps for the given seconds, then returns them
+#
+# Flags:
+#
+# --no-list  - exit instead of listing tools, to test startup failure
+# --linger   - ignore closed input and keep running, to test shutdown
+# --stderr=S - print S to standard error on startup
+#
+# NOTE: requires JSON::PP, which is in core Perl.
+
+use strict;
+use warnings;
+use Getopt::Long;
+use JSON::PP;
+use Time::HiRes qw(sleep);
+
+my $no_list = 0;
+my $linger  = 0;
+my $stderr  = '';
+GetOptions(
+    'no-list'  => \$no_list,
+    'linger'   => \$linger,
+    'stderr=s' => \$stderr,
+) or die("Error in command line arguments\n");
+
+$| = 1;
+print STDERR "$stderr\n" if $stderr;
+
+my $json = JSON::PP->new->canonical;
+
+my @tools = (
+    {   name         => 'echo',
+        description  => 'Echo the arguments.',
+        input_schema => {
+            type       => 'object',
+            properties => { text => { type => 'string' } },
+            required   => ['text'],
+        },
+    },
+    {   name         => 'fail',
+        description  => 'Fail with a message.',
+        input_schema => {
+            type       => 'object',
+            properties => { message => { type => 'string' } },
+        },
+    },
+    { name => 'pid',   description => 'Return the process ID.' },
+    { name => 'crash', description => 'Crash the server.' },
+    {   name         => 'sleep',
+        description  => 'Sleep for some seconds.',
+        input_schema => {
+            type       => 'object',
+            properties => { seconds => { type => 'number' } },
+        },
+    },
+);
+
+sub respond {
+    my ( $id, $key, $val ) = @_;
+    print $json->encode( { jsonrpc => '2.0', id => $id, $key => $val } ),
+        "\n";
+}
+
+while (1) {
+    my $line = <STDIN>;
+    if ( !defined $line ) {
+        last unless $linger;
+        sleep(0.1);
+        next;
+    }
+    next unless $line =~ /\S/;
+    my $req = $json->decode($line);
+    my $id  = $req->{id};
+    my $method = $req->{method} || '';
+    if ( $method eq 'tools/list' ) {
+        exit(2) if $no_list;
+        respond( $id, result => { tools => \@tools } );
+    }
+    elsif ( $method eq 'tools/call' ) {
+        my $name = $req->{params}{name};
+        my $args = $req->{params}{arguments};
+        if ( $name eq 'echo' ) {
+            respond( $id, result => $args );
+        }
+        elsif ( $name eq 'fail' ) {
+            respond( $id,
+                error => { code => -32000, message => $args->{message} } );
+        }
+        elsif ( $name eq 'pid' ) {
+            respond( $id, result => $$ );
+        }
+        elsif ( $name eq 'crash' ) {
+            exit(3);
+        }
+        elsif ( $name eq 'sleep' ) {
+            sleep( $args->{seconds} );
+            respond( $id, result => $args->{seconds} );
+        }
+        else {
+            respond( $id,
+                error => { code => -32602, message => "unknown tool: $name" }
+            );
+        }
+    }
+    else {
+        respond( $id,
+            error => { code => -32601, message => "unknown method: $method" }
+        );
+    }
+}
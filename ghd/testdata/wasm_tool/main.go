@@ -0,0 +1,107 @@
+//go:build wasip1
+
+// Command wasm_tool is a toy WebAssembly tool for testing WASM tools.
+//
+// Build it as a reactor module:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o wasm_tool.wasm
+//
+// The tool takes an action and acts on it:
+//
+//	echo  - returns the text
+//	fail  - returns an error with the text
+//	read  - returns the contents of the file at path
+//	write - writes the text to the file at path
+//	env   - returns the value of the environment variable named by text
+//	spin  - loops forever, to test timeouts
+//	grow  - allocates memory without end, to test limits
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"unsafe"
+)
+
+// Buffers handed to the host are kept here so they are not collected.
+var buffers = map[uintptr][]byte{}
+
+func keep(b []byte) uint64 {
+	if len(b) == 0 {
+		return 0
+	}
+	p := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	buffers[p] = b
+	return uint64(p)<<32 | uint64(len(b))
+}
+
+//go:wasmexport ghd_alloc
+func ghdAlloc(size uint32) uint32 {
+	return uint32(keep(make([]byte, size)) >> 32)
+}
+
+//go:wasmexport ghd_info
+func ghdInfo() uint64 {
+	b, _ := json.Marshal(map[string]any{
+		"name":        "wasm_toy",
+		"description": "Toy WASM tool.",
+		"input_schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"action": map[string]any{"type": "string"},
+				"text":   map[string]any{"type": "string"},
+				"path":   map[string]any{"type": "string"},
+			},
+			"required": []string{"action"},
+		},
+	})
+	return keep(b)
+}
+
+//go:wasmexport ghd_exec
+func ghdExec(ptr, size uint32) uint64 {
+	input := buffers[uintptr(ptr)][:size]
+	delete(buffers, uintptr(ptr))
+	var in struct {
+		Action string `json:"action"`
+		Text   string `json:"text"`
+		Path   string `json:"path"`
+	}
+	res := map[string]any{}
+	if err := json.Unmarshal(input, &in); err != nil {
+		res["error"] = err.Error()
+	}
+	switch in.Action {
+	case "echo":
+		res["result"] = in.Text
+	case "fail":
+		res["error"] = in.Text
+	case "read":
+		b, err := os.ReadFile(in.Path)
+		if err != nil {
+			res["error"] = err.Error()
+		} else {
+			res["result"] = string(b)
+		}
+	case "write":
+		if err := os.WriteFile(in.Path, []byte(in.Text), 0644); err != nil {
+			res["error"] = err.Error()
+		} else {
+			res["result"] = "ok"
+		}
+	case "env":
+		res["result"] = os.Getenv(in.Text)
+	case "spin":
+		for {
+		}
+	case "grow":
+		hog := [][]byte{}
+		for {
+			hog = append(hog, make([]byte, 1<<20))
+		}
+	}
+	b, _ := json.Marshal(res)
+	return keep(b)
+}
+
+func main() {}
@@ -0,0 +1,69 @@
+// tools/definition.go
+
+package tools
+
+// Definition is a provider-neutral definition of a tool, from which each
+// ApiClient builds the tool type of its own API.
+type Definition struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"` // Full description for the LLM.
+	InputSchema  any    `json:"input_schema"`
+	OutputSchema any    `json:"output_schema,omitempty"`
+	Strict       bool   `json:"strict,omitempty"` // All input properties are required, and no others allowed.
+}
+
+// Definer may be implemented by Toolers that provide their own Definition,
+// e.g. to set Strict.  It is usually built on DefaultDefinition.
+type Definer interface {
+	Tooler
+
+	// Definition returns the definition of the tool.
+	Definition() *Definition
+}
+
+// Define returns the Definition of t, from its own Definition method if it
+// is a Definer, otherwise from DefaultDefinition.
+func Define(t Tooler) *Definition {
+	if d, ok := t.(Definer); ok {
+		return d.Definition()
+	}
+	return DefaultDefinition(t)
+}
+
+// DefaultDefinition returns the Definition of t from its Tooler methods,
+// with the FullDescription and the OutputSchema if any.  It is not Strict.
+func DefaultDefinition(t Tooler) *Definition {
+	return &Definition{
+		Name:         t.Name(),
+		Description:  FullDescription(t),
+		InputSchema:  t.InputSchema(),
+		OutputSchema: OutputSchema(t),
+	}
+}
+
+// isStrict returns true if s can be used in strict mode: all properties of
+// objects are required and no others allowed, and only keywords supported
+// by strict mode are used, all the way down.
+func isStrict(s *JsonSchema) bool {
+	if s == nil {
+		return true
+	}
+	if s.Type == "" || s.Default != nil || s.Not != nil || len(s.OneOf) > 0 ||
+		s.MinLength != nil || s.MaxLength != nil {
+		return false
+	}
+	if s.Type == "object" {
+		if s.AdditionalProperties == nil || *s.AdditionalProperties {
+			return false
+		}
+		if len(s.Required) != len(s.Properties) {
+			return false
+		}
+		for _, prop := range s.Properties {
+			if !isStrict(prop) {
+				return false
+			}
+		}
+	}
+	return isStrict(s.Items)
+}
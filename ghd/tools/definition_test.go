@@ -0,0 +1,99 @@
+package tools_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// plainTool is a Tooler with no optional methods.
+type plainTool struct{}
+
+func (plainTool) Name() string        { return "plain" }
+func (plainTool) Description() string { return "Plain tool." }
+func (plainTool) InputSchema() any    { return map[string]any{"type": "object"} }
+func (plainTool) Help() string        { return "plain" }
+func (plainTool) Exec(ctx context.Context, input string) (any, error) {
+	return "plain", nil
+}
+
+func TestDefineDefault(t *testing.T) {
+
+	require := require.New(t)
+
+	d := tools.Define(plainTool{})
+	require.Equal(&tools.Definition{
+		Name:        "plain",
+		Description: "Plain tool.",
+		InputSchema: map[string]any{"type": "object"},
+	}, d)
+	require.JSONEq(`{"name":"plain","description":"Plain tool.","input_schema":{"type":"object"}}`,
+		utils.MustJsonString(d))
+
+}
+
+func TestDefineTool(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := paintTool()
+	d := tools.Define(tool)
+	require.Equal("paint", d.Name)
+	require.Equal(tools.FullDescription(tool), d.Description)
+	require.Contains(d.Description, "The data has this JSON Schema:")
+	require.Equal(tool.InputSchema(), d.InputSchema)
+	require.Equal(tool.OutputSchema(), d.OutputSchema)
+	require.False(d.Strict, "optional color")
+
+	strict := tools.NewTool("strict", "Strict.",
+		func(ctx context.Context, in struct {
+			A string `json:"a"`
+		}) (bool, error) {
+			return true, nil
+		})
+	require.True(tools.Define(strict).Strict)
+
+	nested := tools.NewTool("nested", "Nested.",
+		func(ctx context.Context, in struct {
+			A []struct {
+				B string `json:"b,omitempty"`
+			} `json:"a"`
+		}) (bool, error) {
+			return true, nil
+		})
+	require.False(tools.Define(nested).Strict, "nested optional")
+
+	for _, tool := range []tools.Tooler{
+		tools.NewTool("map", "Map.",
+			func(ctx context.Context, in struct {
+				M map[string]string `json:"m"`
+			}) (bool, error) {
+				return true, nil
+			}),
+		tools.NewTool("default", "Default.",
+			func(ctx context.Context, in struct {
+				S string `json:"s" jsonschema:"default=x"`
+			}) (bool, error) {
+				return true, nil
+			}),
+		tools.NewTool("max_length", "Max length.",
+			func(ctx context.Context, in struct {
+				S string `json:"s" jsonschema:"maxLength=3"`
+			}) (bool, error) {
+				return true, nil
+			}),
+		tools.NewTool("any", "Any value.",
+			func(ctx context.Context, in struct {
+				V any `json:"v"`
+			}) (bool, error) {
+				return true, nil
+			}),
+	} {
+		require.False(tools.Define(tool).Strict, tool.Name())
+	}
+
+}
@@ -91,6 +91,13 @@ func init() {
 		},
 	)
 
+	// State is only kept in memory, so nothing outside is touched, but
+	// storing still changes what later calls see.
+	store.WithMetadata(&tools.Metadata{Tags: []string{"demo"}, Risk: tools.RiskWrite})
+	meta := &tools.Metadata{Tags: []string{"demo"}, Risk: tools.RiskReadOnly}
+	recall.WithMetadata(meta)
+	sum.WithMetadata(meta)
+
 	if err := registry.Register(store); err != nil {
 		panic(err)
 	}
@@ -3,20 +3,31 @@ package tools
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"os"
 	"os/exec"
+	"path"
+	"regexp"
 	"slices"
 	"strings"
+	"text/template"
 
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
-
+	"github.com/biztos/greenhead/ghd/schema"
 	"github.com/biztos/greenhead/ghd/utils"
 )
 
 // ExternalToolArg represents an argument (or option) for a command.
 //
 // An option has a flag; an argument has no flag.
+//
+// Values from the LLM can be constrained, which is strongly recommended for
+// anything passed to a command that might misbehave.  The constraints apply
+// to each value of a Repeat arg.  By default, string args (not options) may
+// not start with a dash, so they can not be mistaken for options.
 type ExternalToolArg struct {
 	Flag        string `toml:"flag"`        // Flag to use in option, e.g. "-foo"; empty for args.
 	Key         string `toml:"key"`         // Key for schema; defaults to trimmed Flag; required for args.
@@ -24,6 +35,15 @@ type ExternalToolArg struct {
 	Description string `toml:"description"` // Description, used in the schema, no default.
 	Optional    bool   `toml:"optional"`    // Is the arg optional or required?
 	Repeat      bool   `toml:"repeat"`      // Can repeat this arg (use array in schema).
+	Default     any    `toml:"default"`     // Default value, which makes the arg optional.
+
+	// Constraints:
+	Pattern   string   `toml:"pattern"`    // Regexp that string values must match.
+	Enum      []any    `toml:"enum"`       // Allowed values, which must be of the arg's type.
+	Min       *float64 `toml:"min"`        // Minimum for numbers.
+	Max       *float64 `toml:"max"`        // Maximum for numbers.
+	MaxLength int      `toml:"max_length"` // Maximum length of strings, in characters.
+	AllowDash bool     `toml:"allow_dash"` // Allow string args to start with "-".
 }
 
 var ExternalToolArgTypes = []string{"string", "number", "integer", "boolean"}
@@ -59,10 +79,122 @@ func (a *ExternalToolArg) Validate() error {
 			ErrExternalToolArgInvalid, a.Key, a.Type)
 	}
 
+	// Constraints must make sense for the type.
+	is_string := a.Type == "string"
+	is_number := a.Type == "number" || a.Type == "integer"
+	if a.Pattern != "" {
+		if !is_string {
+			return fmt.Errorf("%w: pattern for non-string %q",
+				ErrExternalToolArgInvalid, a.Key)
+		}
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			return fmt.Errorf("%w: bad pattern for %q: %w",
+				ErrExternalToolArgInvalid, a.Key, err)
+		}
+	}
+	if a.MaxLength < 0 || (a.MaxLength > 0 && !is_string) {
+		return fmt.Errorf("%w: max_length must be positive and for strings: %q",
+			ErrExternalToolArgInvalid, a.Key)
+	}
+	if (a.Min != nil || a.Max != nil) && !is_number {
+		return fmt.Errorf("%w: min or max for non-number %q",
+			ErrExternalToolArgInvalid, a.Key)
+	}
+	if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+		return fmt.Errorf("%w: min greater than max for %q",
+			ErrExternalToolArgInvalid, a.Key)
+	}
+	for _, v := range a.Enum {
+		if !enumValueOk(a.Type, v) {
+			return fmt.Errorf("%w: enum value %v is not %s for %q",
+				ErrExternalToolArgInvalid, v, a.Type, a.Key)
+		}
+	}
+
+	// A default makes the arg optional, and must satisfy the constraints.
+	if a.Default != nil {
+		a.Optional = true
+		s, err := schema.Compile(utils.MustJsonString(a.propertySchema()))
+		if err != nil {
+			return fmt.Errorf("%w: schema for %q: %w",
+				ErrExternalToolArgInvalid, a.Key, err)
+		}
+		if err := s.Validate(a.Default); err != nil {
+			return fmt.Errorf("%w: bad default for %q: %w",
+				ErrExternalToolArgInvalid, a.Key, err)
+		}
+	}
+
 	return nil
 
 }
 
+// enumValueOk returns true if v, as decoded from TOML, is of the type.
+func enumValueOk(arg_type string, v any) bool {
+	switch v := v.(type) {
+	case string:
+		return arg_type == "string"
+	case bool:
+		return arg_type == "boolean"
+	case int64:
+		return arg_type == "integer" || arg_type == "number"
+	case float64:
+		return arg_type == "number" || (arg_type == "integer" && v == math.Trunc(v))
+	}
+	return false
+}
+
+// noDashPattern matches strings not starting with a dash, including empty
+// ones.
+const noDashPattern = "^([^-]|$)"
+
+// checkDash returns true if a leading dash should be rejected for a.
+func (a *ExternalToolArg) checkDash() bool {
+	return a.Flag == "" && a.Type == "string" && !a.AllowDash
+}
+
+// valueSchema returns the schema for a single value of a.
+func (a *ExternalToolArg) valueSchema() *ExternalToolSchema {
+	s := &ExternalToolSchema{
+		Type:    a.Type,
+		Enum:    a.Enum,
+		Pattern: a.Pattern,
+		Minimum: a.Min,
+		Maximum: a.Max,
+	}
+	if a.MaxLength > 0 {
+		s.MaxLength = &a.MaxLength
+	}
+	if a.checkDash() {
+		// Strict mode does not allow "not", so use it only when needed.
+		if a.Pattern == "" {
+			s.Pattern = noDashPattern
+		} else {
+			s.Not = &ExternalToolSchema{Pattern: "^-"}
+		}
+	}
+	return s
+}
+
+// propertySchema returns the schema for a in the input object.
+func (a *ExternalToolArg) propertySchema() *ExternalToolSchema {
+	if !a.Repeat {
+		s := a.valueSchema()
+		s.Default = a.Default
+		return s
+	}
+	return &ExternalToolSchema{
+		Type:    "array",
+		Items:   a.valueSchema(),
+		Default: a.Default,
+	}
+}
+
+// ExternalToolSchema is the JSON schema of an ExternalTool's input, or part
+// of it.  Unlike jsonschema.Definition it supports the constraints of
+// ExternalToolArg.
+type ExternalToolSchema = JsonSchema
+
 // ExternalToolConfig represents the configuration of an ExternalTool.
 //
 // This is used within a Runner config.
@@ -72,17 +204,71 @@ type ExternalToolConfig struct {
 	Command       string             `toml:"command"`        // Path to the executable command.
 	Args          []*ExternalToolArg `toml:"args"`           // Options/args as defined above.
 	PreArgs       []string           `toml:"pre_args"`       // Args to include verbatim in every call.
+	EndOptions    bool               `toml:"end_options"`    // Put "--" between options and args, moving args to the end.
 	SendInput     bool               `toml:"send_input"`     // Send raw input JSON on STDIN instead of args.
 	CombineOutput bool               `toml:"combine_output"` // Include STDERR after STDOUT in result.
+	Stdin         string             `toml:"stdin"`          // Template for STDIN, rendered with the input.
+
+	// Output handling:
+	OutputFormat   string            `toml:"output_format"`    // One of ExternalToolOutputFormats; default "text".
+	MaxOutputBytes int               `toml:"max_output_bytes"` // Truncate output (and errors) beyond this, keeping head and tail; at most twice this is kept while running.
+	ExitResults    map[string]string `toml:"exit_results"`     // Nonzero exit codes that are results, with their status, e.g. 1 = "no match".
+	OutputSchema   string            `toml:"output_schema"`    // JSON Schema of the output, declared to the LLM.
+
+	// Command environment:
+	Dir        string            `toml:"dir"`         // Working directory for the command.
+	Env        map[string]string `toml:"env"`         // Variables to set, expanding "$VAR" and "${VAR}" from ours.
+	InheritEnv []string          `toml:"inherit_env"` // Variables to inherit, by name or glob e.g. "LC_*"; if set, no others are.
+	ClearEnv   bool              `toml:"clear_env"`   // Inherit no variables except InheritEnv.
+
+	// Restrictions, supported on Linux:
+	Sandbox *ExternalToolSandbox `toml:"sandbox"` // Optional sandbox settings.
+
+	Metadata *Metadata `toml:"metadata"` // Optional metadata for selecting the tool.
 }
 
 var ErrExternalToolConfigInvalid = fmt.Errorf("invalid external tool config")
 
+// Copy returns a deep copy of c, which can be changed and validated without
+// affecting c, e.g. to make several tools from one template.
+func (c *ExternalToolConfig) Copy() *ExternalToolConfig {
+	n := *c
+	n.Args = make([]*ExternalToolArg, len(c.Args))
+	for i, arg := range c.Args {
+		a := *arg
+		a.Enum = slices.Clone(arg.Enum)
+		n.Args[i] = &a
+	}
+	n.PreArgs = slices.Clone(c.PreArgs)
+	n.ExitResults = maps.Clone(c.ExitResults)
+	n.Env = maps.Clone(c.Env)
+	n.InheritEnv = slices.Clone(c.InheritEnv)
+	if c.Sandbox != nil {
+		sb := *c.Sandbox
+		n.Sandbox = &sb
+	}
+	if c.Metadata != nil {
+		m := *c.Metadata
+		m.Tags = slices.Clone(c.Metadata.Tags)
+		n.Metadata = &m
+	}
+	return &n
+}
+
 // Validate checks that c has correct values:
 //
 // - Name and Description must not be empty.
 // - Command must point to an executable file.
 // - Args must all validate, and not have redundant keys.
+// - Dir, if set, must be a directory.
+// - InheritEnv must be valid globs.
+// - Stdin must be a valid template, and not combined with SendInput.
+// - OutputFormat must be supported, and "text" or "lines" if CombineOutput.
+// - MaxOutputBytes must not be negative.
+// - ExitResults must have valid exit codes.
+// - OutputSchema, if set, must be a valid JSON Schema.
+// - Sandbox settings must be valid and supported on this system.
+// - Metadata, if set, must be valid.
 func (c *ExternalToolConfig) Validate() error {
 
 	if strings.TrimSpace(c.Name) == "" {
@@ -120,17 +306,104 @@ func (c *ExternalToolConfig) Validate() error {
 		have_key[arg.Key] = true
 
 	}
+
+	if c.Dir != "" {
+		info, err := os.Stat(c.Dir)
+		if err != nil {
+			return fmt.Errorf("%w: dir error for %q: %w",
+				ErrExternalToolConfigInvalid, c.Name, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: dir not a directory for %q: %s",
+				ErrExternalToolConfigInvalid, c.Name, c.Dir)
+		}
+	}
+	for _, p := range c.InheritEnv {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%w: bad inherit_env pattern for %q: %q",
+				ErrExternalToolConfigInvalid, c.Name, p)
+		}
+	}
+	if c.Stdin != "" {
+		if c.SendInput {
+			return fmt.Errorf("%w: both stdin and send_input set for %q",
+				ErrExternalToolConfigInvalid, c.Name)
+		}
+		if _, err := c.stdinTemplate(); err != nil {
+			return fmt.Errorf("%w: stdin template for %q: %w",
+				ErrExternalToolConfigInvalid, c.Name, err)
+		}
+	}
+
+	if c.OutputFormat != "" && !slices.Contains(ExternalToolOutputFormats, c.OutputFormat) {
+		return fmt.Errorf("%w: unsupported output_format for %q: %q",
+			ErrExternalToolConfigInvalid, c.Name, c.OutputFormat)
+	}
+	if c.CombineOutput && (c.OutputFormat == "json" || c.OutputFormat == "jsonl") {
+		return fmt.Errorf("%w: combine_output with %s output for %q",
+			ErrExternalToolConfigInvalid, c.OutputFormat, c.Name)
+	}
+	if c.MaxOutputBytes < 0 {
+		return fmt.Errorf("%w: negative max_output_bytes for %q",
+			ErrExternalToolConfigInvalid, c.Name)
+	}
+	if _, err := parseExitResults(c.ExitResults); err != nil {
+		return fmt.Errorf("%w: exit_results for %q: %w",
+			ErrExternalToolConfigInvalid, c.Name, err)
+	}
+	if c.OutputSchema != "" {
+		if _, err := schema.Compile(c.OutputSchema); err != nil {
+			return fmt.Errorf("%w: output_schema for %q: %w",
+				ErrExternalToolConfigInvalid, c.Name, err)
+		}
+	}
+	if err := c.Sandbox.Validate(c); err != nil {
+		return fmt.Errorf("%w: sandbox for %q: %w",
+			ErrExternalToolConfigInvalid, c.Name, err)
+	}
+	if c.Metadata != nil {
+		if err := c.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%w: metadata for %q: %w",
+				ErrExternalToolConfigInvalid, c.Name, err)
+		}
+	}
 	return nil
 
 }
 
+func (c *ExternalToolConfig) stdinTemplate() (*template.Template, error) {
+	return template.New("stdin").
+		Funcs(template.FuncMap{"json": utils.MustJsonString}).
+		Option("missingkey=error").
+		Parse(c.Stdin)
+}
+
+// inherits returns true if the command should inherit environment variable
+// name.
+func (c *ExternalToolConfig) inherits(name string) bool {
+	if len(c.InheritEnv) == 0 {
+		return !c.ClearEnv
+	}
+	for _, p := range c.InheritEnv {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // ExternalTool represents a Tooler that executes an external binary.
 //
-// Nonzero return codes are considered errors.
+// Nonzero return codes are considered errors, unless configured in
+// ExitResults.
 type ExternalTool struct {
-	cfg     *ExternalToolConfig
-	argMap  map[string]*ExternalToolArg
-	argList []string
+	cfg       *ExternalToolConfig
+	argMap    map[string]*ExternalToolArg
+	argList   []string
+	schema    *ExternalToolSchema
+	validator *schema.Schema
+	stdin     *template.Template
+	exits     map[int]string
 }
 
 // NewExternalTool creates an ExternalTool from cfg.
@@ -147,11 +420,25 @@ func NewExternalTool(cfg *ExternalToolConfig) (*ExternalTool, error) {
 		argList = append(argList, arg.Key)
 	}
 
-	return &ExternalTool{
+	t := &ExternalTool{
 		cfg:     cfg,
 		argMap:  argMap,
 		argList: argList,
-	}, nil
+	}
+
+	// Schemas are made once, as they are used for every call.
+	t.schema = argsSchema(cfg.Args)
+	validator, err := schema.Compile(utils.MustJsonString(t.schema))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrExternalToolConfigInvalid, cfg.Name, err)
+	}
+	t.validator = validator
+	if cfg.Stdin != "" {
+		t.stdin, _ = cfg.stdinTemplate() // validated above
+	}
+	t.exits, _ = parseExitResults(cfg.ExitResults)
+
+	return t, nil
 }
 
 // Name implements Tooler.
@@ -166,61 +453,98 @@ func (t *ExternalTool) Description() string {
 
 // Help implements Tooler.
 func (t *ExternalTool) Help() string {
-
 	s := fmt.Sprintf("%s\n\n%s\n\n", t.cfg.Name, t.cfg.Description)
-
-	// Hmm, how to handle this?  Can we just use NewTool?
-	// no because no way to coerce the type is there?
-
+	s += schemaHelp("Input", t.schema)
+	if out := t.OutputSchema(); out != nil {
+		s += schemaHelp("Output", out)
+	}
+	s += fmt.Sprintf("Command: %s\n", t.cfg.Command)
 	return s
 }
 
-var ErrInvalidInput = fmt.Errorf("command input invalid")
+// OutputSchema implements OutputTooler, returning the configured
+// OutputSchema, or nil if none.
+func (t *ExternalTool) OutputSchema() any {
+	if t.cfg.OutputSchema == "" {
+		return nil
+	}
+	return json.RawMessage(t.cfg.OutputSchema)
+}
 
 // ValidateInput validates input against the InputSchema and returns the
 // cleaned object if input is valid.
 //
-// Cleanup currently addresses only the conversion of float64 types to int
-// where the arg specifies an "integer" type.
+// Cleanup converts numbers to float64, or to int64 where the arg specifies an
+// "integer" type, and sets defaults for missing args.
 func (t *ExternalTool) ValidateInput(input string) (map[string]any, error) {
 
-	// TODO: cache schemas! Here and for InputSchema.
-	schema := t.InputSchema().(jsonschema.Definition)
-
-	var v map[string]any
-	b := []byte(input)
-	if err := jsonschema.VerifySchemaAndUnmarshal(schema, b, &v); err != nil {
+	v, err := t.validator.ValidateJson(input)
+	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
+	m := v.(map[string]any) // guaranteed by the schema
+	cleanInput(m, t.argMap)
+	return m, nil
 
-	return v, nil
+}
 
+// cleanInput converts the numbers in m, validated against the schema of
+// args, and sets defaults for missing args.
+func cleanInput(m map[string]any, args map[string]*ExternalToolArg) {
+	for k, val := range m {
+		integer := args[k].Type == "integer"
+		if array, ok := val.([]any); ok {
+			for i := range array {
+				array[i] = cleanNumber(array[i], integer)
+			}
+		} else {
+			m[k] = cleanNumber(val, integer)
+		}
+	}
+	for _, a := range args {
+		if _, ok := m[a.Key]; !ok && a.Default != nil {
+			m[a.Key] = a.Default
+		}
+	}
+}
+
+func cleanNumber(v any, integer bool) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	f, _ := n.Float64() // valid per the schema
+	if integer {
+		return int64(f)
+	}
+	return f
 }
 
 // InputSchema implements Tooler, returning the "parameters" object for the
 // tool definition.
 func (t *ExternalTool) InputSchema() any {
+	return t.schema
+}
 
-	// TODO: handle optionals.
-	// Spec is pretty weird about it, AI not helping right now.
-	props := map[string]jsonschema.Definition{}
-	for _, n := range t.argList {
-		a := t.argMap[n]
-		p := jsonschema.Definition{}
-		if a.Repeat {
-			p.Type = jsonschema.Array
-			p.Items = &jsonschema.Definition{Type: jsonschema.DataType(a.Type)}
-		} else {
-			p.Type = jsonschema.DataType(a.Type)
+// argsSchema returns the input object schema for args, which must be
+// validated.
+func argsSchema(args []*ExternalToolArg) *ExternalToolSchema {
+
+	props := map[string]*ExternalToolSchema{}
+	required := []string{}
+	for _, a := range args {
+		props[a.Key] = a.propertySchema()
+		if !a.Optional {
+			required = append(required, a.Key)
 		}
-		props[a.Key] = p
 	}
 
-	return jsonschema.Definition{
-		Type:                 jsonschema.Object,
-		AdditionalProperties: false,
+	no_more := false
+	return &ExternalToolSchema{
+		Type:                 "object",
+		AdditionalProperties: &no_more,
 		Properties:           props,
-		Required:             t.argList,
+		Required:             required,
 	}
 }
 
@@ -229,32 +553,46 @@ var ErrExternalToolInputBadType = fmt.Errorf("wrong type for arg in tool input")
 // CommandArgs returns the full set of arguments to send to the command based
 // on the input JSON.  ValidateInput is called if SendInput is false.
 //
+// Optional args missing from the input, and without defaults, are omitted.
+//
 // Note that the args do not include the t.Command.
 func (t *ExternalTool) CommandArgs(input string) ([]string, error) {
 
-	// Base args are always the same set.
-	all_args := make([]string, len(t.cfg.PreArgs))
-	copy(all_args, t.cfg.PreArgs)
-
 	// If you want your raw input, you get your raw input.
 	if t.cfg.SendInput {
-		return all_args, nil
+		return t.commandArgs(nil), nil
 	}
 
-	// The validation guarantees our type safety for casts below.
+	// The validation guarantees our type safety for casts in commandArgs.
 	// (Fun task: try to find a case where it doesn't.)
 	input_map, err := t.ValidateInput(input)
 	if err != nil {
 		return nil, err
 	}
+	return t.commandArgs(input_map), nil
+}
+
+func (t *ExternalTool) commandArgs(input_map map[string]any) []string {
+
+	// Base args are always the same set.
+	all_args := make([]string, len(t.cfg.PreArgs))
+	copy(all_args, t.cfg.PreArgs)
+	if t.cfg.SendInput {
+		return all_args
+	}
 
 	// Now we build our list, erroring out if we need to on the way.
 	// TODO: revisit the valGetter[T] idea b/c probably faster. But bench it.
 	// TODO: handle weird optional stuff openai-style.
 
+	// With EndOptions, positional args follow the "--" after all options.
+	positional := []string{}
 	for _, k := range t.argList {
 		arg := t.argMap[k]
-		prop := input_map[k]
+		prop, ok := input_map[k]
+		if !ok {
+			continue // optional
+		}
 		vals := []any{}
 		if arg.Repeat {
 			// We trust the validation we did above, and cast to array.
@@ -287,7 +625,9 @@ func (t *ExternalTool) CommandArgs(input string) ([]string, error) {
 		for _, val := range vals {
 			svals = append(svals, fmt.Sprint(val))
 		}
-		if arg.Flag == "" {
+		if arg.Flag == "" && t.cfg.EndOptions {
+			positional = append(positional, svals...)
+		} else if arg.Flag == "" {
 			// Plan arg, use as-is.
 			all_args = append(all_args, svals...)
 		} else {
@@ -298,8 +638,56 @@ func (t *ExternalTool) CommandArgs(input string) ([]string, error) {
 		}
 
 	}
+	if t.cfg.EndOptions {
+		all_args = append(all_args, "--")
+		all_args = append(all_args, positional...)
+	}
 
-	return all_args, nil
+	return all_args
+}
+
+var ErrExternalToolEnv = fmt.Errorf("external tool environment error")
+
+// CommandEnv returns the environment for the command, or nil if it simply
+// inherits ours.  Variables referenced in Env values must be set.
+func (t *ExternalTool) CommandEnv() ([]string, error) {
+
+	c := t.cfg
+	if len(c.Env) == 0 && len(c.InheritEnv) == 0 && !c.ClearEnv {
+		return nil, nil
+	}
+	env := []string{}
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if c.inherits(k) {
+			env = append(env, kv)
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.Env)) {
+		v, missing := ExpandEnv(c.Env[k])
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%w: %s references unset %s",
+				ErrExternalToolEnv, k, strings.Join(missing, ", "))
+		}
+		env = append(env, k+"="+v) // later values win
+	}
+	return env, nil
+}
+
+// commandStdin returns the rendered Stdin template.  Missing optional args
+// are empty strings.
+func (t *ExternalTool) commandStdin(input_map map[string]any) (string, error) {
+	data := maps.Clone(input_map)
+	for _, k := range t.argList {
+		if _, ok := data[k]; !ok {
+			data[k] = ""
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := t.stdin.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("%w: stdin: %w", ErrInvalidInput, err)
+	}
+	return buf.String(), nil
 }
 
 // CommandError represents an error returned from an external command.
@@ -363,62 +751,103 @@ var ErrCommandCanceled = fmt.Errorf("command canceled")
 var ErrCommandFailed = fmt.Errorf("command failed")
 
 // Exec implements Tooler.
+//
+// The result is the output in the configured OutputFormat, or an ExitResult
+// if the exit code is configured in ExitResults.
 func (t *ExternalTool) Exec(ctx context.Context, input string) (any, error) {
 
 	// Prepare the command
-	args, err := t.CommandArgs(input)
+	var input_map map[string]any
+	if !t.cfg.SendInput {
+		m, err := t.ValidateInput(input)
+		if err != nil {
+			return nil, err
+		}
+		input_map = m
+	}
+	env, err := t.CommandEnv()
 	if err != nil {
 		return nil, err
 	}
-	cmd := exec.CommandContext(ctx, t.cfg.Command, args...)
+	cmd := exec.CommandContext(ctx, t.cfg.Command, t.commandArgs(input_map)...)
+	cmd.Dir = t.cfg.Dir
+	cmd.Env = env
 	if t.cfg.SendInput {
 		cmd.Stdin = strings.NewReader(input)
+	} else if t.stdin != nil {
+		stdin, err := t.commandStdin(input_map)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = strings.NewReader(stdin)
 	}
 
-	// Capture stdout and stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Capture stdout and stderr, keeping only what may be returned.
+	stdout := newCappedWriter(t.cfg.MaxOutputBytes)
+	stderr := newCappedWriter(t.cfg.MaxOutputBytes)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	// Run the command
-	err = cmd.Run()
+	// Run the command, sandboxed if so configured.
+	cleanup, err := t.cfg.Sandbox.prepare(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	err = t.cfg.Sandbox.run(cmd)
 
 	// Check for errors.
 	ctx_err := ctx.Err()
 	var cmd_err error
+	exit_code := 0
 	if ctx_err == context.Canceled {
 		cmd_err = ErrCommandCanceled
 	} else if ctx_err == context.DeadlineExceeded {
 		cmd_err = ErrCommandTimedOut
 	} else if err != nil {
-		cmd_err = ErrCommandFailed
+		var exit_err *exec.ExitError
+		if errors.As(err, &exit_err) && t.exits[exit_err.ExitCode()] != "" {
+			exit_code = exit_err.ExitCode()
+		} else {
+			cmd_err = ErrCommandFailed
+		}
 	}
 	if cmd_err != nil {
 		return nil, NewCommandError(
 			fmt.Errorf("%w: %w", cmd_err, err),
-			stdout.String(),
-			stderr.String(),
+			stdout.Truncated(),
+			stderr.Truncated(),
 		)
 	}
+	out := stdout
 	if t.cfg.CombineOutput {
-		return stdout.String() + stderr.String(), nil
-	} else {
-		return stdout.String(), nil
+		out = newCappedWriter(t.cfg.MaxOutputBytes)
+		stdout.copyTo(out)
+		stderr.copyTo(out)
+	}
+	output, err := t.parseOutput(out)
+	if err != nil {
+		return nil, err
 	}
+	if exit_code != 0 {
+		return &ExitResult{
+			ExitCode: exit_code,
+			Status:   t.exits[exit_code],
+			Output:   output,
+		}, nil
+	}
+	return output, nil
 
 }
 
-// OpenAiTool implements Tooler.
-// TODO: move this elsewhere!
-func (t *ExternalTool) OpenAiTool() openai.Tool {
-	return openai.Tool{
-		Type: openai.ToolTypeFunction,
-		Function: &openai.FunctionDefinition{
-			Name:        t.cfg.Name,
-			Description: t.cfg.Description,
-			Strict:      true, // TODO: what does this mean?
-			// TODO: prove this works, it *should* be good to go.
-			Parameters: t.InputSchema(),
-		},
-	}
+// Metadata implements MetadataTooler.
+func (t *ExternalTool) Metadata() *Metadata {
+	return t.cfg.Metadata
+}
+
+// Definition implements Definer, being Strict if all args are required.
+func (t *ExternalTool) Definition() *Definition {
+	d := DefaultDefinition(t)
+	d.Strict = isStrict(t.schema)
+	return d
 }
@@ -0,0 +1,276 @@
+// tools/external_output.go
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExternalToolOutputFormats are the supported values of OutputFormat; the
+// first is the default.
+//
+//	text  - output as a string
+//	json  - output parsed as a single JSON value
+//	jsonl - output parsed as JSON values, one per non-blank line
+//	lines - output split into non-blank lines
+var ExternalToolOutputFormats = []string{"text", "json", "jsonl", "lines"}
+
+var ErrCommandOutputInvalid = fmt.Errorf("command output invalid")
+
+// ExitResult is returned by ExternalTool.Exec for nonzero exit codes
+// configured in ExitResults, which are not errors but still worth reporting,
+// e.g. "no matches" from grep.
+type ExitResult struct {
+	ExitCode int    `json:"exit_code"`
+	Status   string `json:"status"`
+	Output   any    `json:"output"`
+}
+
+// parseExitResults returns the integer-keyed version of results, whose keys
+// must be nonzero exit codes.
+func parseExitResults(results map[string]string) (map[int]string, error) {
+	m := make(map[int]string, len(results))
+	for k, v := range results {
+		code, err := strconv.Atoi(k)
+		if err != nil || code < 1 || code > 255 {
+			return nil, fmt.Errorf("exit code must be 1-255: %q", k)
+		}
+		m[code] = v
+	}
+	return m, nil
+}
+
+// TruncateHeadTail returns s truncated to about max bytes, keeping the head
+// and the tail with a notice of the number of bytes removed in between.  If
+// max is zero or s is not too long, s is returned unchanged.
+//
+// Truncation respects UTF-8 character boundaries.  The notice is not
+// counted in max.
+func TruncateHeadTail(s string, max int) string {
+	return truncateHeadTail(s, max, 0)
+}
+
+// truncateHeadTail is TruncateHeadTail for s already missing dropped bytes
+// somewhere in the part that will be removed, which are included in the
+// notice.
+func truncateHeadTail(s string, max int, dropped int) string {
+	if max <= 0 || (len(s) <= max && dropped == 0) {
+		return s
+	}
+	head := max / 2
+	for head > 0 && !utf8.RuneStart(s[head]) {
+		head--
+	}
+	tail := len(s) - (max - max/2)
+	for tail < len(s) && !utf8.RuneStart(s[tail]) {
+		tail++
+	}
+	return s[:head] + truncationNotice(tail-head+dropped) + s[tail:]
+}
+
+func truncationNotice(n int) string {
+	return fmt.Sprintf("\n[... %d bytes truncated ...]\n", n)
+}
+
+// truncateLines returns lines truncated to about max bytes, counting
+// newlines, keeping whole lines from the head and the tail with a notice in
+// between, whose index is also returned, or -1 if not truncated.  At least
+// one line is always kept.
+//
+// If dropped is not zero, that many bytes are missing before lines[seam],
+// so the notice is always included, and never after that line.
+func truncateLines(lines []string, max int, seam int, dropped int) ([]string, int) {
+	size := 0
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+	if dropped == 0 && (max <= 0 || size <= max) {
+		return lines, -1
+	}
+	head, tail := seam, seam
+	if size > max {
+		budget := max / 2
+		head = 0
+		for head < len(lines)-1 && len(lines[head])+1 <= budget {
+			budget -= len(lines[head]) + 1
+			head++
+		}
+		if head == 0 {
+			head = 1 // always something, even if it's too long
+		}
+		budget += max - max/2
+		tail = len(lines)
+		for tail > head && len(lines[tail-1])+1 <= budget {
+			budget -= len(lines[tail-1]) + 1
+			tail--
+		}
+		if dropped > 0 {
+			head = min(head, seam)
+			if tail < seam {
+				tail = seam
+			}
+		}
+	}
+	if head == tail && dropped == 0 {
+		return lines, -1 // a single long line, which we keep
+	}
+	removed := 0
+	for _, line := range lines[head:tail] {
+		removed += len(line) + 1
+	}
+	res := append([]string{}, lines[:head]...)
+	res = append(res, strings.TrimSpace(truncationNotice(removed+dropped)))
+	return append(res, lines[tail:]...), head
+}
+
+func nonBlankLines(s string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	return lines
+}
+
+// cappedWriter is a Writer for command output that keeps at most the first
+// max bytes and, in a ring buffer, the last max bytes, counting the bytes
+// dropped in between.  If max is zero everything is kept.
+type cappedWriter struct {
+	max     int
+	head    []byte
+	tail    []byte // ring buffer once full, starting at next
+	next    int
+	dropped int
+	last    byte // the last byte dropped
+}
+
+func newCappedWriter(max int) *cappedWriter {
+	return &cappedWriter{max: max}
+}
+
+// Write implements io.Writer.
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.max <= 0 {
+		w.head = append(w.head, p...)
+		return n, nil
+	}
+	take := min(len(p), w.max-len(w.head))
+	w.head = append(w.head, p[:take]...)
+	p = p[take:]
+	for len(p) > 0 {
+		if len(w.tail) < w.max {
+			take = min(len(p), w.max-len(w.tail))
+			w.tail = append(w.tail, p[:take]...)
+		} else {
+			w.last = w.tail[min(w.next+len(p), w.max)-1]
+			take = copy(w.tail[w.next:], p)
+			w.dropped += take
+			w.next = (w.next + take) % w.max
+		}
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// Dropped returns the number of bytes written but not kept.
+func (w *cappedWriter) Dropped() int {
+	return w.dropped
+}
+
+// String returns the bytes kept, in order.
+func (w *cappedWriter) String() string {
+	return string(w.head) + w.tailString()
+}
+
+func (w *cappedWriter) tailString() string {
+	return string(w.tail[w.next:]) + string(w.tail[:w.next])
+}
+
+// Truncated returns the bytes kept, truncated with TruncateHeadTail to max,
+// counting the dropped bytes in the notice.
+func (w *cappedWriter) Truncated() string {
+	return truncateHeadTail(w.String(), w.max, w.dropped)
+}
+
+// lines returns the non-blank lines kept, the index of the first line after
+// the dropped bytes, and the number of bytes dropped.  Lines cut short by the
+// dropped bytes are dropped as well.
+func (w *cappedWriter) lines() ([]string, int, int) {
+	if w.dropped == 0 {
+		return nonBlankLines(w.String()), -1, 0
+	}
+	head := w.head[:bytes.LastIndexByte(w.head, '\n')+1]
+	tail := w.tailString()
+	if w.last != '\n' {
+		tail = tail[strings.IndexByte(tail, '\n')+1:]
+	}
+	dropped := w.dropped + len(w.head) - len(head) + len(w.tail) - len(tail)
+	lines := nonBlankLines(string(head))
+	seam := len(lines)
+	return append(lines, nonBlankLines(tail)...), seam, dropped
+}
+
+// copyTo writes what w kept to dst, which must have the same max, with the
+// dropped bytes still dropped.  Anything dst holds in its tail at that point
+// is dropped as well, as dst's head is full if any bytes were dropped.
+func (w *cappedWriter) copyTo(dst *cappedWriter) {
+	dst.Write(w.head)
+	if w.dropped > 0 {
+		dst.dropped += len(dst.tail) + w.dropped
+		dst.tail = dst.tail[:0]
+		dst.next = 0
+		dst.last = w.last
+	}
+	dst.Write([]byte(w.tailString()))
+}
+
+// parseOutput returns the output of a command according to the OutputFormat
+// and MaxOutputBytes.
+//
+// JSON output over the limit can not be parsed, and is returned as truncated
+// text instead.  JSONL output is truncated by lines before parsing.
+func (t *ExternalTool) parseOutput(out *cappedWriter) (any, error) {
+
+	max := t.cfg.MaxOutputBytes
+	switch t.cfg.OutputFormat {
+	case "", "text":
+		return out.Truncated(), nil
+	case "lines":
+		lines, seam, dropped := out.lines()
+		lines, _ = truncateLines(lines, max, seam, dropped)
+		return lines, nil
+	case "json":
+		s := out.String()
+		if max > 0 && (len(s) > max || out.Dropped() > 0) {
+			return out.Truncated(), nil
+		}
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCommandOutputInvalid, err)
+		}
+		return v, nil
+	case "jsonl":
+		lines, seam, dropped := out.lines()
+		lines, notice := truncateLines(lines, max, seam, dropped)
+		vals := make([]any, len(lines))
+		for i, line := range lines {
+			if i == notice {
+				vals[i] = line
+				continue
+			}
+			if err := json.Unmarshal([]byte(line), &vals[i]); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %w", ErrCommandOutputInvalid, i+1, err)
+			}
+		}
+		return vals, nil
+	}
+	// Not reachable after validation.
+	return nil, fmt.Errorf("%w: unknown format %q", ErrCommandOutputInvalid, t.cfg.OutputFormat)
+}
@@ -0,0 +1,272 @@
+package tools_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// Return a config that echoes its lines with no ID line, so the output is
+// exactly what we send.
+func outputConfig(format string, max int, pre_args ...string) *tools.ExternalToolConfig {
+	cfg := ToyConfig()
+	cfg.Args = []*tools.ExternalToolArg{
+		{Key: "line", Repeat: true, AllowDash: true},
+	}
+	cfg.PreArgs = append([]string{"--no-id"}, pre_args...)
+	cfg.CombineOutput = false
+	cfg.OutputFormat = format
+	cfg.MaxOutputBytes = max
+	return cfg
+}
+
+func execOutput(t *testing.T, cfg *tools.ExternalToolConfig, lines ...string) (any, error) {
+	tool, err := tools.NewExternalTool(cfg)
+	require.NoError(t, err, "NewExternalTool")
+	input := utils.MustJsonString(map[string]any{"line": lines})
+	return tool.Exec(context.Background(), input)
+}
+
+func TestTruncateHeadTail(t *testing.T) {
+
+	require := require.New(t)
+
+	require.Equal("abcdef", tools.TruncateHeadTail("abcdef", 0))
+	require.Equal("abcdef", tools.TruncateHeadTail("abcdef", 6))
+	require.Equal("ab\n[... 2 bytes truncated ...]\nef",
+		tools.TruncateHeadTail("abcdef", 4))
+	require.Equal("a\n[... 3 bytes truncated ...]\nef",
+		tools.TruncateHeadTail("abcdef", 3))
+
+	// Multibyte characters are not split: "é" is two bytes.
+	require.Equal("a\n[... 5 bytes truncated ...]\nf",
+		tools.TruncateHeadTail("aébéf", 4))
+
+}
+
+func TestExternalToolOutputText(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	res, err := execOutput(t, outputConfig("", 0), "one", "two")
+	require.NoError(err)
+	require.Equal("one\ntwo\n", res)
+
+	res, err = execOutput(t, outputConfig("text", 8), "one", "two", "three")
+	require.NoError(err)
+	require.Equal("one\n\n[... 6 bytes truncated ...]\nree\n", res)
+
+}
+
+func TestExternalToolOutputLines(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	res, err := execOutput(t, outputConfig("lines", 0), "one", " ", "two")
+	require.NoError(err)
+	require.Equal([]string{"one", "two"}, res)
+
+	res, err = execOutput(t, outputConfig("lines", 10), "one", "two", "three", "four", "five")
+	require.NoError(err)
+	require.Equal([]string{"one", "[... 15 bytes truncated ...]", "five"}, res)
+
+	// One long line is kept as-is, if it was all captured.
+	res, err = execOutput(t, outputConfig("lines", 5), "long line")
+	require.NoError(err)
+	require.Equal([]string{"long line"}, res)
+	res, err = execOutput(t, outputConfig("lines", 4), "long line")
+	require.NoError(err)
+	require.Equal([]string{"[... 10 bytes truncated ...]"}, res)
+
+}
+
+func TestExternalToolOutputJson(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	res, err := execOutput(t, outputConfig("json", 0), `{"a":`, `[1,"x"]}`)
+	require.NoError(err)
+	require.Equal(map[string]any{"a": []any{float64(1), "x"}}, res)
+
+	_, err = execOutput(t, outputConfig("json", 0), `{"a":`)
+	require.ErrorIs(err, tools.ErrCommandOutputInvalid)
+
+	// Too big to parse, so truncated text.
+	res, err = execOutput(t, outputConfig("json", 6), `{"a":`, `[1,"x"]}`)
+	require.NoError(err)
+	require.Equal("{\"a\n[... 9 bytes truncated ...]\n]}\n", res)
+
+}
+
+func TestExternalToolOutputJsonl(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	res, err := execOutput(t, outputConfig("jsonl", 0), `{"a":1}`, "", `"b"`)
+	require.NoError(err)
+	require.Equal([]any{map[string]any{"a": float64(1)}, "b"}, res)
+
+	res, err = execOutput(t, outputConfig("jsonl", 8), `1`, `2`, `3`, `4`, `5`, `6`)
+	require.NoError(err)
+	require.Equal([]any{float64(1), float64(2),
+		"[... 4 bytes truncated ...]",
+		float64(5), float64(6)}, res)
+
+	_, err = execOutput(t, outputConfig("jsonl", 0), `1`, `{`)
+	require.ErrorIs(err, tools.ErrCommandOutputInvalid)
+	require.ErrorContains(err, "line 2")
+
+}
+
+func TestExternalToolOutputCapped(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	// Far more than is kept, with no partial lines or miscounted bytes.
+	lines := []string{}
+	total := 0
+	for i := 1; i <= 2000; i++ {
+		lines = append(lines, strconv.Itoa(i))
+		total += len(lines[i-1]) + 1
+	}
+	res, err := execOutput(t, outputConfig("jsonl", 20), lines...)
+	require.NoError(err)
+	vals := res.([]any)
+	require.Equal(float64(1), vals[0])
+	require.Equal(float64(2000), vals[len(vals)-1])
+	kept, removed := 0, 0
+	for _, v := range vals {
+		if n, ok := v.(float64); ok {
+			kept += len(strconv.Itoa(int(n))) + 1
+			continue
+		}
+		_, err := fmt.Sscanf(v.(string), "[... %d bytes truncated ...]", &removed)
+		require.NoError(err)
+	}
+	require.LessOrEqual(kept, 20)
+	require.Equal(total, kept+removed)
+
+	// Stdout and stderr are capped separately, then combined.
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	cfg := &tools.ExternalToolConfig{
+		Name:           "capped",
+		Description:    "Lots of output.",
+		Command:        sh,
+		PreArgs:        []string{"-c", "seq 1 100000; seq 1 100000 >&2"},
+		CombineOutput:  true,
+		MaxOutputBytes: 10,
+	}
+	tool, err := tools.NewExternalTool(cfg)
+	require.NoError(err)
+	res, err = tool.Exec(context.Background(), "{}")
+	require.NoError(err)
+	require.Equal("1\n2\n3\n[... 1177780 bytes truncated ...]\n0000\n", res)
+
+}
+
+func TestExternalToolExitResults(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	cfg := outputConfig("lines", 0, "--exit", "3")
+	cfg.ExitResults = map[string]string{"3": "nothing found"}
+	res, err := execOutput(t, cfg, "some")
+	require.NoError(err)
+	require.Equal(&tools.ExitResult{
+		ExitCode: 3,
+		Status:   "nothing found",
+		Output:   []string{"some"},
+	}, res)
+
+	// Other codes are still errors, truncated as configured.
+	cfg = outputConfig("lines", 8, "--exit", "4")
+	cfg.ExitResults = map[string]string{"3": "nothing found"}
+	_, err = execOutput(t, cfg, "one", "two", "three")
+	require.ErrorIs(err, tools.ErrCommandFailed)
+	cerr, ok := err.(tools.CommandError)
+	require.True(ok)
+	require.Equal("one\n\n[... 6 bytes truncated ...]\nree\n", cerr.Stdout)
+	require.Equal("exit 4\n", cerr.Stderr)
+
+}
+
+func TestExternalToolConfigValidateFailsOutput(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	for exp, mod := range map[string]func(*tools.ExternalToolConfig){
+		`unsupported output_format for "echo_format": "xml"`: func(c *tools.ExternalToolConfig) {
+			c.OutputFormat = "xml"
+		},
+		"combine_output with json output": func(c *tools.ExternalToolConfig) {
+			c.OutputFormat = "json"
+		},
+		"negative max_output_bytes": func(c *tools.ExternalToolConfig) {
+			c.MaxOutputBytes = -1
+		},
+		`exit code must be 1-255: "0"`: func(c *tools.ExternalToolConfig) {
+			c.ExitResults = map[string]string{"0": "ok"}
+		},
+		`exit code must be 1-255: "x"`: func(c *tools.ExternalToolConfig) {
+			c.ExitResults = map[string]string{"x": "ok"}
+		},
+		`output_schema for "echo_format": invalid schema`: func(c *tools.ExternalToolConfig) {
+			c.OutputSchema = `{"type":`
+		},
+	} {
+		cfg := ToyConfig() // has CombineOutput
+		mod(cfg)
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrExternalToolConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+func TestExternalToolOutputSchema(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	tool, err := tools.NewExternalTool(ToyConfig())
+	require.NoError(err)
+	require.Nil(tool.OutputSchema())
+	require.Contains(tool.Help(), "Input Schema:")
+	require.NotContains(tool.Help(), "Output Schema:")
+
+	cfg := ToyConfig()
+	cfg.OutputSchema = `{"type":"string"}`
+	tool, err = tools.NewExternalTool(cfg)
+	require.NoError(err)
+	require.Equal(json.RawMessage(`{"type":"string"}`), tool.OutputSchema())
+	require.Contains(tool.Help(), "Output Schema:\n\n{\n  \"type\": \"string\"\n}")
+	require.Contains(tool.Help(), "Command: "+ToyCommandPath)
+	require.Contains(tools.FullDescription(tool), `JSON Schema: {"type":"string"}`)
+
+}
@@ -477,7 +477,8 @@ func TestExternalToolInputSchemaOK(t *testing.T) {
     "line": {
       "type": "array",
       "items": {
-        "type": "string"
+        "type": "string",
+        "pattern": "^([^-]|$)"
       }
     }
   },
@@ -496,7 +497,7 @@ func TestExternalToolInputSchemaOK(t *testing.T) {
 	require.JSONEq(exp, got) // random hash order could bit us otherwise.
 }
 
-func TestExternalToolOpenAiToolOK(t *testing.T) {
+func TestExternalToolDefinitionOK(t *testing.T) {
 
 	SkipInvalidToy(t)
 
@@ -506,57 +507,88 @@ func TestExternalToolOpenAiToolOK(t *testing.T) {
 	require.NoError(err, "NewExternalTool")
 
 	exp := `{
-  "type": "function",
-  "function": {
-    "name": "echo_format",
-    "description": "Echo args back with formatting.",
-    "strict": true,
-    "parameters": {
-      "type": "object",
-      "properties": {
-        "seed": {
-          "type": "number"
-        },
-        "header": {
-          "type": "array",
-          "items": {
-            "type": "string"
-          }
-        },
-        "indent": {
-          "type": "integer"
-        },
-        "prefix": {
+  "name": "echo_format",
+  "description": "Echo args back with formatting.",
+  "strict": true,
+  "input_schema": {
+    "type": "object",
+    "properties": {
+      "seed": {
+        "type": "number"
+      },
+      "header": {
+        "type": "array",
+        "items": {
           "type": "string"
-        },
-        "reverse": {
-          "type": "boolean"
-        },
-        "line": {
-          "type": "array",
-          "items": {
-            "type": "string"
-          }
         }
       },
-      "additionalProperties": false,
-      "required": [
-        "seed",
-        "header",
-        "indent",
-        "prefix",
-        "reverse",
-        "line"
-      ]
-    }
+      "indent": {
+        "type": "integer"
+      },
+      "prefix": {
+        "type": "string"
+      },
+      "reverse": {
+        "type": "boolean"
+      },
+      "line": {
+        "type": "array",
+        "items": {
+          "type": "string",
+          "pattern": "^([^-]|$)"
+        }
+      }
+    },
+    "additionalProperties": false,
+    "required": [
+      "seed",
+      "header",
+      "indent",
+      "prefix",
+      "reverse",
+      "line"
+    ]
   }
 }
 `
-	got := utils.MustJsonStringPretty(tool.OpenAiTool())
-	// require.Equal("", got)
+	got := utils.MustJsonStringPretty(tools.Define(tool))
 	require.JSONEq(exp, got) // random hash order could bit us otherwise.
 }
 
+func TestExternalToolConfigCopy(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &tools.ExternalToolConfig{
+		Name:        "copied",
+		Args:        []*tools.ExternalToolArg{{Flag: "-x", Enum: []any{"a"}}},
+		PreArgs:     []string{"-v"},
+		Env:         map[string]string{"A": "1"},
+		ExitResults: map[string]string{"1": "no match"},
+		Sandbox:     &tools.ExternalToolSandbox{MaxProcs: 1},
+		Metadata:    &tools.Metadata{Tags: []string{"t"}},
+	}
+	cp := cfg.Copy()
+	require.Equal(cfg, cp)
+	cp.Args[0].Key = "x"
+	cp.Args[0].Enum[0] = "b"
+	cp.PreArgs[0] = "-q"
+	cp.Env["A"] = "2"
+	cp.ExitResults["1"] = "nope"
+	cp.Sandbox.MaxProcs = 2
+	cp.Metadata.Tags[0] = "u"
+	require.Equal(&tools.ExternalToolConfig{
+		Name:        "copied",
+		Args:        []*tools.ExternalToolArg{{Flag: "-x", Enum: []any{"a"}}},
+		PreArgs:     []string{"-v"},
+		Env:         map[string]string{"A": "1"},
+		ExitResults: map[string]string{"1": "no match"},
+		Sandbox:     &tools.ExternalToolSandbox{MaxProcs: 1},
+		Metadata:    &tools.Metadata{Tags: []string{"t"}},
+	}, cfg, "original unchanged")
+
+}
+
 func TestExternalToolConfigValidateOK(t *testing.T) {
 
 	SkipInvalidToy(t)
@@ -766,6 +798,385 @@ func TestExternalToolArgValidateUntypedArgDefaultsOK(t *testing.T) {
 
 }
 
+func TestExternalToolArgValidateFailsBadConstraints(t *testing.T) {
+
+	require := require.New(t)
+
+	one, two := 1.0, 2.0
+	for exp, arg := range map[string]*tools.ExternalToolArg{
+		`pattern for non-string "n"`: {Key: "n", Type: "number", Pattern: "x"},
+		`bad pattern for "s"`:        {Key: "s", Pattern: "(["},
+		`max_length must be positive and for strings: "s"`: {
+			Key: "s", MaxLength: -1},
+		`max_length must be positive and for strings: "b"`: {
+			Key: "b", Type: "boolean", MaxLength: 3},
+		`min or max for non-number "s"`:    {Key: "s", Min: &one},
+		`min greater than max for "n"`:     {Key: "n", Type: "integer", Min: &two, Max: &one},
+		`enum value 1 is not string for`:   {Key: "s", Enum: []any{"a", int64(1)}},
+		`enum value 1.5 is not integer`:    {Key: "i", Type: "integer", Enum: []any{1.5}},
+		`enum value x is not number for`:   {Key: "n", Type: "number", Enum: []any{"x"}},
+		`enum value true is not string`:    {Key: "s", Enum: []any{true}},
+		`enum value [] is not boolean for`: {Key: "b", Type: "boolean", Enum: []any{[]any{}}},
+	} {
+		err := arg.Validate()
+		require.ErrorIs(err, tools.ErrExternalToolArgInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+// Return a config with constrained args.
+func constrainedConfig() *tools.ExternalToolConfig {
+	zero, ten := 0.0, 10.0
+	cfg := ToyConfig()
+	cfg.Args = []*tools.ExternalToolArg{
+		{
+			Flag:    "--indent",
+			Type:    "integer",
+			Min:     &zero,
+			Max:     &ten,
+			Enum:    []any{int64(0), int64(2), 4.0},
+			Pattern: "",
+		},
+		{
+			Flag:      "--prefix",
+			Type:      "string",
+			Pattern:   "^[a-z]+$",
+			MaxLength: 4,
+		},
+		{
+			Key:    "line",
+			Repeat: true,
+		},
+		{
+			Key:       "dashed",
+			AllowDash: true,
+		},
+	}
+	cfg.EndOptions = true
+	return cfg
+}
+
+func TestExternalToolInputSchemaConstraintsOK(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	tool, err := tools.NewExternalTool(constrainedConfig())
+	require.NoError(err, "NewExternalTool")
+
+	exp := `{
+  "type": "object",
+  "properties": {
+    "indent": {
+      "type": "integer",
+      "enum": [0, 2, 4],
+      "minimum": 0,
+      "maximum": 10
+    },
+    "prefix": {
+      "type": "string",
+      "pattern": "^[a-z]+$",
+      "maxLength": 4
+    },
+    "line": {
+      "type": "array",
+      "items": {
+        "type": "string",
+        "pattern": "^([^-]|$)"
+      }
+    },
+    "dashed": {
+      "type": "string"
+    }
+  },
+  "additionalProperties": false,
+  "required": ["indent", "prefix", "line", "dashed"]
+}`
+	require.JSONEq(exp, utils.MustJsonString(tool.InputSchema()))
+
+}
+
+func TestExternalToolValidateInputConstraints(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	tool, err := tools.NewExternalTool(constrainedConfig())
+	require.NoError(err, "NewExternalTool")
+
+	v, err := tool.ValidateInput(`{"indent":4.0,"prefix":"ab","line":["x","y-"],"dashed":"-z"}`)
+	require.NoError(err)
+	require.Equal(map[string]any{
+		"indent": int64(4),
+		"prefix": "ab",
+		"line":   []any{"x", "y-"},
+		"dashed": "-z",
+	}, v)
+
+	for exp, input := range map[string]string{
+		"at /indent: value must be one of":     `{"indent":3,"prefix":"ab","line":[],"dashed":""}`,
+		"at /indent: got number, want":         `{"indent":2.5,"prefix":"ab","line":[],"dashed":""}`,
+		"at /prefix: '-ab' does not match":     `{"indent":2,"prefix":"-ab","line":[],"dashed":""}`,
+		"at /prefix: maxLength: got 5, want 4": `{"indent":2,"prefix":"abcde","line":[],"dashed":""}`,
+		"at /line/1: '--all' does not match":   `{"indent":2,"prefix":"ab","line":["x","--all"],"dashed":""}`,
+		"additional properties 'x'":            `{"indent":2,"prefix":"ab","line":[],"dashed":"","x":1}`,
+	} {
+		_, err := tool.ValidateInput(input)
+		require.ErrorIs(err, tools.ErrInvalidInput, input)
+		require.ErrorContains(err, exp, input)
+	}
+
+}
+
+func TestExternalToolDashCheckStrict(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	cfg := ToyConfig()
+	cfg.Args = []*tools.ExternalToolArg{{Key: "line"}}
+	tool, err := tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+	d := tools.Define(tool)
+	require.True(d.Strict, "no not in schema")
+	require.NotContains(utils.MustJsonString(d.InputSchema), `"not"`)
+	_, err = tool.ValidateInput(`{"line":""}`)
+	require.NoError(err, "empty is not a dash")
+	_, err = tool.ValidateInput(`{"line":"-x"}`)
+	require.ErrorIs(err, tools.ErrInvalidInput)
+
+	// With its own pattern, the dash check needs not, which is not strict.
+	cfg = ToyConfig()
+	cfg.Args = []*tools.ExternalToolArg{{Key: "line", Pattern: "^[a-z-]+$"}}
+	tool, err = tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+	require.False(tools.Define(tool).Strict)
+	_, err = tool.ValidateInput(`{"line":"a-b"}`)
+	require.NoError(err)
+	_, err = tool.ValidateInput(`{"line":"-x"}`)
+	require.ErrorContains(err, "'not' failed")
+
+}
+
+func TestCommandArgsEndOptionsOK(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	tool, err := tools.NewExternalTool(constrainedConfig())
+	require.NoError(err, "NewExternalTool")
+
+	args, err := tool.CommandArgs(`{"indent":2,"prefix":"ab","line":["x","y"],"dashed":"-z"}`)
+	require.NoError(err)
+	require.Equal([]string{
+		"--indent", "2",
+		"--prefix", "ab",
+		"--",
+		"x", "y", "-z",
+	}, args)
+
+	// Also to the command itself, which sees no "-z" option.
+	res, err := tool.Exec(context.Background(), `{"indent":0,"prefix":"p","line":["x"],"dashed":"-z"}`)
+	require.NoError(err)
+	require.Contains(res, "px\np-z\n")
+
+}
+
+func TestExternalToolArgValidateDefaults(t *testing.T) {
+
+	require := require.New(t)
+
+	arg := &tools.ExternalToolArg{Flag: "-t", Type: "string", Default: "MX"}
+	require.NoError(arg.Validate())
+	require.True(arg.Optional, "default makes optional")
+
+	arg = &tools.ExternalToolArg{Key: "n", Type: "integer", Repeat: true,
+		Default: []any{int64(1), int64(2)}}
+	require.NoError(arg.Validate())
+
+	ten := 10.0
+	for exp, arg := range map[string]*tools.ExternalToolArg{
+		`bad default for "s": schema validation failed: at /: got number, want string`: {
+			Key: "s", Default: int64(1)},
+		`bad default for "n": schema validation failed: at /: maximum: got 11, want 10`: {
+			Key: "n", Type: "integer", Max: &ten, Default: int64(11)},
+		`bad default for "r": schema validation failed: at /: got string, want array`: {
+			Key: "r", Repeat: true, Default: "x"},
+		`bad default for "d": schema validation failed: at /: '-rf' does not match`: {
+			Key: "d", Default: "-rf"},
+	} {
+		err := arg.Validate()
+		require.ErrorIs(err, tools.ErrExternalToolArgInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+// Return a config with optional args.
+func optionalConfig() *tools.ExternalToolConfig {
+	cfg := ToyConfig()
+	cfg.Args = []*tools.ExternalToolArg{
+		{Flag: "--indent", Type: "integer", Default: int64(2)},
+		{Flag: "--prefix", Type: "string", Optional: true},
+		{Flag: "--reverse", Optional: true},
+		{Key: "line", Repeat: true},
+	}
+	return cfg
+}
+
+func TestExternalToolOptionalArgsOK(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	tool, err := tools.NewExternalTool(optionalConfig())
+	require.NoError(err, "NewExternalTool")
+
+	exp := `{
+  "type": "object",
+  "properties": {
+    "indent": {
+      "type": "integer",
+      "default": 2
+    },
+    "prefix": {
+      "type": "string"
+    },
+    "reverse": {
+      "type": "boolean"
+    },
+    "line": {
+      "type": "array",
+      "items": {
+        "type": "string",
+        "pattern": "^([^-]|$)"
+      }
+    }
+  },
+  "additionalProperties": false,
+  "required": ["line"]
+}`
+	require.JSONEq(exp, utils.MustJsonString(tool.InputSchema()))
+	require.False(tools.Define(tool).Strict, "not strict with optionals")
+
+	args, err := tool.CommandArgs(`{"line":["a"]}`)
+	require.NoError(err)
+	require.Equal([]string{"--indent", "2", "a"}, args)
+
+	args, err = tool.CommandArgs(`{"line":["a"],"indent":0,"prefix":"p","reverse":true}`)
+	require.NoError(err)
+	require.Equal([]string{"--indent", "0", "--prefix", "p", "--reverse", "a"}, args)
+
+	_, err = tool.CommandArgs(`{"indent":0}`)
+	require.ErrorIs(err, tools.ErrInvalidInput)
+	require.ErrorContains(err, "missing property 'line'")
+
+}
+
+func TestExternalToolExecEnvAndDirOK(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	t.Setenv("GHD_TEST_SECRET", "sekrit")
+	t.Setenv("GHD_TEST_KEEP", "kept")
+	t.Setenv("GHD_TEST_LOSE", "lost")
+	dir := t.TempDir()
+	cfg := optionalConfig()
+	cfg.PreArgs = []string{"--pwd",
+		"--env", "GHD_TEST_KEEP", "--env", "GHD_TEST_LOSE", "--env", "TOKEN"}
+	cfg.Dir = dir
+	cfg.Env = map[string]string{"TOKEN": "x-${GHD_TEST_SECRET}"}
+	cfg.InheritEnv = []string{"GHD_TEST_K*", "PATH"}
+	tool, err := tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+
+	res, err := tool.Exec(context.Background(), `{"line":["a"]}`)
+	require.NoError(err)
+	// Perl may see a symlink-resolved temp dir, e.g. on macOS.
+	real_dir, err := filepath.EvalSymlinks(dir)
+	require.NoError(err)
+	require.Contains(res, real_dir+"\n")
+	require.Contains(res, "GHD_TEST_KEEP=kept\n")
+	require.Contains(res, "GHD_TEST_LOSE=<unset>\n")
+	require.Contains(res, "TOKEN=x-sekrit\n")
+
+	// Without the allowlist we get everything.
+	cfg.InheritEnv = nil
+	tool, err = tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+	res, err = tool.Exec(context.Background(), `{"line":["a"]}`)
+	require.NoError(err)
+	require.Contains(res, "GHD_TEST_LOSE=lost\n")
+
+	// Unless we clear it.
+	cfg.ClearEnv = true
+	tool, err = tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+	env, err := tool.CommandEnv()
+	require.NoError(err)
+	require.Equal([]string{"TOKEN=x-sekrit"}, env)
+
+	// Secrets must exist.
+	cfg.Env["OTHER"] = "$GHD_TEST_NOPE"
+	tool, err = tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+	_, err = tool.Exec(context.Background(), `{"line":["a"]}`)
+	require.ErrorIs(err, tools.ErrExternalToolEnv)
+	require.ErrorContains(err, "OTHER references unset GHD_TEST_NOPE")
+
+}
+
+func TestExternalToolExecStdinOK(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	cfg := optionalConfig()
+	cfg.PreArgs = []string{"--stdin"}
+	cfg.Stdin = "in{{.prefix}}\n{{json .line}}\n"
+	tool, err := tools.NewExternalTool(cfg)
+	require.NoError(err, "NewExternalTool")
+
+	res, err := tool.Exec(context.Background(), `{"line":["a","b"]}`)
+	require.NoError(err)
+	require.Contains(res, "  a\n  b\n  in\n  [\"a\",\"b\"]\n")
+
+}
+
+func TestExternalToolConfigValidateFailsEnvAndStdin(t *testing.T) {
+
+	SkipInvalidToy(t)
+
+	require := require.New(t)
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(os.WriteFile(file, []byte{}, 0644))
+	for exp, mod := range map[string]func(*tools.ExternalToolConfig){
+		"dir error for":                 func(c *tools.ExternalToolConfig) { c.Dir = file + "-nope" },
+		"dir not a directory for":       func(c *tools.ExternalToolConfig) { c.Dir = file },
+		"bad inherit_env pattern for":   func(c *tools.ExternalToolConfig) { c.InheritEnv = []string{"["} },
+		"both stdin and send_input set": func(c *tools.ExternalToolConfig) { c.Stdin = "x"; c.SendInput = true },
+		"stdin template for":            func(c *tools.ExternalToolConfig) { c.Stdin = "{{" },
+		`unknown risk "scary"`:          func(c *tools.ExternalToolConfig) { c.Metadata = &tools.Metadata{Risk: "scary"} },
+	} {
+		cfg := ToyConfig()
+		mod(cfg)
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrExternalToolConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
 func init() {
 
 	// Set up the toy command.
@@ -0,0 +1,201 @@
+// Package factory provides tool factories: tools that create other tools at
+// runtime, for the agent calling them.
+//
+// Tool factories only work in programs built with the ghd_tool_factories
+// tag, and only for agents configured with tool_changes.  New tools are
+// registered in the agent's own registry, subject to its locks, and the
+// agent only uses them after an explicit RefreshTools, if its configured
+// tools include them -- usually with a regexp on the factory's prefix:
+//
+//	[[external_factories]]
+//	  name = "make_grep"
+//	  allow_pre_args = ["-i", "-w", "--max-count="]
+//	  [external_factories.template]
+//	    command = "/usr/bin/grep"
+//	    pre_args = ["-n"]
+//	    [[external_factories.template.args]]
+//	      key = "file"
+//	      type = "string"
+//	      description = "File to search."
+//
+//	[[agents]]
+//	  tools = ["make_grep", "/^make_grep_/"]
+//	  tool_changes = true
+//
+// See agent.ToolFactoriesEnabled for why this is a compile-time choice.
+package factory
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrExternalFactoryConfigInvalid = fmt.Errorf("invalid external factory config")
+
+// ExternalFactoryConfig describes a factory of ExternalTools, all running
+// the same command as specified in the Template.
+type ExternalFactoryConfig struct {
+	Name         string                    `toml:"name"`           // Name of the factory tool, required.
+	Description  string                    `toml:"description"`    // Description of the factory tool; has a default.
+	Prefix       string                    `toml:"prefix"`         // Prefix of created tool names; defaults to Name + "_".
+	Template     *tools.ExternalToolConfig `toml:"template"`       // Template for created tools, without Name or Description.
+	Metadata     *tools.Metadata           `toml:"metadata"`       // Optional metadata for the factory tool.
+	AllowPreArgs []string                  `toml:"allow_pre_args"` // Options the LLM may add in pre_args, e.g. "-i", or "--max-count=" with any value.
+}
+
+var toolNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Validate checks that c has a valid Name and Template, setting defaults as
+// needed.
+func (c *ExternalFactoryConfig) Validate() error {
+	if !toolNameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("%w: bad name %q", ErrExternalFactoryConfigInvalid, c.Name)
+	}
+	if c.Prefix == "" {
+		c.Prefix = c.Name + "_"
+	}
+	if !toolNameRegexp.MatchString(c.Prefix) {
+		return fmt.Errorf("%w: bad prefix %q for %q",
+			ErrExternalFactoryConfigInvalid, c.Prefix, c.Name)
+	}
+	if c.Description == "" {
+		c.Description = fmt.Sprintf("Create or remove tools named %s* running %s.",
+			c.Prefix, c.commandName())
+	}
+	if c.Template == nil {
+		return fmt.Errorf("%w: no template for %q", ErrExternalFactoryConfigInvalid, c.Name)
+	}
+	for _, opt := range c.AllowPreArgs {
+		if !strings.HasPrefix(opt, "-") {
+			return fmt.Errorf("%w: allowed pre_arg %q is not an option for %q",
+				ErrExternalFactoryConfigInvalid, opt, c.Name)
+		}
+	}
+	if _, err := c.toolConfig(c.Prefix+"template", "Template.", nil); err != nil {
+		return fmt.Errorf("%w: template for %q: %w",
+			ErrExternalFactoryConfigInvalid, c.Name, err)
+	}
+	if c.Metadata != nil {
+		if err := c.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%w: metadata for %q: %w",
+				ErrExternalFactoryConfigInvalid, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *ExternalFactoryConfig) commandName() string {
+	if c.Template == nil {
+		return "a command"
+	}
+	return c.Template.Command
+}
+
+// checkPreArgs returns an error if any of pre_args, from the LLM, is an
+// option not in AllowPreArgs.  Otherwise the LLM could add options such as
+// find's -exec, getting around the dash check of the Template's args.
+func (c *ExternalFactoryConfig) checkPreArgs(pre_args []string) error {
+	for _, arg := range pre_args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if !slices.ContainsFunc(c.AllowPreArgs, func(opt string) bool {
+			return arg == opt || (strings.HasSuffix(opt, "=") && strings.HasPrefix(arg, opt))
+		}) {
+			return fmt.Errorf("pre_arg %q not allowed: options must be one of %q",
+				arg, c.AllowPreArgs)
+		}
+	}
+	return nil
+}
+
+// toolConfig returns a validated copy of the Template with the given name
+// and description, and with pre_args after the Template's PreArgs.  The
+// Template itself is never changed, as factory calls can be concurrent.
+func (c *ExternalFactoryConfig) toolConfig(name, description string, pre_args []string) (*tools.ExternalToolConfig, error) {
+	cfg := c.Template.Copy()
+	cfg.Name = name
+	cfg.Description = description
+	cfg.PreArgs = slices.Concat(c.Template.PreArgs, pre_args)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ExternalFactoryInput is the input to an external tool factory.
+type ExternalFactoryInput struct {
+	Name        string   `json:"name" description:"Name of the tool, after the prefix."`
+	Description string   `json:"description,omitempty" description:"Description of the new tool."`
+	PreArgs     []string `json:"pre_args,omitempty" description:"Args included in every call to the new tool; only allowed options may start with a dash."`
+	Remove      bool     `json:"remove,omitempty" description:"Remove the tool instead of creating it."`
+}
+
+// NewExternalFactory returns a tool factory creating ExternalTools from the
+// Template in cfg.  The LLM supplies the name, after the prefix, and the
+// description of each new tool, and can add fixed args to specialize it;
+// these can only be options if they are in AllowPreArgs.
+//
+// The factory can only remove tools with its prefix, which are normally
+// tools it created.
+func NewExternalFactory(cfg *ExternalFactoryConfig) (tools.Tooler, error) {
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	tool := tools.NewTool[ExternalFactoryInput, string](
+		cfg.Name,
+		cfg.Description,
+		func(ctx context.Context, in ExternalFactoryInput) (string, error) {
+
+			registrar, err := agent.ToolRegistrar(ctx)
+			if err != nil {
+				return "", err
+			}
+			suffix := strings.TrimPrefix(in.Name, cfg.Prefix)
+			if !toolNameRegexp.MatchString(suffix) {
+				return "", fmt.Errorf("bad tool name %q", in.Name)
+			}
+			name := cfg.Prefix + suffix
+
+			if in.Remove {
+				if err := registrar.Remove(name); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("removed tool %s", name), nil
+			}
+
+			description := strings.TrimSpace(in.Description)
+			if description == "" {
+				return "", fmt.Errorf("empty description for tool %q", name)
+			}
+			if err := cfg.checkPreArgs(in.PreArgs); err != nil {
+				return "", err
+			}
+			tool_cfg, err := cfg.toolConfig(name, description, in.PreArgs)
+			if err != nil {
+				return "", err
+			}
+			made, err := tools.NewExternalTool(tool_cfg)
+			if err != nil {
+				return "", err
+			}
+			if err := registrar.Register(made); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("registered tool %s", name), nil
+		},
+	)
+	if cfg.Metadata != nil {
+		tool.WithMetadata(cfg.Metadata)
+	}
+	return tool, nil
+
+}
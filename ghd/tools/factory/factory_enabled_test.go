@@ -0,0 +1,156 @@
+//go:build ghd_tool_factories
+
+package factory_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/factory"
+)
+
+// scriptClient calls the tool and args in the prompt, separated by a space,
+// and responds to tool results with their data or error.
+type scriptClient struct {
+	agent.BasicApiClient
+}
+
+func (c *scriptClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	if len(req.ToolResults) == 0 {
+		name, args, _ := strings.Cut(req.Content, " ")
+		return &agent.CompletionResponse{
+			ToolCalls: []*agent.ToolCall{{Id: "1", Name: name, Args: args}},
+		}, nil
+	}
+	res := req.ToolResults[0].Output.(*tools.Result)
+	if !res.Ok {
+		return &agent.CompletionResponse{Content: res.Error}, nil
+	}
+	return &agent.CompletionResponse{Content: fmt.Sprint(res.Data)}, nil
+}
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-script", func() (agent.ApiClient, error) {
+		return &scriptClient{}, nil
+	})
+}
+
+func TestExternalFactoryMakesTools(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := toyConfig(t)
+	cfg.AllowPreArgs = []string{"--reverse", "--prefix="}
+	tool, err := factory.NewExternalFactory(cfg)
+	require.NoError(err)
+	reg := registry.New()
+	require.NoError(reg.Register(tool))
+	reg.LockForReplace()
+
+	a, err := agent.NewAgentWithRegistry(&agent.Config{
+		Name:        "maker",
+		Type:        "fake-script",
+		Silent:      true,
+		ToolChanges: true,
+		Tools: []*rgxp.OptionalRgxp{
+			rgxp.MustParseOptional("make_echo"),
+			rgxp.MustParseOptional("/^make_echo_/"),
+		},
+	}, reg)
+	require.NoError(err)
+	require.Equal([]string{"make_echo"}, a.Tools())
+
+	content, err := a.RunCompletionPrompt(
+		`make_echo {"name":"rev","description":"Reverse a line.","pre_args":["--reverse"]}`)
+	require.NoError(err)
+	require.Equal("registered tool make_echo_rev", content)
+	require.NoError(a.RefreshTools())
+	require.Equal([]string{"make_echo", "make_echo_rev"}, a.Tools())
+
+	content, err = a.RunCompletionPrompt(`make_echo_rev {"line":"abc"}`)
+	require.NoError(err)
+	require.Equal("cba", strings.TrimSpace(content))
+
+	// Locks apply, and only prefixed tools can be touched.
+	content, err = a.RunCompletionPrompt(
+		`make_echo {"name":"make_echo_rev","description":"Again."}`)
+	require.NoError(err)
+	require.Equal(registry.ErrReplaceLocked.Error(), content)
+	content, err = a.RunCompletionPrompt(`make_echo {"name":"../x","remove":true}`)
+	require.NoError(err)
+	require.Equal(`bad tool name "../x"`, content)
+	content, err = a.RunCompletionPrompt(
+		`make_echo {"name":"x","description":"X.","pre_args":["--prefix=>","abc"]}`)
+	require.NoError(err)
+	require.Equal("registered tool make_echo_x", content)
+	content, err = a.RunCompletionPrompt(`make_echo {"name":"x","remove":true}`)
+	require.NoError(err)
+	require.Equal("removed tool make_echo_x", content)
+	content, err = a.RunCompletionPrompt(
+		`make_echo {"name":"y","description":"Y.","pre_args":["--reverse","-exec","rm"]}`)
+	require.NoError(err)
+	require.Equal(`pre_arg "-exec" not allowed: options must be one of ["--reverse" "--prefix="]`, content)
+	content, err = a.RunCompletionPrompt(`make_echo {"name":"nope"}`)
+	require.NoError(err)
+	require.Equal(`empty description for tool "make_echo_nope"`, content)
+
+	content, err = a.RunCompletionPrompt(`make_echo {"name":"rev","remove":true}`)
+	require.NoError(err)
+	require.Equal("removed tool make_echo_rev", content)
+	require.NoError(a.RefreshTools())
+	require.Equal([]string{"make_echo"}, a.Tools())
+	require.Equal([]string{"make_echo"}, reg.Names(), "source unchanged")
+
+}
+
+func TestExternalFactoryConcurrentCalls(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := toyConfig(t)
+	cfg.Template.Args = append(cfg.Template.Args,
+		&tools.ExternalToolArg{Flag: "--reverse", Optional: true})
+	cfg.Template.Sandbox = &tools.ExternalToolSandbox{ProcessGroup: true}
+	tool, err := factory.NewExternalFactory(cfg)
+	require.NoError(err)
+
+	// Each agent has its own registry, but they share the factory.
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	errs := make([]error, 8)
+	for i := range results {
+		reg := registry.New()
+		require.NoError(reg.Register(tool))
+		a, err := agent.NewAgentWithRegistry(&agent.Config{
+			Name:        "maker",
+			Type:        "fake-script",
+			Silent:      true,
+			ToolChanges: true,
+			Tools:       []*rgxp.OptionalRgxp{rgxp.MustParseOptional("make_echo")},
+		}, reg)
+		require.NoError(err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = a.RunCompletionPrompt(
+				`make_echo {"name":"x","description":"X."}`)
+		}()
+	}
+	wg.Wait()
+	for i := range results {
+		require.NoError(errs[i])
+		require.Equal("registered tool make_echo_x", results[i])
+	}
+	require.Equal("", cfg.Template.Args[1].Key, "template unchanged")
+	require.Equal("", cfg.Template.Args[1].Type, "template unchanged")
+
+}
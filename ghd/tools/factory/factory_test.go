@@ -0,0 +1,78 @@
+package factory_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/factory"
+)
+
+// toyConfig returns a factory config for the toy command in testdata.
+func toyConfig(t *testing.T) *factory.ExternalFactoryConfig {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	path := filepath.Join(cwd, "..", "..", "testdata", "external_command.pl")
+	return &factory.ExternalFactoryConfig{
+		Name: "make_echo",
+		Template: &tools.ExternalToolConfig{
+			Command: path,
+			PreArgs: []string{"--no-id"},
+			Args: []*tools.ExternalToolArg{
+				{Key: "line", Type: "string", Description: "Line to echo."},
+			},
+		},
+	}
+}
+
+func TestExternalFactoryConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := toyConfig(t)
+	require.NoError(cfg.Validate())
+	require.Equal("make_echo_", cfg.Prefix)
+	require.Contains(cfg.Description, "tools named make_echo_* running")
+
+	for exp, mod := range map[string]func(c *factory.ExternalFactoryConfig){
+		`bad name "make echo"`:    func(c *factory.ExternalFactoryConfig) { c.Name = "make echo" },
+		`bad prefix "x/"`:         func(c *factory.ExternalFactoryConfig) { c.Prefix = "x/" },
+		`no template for`:         func(c *factory.ExternalFactoryConfig) { c.Template = nil },
+		`template for "make_echo`: func(c *factory.ExternalFactoryConfig) { c.Template.Command = "" },
+		`allowed pre_arg "x" is not an option`: func(c *factory.ExternalFactoryConfig) {
+			c.AllowPreArgs = []string{"-i", "x"}
+		},
+		`metadata for "make_echo`: func(c *factory.ExternalFactoryConfig) {
+			c.Metadata = &tools.Metadata{Risk: "scary"}
+		},
+	} {
+		cfg := toyConfig(t)
+		mod(cfg)
+		err := cfg.Validate()
+		require.ErrorIs(err, factory.ErrExternalFactoryConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+		_, err = factory.NewExternalFactory(cfg)
+		require.ErrorIs(err, factory.ErrExternalFactoryConfigInvalid, exp)
+	}
+
+}
+
+func TestExternalFactoryNeedsAgent(t *testing.T) {
+
+	require := require.New(t)
+
+	tool, err := factory.NewExternalFactory(toyConfig(t))
+	require.NoError(err)
+	_, err = tool.Exec(context.Background(), `{"name":"x","description":"X."}`)
+	if agent.ToolFactoriesEnabled() {
+		require.ErrorIs(err, agent.ErrToolChangesNotAllowed)
+	} else {
+		require.ErrorIs(err, agent.ErrToolFactoriesDisabled)
+	}
+
+}
@@ -0,0 +1,740 @@
+// Package fs provides filesystem tools confined to configured root
+// directories, for agents working with code and documents.
+//
+// The tools are not registered on init, as they need roots.  Register them
+// with Register, or in a runner config:
+//
+//	[fs_tools]
+//	  roots = ["./docs", "/srv/notes"]
+//	  write = true
+//
+// The read tools are always registered:
+//
+//	fs_read_file   read a text file, or a range of its lines
+//	fs_list_dir    list a directory, optionally recursively
+//	fs_search      find files by glob and lines by regexp
+//	fs_stat        describe a file or directory
+//
+// The write tools are only registered if Write is set:
+//
+//	fs_write_file  write a text file, replacing any content
+//	fs_apply_patch apply a unified diff to a text file
+//
+// Relative paths are relative to the first root, and absolute paths must be
+// within a root.  Symlinks are resolved before checking, so links can not
+// lead outside the roots.  Files larger than MaxFileBytes, and files that
+// look binary, are never read or written.
+//
+// These checks guard against mistakes and misdirection by the LLM, not
+// against other processes changing the roots at the same time.
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrConfigInvalid = fmt.Errorf("invalid fs tools config")
+
+var ErrOutsideRoots = fmt.Errorf("path is outside the allowed roots")
+
+var ErrNotFound = fmt.Errorf("no such file or directory")
+
+var ErrFileTooLarge = fmt.Errorf("file is too large")
+
+var ErrBinaryFile = fmt.Errorf("file is binary")
+
+// DefaultMaxFileBytes is the default for Config.MaxFileBytes.
+const DefaultMaxFileBytes = 1 << 20
+
+// DefaultMaxResults is the default for Config.MaxResults.
+const DefaultMaxResults = 500
+
+// maxMatchText is the max length of a matching line returned by fs_search.
+const maxMatchText = 500
+
+// binarySampleBytes is how much of a file is checked for binary content.
+const binarySampleBytes = 8000
+
+// Config describes the filesystem tools.
+type Config struct {
+	Roots        []string `toml:"roots"`          // Directories the tools may use; required.
+	Write        bool     `toml:"write"`          // Also register the write tools.
+	MaxFileBytes int64    `toml:"max_file_bytes"` // Max size of files read or written; default 1 MiB.
+	MaxResults   int      `toml:"max_results"`    // Max entries listed or lines found; default 500.
+}
+
+// Validate checks that c has at least one root, setting defaults as needed.
+func (c *Config) Validate() error {
+	if len(c.Roots) == 0 {
+		return fmt.Errorf("%w: no roots", ErrConfigInvalid)
+	}
+	if c.MaxFileBytes < 0 || c.MaxResults < 0 {
+		return fmt.Errorf("%w: negative limit", ErrConfigInvalid)
+	}
+	if c.MaxFileBytes == 0 {
+		c.MaxFileBytes = DefaultMaxFileBytes
+	}
+	if c.MaxResults == 0 {
+		c.MaxResults = DefaultMaxResults
+	}
+	return nil
+}
+
+// FS provides the filesystem tools for a set of root directories.
+type FS struct {
+	config *Config
+	roots  []string // absolute, with symlinks resolved
+}
+
+// New returns an FS for cfg, whose roots must be existing directories.
+func New(cfg *Config) (*FS, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	f := &FS{config: cfg}
+	for _, root := range cfg.Roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("%w: root %q: %w", ErrConfigInvalid, root, err)
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: root %q: %w", ErrConfigInvalid, root, err)
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return nil, fmt.Errorf("%w: root %q: %w", ErrConfigInvalid, root, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%w: root %q is not a directory", ErrConfigInvalid, root)
+		}
+		f.roots = append(f.roots, real)
+	}
+	return f, nil
+}
+
+// Roots returns the root directories of f, absolute and with symlinks
+// resolved.
+func (f *FS) Roots() []string {
+	return append([]string{}, f.roots...)
+}
+
+// Resolve returns the real path for name, which is relative to the first
+// root unless absolute, after resolving any symlinks in its existing part.
+// It is an error if the real path is outside all the roots.
+//
+// The path need not exist, so that files can be created.
+func (f *FS) Resolve(name string) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	p := filepath.FromSlash(name)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(f.roots[0], p)
+	}
+	real, err := evalExisting(filepath.Clean(p))
+	if err != nil {
+		return "", err
+	}
+	if !f.inRoots(real) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoots, name)
+	}
+	return real, nil
+}
+
+// evalExisting evaluates symlinks in the longest existing part of p, and
+// appends the rest.  Broken symlinks are errors, as writing to one could
+// create a file anywhere.
+func evalExisting(p string) (string, error) {
+	rest := []string{}
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(p); lerr == nil {
+			return "", fmt.Errorf("broken symlink: %s", filepath.Base(p))
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// inRoots returns true if real is one of the roots or within one.
+func (f *FS) inRoots(real string) bool {
+	for _, root := range f.roots {
+		if within(root, real) {
+			return true
+		}
+	}
+	return false
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// display returns real as shown to the LLM: relative to the first root if
+// within it, else absolute, with forward slashes.
+func (f *FS) display(real string) string {
+	if within(f.roots[0], real) {
+		rel, _ := filepath.Rel(f.roots[0], real)
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(real)
+}
+
+// stat returns the FileInfo for real, with ErrNotFound if it does not
+// exist.
+func (f *FS) stat(real string) (os.FileInfo, error) {
+	info, err := os.Stat(real)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, f.display(real))
+	}
+	return info, err
+}
+
+// readText returns the content of the text file at real, which must not be
+// too large or binary.
+func (f *FS) readText(real string) ([]byte, error) {
+	info, err := f.stat(real)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("is a directory: %s", f.display(real))
+	}
+	if info.Size() > f.config.MaxFileBytes {
+		return nil, fmt.Errorf("%w: %s is %d bytes, limit is %d",
+			ErrFileTooLarge, f.display(real), info.Size(), f.config.MaxFileBytes)
+	}
+	b, err := os.ReadFile(real)
+	if err != nil {
+		return nil, err
+	}
+	if isBinary(b) {
+		return nil, fmt.Errorf("%w: %s", ErrBinaryFile, f.display(real))
+	}
+	return b, nil
+}
+
+// isBinary returns true if the start of b has a NUL byte or is not valid
+// UTF-8.
+func isBinary(b []byte) bool {
+	if len(b) > binarySampleBytes {
+		b = b[:binarySampleBytes]
+		// Allow for a rune cut off by the sample.
+		for i := 1; i < utf8.UTFMax && !utf8.Valid(b); i++ {
+			b = b[:len(b)-1]
+		}
+	}
+	return bytes.IndexByte(b, 0) >= 0 || !utf8.Valid(b)
+}
+
+// isBinaryFile returns true if the file at real looks binary.
+func isBinaryFile(real string) (bool, error) {
+	fh, err := os.Open(real)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	b := make([]byte, binarySampleBytes+utf8.UTFMax)
+	n, err := io.ReadFull(fh, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return isBinary(b[:n]), nil
+}
+
+// entryType returns the type of a file for display.
+func entryType(mode iofs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode.IsRegular():
+		return "file"
+	case mode&iofs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// ReadFileInput is the input to fs_read_file.
+type ReadFileInput struct {
+	Path      string `json:"path" description:"Path of the file."`
+	StartLine int    `json:"start_line,omitempty" description:"First line to return, from 1." jsonschema:"minimum=1"`
+	EndLine   int    `json:"end_line,omitempty" description:"Last line to return; default is the last line." jsonschema:"minimum=1"`
+}
+
+// ReadFileResult is the output of fs_read_file.
+type ReadFileResult struct {
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	TotalLines int    `json:"total_lines"`
+}
+
+// ReadFile returns the content of a text file, or of the lines from
+// StartLine to EndLine inclusive.
+func (f *FS) ReadFile(in ReadFileInput) (*ReadFileResult, error) {
+	real, err := f.Resolve(in.Path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := f.readText(real)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(string(b))
+	start := max(in.StartLine, 1)
+	end := len(lines)
+	if in.EndLine > 0 && in.EndLine < end {
+		end = in.EndLine
+	}
+	res := &ReadFileResult{
+		Path:       f.display(real),
+		StartLine:  start,
+		EndLine:    end,
+		TotalLines: len(lines),
+	}
+	if start > end {
+		if len(lines) > 0 {
+			return nil, fmt.Errorf("start_line %d is past end_line %d", start, end)
+		}
+		res.StartLine = 0
+		return res, nil
+	}
+	res.Content = strings.Join(lines[start-1:end], "\n")
+	if end < len(lines) || strings.HasSuffix(string(b), "\n") {
+		res.Content += "\n"
+	}
+	return res, nil
+}
+
+// splitLines splits s into lines without their newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// ListDirInput is the input to fs_list_dir.
+type ListDirInput struct {
+	Path      string `json:"path,omitempty" description:"Path of the directory; default is the first root."`
+	Recursive bool   `json:"recursive,omitempty" description:"Also list everything under subdirectories."`
+}
+
+// Entry is a file or directory listed by fs_list_dir.
+type Entry struct {
+	Path string `json:"path"`
+	Type string `json:"type"` // file, dir, symlink or other
+	Size int64  `json:"size,omitempty"`
+}
+
+// ListDirResult is the output of fs_list_dir.
+type ListDirResult struct {
+	Entries   []*Entry `json:"entries"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// ListDir lists a directory, or with Recursive its whole tree, up to
+// MaxResults entries.  Symlinks are listed but not followed.
+func (f *FS) ListDir(in ListDirInput) (*ListDirResult, error) {
+	real, err := f.Resolve(defaultPath(in.Path))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.stat(real)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", f.display(real))
+	}
+	res := &ListDirResult{Entries: []*Entry{}}
+	err = filepath.WalkDir(real, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == real {
+			return nil
+		}
+		if len(res.Entries) >= f.config.MaxResults {
+			res.Truncated = true
+			return filepath.SkipAll
+		}
+		entry := &Entry{Path: f.display(p), Type: entryType(d.Type())}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				entry.Size = info.Size()
+			}
+		}
+		res.Entries = append(res.Entries, entry)
+		if d.IsDir() && !in.Recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func defaultPath(p string) string {
+	if strings.TrimSpace(p) == "" {
+		return "."
+	}
+	return p
+}
+
+// SearchInput is the input to fs_search.
+type SearchInput struct {
+	Path   string `json:"path,omitempty" description:"Directory to search under; default is the first root."`
+	Glob   string `json:"glob,omitempty" description:"Glob for file names, e.g. *.md; or for paths under the directory if it has a slash, e.g. docs/*.md."`
+	Regexp string `json:"regexp,omitempty" description:"Regular expression (RE2 syntax) to find in the lines of text files."`
+}
+
+// Match is a line found by fs_search.
+type Match struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// SearchResult is the output of fs_search.  Files are returned for a Glob
+// without a Regexp, else Matches.
+type SearchResult struct {
+	Files     []string `json:"files,omitempty"`
+	Matches   []*Match `json:"matches,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// Search finds files under a directory matching a Glob, and lines in them
+// matching a Regexp, up to MaxResults.  At least one is required.
+//
+// Symlinks are not followed, and binary or too-large files are skipped.
+func (f *FS) Search(in SearchInput) (*SearchResult, error) {
+	if in.Glob == "" && in.Regexp == "" {
+		return nil, fmt.Errorf("glob or regexp is required")
+	}
+	if _, err := filepath.Match(in.Glob, ""); err != nil {
+		return nil, fmt.Errorf("bad glob %q: %w", in.Glob, err)
+	}
+	var re *regexp.Regexp
+	if in.Regexp != "" {
+		var err error
+		if re, err = regexp.Compile(in.Regexp); err != nil {
+			return nil, fmt.Errorf("bad regexp: %w", err)
+		}
+	}
+	real, err := f.Resolve(defaultPath(in.Path))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.stat(real)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", f.display(real))
+	}
+
+	res := &SearchResult{}
+	found := 0
+	err = filepath.WalkDir(real, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if in.Glob != "" {
+			rel, _ := filepath.Rel(real, p)
+			target := filepath.ToSlash(rel)
+			if !strings.Contains(in.Glob, "/") {
+				target = d.Name()
+			}
+			if ok, _ := filepath.Match(in.Glob, target); !ok {
+				return nil
+			}
+		}
+		if re == nil {
+			if found >= f.config.MaxResults {
+				res.Truncated = true
+				return filepath.SkipAll
+			}
+			res.Files = append(res.Files, f.display(p))
+			found++
+			return nil
+		}
+		b, err := f.readText(p)
+		if err != nil {
+			return nil // skip binary, too large or unreadable files
+		}
+		for i, line := range splitLines(string(b)) {
+			if !re.MatchString(line) {
+				continue
+			}
+			if found >= f.config.MaxResults {
+				res.Truncated = true
+				return filepath.SkipAll
+			}
+			res.Matches = append(res.Matches, &Match{
+				Path: f.display(p),
+				Line: i + 1,
+				Text: shorten(line, maxMatchText),
+			})
+			found++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// shorten returns s cut to at most n bytes on a rune boundary, with "..."
+// if cut.
+func shorten(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+// StatInput is the input to fs_stat.
+type StatInput struct {
+	Path string `json:"path" description:"Path of the file or directory."`
+}
+
+// StatResult is the output of fs_stat.
+type StatResult struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"` // file, dir or other
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+	Binary  bool      `json:"binary,omitempty"` // only checked for files
+}
+
+// Stat describes a file or directory.
+func (f *FS) Stat(in StatInput) (*StatResult, error) {
+	real, err := f.Resolve(in.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.stat(real)
+	if err != nil {
+		return nil, err
+	}
+	res := &StatResult{
+		Path:    f.display(real),
+		Type:    entryType(info.Mode()),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+	}
+	if info.Mode().IsRegular() {
+		if res.Binary, err = isBinaryFile(real); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// WriteFileInput is the input to fs_write_file.
+type WriteFileInput struct {
+	Path       string `json:"path" description:"Path of the file."`
+	Content    string `json:"content" description:"The full new content of the file."`
+	CreateDirs bool   `json:"create_dirs,omitempty" description:"Create any missing parent directories."`
+}
+
+// WriteFile writes a text file, replacing any existing content but keeping
+// its permissions.  Existing binary files are not replaced.
+func (f *FS) WriteFile(in WriteFileInput) (string, error) {
+	real, err := f.Resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := f.writeText(real, []byte(in.Content), in.CreateDirs); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("wrote %d bytes to %s", len(in.Content), f.display(real)), nil
+}
+
+// writeText writes b to the file at real, which if it exists must be a
+// text file.
+func (f *FS) writeText(real string, b []byte, create_dirs bool) error {
+	if int64(len(b)) > f.config.MaxFileBytes {
+		return fmt.Errorf("%w: content is %d bytes, limit is %d",
+			ErrFileTooLarge, len(b), f.config.MaxFileBytes)
+	}
+	if isBinary(b) {
+		return fmt.Errorf("%w: refusing to write binary content", ErrBinaryFile)
+	}
+	perm := os.FileMode(0644)
+	info, err := os.Stat(real)
+	if err == nil {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("not a regular file: %s", f.display(real))
+		}
+		binary, err := isBinaryFile(real)
+		if err != nil {
+			return err
+		}
+		if binary {
+			return fmt.Errorf("%w: %s", ErrBinaryFile, f.display(real))
+		}
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if create_dirs {
+		if err := os.MkdirAll(filepath.Dir(real), 0755); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(filepath.Dir(real)); err != nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, f.display(filepath.Dir(real)))
+	}
+	return os.WriteFile(real, b, perm)
+}
+
+// ApplyPatchInput is the input to fs_apply_patch.
+type ApplyPatchInput struct {
+	Path  string `json:"path" description:"Path of the file."`
+	Patch string `json:"patch" description:"Unified diff for the file, with @@ hunk headers and enough context lines to place each change."`
+}
+
+// ApplyPatch applies a unified diff to a text file, creating it if it does
+// not exist and the patch only adds lines.  See the ApplyPatch function
+// for how hunks are placed.
+func (f *FS) ApplyPatch(in ApplyPatchInput) (string, error) {
+	real, err := f.Resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+	var b []byte
+	if _, err := os.Stat(real); err == nil {
+		if b, err = f.readText(real); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	patched, hunks, err := ApplyPatch(string(b), in.Patch)
+	if err != nil {
+		return "", err
+	}
+	if err := f.writeText(real, []byte(patched), false); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("applied %d hunks to %s", hunks, f.display(real)), nil
+}
+
+// ReadTools returns the read-only tools of f.
+func (f *FS) ReadTools() []tools.Tooler {
+	meta := &tools.Metadata{Tags: []string{"fs"}, Risk: tools.RiskReadOnly}
+	return []tools.Tooler{
+		tools.NewTool[ReadFileInput, *ReadFileResult](
+			"fs_read_file",
+			"Reads a text file, or a range of its lines.  Only files under the allowed directories can be read.",
+			func(ctx context.Context, in ReadFileInput) (*ReadFileResult, error) {
+				return f.ReadFile(in)
+			},
+		).WithMetadata(meta),
+		tools.NewTool[ListDirInput, *ListDirResult](
+			"fs_list_dir",
+			"Lists the files and directories in a directory, optionally including everything under it.",
+			func(ctx context.Context, in ListDirInput) (*ListDirResult, error) {
+				return f.ListDir(in)
+			},
+		).WithMetadata(meta),
+		tools.NewTool[SearchInput, *SearchResult](
+			"fs_search",
+			"Finds files under a directory by name pattern (glob), and lines in text files matching a regular expression.",
+			func(ctx context.Context, in SearchInput) (*SearchResult, error) {
+				return f.Search(in)
+			},
+		).WithMetadata(meta),
+		tools.NewTool[StatInput, *StatResult](
+			"fs_stat",
+			"Describes a file or directory: its type, size, permissions, modification time, and whether it is binary.",
+			func(ctx context.Context, in StatInput) (*StatResult, error) {
+				return f.Stat(in)
+			},
+		).WithMetadata(meta),
+	}
+}
+
+// WriteTools returns the tools of f that change files.
+func (f *FS) WriteTools() []tools.Tooler {
+	meta := &tools.Metadata{Tags: []string{"fs"}, Risk: tools.RiskWrite}
+	return []tools.Tooler{
+		tools.NewTool[WriteFileInput, string](
+			"fs_write_file",
+			"Writes a text file, replacing all of its content.  This changes files on disk, and the old content is lost.",
+			func(ctx context.Context, in WriteFileInput) (string, error) {
+				return f.WriteFile(in)
+			},
+		).WithMetadata(meta),
+		tools.NewTool[ApplyPatchInput, string](
+			"fs_apply_patch",
+			"Changes a text file by applying a patch in unified diff format.  This changes files on disk.",
+			func(ctx context.Context, in ApplyPatchInput) (string, error) {
+				return f.ApplyPatch(in)
+			},
+		).WithMetadata(meta),
+	}
+}
+
+// Register registers the read tools for cfg in reg, and the write tools if
+// cfg.Write is set.
+func Register(reg *registry.Registry, cfg *Config) error {
+	f, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	register := f.ReadTools()
+	if cfg.Write {
+		register = append(register, f.WriteTools()...)
+	}
+	for _, tool := range register {
+		if err := reg.Register(tool); err != nil {
+			return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+		}
+	}
+	return nil
+}
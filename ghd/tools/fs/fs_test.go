@@ -0,0 +1,403 @@
+package fs_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/fs"
+)
+
+// testRoots returns two root dirs with some files, and a dir outside them.
+func testRoots(t *testing.T) (string, string, string) {
+	t.Helper()
+	base := t.TempDir()
+	files := map[string]string{
+		"root/a.txt":         "one\ntwo\nthree\n",
+		"root/docs/b.md":     "# Title\n\nSome text about foxes.\n",
+		"root/docs/sub/c.md": "Foxes again.\n",
+		"root/bin.dat":       "abc\x00def",
+		"other/d.txt":        "the other root\n",
+		"outside/secret.txt": "secret\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(base, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return filepath.Join(base, "root"), filepath.Join(base, "other"), filepath.Join(base, "outside")
+}
+
+func testFS(t *testing.T, cfg *fs.Config) (*fs.FS, string, string, string) {
+	t.Helper()
+	root, other, outside := testRoots(t)
+	if cfg == nil {
+		cfg = &fs.Config{}
+	}
+	cfg.Roots = []string{root, other}
+	f, err := fs.New(cfg)
+	require.NoError(t, err)
+	return f, root, other, outside
+}
+
+func TestNewFails(t *testing.T) {
+
+	require := require.New(t)
+
+	_, err := fs.New(&fs.Config{})
+	require.ErrorIs(err, fs.ErrConfigInvalid)
+	require.ErrorContains(err, "no roots")
+
+	_, err = fs.New(&fs.Config{Roots: []string{t.TempDir()}, MaxResults: -1})
+	require.ErrorIs(err, fs.ErrConfigInvalid)
+
+	_, err = fs.New(&fs.Config{Roots: []string{filepath.Join(t.TempDir(), "nope")}})
+	require.ErrorIs(err, fs.ErrConfigInvalid)
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(os.WriteFile(file, nil, 0644))
+	_, err = fs.New(&fs.Config{Roots: []string{file}})
+	require.ErrorIs(err, fs.ErrConfigInvalid)
+	require.ErrorContains(err, "is not a directory")
+
+}
+
+func TestResolve(t *testing.T) {
+
+	require := require.New(t)
+
+	f, root, other, outside := testFS(t, nil)
+	root = f.Roots()[0]
+
+	p, err := f.Resolve("docs/b.md")
+	require.NoError(err)
+	require.Equal(filepath.Join(root, "docs", "b.md"), p)
+
+	p, err = f.Resolve("new/file.txt")
+	require.NoError(err, "need not exist")
+	require.Equal(filepath.Join(root, "new", "file.txt"), p)
+
+	_, err = f.Resolve(filepath.Join(other, "d.txt"))
+	require.NoError(err, "second root by absolute path")
+
+	for _, bad := range []string{
+		"../outside/secret.txt",
+		filepath.Join(outside, "secret.txt"),
+		"docs/../../outside",
+		"/",
+	} {
+		_, err := f.Resolve(bad)
+		require.ErrorIs(err, fs.ErrOutsideRoots, bad)
+	}
+	_, err = f.Resolve(" ")
+	require.ErrorContains(err, "empty path")
+
+	// Links out of the roots are refused, broken links too.
+	require.NoError(os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(os.Symlink(filepath.Join(outside, "nope"), filepath.Join(root, "broken")))
+	require.NoError(os.Symlink(filepath.Join(other, "d.txt"), filepath.Join(root, "ok")))
+	_, err = f.Resolve("escape/secret.txt")
+	require.ErrorIs(err, fs.ErrOutsideRoots)
+	_, err = f.Resolve("escape/new.txt")
+	require.ErrorIs(err, fs.ErrOutsideRoots)
+	_, err = f.Resolve("broken")
+	require.ErrorContains(err, "broken symlink: broken")
+	p, err = f.Resolve("ok")
+	require.NoError(err, "links between roots are fine")
+	require.Equal(filepath.Join(f.Roots()[1], "d.txt"), p)
+
+}
+
+func TestReadFile(t *testing.T) {
+
+	require := require.New(t)
+
+	f, _, other, _ := testFS(t, &fs.Config{MaxFileBytes: 20})
+
+	res, err := f.ReadFile(fs.ReadFileInput{Path: "a.txt"})
+	require.NoError(err)
+	require.Equal(&fs.ReadFileResult{
+		Path:       "a.txt",
+		Content:    "one\ntwo\nthree\n",
+		StartLine:  1,
+		EndLine:    3,
+		TotalLines: 3,
+	}, res)
+
+	res, err = f.ReadFile(fs.ReadFileInput{Path: "a.txt", StartLine: 2, EndLine: 2})
+	require.NoError(err)
+	require.Equal("two\n", res.Content)
+	require.Equal(2, res.StartLine)
+	require.Equal(2, res.EndLine)
+
+	res, err = f.ReadFile(fs.ReadFileInput{Path: filepath.Join(other, "d.txt")})
+	require.NoError(err)
+	require.Equal(filepath.ToSlash(filepath.Join(f.Roots()[1], "d.txt")), res.Path,
+		"absolute outside the first root")
+
+	_, err = f.ReadFile(fs.ReadFileInput{Path: "a.txt", StartLine: 9})
+	require.ErrorContains(err, "start_line 9 is past end_line 3")
+	_, err = f.ReadFile(fs.ReadFileInput{Path: "bin.dat"})
+	require.ErrorIs(err, fs.ErrBinaryFile)
+	_, err = f.ReadFile(fs.ReadFileInput{Path: "docs/b.md"})
+	require.ErrorIs(err, fs.ErrFileTooLarge)
+	require.ErrorContains(err, "docs/b.md is 32 bytes, limit is 20")
+	_, err = f.ReadFile(fs.ReadFileInput{Path: "docs"})
+	require.ErrorContains(err, "is a directory: docs")
+	_, err = f.ReadFile(fs.ReadFileInput{Path: "nope.txt"})
+	require.ErrorIs(err, fs.ErrNotFound)
+	require.ErrorContains(err, "nope.txt")
+	_, err = f.ReadFile(fs.ReadFileInput{Path: "../outside/secret.txt"})
+	require.ErrorIs(err, fs.ErrOutsideRoots)
+
+}
+
+func TestListDir(t *testing.T) {
+
+	require := require.New(t)
+
+	f, root, _, outside := testFS(t, nil)
+	require.NoError(os.Symlink(outside, filepath.Join(root, "escape")))
+
+	res, err := f.ListDir(fs.ListDirInput{})
+	require.NoError(err)
+	require.Equal([]*fs.Entry{
+		{Path: "a.txt", Type: "file", Size: 14},
+		{Path: "bin.dat", Type: "file", Size: 7},
+		{Path: "docs", Type: "dir"},
+		{Path: "escape", Type: "symlink"},
+	}, res.Entries)
+	require.False(res.Truncated)
+
+	res, err = f.ListDir(fs.ListDirInput{Path: "docs", Recursive: true})
+	require.NoError(err)
+	require.Equal([]*fs.Entry{
+		{Path: "docs/b.md", Type: "file", Size: 32},
+		{Path: "docs/sub", Type: "dir"},
+		{Path: "docs/sub/c.md", Type: "file", Size: 13},
+	}, res.Entries)
+
+	_, err = f.ListDir(fs.ListDirInput{Path: "a.txt"})
+	require.ErrorContains(err, "not a directory: a.txt")
+	_, err = f.ListDir(fs.ListDirInput{Path: "escape"})
+	require.ErrorIs(err, fs.ErrOutsideRoots)
+
+	f, _, _, _ = testFS(t, &fs.Config{MaxResults: 2})
+	res, err = f.ListDir(fs.ListDirInput{Recursive: true})
+	require.NoError(err)
+	require.Len(res.Entries, 2)
+	require.True(res.Truncated)
+
+}
+
+func TestSearch(t *testing.T) {
+
+	require := require.New(t)
+
+	f, root, _, outside := testFS(t, nil)
+	require.NoError(os.Symlink(outside, filepath.Join(root, "escape")))
+
+	res, err := f.Search(fs.SearchInput{Glob: "*.md"})
+	require.NoError(err)
+	require.Equal([]string{"docs/b.md", "docs/sub/c.md"}, res.Files)
+	require.Nil(res.Matches)
+
+	res, err = f.Search(fs.SearchInput{Glob: "docs/*.md"})
+	require.NoError(err)
+	require.Equal([]string{"docs/b.md"}, res.Files)
+
+	res, err = f.Search(fs.SearchInput{Path: "docs", Glob: "sub/*"})
+	require.NoError(err)
+	require.Equal([]string{"docs/sub/c.md"}, res.Files)
+
+	res, err = f.Search(fs.SearchInput{Regexp: "(?i)fox|secret|def"})
+	require.NoError(err)
+	require.Nil(res.Files)
+	require.Equal([]*fs.Match{
+		{Path: "docs/b.md", Line: 3, Text: "Some text about foxes."},
+		{Path: "docs/sub/c.md", Line: 1, Text: "Foxes again."},
+	}, res.Matches, "no binary files, no links followed")
+
+	res, err = f.Search(fs.SearchInput{Glob: "c.*", Regexp: "o"})
+	require.NoError(err)
+	require.Equal([]*fs.Match{{Path: "docs/sub/c.md", Line: 1, Text: "Foxes again."}}, res.Matches)
+
+	long := strings.Repeat("é", 300) + "\n"
+	require.NoError(os.WriteFile(filepath.Join(root, "long.txt"), []byte(long), 0644))
+	res, err = f.Search(fs.SearchInput{Glob: "long.txt", Regexp: "é"})
+	require.NoError(err)
+	require.Equal(strings.Repeat("é", 250)+"...", res.Matches[0].Text)
+
+	f, _, _, _ = testFS(t, &fs.Config{MaxResults: 1})
+	res, err = f.Search(fs.SearchInput{Regexp: "o"})
+	require.NoError(err)
+	require.Len(res.Matches, 1)
+	require.True(res.Truncated)
+	res, err = f.Search(fs.SearchInput{Glob: "*"})
+	require.NoError(err)
+	require.Len(res.Files, 1)
+	require.True(res.Truncated)
+
+	for exp, in := range map[string]fs.SearchInput{
+		"glob or regexp is required": {},
+		"bad glob":                   {Glob: "["},
+		"bad regexp":                 {Regexp: "("},
+		"not a directory: a.txt":     {Path: "a.txt", Glob: "*"},
+		"no such file or directory":  {Path: "nope", Glob: "*"},
+	} {
+		_, err := f.Search(in)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+func TestStat(t *testing.T) {
+
+	require := require.New(t)
+
+	f, _, _, _ := testFS(t, nil)
+
+	res, err := f.Stat(fs.StatInput{Path: "a.txt"})
+	require.NoError(err)
+	require.Equal("a.txt", res.Path)
+	require.Equal("file", res.Type)
+	require.EqualValues(14, res.Size)
+	require.Equal("-rw-r--r--", res.Mode)
+	require.False(res.Binary)
+	require.False(res.ModTime.IsZero())
+
+	res, err = f.Stat(fs.StatInput{Path: "bin.dat"})
+	require.NoError(err)
+	require.True(res.Binary)
+
+	res, err = f.Stat(fs.StatInput{Path: "docs"})
+	require.NoError(err)
+	require.Equal("dir", res.Type)
+
+	_, err = f.Stat(fs.StatInput{Path: "nope"})
+	require.ErrorIs(err, fs.ErrNotFound)
+
+}
+
+func TestWriteFile(t *testing.T) {
+
+	require := require.New(t)
+
+	f, root, _, outside := testFS(t, &fs.Config{MaxFileBytes: 20})
+	require.NoError(os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(os.Chmod(filepath.Join(root, "a.txt"), 0600))
+
+	msg, err := f.WriteFile(fs.WriteFileInput{Path: "a.txt", Content: "new\n"})
+	require.NoError(err)
+	require.Equal("wrote 4 bytes to a.txt", msg)
+	b, err := os.ReadFile(filepath.Join(root, "a.txt"))
+	require.NoError(err)
+	require.Equal("new\n", string(b))
+	info, err := os.Stat(filepath.Join(root, "a.txt"))
+	require.NoError(err)
+	require.Equal(os.FileMode(0600), info.Mode().Perm(), "permissions kept")
+
+	_, err = f.WriteFile(fs.WriteFileInput{Path: "x/y/z.txt", Content: "z"})
+	require.ErrorIs(err, fs.ErrNotFound)
+	msg, err = f.WriteFile(fs.WriteFileInput{Path: "x/y/z.txt", Content: "z", CreateDirs: true})
+	require.NoError(err)
+	require.Equal("wrote 1 bytes to x/y/z.txt", msg)
+
+	for exp, in := range map[string]fs.WriteFileInput{
+		"path is outside the allowed roots":       {Path: "escape/secret.txt", Content: "x"},
+		"content is 21 bytes, limit is 20":        {Path: "big.txt", Content: strings.Repeat("x", 21)},
+		"refusing to write binary content":        {Path: "bin.txt", Content: "a\x00b"},
+		"file is binary: bin.dat":                 {Path: "bin.dat", Content: "text"},
+		"not a regular file: docs":                {Path: "docs", Content: "text"},
+		"no such file or directory: nope/ok.txt":  {Path: "nope/ok.txt/x", Content: "x"},
+		"path is outside the allowed roots: ../x": {Path: "../x", Content: "x"},
+	} {
+		_, err := f.WriteFile(in)
+		require.ErrorContains(err, exp)
+	}
+	b, err = os.ReadFile(filepath.Join(outside, "secret.txt"))
+	require.NoError(err)
+	require.Equal("secret\n", string(b))
+
+}
+
+func TestApplyPatchFile(t *testing.T) {
+
+	require := require.New(t)
+
+	f, root, _, _ := testFS(t, nil)
+
+	msg, err := f.ApplyPatch(fs.ApplyPatchInput{
+		Path:  "a.txt",
+		Patch: "--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n",
+	})
+	require.NoError(err)
+	require.Equal("applied 1 hunks to a.txt", msg)
+	b, err := os.ReadFile(filepath.Join(root, "a.txt"))
+	require.NoError(err)
+	require.Equal("one\nTWO\nthree\n", string(b))
+
+	msg, err = f.ApplyPatch(fs.ApplyPatchInput{
+		Path:  "new.txt",
+		Patch: "@@ -0,0 +1,2 @@\n+hello\n+world\n",
+	})
+	require.NoError(err)
+	require.Equal("applied 1 hunks to new.txt", msg)
+	b, err = os.ReadFile(filepath.Join(root, "new.txt"))
+	require.NoError(err)
+	require.Equal("hello\nworld\n", string(b))
+
+	_, err = f.ApplyPatch(fs.ApplyPatchInput{Path: "a.txt", Patch: "@@ -1 +1 @@\n-nope\n+yes\n"})
+	require.ErrorIs(err, fs.ErrPatchFailed)
+	_, err = f.ApplyPatch(fs.ApplyPatchInput{Path: "bin.dat", Patch: "@@ -1 +1 @@\n+yes\n"})
+	require.ErrorIs(err, fs.ErrBinaryFile)
+	_, err = f.ApplyPatch(fs.ApplyPatchInput{Path: "../x", Patch: "@@ -1 +1 @@\n+yes\n"})
+	require.ErrorIs(err, fs.ErrOutsideRoots)
+
+}
+
+func TestRegister(t *testing.T) {
+
+	require := require.New(t)
+
+	root, _, _ := testRoots(t)
+
+	reg := registry.New()
+	require.NoError(fs.Register(reg, &fs.Config{Roots: []string{root}}))
+	require.Equal([]string{"fs_read_file", "fs_list_dir", "fs_search", "fs_stat"}, reg.Names())
+
+	reg = registry.New()
+	require.NoError(fs.Register(reg, &fs.Config{Roots: []string{root}, Write: true}))
+	require.Equal([]string{
+		"fs_read_file", "fs_list_dir", "fs_search", "fs_stat", "fs_write_file", "fs_apply_patch",
+	}, reg.Names())
+	for _, name := range reg.Names() {
+		tool, err := reg.Get(name)
+		require.NoError(err)
+		meta := tools.MetadataOf(tool)
+		require.True(meta.HasTag("fs"), name)
+		if strings.HasPrefix(name, "fs_write") || strings.HasPrefix(name, "fs_apply") {
+			require.Equal(tools.RiskWrite, meta.Risk, name)
+		} else {
+			require.Equal(tools.RiskReadOnly, meta.Risk, name)
+		}
+	}
+
+	tool, err := reg.Get("fs_read_file")
+	require.NoError(err)
+	res, err := tool.Exec(context.Background(), `{"path":"a.txt","start_line":3}`)
+	require.NoError(err)
+	require.Equal("three\n", res.(*fs.ReadFileResult).Content)
+
+	require.ErrorIs(fs.Register(registry.New(), &fs.Config{}), fs.ErrConfigInvalid)
+	reg.Lock()
+	require.ErrorContains(fs.Register(reg, &fs.Config{Roots: []string{root}}),
+		`failed to register "fs_read_file"`)
+
+}
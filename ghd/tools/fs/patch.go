@@ -0,0 +1,128 @@
+// tools/fs/patch.go
+
+package fs
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrPatchInvalid = fmt.Errorf("invalid patch")
+
+var ErrPatchFailed = fmt.Errorf("patch does not apply")
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// hunk is one change in a unified diff.
+type hunk struct {
+	line int      // starting line in the old content, from 1
+	old  []string // context and removed lines
+	new  []string // context and added lines
+}
+
+// parsePatch returns the hunks of a unified diff.  Anything before the first
+// hunk header, such as "---" and "+++" lines, is ignored.
+func parsePatch(patch string) ([]*hunk, error) {
+	var hunks []*hunk
+	var h *hunk
+	for i, line := range splitLines(strings.ReplaceAll(patch, "\r\n", "\n")) {
+		if strings.HasPrefix(line, "@@") {
+			m := hunkHeaderRegexp.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("%w: line %d: bad hunk header %q",
+					ErrPatchInvalid, i+1, line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			h = &hunk{line: start}
+			hunks = append(hunks, h)
+			continue
+		}
+		if h == nil {
+			continue
+		}
+		switch {
+		case line == "":
+			// Blank context lines often lose their leading space.
+			h.old = append(h.old, "")
+			h.new = append(h.new, "")
+		case line[0] == ' ':
+			h.old = append(h.old, line[1:])
+			h.new = append(h.new, line[1:])
+		case line[0] == '-':
+			h.old = append(h.old, line[1:])
+		case line[0] == '+':
+			h.new = append(h.new, line[1:])
+		case line[0] == '\\':
+			// "\ No newline at end of file" is ignored.
+		default:
+			return nil, fmt.Errorf("%w: line %d: unexpected %q", ErrPatchInvalid, i+1, line)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("%w: no hunks", ErrPatchInvalid)
+	}
+	return hunks, nil
+}
+
+// ApplyPatch applies the unified diff patch to content, returning the new
+// content and the number of hunks applied.
+//
+// Hunks are placed by their context and removed lines, which must match
+// exactly, at the position nearest to the line number in the hunk header.
+// Line counts in the headers are not checked, as LLMs often get them
+// wrong.  Hunks must be in order and must not overlap.  Unless empty, the
+// new content ends with a newline.
+func ApplyPatch(content, patch string) (string, int, error) {
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return "", 0, err
+	}
+	lines := splitLines(content)
+	out := []string{}
+	next := 0 // first line not yet copied to out
+	for i, h := range hunks {
+		pos := findHunk(lines, h, next)
+		if pos < 0 {
+			return "", 0, fmt.Errorf("%w: hunk %d at line %d: lines not found",
+				ErrPatchFailed, i+1, h.line)
+		}
+		out = append(out, lines[next:pos]...)
+		out = append(out, h.new...)
+		next = pos + len(h.old)
+	}
+	out = append(out, lines[next:]...)
+	if len(out) == 0 {
+		return "", len(hunks), nil
+	}
+	return strings.Join(out, "\n") + "\n", len(hunks), nil
+}
+
+// findHunk returns the index in lines, not before from, where h applies,
+// nearest to its header line, or -1 if it does not apply.
+func findHunk(lines []string, h *hunk, from int) int {
+	// A hunk adding to empty old lines is placed after its header line.
+	want := h.line - 1
+	if len(h.old) == 0 {
+		want = h.line
+	}
+	last := len(lines) - len(h.old)
+	if last < from {
+		return -1
+	}
+	want = min(max(want, from), last)
+	matches := func(pos int) bool {
+		return slices.Equal(lines[pos:pos+len(h.old)], h.old)
+	}
+	for d := 0; want-d >= from || want+d <= last; d++ {
+		if want-d >= from && matches(want-d) {
+			return want - d
+		}
+		if want+d <= last && matches(want+d) {
+			return want + d
+		}
+	}
+	return -1
+}
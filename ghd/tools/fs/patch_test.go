@@ -0,0 +1,70 @@
+package fs_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools/fs"
+)
+
+func TestApplyPatch(t *testing.T) {
+
+	require := require.New(t)
+
+	const content = "a\nb\nc\nd\ne\nf\ng\nh\n"
+	for _, tc := range []struct {
+		name  string
+		patch string
+		exp   string
+		hunks int
+	}{
+		{"replace", "@@ -2,3 +2,3 @@\n a\n-b\n+B\n c\n",
+			"a\nB\nc\nd\ne\nf\ng\nh\n", 1},
+		{"headers ignored", "diff --git a/x b/x\n--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n",
+			"A\nb\nc\nd\ne\nf\ng\nh\n", 1},
+		{"wrong line numbers", "@@ -1,1 +1,1 @@\n f\n-g\n+G\n",
+			"a\nb\nc\nd\ne\nf\nG\nh\n", 1},
+		{"two hunks", "@@ -1,2 +1,3 @@\n a\n+a2\n b\n@@ -7,2 +8,1 @@\n g\n-h\n",
+			"a\na2\nb\nc\nd\ne\nf\ng\n", 2},
+		{"insert after line", "@@ -3,0 +4,1 @@\n+c2\n",
+			"a\nb\nc\nc2\nd\ne\nf\ng\nh\n", 1},
+		{"insert at start", "@@ -0,0 +1 @@\n+0\n",
+			"0\na\nb\nc\nd\ne\nf\ng\nh\n", 1},
+		{"crlf and no newline marker", "@@ -8 +8 @@\r\n-h\r\n\\ No newline at end of file\r\n+H\r\n",
+			"a\nb\nc\nd\ne\nf\ng\nH\n", 1},
+		{"delete all", "@@ -1,8 +0,0 @@\n-a\n-b\n-c\n-d\n-e\n-f\n-g\n-h\n", "", 1},
+	} {
+		got, hunks, err := fs.ApplyPatch(content, tc.patch)
+		require.NoError(err, tc.name)
+		require.Equal(tc.exp, got, tc.name)
+		require.Equal(tc.hunks, hunks, tc.name)
+	}
+
+	got, _, err := fs.ApplyPatch("x\n\ny\n", "@@ -1,3 +1,3 @@\n x\n\n-y\n+Y\n")
+	require.NoError(err, "blank context line without its space")
+	require.Equal("x\n\nY\n", got)
+
+	got, _, err = fs.ApplyPatch("", "@@ -0,0 +1,2 @@\n+new\n+file\n")
+	require.NoError(err)
+	require.Equal("new\nfile\n", got)
+
+}
+
+func TestApplyPatchFails(t *testing.T) {
+
+	require := require.New(t)
+
+	for exp, patch := range map[string]string{
+		"invalid patch: no hunks":                       "--- a/x\n+++ b/x\n",
+		`invalid patch: line 1: bad hunk header "@@ x"`: "@@ x\n-a\n",
+		`invalid patch: line 2: unexpected "?a"`:        "@@ -1 +1 @@\n?a\n",
+		"patch does not apply: hunk 1 at line 1":        "@@ -1 +1 @@\n-z\n+Z\n",
+		"patch does not apply: hunk 2 at line 1":        "@@ -2 +2 @@\n-b\n+B\n@@ -1 +1 @@\n-a\n+A\n",
+		"patch does not apply: hunk 1 at line 9":        "@@ -9 +9 @@\n a\n b\n c\n d\n",
+	} {
+		_, _, err := fs.ApplyPatch("a\nb\nc\n", patch)
+		require.ErrorContains(err, exp, patch)
+	}
+
+}
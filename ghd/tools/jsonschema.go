@@ -0,0 +1,307 @@
+// tools/jsonschema.go
+
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JsonSchema is a JSON schema for tool input or output, or part of one.  It
+// supports the subset of JSON Schema that LLM APIs generally understand.
+//
+// Properties and Required are always included for objects, even if empty,
+// as some APIs insist on them.
+type JsonSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Default              any                    `json:"default,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	Not                  *JsonSchema            `json:"not,omitempty"`
+	Items                *JsonSchema            `json:"items,omitempty"`
+	OneOf                []*JsonSchema          `json:"oneOf,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Properties           map[string]*JsonSchema `json:"properties,omitzero"`
+	Required             []string               `json:"required,omitzero"`
+}
+
+// SchemaProvider is implemented by types that provide their own schema,
+// e.g. to use OneOf, instead of having it generated by GenerateSchema.  The
+// method is called on the zero value, and must return a new schema each
+// time, as field tags are applied to it.
+type SchemaProvider interface {
+	JsonSchema() *JsonSchema
+}
+
+var schemaProviderType = reflect.TypeFor[SchemaProvider]()
+
+var ErrSchemaGeneration = fmt.Errorf("schema generation failed")
+
+// GenerateSchema returns the schema for values of type t, as encoded by
+// encoding/json.
+//
+// Struct fields are named by their json tags, and are required unless tagged
+// omitempty or omitzero.  They can be annotated with a description tag, and
+// a jsonschema tag of semicolon-separated keys and values:
+//
+//	Color string `json:"color" description:"Paint color." jsonschema:"enum=red,green;default=red"`
+//
+// Supported keys are description, enum (comma-separated), default, format,
+// pattern, minimum, maximum, minLength, maxLength, minItems and maxItems,
+// as well as the flags required and optional.  For slices, the value keys
+// apply to the items.  Enum and default values are parsed as the type of
+// the field.
+//
+// Pointers are treated as their elements, interfaces allow any value, and
+// maps must have string keys.  Recursive types are not supported.
+func GenerateSchema(t reflect.Type) (*JsonSchema, error) {
+	s, err := reflectSchema(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrSchemaGeneration, t, err)
+	}
+	return s, nil
+}
+
+func reflectSchema(t reflect.Type, seen map[reflect.Type]bool) (*JsonSchema, error) {
+
+	if t.Kind() == reflect.Pointer && t.Implements(schemaProviderType) {
+		return reflect.New(t.Elem()).Interface().(SchemaProvider).JsonSchema(), nil
+	} else if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(SchemaProvider).JsonSchema(), nil
+	} else if reflect.PointerTo(t).Implements(schemaProviderType) {
+		return reflect.New(t).Interface().(SchemaProvider).JsonSchema(), nil
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return &JsonSchema{Type: "string", Format: "date-time"}, nil
+	}
+	if t == reflect.TypeFor[json.RawMessage]() {
+		return &JsonSchema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JsonSchema{Type: "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JsonSchema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JsonSchema{Type: "number"}, nil
+	case reflect.Bool:
+		return &JsonSchema{Type: "boolean"}, nil
+	case reflect.Interface:
+		return &JsonSchema{}, nil
+	case reflect.Pointer:
+		return reflectSchema(t.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JsonSchema{Type: "string"}, nil // base64
+		}
+		items, err := reflectSchema(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &JsonSchema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key not a string: %s", t)
+		}
+		return &JsonSchema{Type: "object"}, nil
+	case reflect.Struct:
+		if seen[t] {
+			return nil, fmt.Errorf("recursive type: %s", t)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &JsonSchema{
+			Type:                 "object",
+			AdditionalProperties: new(bool),
+			Properties:           map[string]*JsonSchema{},
+			Required:             []string{},
+		}
+		if err := reflectFields(s, t, seen); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported type: %s", t)
+}
+
+// reflectFields adds the fields of struct type t to s, flattening embedded
+// structs as encoding/json does.
+func reflectFields(s *JsonSchema, t reflect.Type, seen map[reflect.Type]bool) error {
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		json_tag := field.Tag.Get("json")
+		if json_tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(json_tag, ",")
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := reflectFields(s, ft, seen); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop, err := reflectSchema(field.Type, seen)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		required := !strings.Contains(","+opts+",", ",omitempty,") &&
+			!strings.Contains(","+opts+",", ",omitzero,")
+		if desc := field.Tag.Get("description"); desc != "" {
+			prop.Description = desc
+		}
+		if tag := field.Tag.Get("jsonschema"); tag != "" {
+			if err := applyTag(prop, field.Type, tag, &required); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+		if _, ok := s.Properties[name]; !ok && required {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	return nil
+}
+
+// applyTag applies the keys and values of a jsonschema tag to s, the schema
+// of a field of type t.
+func applyTag(s *JsonSchema, t reflect.Type, tag string, required *bool) error {
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Value constraints of slices are for their items.
+	target, target_type := s, t
+	if s.Type == "array" && s.Items != nil {
+		target, target_type = s.Items, t.Elem()
+		for target_type.Kind() == reflect.Pointer {
+			target_type = target_type.Elem()
+		}
+	}
+
+	for _, part := range strings.Split(tag, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		var err error
+		switch key {
+		case "":
+		case "required":
+			*required = true
+		case "optional":
+			*required = false
+		case "description":
+			s.Description = val
+		case "format":
+			target.Format = val
+		case "pattern":
+			target.Pattern = val
+		case "enum":
+			for _, v := range strings.Split(val, ",") {
+				var ev any
+				if ev, err = parseTagValue(target_type, v); err != nil {
+					break
+				}
+				target.Enum = append(target.Enum, ev)
+			}
+		case "default":
+			s.Default, err = parseTagValue(t, val)
+		case "minimum":
+			target.Minimum, err = parseTagFloat(val)
+		case "maximum":
+			target.Maximum, err = parseTagFloat(val)
+		case "minLength":
+			target.MinLength, err = parseTagInt(val)
+		case "maxLength":
+			target.MaxLength, err = parseTagInt(val)
+		case "minItems":
+			s.MinItems, err = parseTagInt(val)
+		case "maxItems":
+			s.MaxItems, err = parseTagInt(val)
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return fmt.Errorf("jsonschema tag %q: %w", part, err)
+		}
+	}
+	return nil
+}
+
+// parseTagValue parses v as a value of type t.
+func parseTagValue(t reflect.Type, v string) (any, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return v, nil
+	case reflect.Bool:
+		return strconv.ParseBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseInt(v, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(v, 64)
+	}
+	return nil, fmt.Errorf("values not supported for %s", t)
+}
+
+func parseTagFloat(v string) (*float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseTagInt(v string) (*int, error) {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// applyDefaults sets the defaults of s for properties missing from v, as
+// decoded from JSON, recursively.
+func applyDefaults(s *JsonSchema, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, prop := range s.Properties {
+			if val, ok := v[k]; ok {
+				applyDefaults(prop, val)
+			} else if prop.Default != nil {
+				v[k] = prop.Default
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for _, item := range v {
+				applyDefaults(s.Items, item)
+			}
+		}
+	}
+}
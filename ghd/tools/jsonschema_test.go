@@ -0,0 +1,152 @@
+package tools_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+type SchemaBase struct {
+	Id string `json:"id" description:"The ID."`
+}
+
+type SchemaPoint struct {
+	X float64 `json:"x" jsonschema:"minimum=0;maximum=10"`
+	Y float64 `json:"y,omitempty"`
+}
+
+// SchemaShape provides its own schema.
+type SchemaShape struct {
+	Kind string `json:"kind"`
+}
+
+func (SchemaShape) JsonSchema() *tools.JsonSchema {
+	return &tools.JsonSchema{OneOf: []*tools.JsonSchema{
+		{Type: "string"},
+		{Type: "object", Properties: map[string]*tools.JsonSchema{}},
+	}}
+}
+
+type SchemaInput struct {
+	SchemaBase
+	Color   string         `json:"color" description:"Paint color." jsonschema:"enum=red,green;default=red;optional"`
+	Count   int            `json:"count" jsonschema:"minimum=1;maximum=5;default=1;optional"`
+	Tags    []string       `json:"tags,omitempty" jsonschema:"maxLength=3;pattern=^[a-z]+$;minItems=1;maxItems=2"`
+	Ratio   *float64       `json:"ratio" jsonschema:"required;description=The ratio."`
+	When    time.Time      `json:"when,omitzero" jsonschema:"format=date-time"`
+	Email   string         `json:"email,omitempty" jsonschema:"format=email;minLength=3"`
+	Point   SchemaPoint    `json:"point" description:"A point."`
+	Extra   map[string]any `json:"extra,omitempty"`
+	Any     any            `json:"any,omitempty"`
+	Shape   *SchemaShape   `json:"shape,omitempty"`
+	Ignored string         `json:"-"`
+	private string
+}
+
+func TestGenerateSchema(t *testing.T) {
+
+	require := require.New(t)
+
+	s, err := tools.GenerateSchema(reflect.TypeFor[SchemaInput]())
+	require.NoError(err)
+	exp := `{
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "id": {"type": "string", "description": "The ID."},
+    "color": {"type": "string", "description": "Paint color.", "enum": ["red", "green"], "default": "red"},
+    "count": {"type": "integer", "minimum": 1, "maximum": 5, "default": 1},
+    "tags": {
+      "type": "array",
+      "minItems": 1,
+      "maxItems": 2,
+      "items": {"type": "string", "maxLength": 3, "pattern": "^[a-z]+$"}
+    },
+    "ratio": {"type": "number", "description": "The ratio."},
+    "when": {"type": "string", "format": "date-time"},
+    "email": {"type": "string", "format": "email", "minLength": 3},
+    "point": {
+      "type": "object",
+      "description": "A point.",
+      "additionalProperties": false,
+      "properties": {
+        "x": {"type": "number", "minimum": 0, "maximum": 10},
+        "y": {"type": "number"}
+      },
+      "required": ["x"]
+    },
+    "extra": {"type": "object"},
+    "any": {},
+    "shape": {"oneOf": [{"type": "string"}, {"type": "object", "properties": {}}]}
+  },
+  "required": ["id", "ratio", "point"]
+}`
+	require.JSONEq(exp, utils.MustJsonString(s))
+
+	// Empty objects still have properties.
+	s, err = tools.GenerateSchema(reflect.TypeFor[struct{}]())
+	require.NoError(err)
+	require.JSONEq(`{"type":"object","additionalProperties":false,"properties":{},"required":[]}`,
+		utils.MustJsonString(s))
+
+}
+
+type schemaRecursive struct {
+	Next *schemaRecursive `json:"next"`
+}
+
+func TestGenerateSchemaErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	for exp, typ := range map[string]reflect.Type{
+		"unsupported type: chan int": reflect.TypeFor[chan int](),
+		"map key not a string":       reflect.TypeFor[map[int]string](),
+		"recursive type":             reflect.TypeFor[schemaRecursive](),
+		`unknown key`: reflect.TypeFor[struct {
+			A string `jsonschema:"nope=1"`
+		}](),
+		`"minimum=x"`: reflect.TypeFor[struct {
+			A int `jsonschema:"minimum=x"`
+		}](),
+		`"enum=1,x"`: reflect.TypeFor[struct {
+			A int `jsonschema:"enum=1,x"`
+		}](),
+		"values not supported": reflect.TypeFor[struct {
+			A []int `jsonschema:"default=1"`
+		}](),
+	} {
+		_, err := tools.GenerateSchema(typ)
+		require.ErrorIs(err, tools.ErrSchemaGeneration, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+func TestGenerateSchemaNumbers(t *testing.T) {
+
+	require := require.New(t)
+
+	s, err := tools.GenerateSchema(reflect.TypeFor[struct {
+		U  uint8   `json:"u" jsonschema:"enum=1,2"`
+		F  float32 `json:"f" jsonschema:"default=1.5"`
+		B  bool    `json:"b" jsonschema:"default=true"`
+		Bs []byte  `json:"bs"`
+	}]())
+	require.NoError(err)
+	b, err := json.Marshal(s.Properties)
+	require.NoError(err)
+	require.JSONEq(`{
+		"u": {"type": "integer", "enum": [1, 2]},
+		"f": {"type": "number", "default": 1.5},
+		"b": {"type": "boolean", "default": true},
+		"bs": {"type": "string"}
+	}`, string(b))
+
+}
@@ -0,0 +1,513 @@
+// Package memory provides tools for agents to remember things across
+// sessions, such as user preferences.
+//
+// Memories are key-value pairs kept in a local SQLite database, so they
+// survive restarts and can be shared by several ghd processes.  They are
+// kept per agent name, and optionally also per access key, so that each API
+// user has their own.  Memories expire after a configured time, and there
+// are limits on their number and size.
+//
+// The tools are not registered on init, as they need a database.  Register
+// them with Register, or in a runner config:
+//
+//	[memory_tools]
+//	  path = "/var/lib/ghd/memory.db"
+//	  per_key = true
+//	  ttl = "2160h"
+//
+// The tools are:
+//
+//	memory_remember  remember a value by key, replacing any value there
+//	memory_recall    recall the value for a key
+//	memory_search    search memories by words in their keys and values
+//	memory_forget    forget the value for a key
+//	memory_context   memories relevant to a prompt, for context_tools
+//
+// To add relevant memories to each prompt automatically, set the agent's
+// context_tools to ["memory_context"].
+package memory
+
+import (
+	"context"
+	stdsql "database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrConfigInvalid = fmt.Errorf("invalid memory tools config")
+
+var ErrNoAgent = fmt.Errorf("no calling agent")
+
+var ErrNoKey = fmt.Errorf("no key")
+
+var ErrNotFound = fmt.Errorf("memory not found")
+
+var ErrTooLarge = fmt.Errorf("memory too large")
+
+var ErrFull = fmt.Errorf("memory full")
+
+// DefaultMaxKeyBytes is the default for Config.MaxKeyBytes.
+const DefaultMaxKeyBytes = 200
+
+// DefaultMaxValueBytes is the default for Config.MaxValueBytes.
+const DefaultMaxValueBytes = 4096
+
+// DefaultMaxEntries is the default for Config.MaxEntries.
+const DefaultMaxEntries = 1000
+
+// DefaultMaxResults is the default for Config.MaxResults.
+const DefaultMaxResults = 10
+
+// DefaultContextResults is the default for Config.ContextResults.
+const DefaultContextResults = 5
+
+// maxTtlHours caps RememberInput.TtlHours, to about a century, well within
+// the range of time.Duration.
+const maxTtlHours = 100 * 365 * 24
+
+const schema = `CREATE TABLE IF NOT EXISTS memories (
+	agent   TEXT NOT NULL,
+	access  TEXT NOT NULL,
+	key     TEXT NOT NULL,
+	value   TEXT NOT NULL,
+	created INTEGER NOT NULL,
+	updated INTEGER NOT NULL,
+	expires INTEGER,
+	PRIMARY KEY (agent, access, key)
+);
+CREATE INDEX IF NOT EXISTS memories_expires ON memories (expires)`
+
+// Config describes the memory tools and their database.
+type Config struct {
+	Path           string        `toml:"path"`            // SQLite database file, created if needed; required.
+	PerKey         bool          `toml:"per_key"`         // Keep memories per access key as well as per agent.
+	TTL            time.Duration `toml:"ttl"`             // How long memories are kept after they are last set; zero for no limit.
+	MaxKeyBytes    int           `toml:"max_key_bytes"`   // Max length of a key; default 200.
+	MaxValueBytes  int           `toml:"max_value_bytes"` // Max length of a value; default 4 KiB.
+	MaxEntries     int           `toml:"max_entries"`     // Max memories per agent (and key); default 1000.
+	MaxResults     int           `toml:"max_results"`     // Max memories returned by a search; default 10.
+	ContextResults int           `toml:"context_results"` // Max memories added to prompts by memory_context; default 5.
+}
+
+// Validate checks c, setting defaults as needed.
+func (c *Config) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("%w: no path", ErrConfigInvalid)
+	}
+	if c.TTL < 0 || c.MaxKeyBytes < 0 || c.MaxValueBytes < 0 || c.MaxEntries < 0 ||
+		c.MaxResults < 0 || c.ContextResults < 0 {
+		return fmt.Errorf("%w: negative limit", ErrConfigInvalid)
+	}
+	if c.MaxKeyBytes == 0 {
+		c.MaxKeyBytes = DefaultMaxKeyBytes
+	}
+	if c.MaxValueBytes == 0 {
+		c.MaxValueBytes = DefaultMaxValueBytes
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = DefaultMaxEntries
+	}
+	if c.MaxResults == 0 {
+		c.MaxResults = DefaultMaxResults
+	}
+	if c.ContextResults == 0 {
+		c.ContextResults = DefaultContextResults
+	}
+	return nil
+}
+
+// Store keeps memories in a SQLite database.
+type Store struct {
+	config *Config
+	db     *stdsql.DB
+}
+
+// Open opens the store for cfg, creating the database if needed.
+func Open(cfg *Config) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigInvalid, err)
+	}
+	// Other processes may use the same database, so we wait for them, and
+	// take the write lock at the start of transactions, so that the entry
+	// limit holds across processes.
+	dsn := "file:" + cfg.Path +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := stdsql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigInvalid, err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%w: %s: %w", ErrConfigInvalid, cfg.Path, err)
+	}
+	return &Store{config: cfg, db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// namespace is the agent and access key whose memories are used, from the
+// calling agent in ctx.
+type namespace struct {
+	agent  string
+	access string
+}
+
+func (s *Store) namespace(ctx context.Context) (namespace, error) {
+	callers := agent.Callers(ctx)
+	if len(callers) == 0 {
+		return namespace{}, ErrNoAgent
+	}
+	ns := namespace{agent: callers[len(callers)-1].Name}
+	if s.config.PerKey {
+		ns.access = agent.AccessName(ctx)
+	}
+	return ns, nil
+}
+
+// Memory is a remembered value.
+type Memory struct {
+	Key     string     `json:"key"`
+	Value   string     `json:"value"`
+	Updated time.Time  `json:"updated"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// RememberInput is the input to memory_remember.
+type RememberInput struct {
+	Key      string `json:"key" description:"Short, descriptive key, e.g. preferred_language."`
+	Value    string `json:"value" description:"What to remember."`
+	TtlHours int    `json:"ttl_hours,omitempty" jsonschema:"minimum=1" description:"Forget after this many hours; default is the configured time."`
+}
+
+// Remember sets the value for a key, replacing any value there.
+func (s *Store) Remember(ctx context.Context, in RememberInput) (*Memory, error) {
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(in.Key)
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	if len(key) > s.config.MaxKeyBytes {
+		return nil, fmt.Errorf("%w: key is over %d bytes", ErrTooLarge, s.config.MaxKeyBytes)
+	}
+	if len(in.Value) > s.config.MaxValueBytes {
+		return nil, fmt.Errorf("%w: value is over %d bytes", ErrTooLarge, s.config.MaxValueBytes)
+	}
+	now := time.Now().Truncate(time.Millisecond) // as stored
+	ttl := s.config.TTL
+	if in.TtlHours > 0 {
+		d := time.Duration(min(in.TtlHours, maxTtlHours)) * time.Hour
+		if ttl == 0 || d < ttl {
+			ttl = d
+		}
+	}
+	m := &Memory{Key: key, Value: in.Value, Updated: now}
+	var expires any
+	if ttl > 0 {
+		t := now.Add(ttl)
+		m.Expires = &t
+		expires = t.UnixMilli()
+	}
+
+	// The count and the insert must be in one transaction.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, purgeSql, now.UnixMilli()); err != nil {
+		return nil, err
+	}
+	var exists, count int
+	err = tx.QueryRowContext(ctx,
+		`SELECT count(*), coalesce(sum(key = ?), 0) FROM memories WHERE agent = ? AND access = ?`,
+		key, ns.agent, ns.access).Scan(&count, &exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 && count >= s.config.MaxEntries {
+		return nil, fmt.Errorf("%w: %d memories; forget some first", ErrFull, count)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO memories
+(agent, access, key, value, created, updated, expires) VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (agent, access, key) DO UPDATE
+SET value = excluded.value, updated = excluded.updated, expires = excluded.expires`,
+		ns.agent, ns.access, key, in.Value, now.UnixMilli(), now.UnixMilli(), expires)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// purgeSql deletes all memories expired at a time in unix milliseconds.
+const purgeSql = `DELETE FROM memories WHERE expires IS NOT NULL AND expires <= ?`
+
+// RecallInput is the input to memory_recall.
+type RecallInput struct {
+	Key string `json:"key" description:"Key of the memory."`
+}
+
+// Recall returns the memory for a key.
+func (s *Store) Recall(ctx context.Context, in RecallInput) (*Memory, error) {
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(in.Key)
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value, updated, expires FROM memories
+WHERE agent = ? AND access = ? AND key = ? AND (expires IS NULL OR expires > ?)`,
+		ns.agent, ns.access, key, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	memories, err := scanMemories(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(memories) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, key)
+	}
+	return memories[0], nil
+}
+
+// all returns all the live memories in the namespace, most recent first.
+func (s *Store) all(ctx context.Context, ns namespace) ([]*Memory, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value, updated, expires FROM memories
+WHERE agent = ? AND access = ? AND (expires IS NULL OR expires > ?)
+ORDER BY updated DESC, key`,
+		ns.agent, ns.access, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	return scanMemories(rows)
+}
+
+func scanMemories(rows *stdsql.Rows) ([]*Memory, error) {
+	defer rows.Close()
+	memories := []*Memory{}
+	for rows.Next() {
+		m := &Memory{}
+		var updated int64
+		var expires stdsql.NullInt64
+		if err := rows.Scan(&m.Key, &m.Value, &updated, &expires); err != nil {
+			return nil, err
+		}
+		m.Updated = time.UnixMilli(updated)
+		if expires.Valid {
+			t := time.UnixMilli(expires.Int64)
+			m.Expires = &t
+		}
+		memories = append(memories, m)
+	}
+	return memories, rows.Err()
+}
+
+// SearchInput is the input to memory_search.
+type SearchInput struct {
+	Query      string `json:"query,omitempty" description:"Words to search for in keys and values; empty for the most recent memories."`
+	MaxResults int    `json:"max_results,omitempty" jsonschema:"minimum=1" description:"Max memories to return; default is the configured max."`
+}
+
+// SearchResult is the output of memory_search.
+type SearchResult struct {
+	Memories []*Memory `json:"memories"`
+	Total    int       `json:"total"` // All memories, not just those found.
+}
+
+// Search returns the memories with the most words of the query in their
+// keys and values, most recent first among equals.
+func (s *Store) Search(ctx context.Context, in SearchInput) (*SearchResult, error) {
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n := s.config.MaxResults
+	if in.MaxResults > 0 && in.MaxResults < n {
+		n = in.MaxResults
+	}
+	all, err := s.all(ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &SearchResult{Memories: matching(all, in.Query, n, true), Total: len(all)}, nil
+}
+
+// matching returns up to n memories matching words of the query, best
+// first, or if the query has no words and recent is set, the most recent.
+func matching(memories []*Memory, query string, n int, recent bool) []*Memory {
+	want := words(query)
+	if len(want) == 0 {
+		if !recent {
+			return []*Memory{}
+		}
+		return memories[:min(n, len(memories))]
+	}
+	scores := map[*Memory]int{}
+	found := []*Memory{}
+	for _, m := range memories {
+		have := words(m.Key + " " + m.Value)
+		for _, w := range want {
+			if slices.Contains(have, w) {
+				scores[m]++
+			}
+		}
+		if scores[m] > 0 {
+			found = append(found, m)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return scores[found[i]] > scores[found[j]]
+	})
+	return found[:min(n, len(found))]
+}
+
+// words returns the distinct words of s in lower case, leaving out single
+// letters; keys like preferred_language count as two words.
+func words(s string) []string {
+	res := []string{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) > 1 && !slices.Contains(res, w) {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+// ForgetInput is the input to memory_forget.
+type ForgetInput struct {
+	Key string `json:"key" description:"Key of the memory to forget."`
+}
+
+// ForgetResult is the output of memory_forget.
+type ForgetResult struct {
+	Key       string `json:"key"`
+	Forgotten bool   `json:"forgotten"` // False if there was no such memory.
+}
+
+// Forget deletes the memory for a key.
+func (s *Store) Forget(ctx context.Context, in ForgetInput) (*ForgetResult, error) {
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(in.Key)
+	now := time.Now().UnixMilli()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM memories
+WHERE agent = ? AND access = ? AND key = ? AND (expires IS NULL OR expires > ?)`,
+		ns.agent, ns.access, key, now)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &ForgetResult{Key: key, Forgotten: n > 0}, nil
+}
+
+// Context returns the memories relevant to a prompt, as text to add before
+// it, or an empty string if there are none.  It is meant for the agent's
+// context_tools.
+func (s *Store) Context(ctx context.Context, in agent.ContextToolInput) (string, error) {
+	ns, err := s.namespace(ctx)
+	if err != nil {
+		return "", err
+	}
+	all, err := s.all(ctx, ns)
+	if err != nil {
+		return "", err
+	}
+	found := matching(all, in.Prompt, s.config.ContextResults, false)
+	if len(found) == 0 {
+		return "", nil
+	}
+	lines := []string{"Remembered from earlier sessions, and possibly relevant:"}
+	for _, m := range found {
+		lines = append(lines, fmt.Sprintf("- %s: %s", m.Key, strings.ReplaceAll(m.Value, "\n", " ")))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Tools returns the memory tools for s.
+func (s *Store) Tools() []tools.Tooler {
+	scope := "this agent"
+	if s.config.PerKey {
+		scope = "this agent and user"
+	}
+	read_meta := &tools.Metadata{Tags: []string{"memory"}, Risk: tools.RiskReadOnly}
+	write_meta := &tools.Metadata{Tags: []string{"memory"}, Risk: tools.RiskWrite}
+	return []tools.Tooler{
+		tools.NewTool[RememberInput, *Memory](
+			"memory_remember",
+			"Remembers a value by key, for later sessions, replacing any value "+
+				"for the key.  Use it for lasting facts such as preferences, not "+
+				"for the current conversation.  Memories are kept for "+scope+".",
+			s.Remember,
+		).WithMetadata(write_meta),
+		tools.NewTool[RecallInput, *Memory](
+			"memory_recall",
+			"Recalls the value remembered for a key.",
+			s.Recall,
+		).WithMetadata(read_meta),
+		tools.NewTool[SearchInput, *SearchResult](
+			"memory_search",
+			"Searches remembered values by words in their keys and values, "+
+				"or lists the most recent if there is no query.",
+			s.Search,
+		).WithMetadata(read_meta),
+		tools.NewTool[ForgetInput, *ForgetResult](
+			"memory_forget",
+			"Forgets the value remembered for a key, e.g. when it is wrong or "+
+				"the user asks.",
+			s.Forget,
+		).WithMetadata(write_meta),
+		tools.NewTool[agent.ContextToolInput, string](
+			"memory_context",
+			"Returns remembered values relevant to a prompt.  Meant for agent "+
+				"context_tools, not for calling directly.",
+			s.Context,
+		).WithMetadata(read_meta),
+	}
+}
+
+// Register registers the memory tools for cfg in reg, returning the Store,
+// which must be closed when the tools are no longer needed.
+func Register(reg *registry.Registry, cfg *Config) (*Store, error) {
+	s, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range s.Tools() {
+		if err := reg.Register(tool); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,417 @@
+package memory_test
+
+import (
+	"context"
+	stdsql "database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/memory"
+)
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-memory", func() (agent.ApiClient, error) {
+		return &agent.BasicApiClient{}, nil
+	})
+}
+
+// callerCtx returns a context with a new agent of the given name as caller.
+func callerCtx(t *testing.T, name string) context.Context {
+	t.Helper()
+	a, err := agent.NewAgentWithRegistry(&agent.Config{
+		Name:   name,
+		Type:   "fake-memory",
+		Silent: true,
+	}, registry.New())
+	require.NoError(t, err)
+	return agent.WithCaller(context.Background(), a)
+}
+
+func testStore(t *testing.T, cfg *memory.Config) *memory.Store {
+	t.Helper()
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "sub", "memory.db")
+	}
+	s, err := memory.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &memory.Config{}
+	require.ErrorIs(cfg.Validate(), memory.ErrConfigInvalid)
+	cfg = &memory.Config{Path: "x.db", MaxEntries: -1}
+	require.ErrorContains(cfg.Validate(), "negative limit")
+	cfg = &memory.Config{Path: "x.db"}
+	require.NoError(cfg.Validate())
+	require.Equal(memory.DefaultMaxKeyBytes, cfg.MaxKeyBytes)
+	require.Equal(memory.DefaultMaxValueBytes, cfg.MaxValueBytes)
+	require.Equal(memory.DefaultMaxEntries, cfg.MaxEntries)
+	require.Equal(memory.DefaultMaxResults, cfg.MaxResults)
+	require.Equal(memory.DefaultContextResults, cfg.ContextResults)
+
+}
+
+func TestRememberRecallForget(t *testing.T) {
+
+	require := require.New(t)
+
+	s := testStore(t, &memory.Config{TTL: time.Hour})
+	ctx := callerCtx(t, "support")
+
+	m, err := s.Remember(ctx, memory.RememberInput{Key: " language ", Value: "Go"})
+	require.NoError(err)
+	require.Equal("language", m.Key)
+	require.NotNil(m.Expires)
+	require.Equal(time.Hour, m.Expires.Sub(m.Updated))
+
+	m, err = s.Remember(ctx, memory.RememberInput{Key: "language", Value: "Rust", TtlHours: 2})
+	require.NoError(err)
+	require.Equal(time.Hour, m.Expires.Sub(m.Updated), "configured TTL is the max")
+
+	got, err := s.Recall(ctx, memory.RecallInput{Key: "language"})
+	require.NoError(err)
+	require.Equal("Rust", got.Value)
+	require.True(got.Updated.Equal(m.Updated))
+	require.True(got.Expires.Equal(*m.Expires))
+
+	_, err = s.Recall(ctx, memory.RecallInput{Key: "editor"})
+	require.ErrorIs(err, memory.ErrNotFound)
+	require.ErrorContains(err, `"editor"`)
+
+	res, err := s.Forget(ctx, memory.ForgetInput{Key: "language"})
+	require.NoError(err)
+	require.Equal(&memory.ForgetResult{Key: "language", Forgotten: true}, res)
+	res, err = s.Forget(ctx, memory.ForgetInput{Key: "language"})
+	require.NoError(err)
+	require.False(res.Forgotten)
+	_, err = s.Recall(ctx, memory.RecallInput{Key: "language"})
+	require.ErrorIs(err, memory.ErrNotFound)
+
+}
+
+func TestRememberErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	s := testStore(t, &memory.Config{MaxKeyBytes: 5, MaxValueBytes: 10, MaxEntries: 2})
+	ctx := callerCtx(t, "support")
+
+	_, err := s.Remember(context.Background(), memory.RememberInput{Key: "k", Value: "v"})
+	require.ErrorIs(err, memory.ErrNoAgent)
+	_, err = s.Recall(context.Background(), memory.RecallInput{Key: "k"})
+	require.ErrorIs(err, memory.ErrNoAgent)
+	_, err = s.Search(context.Background(), memory.SearchInput{})
+	require.ErrorIs(err, memory.ErrNoAgent)
+	_, err = s.Forget(context.Background(), memory.ForgetInput{Key: "k"})
+	require.ErrorIs(err, memory.ErrNoAgent)
+
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "  ", Value: "v"})
+	require.ErrorIs(err, memory.ErrNoKey)
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "toolong", Value: "v"})
+	require.ErrorIs(err, memory.ErrTooLarge)
+	require.ErrorContains(err, "key is over 5 bytes")
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "k", Value: "much too long"})
+	require.ErrorIs(err, memory.ErrTooLarge)
+	require.ErrorContains(err, "value is over 10 bytes")
+
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "a", Value: "1"})
+	require.NoError(err)
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "b", Value: "2"})
+	require.NoError(err)
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "c", Value: "3"})
+	require.ErrorIs(err, memory.ErrFull)
+	require.ErrorContains(err, "forget some first")
+
+	// Existing keys can still be set, and other agents have their own.
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "b", Value: "22"})
+	require.NoError(err)
+	_, err = s.Remember(callerCtx(t, "other"), memory.RememberInput{Key: "c", Value: "3"})
+	require.NoError(err)
+
+}
+
+func TestExpiry(t *testing.T) {
+
+	require := require.New(t)
+
+	s := testStore(t, &memory.Config{TTL: 100 * time.Millisecond, MaxEntries: 1})
+	ctx := callerCtx(t, "support")
+
+	_, err := s.Remember(ctx, memory.RememberInput{Key: "a", Value: "1"})
+	require.NoError(err)
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "b", Value: "2"})
+	require.ErrorIs(err, memory.ErrFull)
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = s.Recall(ctx, memory.RecallInput{Key: "a"})
+	require.ErrorIs(err, memory.ErrNotFound)
+	res, err := s.Forget(ctx, memory.ForgetInput{Key: "a"})
+	require.NoError(err)
+	require.False(res.Forgotten)
+
+	// Expired memories no longer count toward the limit.
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "b", Value: "2"})
+	require.NoError(err)
+
+}
+
+func TestRememberHugeTtlHours(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := callerCtx(t, "support")
+	in := memory.RememberInput{Key: "k", Value: "v", TtlHours: 1 << 40}
+
+	m, err := testStore(t, &memory.Config{TTL: time.Hour}).Remember(ctx, in)
+	require.NoError(err)
+	require.NotNil(m.Expires)
+	require.Equal(time.Hour, m.Expires.Sub(m.Updated))
+
+	m, err = testStore(t, &memory.Config{}).Remember(ctx, in)
+	require.NoError(err)
+	require.NotNil(m.Expires)
+	require.True(m.Expires.After(m.Updated.Add(50*365*24*time.Hour)), "capped, not negative")
+
+}
+
+func TestMaxEntriesSharedDatabase(t *testing.T) {
+
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "memory.db")
+	s := testStore(t, &memory.Config{Path: path, MaxEntries: 1})
+	ctx := callerCtx(t, "support")
+
+	// Another process adds the last allowed memory while we add ours.
+	other, err := stdsql.Open("sqlite", path)
+	require.NoError(err)
+	defer other.Close()
+	conn, err := other.Conn(context.Background())
+	require.NoError(err)
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	require.NoError(err)
+	_, err = conn.ExecContext(ctx, `INSERT INTO memories
+(agent, access, key, value, created, updated) VALUES ('support', '', 'a', '1', 0, 0)`)
+	require.NoError(err)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.Remember(ctx, memory.RememberInput{Key: "b", Value: "2"})
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	require.NoError(err)
+	require.ErrorIs(<-done, memory.ErrFull)
+
+}
+
+func TestNamespaces(t *testing.T) {
+
+	require := require.New(t)
+
+	shared := testStore(t, &memory.Config{})
+	per_key := testStore(t, &memory.Config{PerKey: true})
+	ctx := callerCtx(t, "support")
+	alice := agent.WithAccessName(ctx, "alice")
+	bob := agent.WithAccessName(ctx, "bob")
+
+	for _, s := range []*memory.Store{shared, per_key} {
+		_, err := s.Remember(alice, memory.RememberInput{Key: "name", Value: "Alice"})
+		require.NoError(err)
+		_, err = s.Remember(bob, memory.RememberInput{Key: "name", Value: "Bob"})
+		require.NoError(err)
+	}
+
+	m, err := shared.Recall(alice, memory.RecallInput{Key: "name"})
+	require.NoError(err)
+	require.Equal("Bob", m.Value)
+	m, err = per_key.Recall(alice, memory.RecallInput{Key: "name"})
+	require.NoError(err)
+	require.Equal("Alice", m.Value)
+	_, err = per_key.Recall(ctx, memory.RecallInput{Key: "name"})
+	require.ErrorIs(err, memory.ErrNotFound)
+
+	// The innermost caller is the one whose memories are used.
+	other := callerCtx(t, "other")
+	a, err := agent.NewAgentWithRegistry(&agent.Config{Name: "support", Type: "fake-memory", Silent: true}, registry.New())
+	require.NoError(err)
+	_, err = shared.Recall(other, memory.RecallInput{Key: "name"})
+	require.ErrorIs(err, memory.ErrNotFound)
+	m, err = shared.Recall(agent.WithCaller(other, a), memory.RecallInput{Key: "name"})
+	require.NoError(err)
+	require.Equal("Bob", m.Value)
+
+}
+
+func TestPersistence(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &memory.Config{Path: filepath.Join(t.TempDir(), "memory.db")}
+	ctx := callerCtx(t, "support")
+
+	s, err := memory.Open(cfg)
+	require.NoError(err)
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "color", Value: "green"})
+	require.NoError(err)
+	require.NoError(s.Close())
+
+	s, err = memory.Open(cfg)
+	require.NoError(err)
+	defer s.Close()
+	m, err := s.Recall(ctx, memory.RecallInput{Key: "color"})
+	require.NoError(err)
+	require.Equal("green", m.Value)
+	require.Nil(m.Expires)
+
+}
+
+func TestOpenFails(t *testing.T) {
+
+	require := require.New(t)
+
+	dir := t.TempDir()
+	_, err := memory.Open(&memory.Config{Path: dir})
+	require.ErrorIs(err, memory.ErrConfigInvalid)
+	require.ErrorContains(err, dir)
+
+	_, err = memory.Open(&memory.Config{})
+	require.ErrorIs(err, memory.ErrConfigInvalid)
+
+}
+
+func keys(memories []*memory.Memory) []string {
+	res := []string{}
+	for _, m := range memories {
+		res = append(res, m.Key)
+	}
+	return res
+}
+
+func TestSearch(t *testing.T) {
+
+	require := require.New(t)
+
+	s := testStore(t, &memory.Config{MaxResults: 3})
+	ctx := callerCtx(t, "support")
+	for _, in := range []memory.RememberInput{
+		{Key: "preferred_language", Value: "Go, then Python"},
+		{Key: "editor", Value: "Vim with Go plugins"},
+		{Key: "pet", Value: "A cat named Python"},
+		{Key: "city", Value: "Lisbon"},
+	} {
+		_, err := s.Remember(ctx, in)
+		require.NoError(err)
+		time.Sleep(2 * time.Millisecond) // distinct update times
+	}
+
+	res, err := s.Search(ctx, memory.SearchInput{Query: "Which language? Go or Python"})
+	require.NoError(err)
+	require.Equal(4, res.Total)
+	require.Equal([]string{"preferred_language", "pet", "editor"}, keys(res.Memories))
+
+	res, err = s.Search(ctx, memory.SearchInput{Query: "python", MaxResults: 1})
+	require.NoError(err)
+	require.Equal([]string{"pet"}, keys(res.Memories))
+
+	res, err = s.Search(ctx, memory.SearchInput{Query: "a"})
+	require.NoError(err)
+	require.Equal([]string{"city", "pet", "editor"}, keys(res.Memories))
+
+	res, err = s.Search(ctx, memory.SearchInput{Query: "weather"})
+	require.NoError(err)
+	require.Empty(res.Memories)
+	require.Equal(4, res.Total)
+
+}
+
+func TestContext(t *testing.T) {
+
+	require := require.New(t)
+
+	s := testStore(t, &memory.Config{ContextResults: 1})
+	ctx := callerCtx(t, "support")
+
+	out, err := s.Context(ctx, agent.ContextToolInput{Prompt: "What should I eat?"})
+	require.NoError(err)
+	require.Equal("", out)
+
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "diet", Value: "Vegetarian,\nno nuts"})
+	require.NoError(err)
+	_, err = s.Remember(ctx, memory.RememberInput{Key: "city", Value: "Lisbon"})
+	require.NoError(err)
+
+	out, err = s.Context(ctx, agent.ContextToolInput{Prompt: ""})
+	require.NoError(err)
+	require.Equal("", out)
+
+	out, err = s.Context(ctx, agent.ContextToolInput{Prompt: "Dinner in Lisbon, no nuts please."})
+	require.NoError(err)
+	require.Equal("Remembered from earlier sessions, and possibly relevant:\n- diet: Vegetarian, no nuts", out)
+
+	_, err = s.Context(context.Background(), agent.ContextToolInput{Prompt: "x"})
+	require.ErrorIs(err, memory.ErrNoAgent)
+
+}
+
+func TestRegister(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &memory.Config{Path: filepath.Join(t.TempDir(), "memory.db"), PerKey: true}
+	reg := registry.New()
+	s, err := memory.Register(reg, cfg)
+	require.NoError(err)
+	defer s.Close()
+	require.Equal([]string{
+		"memory_remember",
+		"memory_recall",
+		"memory_search",
+		"memory_forget",
+		"memory_context",
+	}, reg.Names())
+	for _, name := range reg.Names() {
+		tool, err := reg.Get(name)
+		require.NoError(err)
+		meta := tools.MetadataOf(tool)
+		require.True(meta.HasTag("memory"), name)
+		if name == "memory_remember" || name == "memory_forget" {
+			require.Equal(tools.RiskWrite, meta.Risk, name)
+		} else {
+			require.Equal(tools.RiskReadOnly, meta.Risk, name)
+		}
+	}
+	tool, err := reg.Get("memory_remember")
+	require.NoError(err)
+	require.True(strings.HasSuffix(tool.Description(), "this agent and user."))
+
+	ctx := agent.WithAccessName(callerCtx(t, "support"), "alice")
+	_, err = tool.Exec(ctx, `{"key":"tea","value":"green"}`)
+	require.NoError(err)
+	tool, err = reg.Get("memory_context")
+	require.NoError(err)
+	out, err := tool.Exec(ctx, `{"prompt":"Some tea?"}`)
+	require.NoError(err)
+	require.Equal("Remembered from earlier sessions, and possibly relevant:\n- tea: green", out)
+
+	_, err = memory.Register(registry.New(), &memory.Config{})
+	require.ErrorIs(err, memory.ErrConfigInvalid)
+	reg.Lock()
+	_, err = memory.Register(reg, cfg)
+	require.ErrorContains(err, `failed to register "memory_remember"`)
+
+}
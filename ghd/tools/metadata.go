@@ -0,0 +1,114 @@
+// tools/metadata.go
+
+package tools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Risk is the risk level of a tool, for selecting tools by capability.
+type Risk string
+
+const (
+	RiskReadOnly    Risk = "read-only"   // Reads data but changes nothing.
+	RiskWrite       Risk = "write"       // Changes local data, e.g. files.
+	RiskNetwork     Risk = "network"     // Talks to other systems.
+	RiskDestructive Risk = "destructive" // Can destroy data or do other harm.
+	RiskUnknown     Risk = "unknown"     // Not declared; the same as empty.
+)
+
+// RiskLevels are the known Risk levels, from least to most risky.  Unknown
+// risk is the most risky of all, so tools that do not declare their risk are
+// never selected as less risky than anything.
+var RiskLevels = []Risk{RiskReadOnly, RiskWrite, RiskNetwork, RiskDestructive, RiskUnknown}
+
+// Level returns the position of r in RiskLevels, starting at 1.  The empty
+// Risk has the level of RiskUnknown, and invalid values zero.
+func (r Risk) Level() int {
+	if r == "" {
+		r = RiskUnknown
+	}
+	return slices.Index(RiskLevels, r) + 1
+}
+
+// String returns r, or RiskUnknown if r is empty.
+func (r Risk) String() string {
+	if r == "" {
+		return string(RiskUnknown)
+	}
+	return string(r)
+}
+
+// Metadata describes a tool for humans and policies, and is never sent to
+// the LLM.  All fields are optional.
+type Metadata struct {
+	Tags    []string `toml:"tags" json:"tags,omitempty"`       // Tags or categories, e.g. "fs".
+	Risk    Risk     `toml:"risk" json:"risk,omitempty"`       // One of RiskLevels.
+	Version string   `toml:"version" json:"version,omitempty"` // Version of the tool.
+	Owner   string   `toml:"owner" json:"owner,omitempty"`     // Owner, e.g. a team or an email address.
+}
+
+var ErrMetadataInvalid = fmt.Errorf("invalid tool metadata")
+
+// Validate checks that m has a known Risk level, if any, and that its Tags
+// are not empty and have no spaces.
+func (m *Metadata) Validate() error {
+	if m.Risk.Level() == 0 {
+		return fmt.Errorf("%w: unknown risk %q", ErrMetadataInvalid, m.Risk)
+	}
+	for _, tag := range m.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\r\n") {
+			return fmt.Errorf("%w: bad tag %q", ErrMetadataInvalid, tag)
+		}
+	}
+	return nil
+}
+
+// HasTag returns true if m has tag.
+func (m *Metadata) HasTag(tag string) bool {
+	return slices.Contains(m.Tags, tag)
+}
+
+// IsZero returns true if m declares nothing.
+func (m *Metadata) IsZero() bool {
+	return len(m.Tags) == 0 && m.Risk == "" && m.Version == "" && m.Owner == ""
+}
+
+// Summary returns a one-line summary of m for display, or an empty string
+// if m declares nothing.
+func (m *Metadata) Summary() string {
+	if m.IsZero() {
+		return ""
+	}
+	parts := []string{"risk: " + m.Risk.String()}
+	if len(m.Tags) > 0 {
+		parts = append(parts, "tags: "+strings.Join(m.Tags, ", "))
+	}
+	if m.Version != "" {
+		parts = append(parts, "version: "+m.Version)
+	}
+	if m.Owner != "" {
+		parts = append(parts, "owner: "+m.Owner)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// MetadataTooler may be implemented by Toolers that declare Metadata.
+type MetadataTooler interface {
+	Tooler
+
+	// Metadata returns the metadata of the tool, or nil if none.
+	Metadata() *Metadata
+}
+
+// MetadataOf returns the Metadata of t, which is empty if t declares none.
+func MetadataOf(t Tooler) *Metadata {
+	if mt, ok := t.(MetadataTooler); ok {
+		if m := mt.Metadata(); m != nil {
+			return m
+		}
+	}
+	return &Metadata{}
+}
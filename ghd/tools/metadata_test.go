@@ -0,0 +1,76 @@
+package tools_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+func TestRiskLevel(t *testing.T) {
+
+	require := require.New(t)
+
+	require.Equal(1, tools.RiskReadOnly.Level())
+	require.Equal(4, tools.RiskDestructive.Level())
+	require.Equal(5, tools.RiskUnknown.Level())
+	require.Equal(5, tools.Risk("").Level(), "empty is unknown")
+	require.Equal(0, tools.Risk("scary").Level(), "invalid")
+	require.Equal("unknown", tools.Risk("").String())
+	require.Equal("write", tools.RiskWrite.String())
+
+}
+
+func TestMetadataValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	require.NoError((&tools.Metadata{}).Validate())
+	require.NoError((&tools.Metadata{Tags: []string{"fs"}, Risk: tools.RiskWrite}).Validate())
+	for exp, m := range map[string]*tools.Metadata{
+		`unknown risk "scary"`: {Risk: "scary"},
+		`bad tag ""`:           {Tags: []string{""}},
+		`bad tag "a b"`:        {Tags: []string{"ok", "a b"}},
+	} {
+		err := m.Validate()
+		require.ErrorIs(err, tools.ErrMetadataInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+func TestMetadataSummary(t *testing.T) {
+
+	require := require.New(t)
+
+	require.Equal("", (&tools.Metadata{}).Summary())
+	require.Equal("risk: unknown; owner: me", (&tools.Metadata{Owner: "me"}).Summary())
+	m := &tools.Metadata{
+		Tags:    []string{"fs", "search"},
+		Risk:    tools.RiskReadOnly,
+		Version: "1.0",
+		Owner:   "me",
+	}
+	require.Equal("risk: read-only; tags: fs, search; version: 1.0; owner: me", m.Summary())
+	require.True(m.HasTag("fs"))
+	require.False(m.HasTag("net"))
+
+}
+
+func TestMetadataOf(t *testing.T) {
+
+	require := require.New(t)
+
+	require.Equal(&tools.Metadata{}, tools.MetadataOf(plainTool{}))
+	tool := paintTool()
+	require.Equal(&tools.Metadata{}, tools.MetadataOf(tool), "none set")
+
+	m := &tools.Metadata{Tags: []string{"paint"}, Risk: tools.RiskWrite}
+	require.Same(tool, tool.WithMetadata(m))
+	require.Same(m, tools.MetadataOf(tool))
+
+	require.PanicsWithValue(`Metadata for paint: invalid tool metadata: unknown risk "scary"`,
+		func() { paintTool().WithMetadata(&tools.Metadata{Risk: "scary"}) })
+
+}
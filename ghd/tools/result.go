@@ -0,0 +1,82 @@
+// tools/result.go
+
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Result is the standard envelope for tool results sent to LLMs, so that
+// every tool's output has the same shape regardless of the tool or the API:
+//
+//	{"ok": true, "data": {"any": "output"}}
+//	{"ok": false, "error": "what went wrong"}
+//	{"ok": true, "data": "{\"partial\": ...", "truncated": true}
+//
+// Data has the OutputSchema of the tool, if it declares one, unless it has
+// been truncated, in which case it is the truncated JSON as a string.
+type Result struct {
+	Ok        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	Data      any    `json:"data,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// NewResult returns the Result for output and err as returned by a tool's
+// Exec.  If output is already a *Result it is returned as-is, so tools can
+// report truncation themselves.
+func NewResult(output any, err error) *Result {
+	if err != nil {
+		return &Result{Error: err.Error()}
+	}
+	if r, ok := output.(*Result); ok {
+		return r
+	}
+	return &Result{Ok: true, Data: output}
+}
+
+// Limit returns r with its Data truncated, if its JSON is larger than max
+// bytes, by TruncateHeadTail.  Errors are truncated the same way.  If max is
+// zero or r is small enough, r is returned unchanged.
+func (r *Result) Limit(max int) *Result {
+	if max <= 0 {
+		return r
+	}
+	limited := *r
+	if len(r.Error) > max {
+		limited.Error = TruncateHeadTail(r.Error, max)
+		limited.Truncated = true
+	}
+	if r.Data != nil {
+		b, err := json.Marshal(r.Data)
+		if err != nil {
+			return r // the client will fail on this anyway.
+		}
+		if len(b) > max {
+			limited.Data = TruncateHeadTail(string(b), max)
+			limited.Truncated = true
+		}
+	}
+	if !limited.Truncated {
+		return r
+	}
+	return &limited
+}
+
+// FullDescription returns the Description of t for an LLM.  If t declares an
+// OutputSchema, it is appended with a summary of the Result envelope, as
+// most APIs have no other way to declare the output of a tool.
+func FullDescription(t Tooler) string {
+	out := OutputSchema(t)
+	if out == nil {
+		return t.Description()
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return t.Description()
+	}
+	return fmt.Sprintf("%s\n\nResults are JSON objects with ok (boolean), "+
+		"error (string), data and truncated (boolean).  "+
+		"The data has this JSON Schema: %s", t.Description(), b)
+}
@@ -0,0 +1,72 @@
+package tools_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+func TestNewResult(t *testing.T) {
+
+	require := require.New(t)
+
+	require.Equal(&tools.Result{Ok: true, Data: "hi"}, tools.NewResult("hi", nil))
+	require.Equal(&tools.Result{Error: "oops"}, tools.NewResult("hi", errors.New("oops")))
+	r := &tools.Result{Ok: true, Data: "part", Truncated: true}
+	require.Same(r, tools.NewResult(r, nil), "own result kept")
+
+	require.JSONEq(`{"ok":true}`, utils.MustJsonString(tools.NewResult(nil, nil)))
+	require.JSONEq(`{"ok":false,"error":"oops"}`,
+		utils.MustJsonString(tools.NewResult(nil, errors.New("oops"))))
+
+}
+
+func TestResultLimit(t *testing.T) {
+
+	require := require.New(t)
+
+	r := tools.NewResult(map[string]string{"text": strings.Repeat("x", 100)}, nil)
+	require.Same(r, r.Limit(0), "no limit")
+	require.Same(r, r.Limit(1000), "under limit")
+
+	limited := r.Limit(40)
+	require.True(limited.Ok)
+	require.True(limited.Truncated)
+	require.False(r.Truncated, "original unchanged")
+	data := limited.Data.(string)
+	require.True(strings.HasPrefix(data, `{"text":"xxx`))
+	require.Contains(data, "bytes truncated")
+	require.True(strings.HasSuffix(data, `xxx"}`))
+
+	limited = tools.NewResult(nil, errors.New(strings.Repeat("e", 100))).Limit(40)
+	require.False(limited.Ok)
+	require.True(limited.Truncated)
+	require.Contains(limited.Error, "bytes truncated")
+
+}
+
+func TestFullDescription(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := paintTool()
+	desc := tools.FullDescription(tool)
+	require.True(strings.HasPrefix(desc, "Paint walls.\n\nResults are JSON objects"))
+	require.Contains(desc, `The data has this JSON Schema: {"type":"object"`)
+
+	no_output := tools.NewTool("bad_output", "Bad output.",
+		func(ctx context.Context, in struct{}) (chan int, error) {
+			return nil, nil
+		})
+	require.Nil(no_output.OutputSchema())
+	require.Nil(tools.OutputSchema(no_output))
+	require.Equal("Bad output.", tools.FullDescription(no_output))
+	require.NotContains(no_output.Help(), "Output Schema")
+
+}
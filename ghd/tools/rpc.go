@@ -0,0 +1,583 @@
+// tools/rpc.go
+
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/biztos/greenhead/ghd/schema"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// Defaults for RpcServerConfig durations.
+var (
+	DefaultRpcStartTimeout    = 10 * time.Second
+	DefaultRpcShutdownTimeout = 5 * time.Second
+	DefaultRpcRestartDelay    = time.Second
+	DefaultRpcMaxRestartDelay = time.Minute
+)
+
+// RpcServerConfig represents the configuration of an RpcServer.
+//
+// This is used within a Runner config.
+type RpcServerConfig struct {
+	Name            string            `toml:"name"`              // Name for logs and errors, required.
+	Command         string            `toml:"command"`           // Path to the executable command.
+	Args            []string          `toml:"args"`              // Args for the command.
+	Dir             string            `toml:"dir"`               // Working directory for the command.
+	Env             map[string]string `toml:"env"`               // Variables to set, expanding "$VAR" and "${VAR}" from ours.
+	StartTimeout    time.Duration     `toml:"start_timeout"`     // Time allowed to list tools at start.
+	CallTimeout     time.Duration     `toml:"call_timeout"`      // Time allowed per call; zero for no limit.
+	ShutdownTimeout time.Duration     `toml:"shutdown_timeout"`  // Time allowed to exit after input closes.
+	RestartDelay    time.Duration     `toml:"restart_delay"`     // Delay before restart, doubled each time.
+	MaxRestartDelay time.Duration     `toml:"max_restart_delay"` // Longest delay before restart.
+	MaxRestarts     int               `toml:"max_restarts"`      // Consecutive restarts before giving up; zero for no limit.
+	Metadata        *Metadata         `toml:"metadata"`          // Optional metadata for all the server's tools.
+}
+
+var ErrRpcServerConfigInvalid = fmt.Errorf("invalid rpc server config")
+
+// Validate checks that c has correct values, setting default durations:
+//
+// - Name must not be empty.
+// - Command must point to an executable file.
+// - Durations and MaxRestarts must not be negative.
+// - Metadata, if set, must be valid.
+func (c *RpcServerConfig) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("%w: empty name", ErrRpcServerConfigInvalid)
+	}
+	if strings.TrimSpace(c.Command) == "" {
+		return fmt.Errorf("%w: empty command for %q",
+			ErrRpcServerConfigInvalid, c.Name)
+	}
+	can_exec, err := utils.IsExecutable(c.Command)
+	if err != nil {
+		return fmt.Errorf("%w: command error for %q: %w",
+			ErrRpcServerConfigInvalid, c.Name, err)
+	}
+	if !can_exec {
+		return fmt.Errorf("%w: command not executable for %q",
+			ErrRpcServerConfigInvalid, c.Name)
+	}
+	for _, d := range []struct {
+		name string
+		val  *time.Duration
+		def  time.Duration
+	}{
+		{"start_timeout", &c.StartTimeout, DefaultRpcStartTimeout},
+		{"call_timeout", &c.CallTimeout, 0},
+		{"shutdown_timeout", &c.ShutdownTimeout, DefaultRpcShutdownTimeout},
+		{"restart_delay", &c.RestartDelay, DefaultRpcRestartDelay},
+		{"max_restart_delay", &c.MaxRestartDelay, DefaultRpcMaxRestartDelay},
+	} {
+		if *d.val < 0 {
+			return fmt.Errorf("%w: negative %s for %q",
+				ErrRpcServerConfigInvalid, d.name, c.Name)
+		}
+		if *d.val == 0 {
+			*d.val = d.def
+		}
+	}
+	if c.MaxRestarts < 0 {
+		return fmt.Errorf("%w: negative max_restarts for %q",
+			ErrRpcServerConfigInvalid, c.Name)
+	}
+	if c.Metadata != nil {
+		if err := c.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%w: metadata for %q: %w",
+				ErrRpcServerConfigInvalid, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// RpcServer manages a persistent command that provides tools using
+// line-delimited JSON-RPC 2.0 on its STDIN and STDOUT, which is useful for
+// tools with expensive startup, e.g. loading an index.
+//
+// At start, the tools are listed with the method "tools/list", whose result
+// must be an object with an array of tools:
+//
+//	{"tools":[{"name":"search","description":"Search.","input_schema":{...}}]}
+//
+// Tools are called with the method "tools/call" and parameters:
+//
+//	{"name":"search","arguments":{...}}
+//
+// The result of the call is the result of the tool, and an error response is
+// a tool error.  Calls may be concurrent, so responses may be out of order.
+// Anything the command writes to STDERR is logged.
+//
+// If the command exits it is restarted, with increasing delays between
+// consecutive restarts, while calls to its tools fail.  Its tools are not
+// listed again.  On Close, its STDIN is closed, and it should exit.
+type RpcServer struct {
+	cfg    *RpcServerConfig
+	logger *slog.Logger
+	tools  []*RpcTool
+
+	mutex    sync.Mutex
+	proc     *rpcProcess // nil while down.
+	lastId   int64
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopped  sync.Once
+	closeErr error
+}
+
+// NewRpcServer creates an RpcServer from cfg, logging to logger, which may
+// be nil for the default.  The server must be started with Start.
+func NewRpcServer(cfg *RpcServerConfig, logger *slog.Logger) (*RpcServer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &RpcServer{
+		cfg:    cfg,
+		logger: logger.With("rpc_server", cfg.Name),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+var ErrRpcServerStart = fmt.Errorf("rpc server failed to start")
+var ErrRpcServerDown = fmt.Errorf("rpc server not running")
+var ErrRpcCallFailed = fmt.Errorf("rpc call failed")
+
+// RpcError is a JSON-RPC error response.
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Error implements error.
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// Start starts the command and lists its tools, which are then available
+// from Tools.  The command is then supervised until Close.
+func (s *RpcServer) Start() error {
+	p, err := s.spawn()
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrRpcServerStart, s.cfg.Name, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.StartTimeout)
+	defer cancel()
+	tools, err := s.listTools(ctx, p)
+	if err != nil {
+		p.kill()
+		return fmt.Errorf("%w: %q: %w", ErrRpcServerStart, s.cfg.Name, err)
+	}
+	s.tools = tools
+	s.mutex.Lock()
+	s.proc = p
+	s.mutex.Unlock()
+	s.started = true
+	go s.supervise(p)
+	return nil
+}
+
+// Name returns the configured name of the server.
+func (s *RpcServer) Name() string {
+	return s.cfg.Name
+}
+
+// Tools returns the tools listed by the server at Start.
+func (s *RpcServer) Tools() []*RpcTool {
+	return s.tools
+}
+
+// Close stops the server, closing the command's STDIN and waiting for it to
+// exit, killing it after ShutdownTimeout.  It is safe to call Close more than
+// once, or on a server that was never started.
+func (s *RpcServer) Close() error {
+	s.stopped.Do(func() {
+		close(s.stop)
+		if s.started {
+			<-s.done
+		}
+	})
+	return s.closeErr
+}
+
+// shutdown closes the STDIN of p and waits for it to exit, killing it after
+// ShutdownTimeout.
+func (s *RpcServer) shutdown(p *rpcProcess) error {
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+		return nil
+	case <-time.After(s.cfg.ShutdownTimeout):
+		s.logger.Warn("rpc server killed after shutdown timeout")
+		p.kill()
+		return fmt.Errorf("rpc server %q killed after shutdown timeout", s.cfg.Name)
+	}
+}
+
+// supervise restarts the command whenever p exits, until Close.
+func (s *RpcServer) supervise(p *rpcProcess) {
+
+	defer close(s.done)
+	delay := s.cfg.RestartDelay
+	restarts := 0
+	for {
+		select {
+		case <-p.exited:
+		case <-s.stop:
+			s.closeErr = s.shutdown(p)
+			s.mutex.Lock()
+			s.proc = nil
+			s.mutex.Unlock()
+			return
+		}
+		s.mutex.Lock()
+		s.proc = nil
+		s.mutex.Unlock()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		s.logger.Warn("rpc server exited", "error", p.err)
+
+		// Stable for long enough to be a new problem, so start over.
+		if time.Since(p.started) > s.cfg.MaxRestartDelay {
+			delay = s.cfg.RestartDelay
+			restarts = 0
+		}
+
+		for {
+			restarts++
+			if s.cfg.MaxRestarts > 0 && restarts > s.cfg.MaxRestarts {
+				s.logger.Error("rpc server restarts exceeded, giving up",
+					"max_restarts", s.cfg.MaxRestarts)
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-s.stop:
+				return
+			}
+			delay = min(delay*2, s.cfg.MaxRestartDelay)
+			next, err := s.spawn()
+			if err == nil {
+				// Make sure it is responsive before taking calls.
+				ctx, cancel := context.WithTimeout(context.Background(), s.cfg.StartTimeout)
+				_, err = s.listTools(ctx, next)
+				cancel()
+				if err != nil {
+					next.kill()
+				}
+			}
+			if err != nil {
+				s.logger.Warn("rpc server restart failed", "error", err)
+				continue
+			}
+			s.mutex.Lock()
+			s.proc = next
+			s.mutex.Unlock()
+			s.logger.Info("rpc server restarted", "restarts", restarts)
+			p = next
+			break
+		}
+	}
+}
+
+// spawn starts the command.
+func (s *RpcServer) spawn() (*rpcProcess, error) {
+
+	env := os.Environ()
+	for _, k := range slices.Sorted(maps.Keys(s.cfg.Env)) {
+		v, missing := ExpandEnv(s.cfg.Env[k])
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%s references unset %s", k, strings.Join(missing, ", "))
+		}
+		env = append(env, k+"="+v)
+	}
+	cmd := exec.Command(s.cfg.Command, s.cfg.Args...)
+	cmd.Dir = s.cfg.Dir
+	cmd.Env = env
+	cmd.Stderr = &rpcLogWriter{logger: s.logger}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &rpcProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: map[int64]chan *rpcResponse{},
+		exited:  make(chan struct{}),
+		started: time.Now(),
+	}
+	go p.read(stdout, s.logger)
+	return p, nil
+}
+
+// nextId returns the next request ID.
+func (s *RpcServer) nextId() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastId++
+	return s.lastId
+}
+
+// listTools lists the tools of process p.
+func (s *RpcServer) listTools(ctx context.Context, p *rpcProcess) ([]*RpcTool, error) {
+
+	raw, err := p.call(ctx, s.nextId(), "tools/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Tools []struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			InputSchema json.RawMessage `json:"input_schema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("bad tools list: %w", err)
+	}
+	tools := make([]*RpcTool, 0, len(res.Tools))
+	for _, t := range res.Tools {
+		if strings.TrimSpace(t.Name) == "" || strings.TrimSpace(t.Description) == "" {
+			return nil, fmt.Errorf("tool needs name and description: %q", t.Name)
+		}
+		src := t.InputSchema
+		if len(src) == 0 || string(src) == "null" {
+			src = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		validator, err := schema.Compile(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("tool %q: %w", t.Name, err)
+		}
+		tools = append(tools, &RpcTool{
+			name:      t.Name,
+			desc:      t.Description,
+			schema:    src,
+			validator: validator,
+			server:    s,
+		})
+	}
+	return tools, nil
+}
+
+// Call calls method with params on the running command, returning the raw
+// result.  Errors from the command are RpcErrors.
+func (s *RpcServer) Call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	s.mutex.Lock()
+	p := s.proc
+	s.mutex.Unlock()
+	if p == nil {
+		return nil, fmt.Errorf("%w: %q", ErrRpcServerDown, s.cfg.Name)
+	}
+	if s.cfg.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.CallTimeout)
+		defer cancel()
+	}
+	return p.call(ctx, s.nextId(), method, params)
+}
+
+type rpcRequest struct {
+	JsonRpc string `json:"jsonrpc"`
+	Id      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Id     *int64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RpcError       `json:"error"`
+}
+
+// rpcProcess is a single run of an RpcServer command.
+type rpcProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	write   sync.Mutex
+	mutex   sync.Mutex
+	pending map[int64]chan *rpcResponse
+	exited  chan struct{} // closed after exit.
+	err     error         // exit error, set before exited is closed.
+	started time.Time
+}
+
+// read reads responses from stdout until it closes, then waits for the
+// command to exit.
+func (p *rpcProcess) read(stdout io.Reader, logger *slog.Logger) {
+
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			res := &rpcResponse{}
+			if json.Unmarshal(line, res) != nil || res.Id == nil {
+				logger.Warn("rpc server sent unexpected output",
+					"output", string(bytes.TrimSpace(line)))
+			} else {
+				p.mutex.Lock()
+				ch := p.pending[*res.Id]
+				delete(p.pending, *res.Id)
+				p.mutex.Unlock()
+				if ch != nil {
+					ch <- res
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	p.err = p.cmd.Wait()
+	close(p.exited)
+}
+
+// call sends a request and waits for the response.
+func (p *rpcProcess) call(ctx context.Context, id int64, method string, params any) (json.RawMessage, error) {
+
+	b, err := json.Marshal(&rpcRequest{JsonRpc: "2.0", Id: id, Method: method, Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRpcCallFailed, err)
+	}
+	ch := make(chan *rpcResponse, 1)
+	p.mutex.Lock()
+	p.pending[id] = ch
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.pending, id)
+		p.mutex.Unlock()
+	}()
+
+	p.write.Lock()
+	_, err = p.stdin.Write(append(b, '\n'))
+	p.write.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRpcCallFailed, err)
+	}
+
+	select {
+	case res := <-ch:
+		if res.Error != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRpcCallFailed, res.Error)
+		}
+		return res.Result, nil
+	case <-p.exited:
+		return nil, fmt.Errorf("%w: server exited: %v", ErrRpcCallFailed, p.err)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrRpcCallFailed, ctx.Err())
+	}
+}
+
+// kill kills the process and waits for it to exit.
+func (p *rpcProcess) kill() {
+	p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// rpcLogWriter logs each line written to it.
+type rpcLogWriter struct {
+	logger *slog.Logger
+	buf    []byte
+}
+
+// Write implements io.Writer.
+func (w *rpcLogWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			return len(b), nil
+		}
+		if line := strings.TrimSpace(string(w.buf[:idx])); line != "" {
+			w.logger.Info("rpc server stderr", "line", line)
+		}
+		w.buf = w.buf[idx+1:]
+	}
+}
+
+// RpcTool is a Tooler provided by an RpcServer.
+type RpcTool struct {
+	name      string
+	desc      string
+	schema    json.RawMessage
+	validator *schema.Schema
+	server    *RpcServer
+}
+
+// Name implements Tooler.
+func (t *RpcTool) Name() string {
+	return t.name
+}
+
+// Description implements Tooler.
+func (t *RpcTool) Description() string {
+	return t.desc
+}
+
+// Metadata implements MetadataTooler.
+func (t *RpcTool) Metadata() *Metadata {
+	return t.server.cfg.Metadata
+}
+
+// Help implements Tooler.
+func (t *RpcTool) Help() string {
+	var buf bytes.Buffer
+	json.Indent(&buf, t.schema, "", "  ")
+	return fmt.Sprintf("%s\n\n%s\n\nRPC Server: %s\n\nInput Schema:\n\n%s\n",
+		t.name, t.desc, t.server.Name(), buf.String())
+}
+
+// InputSchema implements Tooler.
+func (t *RpcTool) InputSchema() any {
+	return t.schema
+}
+
+// Exec implements Tooler by validating the input and calling the tool on
+// the server.
+func (t *RpcTool) Exec(ctx context.Context, input string) (any, error) {
+	if _, err := t.validator.ValidateJson(input); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	params := map[string]any{
+		"name":      t.name,
+		"arguments": json.RawMessage(input),
+	}
+	raw, err := t.server.Call(ctx, "tools/call", params)
+	if err != nil {
+		return nil, err
+	}
+	var res any
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRpcCallFailed, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,267 @@
+package tools_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// syncBuffer is a bytes.Buffer safe for logging from other goroutines.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// Return a config for the toy RPC server, which needs the same Perl as the
+// toy command.
+func rpcConfig(t *testing.T, args ...string) *tools.RpcServerConfig {
+	SkipInvalidToy(t)
+	return &tools.RpcServerConfig{
+		Name:         "toy",
+		Command:      filepath.Join(filepath.Dir(ToyCommandPath), "rpc_server.pl"),
+		Args:         args,
+		RestartDelay: 10 * time.Millisecond,
+	}
+}
+
+// Start a server for cfg, logging to the returned buffer.
+func startRpc(t *testing.T, cfg *tools.RpcServerConfig) (*tools.RpcServer, *syncBuffer) {
+	buf := &syncBuffer{}
+	logger := slog.New(slog.NewTextHandler(buf, nil))
+	s, err := tools.NewRpcServer(cfg, logger)
+	require.NoError(t, err, "NewRpcServer")
+	require.NoError(t, s.Start(), "Start")
+	t.Cleanup(func() { s.Close() })
+	return s, buf
+}
+
+// Return the named tool from s.
+func rpcTool(t *testing.T, s *tools.RpcServer, name string) *tools.RpcTool {
+	for _, tool := range s.Tools() {
+		if tool.Name() == name {
+			return tool
+		}
+	}
+	t.Fatalf("no tool %q", name)
+	return nil
+}
+
+func TestRpcServerConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := rpcConfig(t)
+	require.NoError(cfg.Validate())
+	require.Equal(tools.DefaultRpcStartTimeout, cfg.StartTimeout)
+	require.Equal(tools.DefaultRpcShutdownTimeout, cfg.ShutdownTimeout)
+	require.Equal(10*time.Millisecond, cfg.RestartDelay)
+	require.Zero(cfg.CallTimeout)
+
+	for exp, mod := range map[string]func(*tools.RpcServerConfig){
+		"empty name":                  func(c *tools.RpcServerConfig) { c.Name = " " },
+		"empty command for":           func(c *tools.RpcServerConfig) { c.Command = "" },
+		"command error for":           func(c *tools.RpcServerConfig) { c.Command += "-nope" },
+		"negative call_timeout for":   func(c *tools.RpcServerConfig) { c.CallTimeout = -1 },
+		"negative max_restarts for":   func(c *tools.RpcServerConfig) { c.MaxRestarts = -1 },
+		"negative restart_delay for":  func(c *tools.RpcServerConfig) { c.RestartDelay = -1 },
+		"negative start_timeout for ": func(c *tools.RpcServerConfig) { c.StartTimeout = -1 },
+	} {
+		cfg := rpcConfig(t)
+		mod(cfg)
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrRpcServerConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+func TestRpcServerTools(t *testing.T) {
+
+	require := require.New(t)
+
+	s, buf := startRpc(t, rpcConfig(t, "--stderr=hello there"))
+	names := []string{}
+	for _, tool := range s.Tools() {
+		names = append(names, tool.Name())
+	}
+	require.Equal([]string{"echo", "fail", "pid", "crash", "sleep"}, names)
+
+	echo := rpcTool(t, s, "echo")
+	require.Equal("Echo the arguments.", echo.Description())
+	require.JSONEq(`{"type":"object","properties":{"text":{"type":"string"}},"required":["text"]}`,
+		string(echo.InputSchema().(json.RawMessage)))
+	require.Contains(echo.Help(), "RPC Server: toy")
+	require.Equal("echo", tools.Define(echo).Name)
+
+	// Missing schema is an empty object.
+	require.JSONEq(`{"type":"object","properties":{}}`,
+		string(rpcTool(t, s, "pid").InputSchema().(json.RawMessage)))
+
+	res, err := echo.Exec(context.Background(), `{"text":"hi"}`)
+	require.NoError(err)
+	require.Equal(map[string]any{"text": "hi"}, res)
+
+	_, err = echo.Exec(context.Background(), `{"text":1}`)
+	require.ErrorIs(err, tools.ErrInvalidInput)
+
+	_, err = rpcTool(t, s, "fail").Exec(context.Background(), `{"message":"oops"}`)
+	require.ErrorIs(err, tools.ErrRpcCallFailed)
+	var rpc_err *tools.RpcError
+	require.ErrorAs(err, &rpc_err)
+	require.Equal(-32000, rpc_err.Code)
+	require.Equal("oops", rpc_err.Message)
+
+	require.Eventually(func() bool {
+		return bytes.Contains([]byte(buf.String()), []byte(`line="hello there"`))
+	}, time.Second, 10*time.Millisecond, "stderr logged")
+
+}
+
+func TestRpcServerConcurrentCalls(t *testing.T) {
+
+	require := require.New(t)
+
+	s, _ := startRpc(t, rpcConfig(t))
+	sleep := rpcTool(t, s, "sleep")
+
+	// The server is sequential, but calls are independent.
+	var wg sync.WaitGroup
+	results := make([]any, 3)
+	errs := make([]error, 3)
+	for i, secs := range []string{"0.2", "0", "0.1"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = sleep.Exec(context.Background(), `{"seconds":`+secs+`}`)
+		}()
+	}
+	wg.Wait()
+	require.Equal([]error{nil, nil, nil}, errs)
+	require.Equal([]any{0.2, float64(0), 0.1}, results)
+
+}
+
+func TestRpcServerCallTimeout(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := rpcConfig(t)
+	cfg.CallTimeout = 50 * time.Millisecond
+	s, _ := startRpc(t, cfg)
+
+	_, err := rpcTool(t, s, "sleep").Exec(context.Background(), `{"seconds":1}`)
+	require.ErrorIs(err, tools.ErrRpcCallFailed)
+	require.ErrorIs(err, context.DeadlineExceeded)
+
+}
+
+func TestRpcServerRestarts(t *testing.T) {
+
+	require := require.New(t)
+
+	s, buf := startRpc(t, rpcConfig(t))
+	pid := rpcTool(t, s, "pid")
+	first, err := pid.Exec(context.Background(), "{}")
+	require.NoError(err)
+
+	_, err = rpcTool(t, s, "crash").Exec(context.Background(), "{}")
+	require.ErrorIs(err, tools.ErrRpcCallFailed)
+	require.ErrorContains(err, "server exited")
+
+	var second any
+	require.Eventually(func() bool {
+		second, err = pid.Exec(context.Background(), "{}")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "restarted")
+	require.NotEqual(first, second)
+	require.Contains(buf.String(), "rpc server restarted")
+
+}
+
+func TestRpcServerGivesUp(t *testing.T) {
+
+	require := require.New(t)
+
+	// A server that can not list its tools can not start.
+	s, err := tools.NewRpcServer(rpcConfig(t, "--no-list"), nil)
+	require.NoError(err)
+	err = s.Start()
+	require.ErrorIs(err, tools.ErrRpcServerStart)
+	require.ErrorContains(err, `"toy"`)
+	require.NoError(s.Close(), "Close after failed Start")
+
+	cfg := rpcConfig(t)
+	cfg.MaxRestarts = 1
+	s, buf := startRpc(t, cfg)
+	_, err = rpcTool(t, s, "crash").Exec(context.Background(), "{}")
+	require.ErrorIs(err, tools.ErrRpcCallFailed)
+	pid := rpcTool(t, s, "pid")
+	require.Eventually(func() bool {
+		_, err = pid.Exec(context.Background(), "{}")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "restarted")
+
+	_, err = rpcTool(t, s, "crash").Exec(context.Background(), "{}")
+	require.ErrorIs(err, tools.ErrRpcCallFailed)
+	require.Eventually(func() bool {
+		return bytes.Contains([]byte(buf.String()), []byte("giving up"))
+	}, 5*time.Second, 10*time.Millisecond, "gave up")
+	_, err = pid.Exec(context.Background(), "{}")
+	require.ErrorIs(err, tools.ErrRpcServerDown)
+	require.NoError(s.Close())
+
+}
+
+func TestRpcServerClose(t *testing.T) {
+
+	require := require.New(t)
+
+	s, _ := startRpc(t, rpcConfig(t))
+	require.NoError(s.Close())
+	require.NoError(s.Close(), "second Close")
+	_, err := rpcTool(t, s, "pid").Exec(context.Background(), "{}")
+	require.ErrorIs(err, tools.ErrRpcServerDown)
+
+	// A server that ignores its input closing is killed.
+	cfg := rpcConfig(t, "--linger")
+	cfg.ShutdownTimeout = 100 * time.Millisecond
+	s, _ = startRpc(t, cfg)
+	err = s.Close()
+	require.ErrorContains(err, `rpc server "toy" killed after shutdown timeout`)
+
+}
+
+func TestRpcServerEnvUnset(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := rpcConfig(t)
+	cfg.Env = map[string]string{"FOO": "$GHD_TEST_RPC_ENV"}
+	s, err := tools.NewRpcServer(cfg, slog.New(slog.DiscardHandler))
+	require.NoError(err, "NewRpcServer")
+	err = s.Start()
+	require.ErrorIs(err, tools.ErrRpcServerStart)
+	require.ErrorContains(err, "FOO references unset GHD_TEST_RPC_ENV")
+
+}
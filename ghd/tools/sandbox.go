@@ -0,0 +1,87 @@
+// tools/sandbox.go
+
+package tools
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// ExternalToolSandbox represents optional restrictions on the execution of
+// an ExternalTool, using standard Linux facilities:
+//
+//   - Resource limits (rlimits) on CPU time, memory, file size and the
+//     number of processes.
+//   - A new process group, all of which is killed on cancel or timeout.
+//   - A working directory mounted read-only for the command, or a new empty
+//     temporary directory for each call.
+//   - No network access, using a new network namespace (and a user namespace
+//     if not running as root).
+//   - Running as a different user, which requires running as root.
+//
+// Settings that are not supported on the current system cause an error at
+// Validate time, rather than running the command without them.
+//
+// ReadOnlyDir bind-mounts Dir read-only in a new mount namespace (and user
+// namespace if not running as root), set up by this program run as a helper
+// before the command.  It only protects Dir: the command can still write
+// anywhere else it has permission, so it is not a filesystem sandbox.
+//
+// Note that MaxProcs counts all processes of the (real) user, and is not
+// enforced for root, so it is best used with RunAsUser.
+type ExternalToolSandbox struct {
+	MaxCpuSeconds  int64  `toml:"max_cpu_seconds"`  // CPU time limit; the command is killed beyond it.
+	MaxMemoryBytes int64  `toml:"max_memory_bytes"` // Address space (virtual memory) limit.
+	MaxFileBytes   int64  `toml:"max_file_bytes"`   // Largest file the command may write.
+	MaxProcs       int64  `toml:"max_procs"`        // Process limit for the user, see above.
+	ProcessGroup   bool   `toml:"process_group"`    // Run in a new process group, killing it on cancel.
+	ReadOnlyDir    bool   `toml:"read_only_dir"`    // Mount the configured dir read-only for the command, see above.
+	TempDir        bool   `toml:"temp_dir"`         // Run in a new temporary dir, removed afterwards.
+	NoNetwork      bool   `toml:"no_network"`       // Run in a new network namespace, with no interfaces.
+	RunAsUser      string `toml:"run_as_user"`      // User name or ID to run as.
+
+	runAs *user.User // resolved from RunAsUser in Validate.
+}
+
+var ErrSandboxUnsupported = fmt.Errorf("sandbox setting unsupported")
+var ErrSandboxFailed = fmt.Errorf("sandbox setup failed")
+
+// hasLimits returns true if any resource limits are set.
+func (s *ExternalToolSandbox) hasLimits() bool {
+	return s.MaxCpuSeconds > 0 || s.MaxMemoryBytes > 0 ||
+		s.MaxFileBytes > 0 || s.MaxProcs > 0
+}
+
+// validateCommon checks the settings that are not system-specific, for
+// sandboxing tool config c.
+func (s *ExternalToolSandbox) validateCommon(c *ExternalToolConfig) error {
+	for name, v := range map[string]int64{
+		"max_cpu_seconds":  s.MaxCpuSeconds,
+		"max_memory_bytes": s.MaxMemoryBytes,
+		"max_file_bytes":   s.MaxFileBytes,
+		"max_procs":        s.MaxProcs,
+	} {
+		if v < 0 {
+			return fmt.Errorf("negative %s", name)
+		}
+	}
+	if s.TempDir && c.Dir != "" {
+		return fmt.Errorf("temp_dir with dir")
+	}
+	if s.ReadOnlyDir && c.Dir == "" {
+		return fmt.Errorf("read_only_dir without dir")
+	}
+	if s.RunAsUser != "" {
+		lookup := user.Lookup
+		if _, err := strconv.Atoi(s.RunAsUser); err == nil {
+			lookup = user.LookupId
+		}
+		u, err := lookup(s.RunAsUser)
+		if err != nil {
+			return fmt.Errorf("run_as_user: %w", err)
+		}
+		s.runAs = u
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+//go:build linux
+
+// tools/sandbox_linux.go
+
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// sandboxHelperEnv is set in the environment of this program when it is run
+// as the helper that mounts the command's dir read-only; see runHelper.
+const sandboxHelperEnv = "GHD_SANDBOX_HELPER"
+
+// sandboxHelperFailed is the exit code of the helper if setup fails.
+const sandboxHelperFailed = 125
+
+func init() {
+	if spec, ok := os.LookupEnv(sandboxHelperEnv); ok {
+		err := runHelper(spec)
+		fmt.Fprintf(os.Stderr, "%s: %v\n", ErrSandboxFailed, err)
+		os.Exit(sandboxHelperFailed)
+	}
+}
+
+// Validate checks the sandbox settings for tool config c, normalizing
+// RunAsUser.
+//
+// Namespaces, user switching and resource limits are checked by starting a
+// process with them, which execs nothing; if that fails, so would the
+// command.  With ReadOnlyDir the helper is started, and exits once the dir
+// is mounted.
+func (s *ExternalToolSandbox) Validate(c *ExternalToolConfig) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.validateCommon(c); err != nil {
+		return err
+	}
+	if s.runAs != nil {
+		uid, _ := strconv.Atoi(s.runAs.Uid)
+		if euid := os.Geteuid(); euid != 0 && uid != euid {
+			return fmt.Errorf("%w: run_as_user requires root", ErrSandboxUnsupported)
+		}
+	}
+	if s.ReadOnlyDir {
+		cmd := exec.Command(c.Command)
+		cmd.Dir = c.Dir
+		cmd.SysProcAttr = s.sysProcAttr()
+		if err := s.useHelper(cmd, true); err != nil {
+			return fmt.Errorf("%w: read_only_dir: %w", ErrSandboxUnsupported, err)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: read_only_dir: %w: %s",
+				ErrSandboxUnsupported, err, strings.TrimSpace(string(out)))
+		}
+	} else if s.NoNetwork || s.runAs != nil || s.hasLimits() {
+		if err := probeSandbox(s.sysProcAttr()); err != nil {
+			return fmt.Errorf("%w: %w", ErrSandboxUnsupported, err)
+		}
+	}
+	return nil
+}
+
+// rlimit is a resource limit.
+type rlimit struct {
+	Resource int   `json:"resource"`
+	Value    int64 `json:"value"`
+}
+
+// limits returns the resource limits that are set.
+func (s *ExternalToolSandbox) limits() []rlimit {
+	limits := []rlimit{}
+	for _, lim := range []rlimit{
+		{unix.RLIMIT_CPU, s.MaxCpuSeconds},
+		{unix.RLIMIT_AS, s.MaxMemoryBytes},
+		{unix.RLIMIT_FSIZE, s.MaxFileBytes},
+		{unix.RLIMIT_NPROC, s.MaxProcs},
+	} {
+		if lim.Value > 0 {
+			limits = append(limits, lim)
+		}
+	}
+	return limits
+}
+
+// traced returns true if the command is held at exec to set its limits.
+// The helper sets them itself, as the Go runtime may not start with them.
+func (s *ExternalToolSandbox) traced() bool {
+	return s.hasLimits() && !s.ReadOnlyDir
+}
+
+// helperSpec is what the helper sets up before execing the command.
+type helperSpec struct {
+	Dir    string   `json:"dir"`    // Mounted read-only and made the working dir.
+	Uid    int      `json:"uid"`    // User to run as, or -1.
+	Gid    int      `json:"gid"`    // Group to run as with Uid.
+	Limits []rlimit `json:"limits"` // Resource limits to set.
+	Probe  bool     `json:"probe"`  // Exit after setup instead of execing.
+}
+
+// useHelper changes cmd, already set up with sysProcAttr, to run this
+// program as the helper, in its own mount namespace, which then execs the
+// command with the same args and environment.
+func (s *ExternalToolSandbox) useHelper(cmd *exec.Cmd, probe bool) error {
+	dir, err := filepath.Abs(cmd.Dir)
+	if err != nil {
+		return err
+	}
+	spec := helperSpec{Dir: dir, Uid: -1, Gid: -1, Limits: s.limits(), Probe: probe}
+	if s.runAs != nil {
+		spec.Uid, _ = strconv.Atoi(s.runAs.Uid)
+		spec.Gid, _ = strconv.Atoi(s.runAs.Gid)
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(slices.Clone(env), sandboxHelperEnv+"="+utils.MustJsonString(spec))
+	cmd.Args = append([]string{"ghd-sandbox-helper", cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	return nil
+}
+
+// runHelper sets up the read-only dir described by spec in the current
+// mount namespace, which must be new, and execs the command in os.Args.
+// It only returns on error.
+//
+// Mounts are made private first, so nothing propagates to other
+// namespaces.  The bind mount is not recursive, so mounts under the dir are
+// not visible in it.  Mount flags that can not be cleared in a user
+// namespace are kept.
+func runHelper(spec string) error {
+	var h helperSpec
+	if err := json.Unmarshal([]byte(spec), &h); err != nil {
+		return err
+	}
+	if len(os.Args) < 3 {
+		return fmt.Errorf("no command")
+	}
+	// Ambient capabilities are per thread, and must be gone at exec.
+	runtime.LockOSThread()
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+	if err := unix.Mount(h.Dir, h.Dir, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("binding %s: %w", h.Dir, err)
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(h.Dir, &st); err != nil {
+		return err
+	}
+	locked := uintptr(st.Flags) & (unix.ST_NOSUID | unix.ST_NODEV | unix.ST_NOEXEC |
+		unix.ST_NOATIME | unix.ST_NODIRATIME | unix.ST_RELATIME)
+	flags := unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY | locked
+	if err := unix.Mount("", h.Dir, "", flags, ""); err != nil {
+		return fmt.Errorf("remounting %s read-only: %w", h.Dir, err)
+	}
+	// The working dir is still on the old mount until changed.
+	if err := unix.Chdir(h.Dir); err != nil {
+		return err
+	}
+	if h.Uid >= 0 {
+		if err := syscall.Setgroups(nil); err != nil {
+			return err
+		}
+		if err := syscall.Setgid(h.Gid); err != nil {
+			return err
+		}
+		if err := syscall.Setuid(h.Uid); err != nil {
+			return err
+		}
+	}
+	for _, lim := range h.Limits {
+		rlim := &unix.Rlimit{Cur: uint64(lim.Value), Max: uint64(lim.Value)}
+		if err := unix.Setrlimit(lim.Resource, rlim); err != nil {
+			return fmt.Errorf("setting limit %d: %w", lim.Resource, err)
+		}
+	}
+	if err := clearInheritableCaps(); err != nil {
+		return fmt.Errorf("clearing capabilities: %w", err)
+	}
+	if h.Probe {
+		os.Exit(0)
+	}
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, sandboxHelperEnv+"=")
+	})
+	return unix.Exec(os.Args[1], os.Args[2:], env)
+}
+
+// clearInheritableCaps clears the ambient and inheritable capabilities of
+// the current thread.
+func clearInheritableCaps() error {
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return err
+	}
+	hdr := &unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := make([]unix.CapUserData, 2)
+	if err := unix.Capget(hdr, &data[0]); err != nil {
+		return err
+	}
+	data[0].Inheritable, data[1].Inheritable = 0, 0
+	return unix.Capset(hdr, &data[0])
+}
+
+// sysProcAttr returns the process attributes for the sandbox.
+func (s *ExternalToolSandbox) sysProcAttr() *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Setpgid: s.ProcessGroup,
+		// Held at exec until the limits are set, see start.
+		Ptrace: s.traced(),
+	}
+	if s.NoNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if s.ReadOnlyDir {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	if attr.Cloneflags != 0 && os.Geteuid() != 0 {
+		// Unprivileged users may only unshare namespaces in their own user
+		// namespace, in which they keep their IDs.
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{
+			{ContainerID: os.Geteuid(), HostID: os.Geteuid(), Size: 1},
+		}
+		attr.GidMappings = []syscall.SysProcIDMap{
+			{ContainerID: os.Getegid(), HostID: os.Getegid(), Size: 1},
+		}
+		if s.ReadOnlyDir {
+			// Otherwise lost at exec, as the helper is not root there.
+			attr.AmbientCaps = []uintptr{unix.CAP_SYS_ADMIN}
+		}
+	}
+	// The helper switches users itself, after mounting.
+	if s.runAs != nil && !s.ReadOnlyDir {
+		uid, _ := strconv.Atoi(s.runAs.Uid)
+		gid, _ := strconv.Atoi(s.runAs.Gid)
+		// No supplementary groups, so none are inherited from us.
+		attr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	}
+	return attr
+}
+
+// probeSandbox checks that a process can be started with attr, by trying to
+// exec the root directory, which fails with EACCES after everything else has
+// succeeded.
+func probeSandbox(attr *syscall.SysProcAttr) error {
+	_, err := syscall.ForkExec("/", []string{"/"}, &syscall.ProcAttr{Sys: attr})
+	if err == nil || errors.Is(err, syscall.EACCES) {
+		return nil
+	}
+	return err
+}
+
+// prepare sets up cmd to run in the sandbox, returning a cleanup function.
+func (s *ExternalToolSandbox) prepare(cmd *exec.Cmd) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	cmd.SysProcAttr = s.sysProcAttr()
+	if s.ReadOnlyDir {
+		if err := s.useHelper(cmd, false); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSandboxFailed, err)
+		}
+	}
+	if !s.TempDir {
+		return func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "ghd-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSandboxFailed, err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if s.runAs != nil {
+		uid, _ := strconv.Atoi(s.runAs.Uid)
+		gid, _ := strconv.Atoi(s.runAs.Gid)
+		if err := os.Chown(dir, uid, gid); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("%w: %w", ErrSandboxFailed, err)
+		}
+	}
+	cmd.Dir = dir
+	return cleanup, nil
+}
+
+// run runs cmd, already prepared, in the sandbox.
+//
+// With ProcessGroup the whole group is killed on cancel, so that children
+// holding the output open do not outlive the command.
+func (s *ExternalToolSandbox) run(cmd *exec.Cmd) error {
+	if s == nil || (!s.ProcessGroup && !s.traced()) {
+		return cmd.Run()
+	}
+
+	// Cancel is called as soon as the context is done after Start, which
+	// must not interfere with setting the limits.
+	ready := make(chan struct{})
+	cmd.Cancel = func() error {
+		<-ready
+		if s.ProcessGroup {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+		return cmd.Process.Kill()
+	}
+	err := s.start(cmd)
+	close(ready)
+	if err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// start starts cmd, setting any resource limits before it execs.
+//
+// The process is traced, so it stops at exec; the limits are set, and it is
+// released.  Tracing must be done from a single thread.
+func (s *ExternalToolSandbox) start(cmd *exec.Cmd) error {
+	if !s.traced() {
+		return cmd.Start()
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	_, err := syscall.Wait4(pid, &ws, 0, nil)
+	for errors.Is(err, syscall.EINTR) {
+		_, err = syscall.Wait4(pid, &ws, 0, nil)
+	}
+	if err == nil && !ws.Stopped() {
+		err = fmt.Errorf("process not stopped at exec: %v", ws)
+	}
+	if err == nil {
+		err = s.setLimits(pid)
+	}
+	if err == nil {
+		err = syscall.PtraceDetach(pid)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("%w: %w", ErrSandboxFailed, err)
+	}
+	return nil
+}
+
+// setLimits sets the resource limits of process pid.
+func (s *ExternalToolSandbox) setLimits(pid int) error {
+	for _, lim := range s.limits() {
+		rlim := &unix.Rlimit{Cur: uint64(lim.Value), Max: uint64(lim.Value)}
+		if err := unix.Prlimit(pid, lim.Resource, rlim, nil); err != nil {
+			return fmt.Errorf("setting limit %d: %w", lim.Resource, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package tools_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// Return a config running a system command with fixed args in sandbox sb,
+// skipping the test if the command is not available.
+func sandboxConfig(t *testing.T, sb *tools.ExternalToolSandbox, command string, pre_args ...string) *tools.ExternalToolConfig {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		t.Skip("command not available: " + command)
+	}
+	return &tools.ExternalToolConfig{
+		Name:        "sandboxed",
+		Description: "Sandboxed command.",
+		Command:     path,
+		PreArgs:     pre_args,
+		Sandbox:     sb,
+	}
+}
+
+// Run cfg in a sandbox, skipping if the sandbox is not supported.
+func execSandbox(t *testing.T, ctx context.Context, cfg *tools.ExternalToolConfig) (any, error) {
+	if err := cfg.Validate(); errors.Is(err, tools.ErrSandboxUnsupported) {
+		t.Skip(err.Error())
+	}
+	tool, err := tools.NewExternalTool(cfg)
+	require.NoError(t, err, "NewExternalTool")
+	return tool.Exec(ctx, "{}")
+}
+
+func TestSandboxValidateFails(t *testing.T) {
+
+	require := require.New(t)
+
+	writable := t.TempDir()
+
+	for exp, sb := range map[string]*tools.ExternalToolSandbox{
+		"negative max_procs":        {MaxProcs: -1},
+		"negative max_cpu_seconds":  {MaxCpuSeconds: -1},
+		"read_only_dir without dir": {ReadOnlyDir: true},
+		"run_as_user: user: unknown user": {
+			RunAsUser: "no-such-user-here",
+		},
+	} {
+		cfg := sandboxConfig(t, sb, "cat")
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrExternalToolConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{TempDir: true}, "cat")
+	cfg.Dir = writable
+	require.ErrorContains(cfg.Validate(), "temp_dir with dir")
+
+}
+
+func TestSandboxLimits(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{
+		MaxCpuSeconds:  7,
+		MaxMemoryBytes: 1 << 30,
+		MaxFileBytes:   1 << 20,
+		MaxProcs:       99,
+	}, "cat", "/proc/self/limits")
+	res, err := execSandbox(t, context.Background(), cfg)
+	require.NoError(err)
+	limits := strings.Join(strings.Fields(res.(string)), " ")
+	require.Contains(limits, "Max cpu time 7 7 seconds")
+	require.Contains(limits, "Max address space 1073741824 1073741824 bytes")
+	require.Contains(limits, "Max file size 1048576 1048576 bytes")
+	require.Contains(limits, "Max processes 99 99 processes")
+
+}
+
+func TestSandboxReadOnlyDir(t *testing.T) {
+
+	require := require.New(t)
+
+	// Writable by anyone, but not by the command.
+	dir := t.TempDir()
+	require.NoError(os.Chmod(dir, 0777))
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{ReadOnlyDir: true},
+		"sh", "-c", "pwd; touch x")
+	cfg.Dir = dir
+	_, err := execSandbox(t, context.Background(), cfg)
+	require.ErrorIs(err, tools.ErrCommandFailed)
+	require.ErrorContains(err, "Read-only file system")
+	require.NoFileExists(filepath.Join(dir, "x"))
+
+	// Still writable outside the command.
+	require.NoError(os.WriteFile(filepath.Join(dir, "y"), []byte("y"), 0644))
+
+	// Args, env and limits are passed through the helper.
+	cfg = sandboxConfig(t, &tools.ExternalToolSandbox{
+		ReadOnlyDir: true,
+		MaxProcs:    99,
+	}, "sh", "-c", "pwd; cat y; echo; echo env:$GHD_SANDBOX_HELPER; cat /proc/self/limits")
+	cfg.Dir = dir
+	res, err := execSandbox(t, context.Background(), cfg)
+	require.NoError(err)
+	lines := strings.Split(res.(string), "\n")
+	require.Equal(dir, lines[0])
+	require.Equal("y", lines[1])
+	require.Equal("env:", lines[2])
+	require.Contains(strings.Join(strings.Fields(res.(string)), " "),
+		"Max processes 99 99 processes")
+
+}
+
+func TestSandboxCpuLimitKills(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{MaxCpuSeconds: 1},
+		"sh", "-c", "while :; do :; done")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := execSandbox(t, ctx, cfg)
+	require.ErrorIs(err, tools.ErrCommandFailed)
+	require.ErrorContains(err, "signal")
+
+}
+
+func TestSandboxNoNetwork(t *testing.T) {
+
+	require := require.New(t)
+
+	ours, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		t.Skip("no network namespace info: " + err.Error())
+	}
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{NoNetwork: true},
+		"readlink", "/proc/self/ns/net")
+	res, err := execSandbox(t, context.Background(), cfg)
+	require.NoError(err)
+	theirs := strings.TrimSpace(res.(string))
+	require.True(strings.HasPrefix(theirs, "net:"), theirs)
+	require.NotEqual(ours, theirs)
+
+}
+
+func TestSandboxRunAsUser(t *testing.T) {
+
+	require := require.New(t)
+
+	if os.Geteuid() != 0 {
+		cfg := sandboxConfig(t, &tools.ExternalToolSandbox{RunAsUser: "0"}, "id")
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrSandboxUnsupported)
+		require.ErrorContains(err, "run_as_user requires root")
+		return
+	}
+
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{RunAsUser: "nobody"}, "id", "-u")
+	if cfg.Validate() != nil {
+		t.Skip("no usable nobody user")
+	}
+	res, err := execSandbox(t, context.Background(), cfg)
+	require.NoError(err)
+	uid, err := strconv.Atoi(strings.TrimSpace(res.(string)))
+	require.NoError(err)
+	require.NotZero(uid)
+
+	// The helper switches user after mounting.
+	// (The test temp dir itself is not reachable for nobody.)
+	dir, err := os.MkdirTemp("", "ghd-sandbox-test-")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(os.Chmod(dir, 0777))
+	cfg = sandboxConfig(t, &tools.ExternalToolSandbox{
+		RunAsUser:   "nobody",
+		ReadOnlyDir: true,
+	}, "sh", "-c", "id -u; touch x")
+	cfg.Dir = dir
+	_, err = execSandbox(t, context.Background(), cfg)
+	require.ErrorContains(err, "Read-only file system")
+	require.NotContains(err.Error(), "\n0\n")
+	require.NoFileExists(filepath.Join(dir, "x"))
+
+}
+
+func TestSandboxTempDir(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{TempDir: true}, "pwd")
+	res, err := execSandbox(t, context.Background(), cfg)
+	require.NoError(err)
+	dir := strings.TrimSpace(res.(string))
+	require.True(strings.HasPrefix(filepath.Base(dir), "ghd-sandbox-"), dir)
+	require.NoDirExists(dir, "removed after use")
+
+}
+
+func TestSandboxProcessGroupKilled(t *testing.T) {
+
+	require := require.New(t)
+
+	// The background sleep holds the output open; without the process group
+	// it would keep the command running after the timeout.
+	cfg := sandboxConfig(t, &tools.ExternalToolSandbox{ProcessGroup: true},
+		"sh", "-c", "sleep 30 & echo $!; wait")
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := execSandbox(t, ctx, cfg)
+	require.ErrorIs(err, tools.ErrCommandTimedOut)
+	require.Less(time.Since(start), 10*time.Second)
+
+	var cmd_err tools.CommandError
+	require.ErrorAs(err, &cmd_err)
+	pid := strings.TrimSpace(cmd_err.Stdout)
+	require.NotEmpty(pid)
+	// Killed but maybe not reaped, if nothing reaps orphans here; dying
+	// takes a moment.
+	require.Eventually(func() bool {
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil {
+			return true
+		}
+		fields := strings.Fields(string(stat))
+		return fields[1] != "(sleep)" || fields[2] == "Z"
+	}, 5*time.Second, 10*time.Millisecond, "sleep killed")
+
+}
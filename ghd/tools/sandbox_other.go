@@ -0,0 +1,29 @@
+//go:build !linux
+
+// tools/sandbox_other.go
+
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Validate checks the sandbox settings for tool config c.  Outside Linux no
+// settings are supported.
+func (s *ExternalToolSandbox) Validate(c *ExternalToolConfig) error {
+	if s == nil || *s == (ExternalToolSandbox{}) {
+		return nil
+	}
+	return fmt.Errorf("%w: sandbox requires Linux", ErrSandboxUnsupported)
+}
+
+// prepare sets up cmd to run in the sandbox, returning a cleanup function.
+func (s *ExternalToolSandbox) prepare(cmd *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
+
+// run runs cmd in the sandbox.
+func (s *ExternalToolSandbox) run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
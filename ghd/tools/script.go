@@ -0,0 +1,520 @@
+// tools/script.go
+
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	starlarkmath "go.starlark.net/lib/math"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"github.com/biztos/greenhead/ghd/schema"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// Defaults for ScriptToolConfig limits.
+var (
+	DefaultScriptMaxSteps     int64 = 10_000_000
+	DefaultScriptTimeout            = 10 * time.Second
+	DefaultScriptMaxHttpBytes int64 = 1 << 20
+)
+
+// ScriptToolModules are the modules a script may be granted.
+var ScriptToolModules = []string{"json", "time", "math", "http"}
+
+// ScriptToolConfig represents the configuration of a ScriptTool.
+//
+// This is used within a Runner config.
+type ScriptToolConfig struct {
+	Name         string             `toml:"name"`           // Name, required.
+	Description  string             `toml:"description"`    // Description, required.
+	Args         []*ExternalToolArg `toml:"args"`           // Input args, as for external tools but without flags.
+	Script       string             `toml:"script"`         // Starlark source, defining run(args).
+	ScriptFile   string             `toml:"script_file"`    // Path to the Starlark source, instead of Script.
+	Modules      []string           `toml:"modules"`        // Modules to grant, from ScriptToolModules.
+	HttpHosts    []string           `toml:"http_hosts"`     // Hosts the http module may call, e.g. "example.com" or "localhost:8080".
+	MaxHttpBytes int64              `toml:"max_http_bytes"` // Limit for http response bodies.
+	MaxSteps     int64              `toml:"max_steps"`      // Limit for Starlark execution steps per call.
+	Timeout      time.Duration      `toml:"timeout"`        // Time limit per call.
+	Metadata     *Metadata          `toml:"metadata"`       // Optional metadata for selecting the tool.
+}
+
+var ErrScriptToolConfigInvalid = fmt.Errorf("invalid script tool config")
+
+// Validate checks that c has correct values, setting default limits:
+//
+// - Name and Description must not be empty.
+// - Exactly one of Script and ScriptFile must be set.
+// - Args must all validate, have no flags, and not have redundant keys.
+// - Modules must be in ScriptToolModules.
+// - HttpHosts must be set if, and only if, the http module is granted.
+// - MaxHttpBytes, MaxSteps and Timeout must not be negative.
+// - Metadata, if set, must be valid.
+//
+// The script itself is checked by NewScriptTool.
+func (c *ScriptToolConfig) Validate() error {
+
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("%w: empty name", ErrScriptToolConfigInvalid)
+	}
+	if strings.TrimSpace(c.Description) == "" {
+		return fmt.Errorf("%w: empty description for %q",
+			ErrScriptToolConfigInvalid, c.Name)
+	}
+	if (c.Script == "") == (c.ScriptFile == "") {
+		return fmt.Errorf("%w: need one of script and script_file for %q",
+			ErrScriptToolConfigInvalid, c.Name)
+	}
+
+	have_key := map[string]bool{}
+	for i, arg := range c.Args {
+		if arg.Flag != "" {
+			return fmt.Errorf("%w: %q arg %d: flag not allowed",
+				ErrScriptToolConfigInvalid, c.Name, i)
+		}
+		arg.AllowDash = true // no command line to confuse.
+		if err := arg.Validate(); err != nil {
+			return fmt.Errorf("%w: %q arg %d: %w",
+				ErrScriptToolConfigInvalid, c.Name, i, err)
+		}
+		if have_key[arg.Key] {
+			return fmt.Errorf("%w: %q arg %d: duplicate key %q",
+				ErrScriptToolConfigInvalid, c.Name, i, arg.Key)
+		}
+		have_key[arg.Key] = true
+	}
+
+	for _, m := range c.Modules {
+		if !slices.Contains(ScriptToolModules, m) {
+			return fmt.Errorf("%w: unsupported module for %q: %q",
+				ErrScriptToolConfigInvalid, c.Name, m)
+		}
+	}
+	if slices.Contains(c.Modules, "http") != (len(c.HttpHosts) > 0) {
+		return fmt.Errorf("%w: http module requires http_hosts and vice versa for %q",
+			ErrScriptToolConfigInvalid, c.Name)
+	}
+
+	if c.MaxHttpBytes < 0 || c.MaxSteps < 0 || c.Timeout < 0 {
+		return fmt.Errorf("%w: negative limit for %q",
+			ErrScriptToolConfigInvalid, c.Name)
+	}
+	if c.MaxHttpBytes == 0 {
+		c.MaxHttpBytes = DefaultScriptMaxHttpBytes
+	}
+	if c.MaxSteps == 0 {
+		c.MaxSteps = DefaultScriptMaxSteps
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultScriptTimeout
+	}
+	if c.Metadata != nil {
+		if err := c.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%w: metadata for %q: %w",
+				ErrScriptToolConfigInvalid, c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ScriptTool is a Tooler implemented by a Starlark script, a dialect of
+// Python designed for embedding.  The script must define a function run,
+// which receives the input as a dict and returns a JSON-able value: None,
+// bools, numbers, strings, lists, tuples and dicts with string keys.  Errors
+// are raised with fail.
+//
+//	def run(args):
+//	    return args["text"].upper()
+//
+// Scripts have no access to the filesystem, environment or network except
+// for the granted modules, which are predeclared by name:
+//
+//	json - encode, decode and indent, from the Starlark library
+//	time - now, parse_time etc., from the Starlark library
+//	math - the Starlark math library
+//	http - get(url, headers={}) and post(url, body="", headers={})
+//
+// The http functions return a dict with status, headers and body, and are
+// limited to the configured hosts, including on redirects.  Non-2xx statuses
+// are not errors.
+//
+// Each call runs in a new Starlark thread, limited by steps and time.  Output
+// of print is discarded.
+type ScriptTool struct {
+	cfg       *ScriptToolConfig
+	argMap    map[string]*ExternalToolArg
+	schema    *ExternalToolSchema
+	validator *schema.Schema
+	run       *starlark.Function
+	client    *http.Client
+}
+
+var ErrScriptToolFailed = fmt.Errorf("script tool failed")
+
+// NewScriptTool creates a ScriptTool from cfg, loading its script.
+func NewScriptTool(cfg *ScriptToolConfig) (*ScriptTool, error) {
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	t := &ScriptTool{
+		cfg:    cfg,
+		argMap: make(map[string]*ExternalToolArg, len(cfg.Args)),
+		schema: argsSchema(cfg.Args),
+	}
+	for _, arg := range cfg.Args {
+		t.argMap[arg.Key] = arg
+	}
+	validator, err := schema.Compile(utils.MustJsonString(t.schema))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrScriptToolConfigInvalid, cfg.Name, err)
+	}
+	t.validator = validator
+
+	predeclared := starlark.StringDict{}
+	for _, m := range cfg.Modules {
+		switch m {
+		case "json":
+			predeclared[m] = starlarkjson.Module
+		case "time":
+			predeclared[m] = starlarktime.Module
+		case "math":
+			predeclared[m] = starlarkmath.Module
+		case "http":
+			predeclared[m] = t.httpModule()
+		}
+	}
+
+	src := []byte(cfg.Script)
+	filename := cfg.Name + ".star"
+	if cfg.ScriptFile != "" {
+		src, err = os.ReadFile(cfg.ScriptFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrScriptToolConfigInvalid, cfg.Name, err)
+		}
+		filename = cfg.ScriptFile
+	}
+	opts := &syntax.FileOptions{
+		Set:             true,
+		While:           true,
+		TopLevelControl: true,
+		Recursion:       true,
+	}
+	thread, done := t.thread(context.Background())
+	globals, err := starlark.ExecFileOptions(opts, thread, filename, src, predeclared)
+	done()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %s", ErrScriptToolConfigInvalid, cfg.Name,
+			scriptError(err))
+	}
+	run, ok := globals["run"].(*starlark.Function)
+	if !ok || run.NumParams() != 1 {
+		return nil, fmt.Errorf("%w: %q: script must define run(args)",
+			ErrScriptToolConfigInvalid, cfg.Name)
+	}
+	t.run = run
+
+	return t, nil
+}
+
+// thread returns a new Starlark thread limited by the config and ctx, and a
+// function to call when done with it.
+func (t *ScriptTool) thread(ctx context.Context) (*starlark.Thread, func()) {
+
+	ctx, cancel := context.WithTimeout(ctx, t.cfg.Timeout)
+	thread := &starlark.Thread{
+		Name:  t.cfg.Name,
+		Print: func(*starlark.Thread, string) {},
+	}
+	thread.SetMaxExecutionSteps(uint64(t.cfg.MaxSteps))
+	thread.SetLocal("context", ctx)
+	stop := context.AfterFunc(ctx, func() {
+		thread.Cancel(ctx.Err().Error())
+	})
+	return thread, func() {
+		stop()
+		cancel()
+	}
+}
+
+// scriptError returns the message of err, with the Starlark backtrace if
+// available.
+func scriptError(err error) string {
+	if eval_err, ok := err.(*starlark.EvalError); ok {
+		return eval_err.Backtrace()
+	}
+	return err.Error()
+}
+
+// Name implements Tooler.
+func (t *ScriptTool) Name() string {
+	return t.cfg.Name
+}
+
+// Description implements Tooler.
+func (t *ScriptTool) Description() string {
+	return t.cfg.Description
+}
+
+// Metadata implements MetadataTooler.
+func (t *ScriptTool) Metadata() *Metadata {
+	return t.cfg.Metadata
+}
+
+// Help implements Tooler.
+func (t *ScriptTool) Help() string {
+	script := "inline"
+	if t.cfg.ScriptFile != "" {
+		script = t.cfg.ScriptFile
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, []byte(utils.MustJsonString(t.schema)), "", "  ")
+	return fmt.Sprintf("%s\n\n%s\n\nStarlark Script: %s\n\nInput Schema:\n\n%s\n",
+		t.cfg.Name, t.cfg.Description, script, buf.String())
+}
+
+// InputSchema implements Tooler.
+func (t *ScriptTool) InputSchema() any {
+	return t.schema
+}
+
+// Exec implements Tooler by validating the input and calling the run
+// function of the script, within the configured limits.
+func (t *ScriptTool) Exec(ctx context.Context, input string) (any, error) {
+
+	v, err := t.validator.ValidateJson(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	m := v.(map[string]any) // guaranteed by the schema
+	cleanInput(m, t.argMap)
+	args, err := toStarlark(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	thread, done := t.thread(ctx)
+	defer done()
+	res, err := starlark.Call(thread, t.run, starlark.Tuple{args}, nil)
+	if err != nil {
+		ctx := thread.Local("context").(context.Context)
+		if ctx_err := ctx.Err(); ctx_err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrScriptToolFailed, ctx_err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrScriptToolFailed, scriptError(err))
+	}
+	out, err := fromStarlark(res, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad result: %w", ErrScriptToolFailed, err)
+	}
+	return out, nil
+}
+
+// Definition implements Definer, being Strict if all args are required.
+func (t *ScriptTool) Definition() *Definition {
+	d := DefaultDefinition(t)
+	d.Strict = isStrict(t.schema)
+	return d
+}
+
+// toStarlark converts v, as decoded from JSON or TOML, to a Starlark value.
+func toStarlark(v any) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []any:
+		list := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, sv)
+		}
+		return starlark.NewList(list), nil
+	case map[string]any:
+		dict := starlark.NewDict(len(v))
+		for k, item := range v {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(k), sv)
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+// maxStarlarkDepth limits the nesting of script results, which may contain
+// themselves.
+const maxStarlarkDepth = 100
+
+// fromStarlark converts v to a JSON-able Go value.
+func fromStarlark(v starlark.Value, depth int) (any, error) {
+	if depth > maxStarlarkDepth {
+		return nil, fmt.Errorf("nested too deeply")
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, fmt.Errorf("int out of range: %s", v)
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Indexable: // list, tuple
+		list := make([]any, 0, v.Len())
+		for i := range v.Len() {
+			item, err := fromStarlark(v.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case *starlark.Dict:
+		m := make(map[string]any, v.Len())
+		for _, kv := range v.Items() {
+			k, ok := starlark.AsString(kv[0])
+			if !ok {
+				return nil, fmt.Errorf("dict key not a string: %s", kv[0])
+			}
+			item, err := fromStarlark(kv[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = item
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("not JSON-able: %s", v.Type())
+}
+
+// httpModule returns the http module, limited to the configured hosts.
+func (t *ScriptTool) httpModule() *starlarkstruct.Module {
+	t.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return t.checkUrl(req.URL)
+		},
+	}
+	get := func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var raw_url string
+		var headers *starlark.Dict
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &raw_url, "headers?", &headers); err != nil {
+			return nil, err
+		}
+		return t.httpRequest(thread, http.MethodGet, raw_url, nil, headers)
+	}
+	post := func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var raw_url, body string
+		var headers *starlark.Dict
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &raw_url, "body?", &body, "headers?", &headers); err != nil {
+			return nil, err
+		}
+		return t.httpRequest(thread, http.MethodPost, raw_url, strings.NewReader(body), headers)
+	}
+	return &starlarkstruct.Module{
+		Name: "http",
+		Members: starlark.StringDict{
+			"get":  starlark.NewBuiltin("get", get),
+			"post": starlark.NewBuiltin("post", post),
+		},
+	}
+}
+
+// checkUrl returns an error unless u is http(s) on an allowed host.
+func (t *ScriptTool) checkUrl(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	for _, host := range t.cfg.HttpHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host not allowed: %q", u.Host)
+}
+
+// httpRequest makes a request for a script, returning a dict with status,
+// headers and body.
+func (t *ScriptTool) httpRequest(thread *starlark.Thread, method string, raw_url string, body io.Reader, headers *starlark.Dict) (starlark.Value, error) {
+
+	u, err := url.Parse(raw_url)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkUrl(u); err != nil {
+		return nil, err
+	}
+	ctx := thread.Local("context").(context.Context)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		for _, kv := range headers.Items() {
+			k, k_ok := starlark.AsString(kv[0])
+			v, v_ok := starlark.AsString(kv[1])
+			if !k_ok || !v_ok {
+				return nil, fmt.Errorf("headers must be strings")
+			}
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, t.cfg.MaxHttpBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > t.cfg.MaxHttpBytes {
+		return nil, fmt.Errorf("response body exceeds %d bytes", t.cfg.MaxHttpBytes)
+	}
+
+	resp_headers := starlark.NewDict(len(resp.Header))
+	for k, vals := range resp.Header {
+		resp_headers.SetKey(starlark.String(k), starlark.String(strings.Join(vals, ", ")))
+	}
+	res := starlark.NewDict(3)
+	res.SetKey(starlark.String("status"), starlark.MakeInt(resp.StatusCode))
+	res.SetKey(starlark.String("headers"), resp_headers)
+	res.SetKey(starlark.String("body"), starlark.String(b))
+	return res, nil
+}
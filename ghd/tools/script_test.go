@@ -0,0 +1,279 @@
+package tools_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+// Return a ScriptTool for script, failing if it can not be created.
+func scriptTool(t *testing.T, cfg *tools.ScriptToolConfig, script string) *tools.ScriptTool {
+	if cfg.Name == "" {
+		cfg.Name = "script_toy"
+		cfg.Description = "Toy script tool."
+	}
+	cfg.Script = script
+	tool, err := tools.NewScriptTool(cfg)
+	require.NoError(t, err, "NewScriptTool")
+	return tool
+}
+
+func TestScriptToolConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	good := func() *tools.ScriptToolConfig {
+		return &tools.ScriptToolConfig{
+			Name:        "foo",
+			Description: "Foo.",
+			Script:      "def run(args): return 1",
+			Args:        []*tools.ExternalToolArg{{Key: "text"}},
+		}
+	}
+	cfg := good()
+	require.NoError(cfg.Validate())
+	require.Equal(tools.DefaultScriptMaxSteps, cfg.MaxSteps)
+	require.Equal(tools.DefaultScriptTimeout, cfg.Timeout)
+	require.Equal(tools.DefaultScriptMaxHttpBytes, cfg.MaxHttpBytes)
+	require.True(cfg.Args[0].AllowDash)
+
+	for exp, mod := range map[string]func(*tools.ScriptToolConfig){
+		"empty name":                     func(c *tools.ScriptToolConfig) { c.Name = "" },
+		"empty description for":          func(c *tools.ScriptToolConfig) { c.Description = "" },
+		"need one of script and":         func(c *tools.ScriptToolConfig) { c.ScriptFile = "x.star" },
+		"flag not allowed":               func(c *tools.ScriptToolConfig) { c.Args[0].Flag = "-x" },
+		"unsupported type":               func(c *tools.ScriptToolConfig) { c.Args[0].Type = "nope" },
+		"duplicate key":                  func(c *tools.ScriptToolConfig) { c.Args = append(c.Args, c.Args[0]) },
+		"unsupported module":             func(c *tools.ScriptToolConfig) { c.Modules = []string{"os"} },
+		"http module requires":           func(c *tools.ScriptToolConfig) { c.Modules = []string{"http"} },
+		"http module requires http_host": func(c *tools.ScriptToolConfig) { c.HttpHosts = []string{"x"} },
+		"negative limit":                 func(c *tools.ScriptToolConfig) { c.MaxSteps = -1 },
+	} {
+		cfg := good()
+		mod(cfg)
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrScriptToolConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+	for exp, script := range map[string]string{
+		"want indent":                   "def run(args):\nreturn 1",
+		"script must define run(args)":  "def go(args): return 1",
+		"script must define run(args) ": "def run(): return 1",
+		"undefined: os":                 "def run(args): return os.getenv('HOME')",
+		"kaboom":                        "fail('kaboom')",
+	} {
+		cfg := good()
+		cfg.Script = script
+		_, err := tools.NewScriptTool(cfg)
+		require.ErrorIs(err, tools.ErrScriptToolConfigInvalid, exp)
+		require.ErrorContains(err, strings.TrimSpace(exp))
+	}
+
+	cfg = good()
+	cfg.Script = ""
+	cfg.ScriptFile = filepath.Join(t.TempDir(), "nope.star")
+	_, err := tools.NewScriptTool(cfg)
+	require.ErrorIs(err, tools.ErrScriptToolConfigInvalid)
+	require.ErrorIs(err, os.ErrNotExist)
+
+}
+
+func TestScriptTool(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := scriptTool(t, &tools.ScriptToolConfig{
+		Args: []*tools.ExternalToolArg{
+			{Key: "text"},
+			{Key: "count", Type: "integer", Default: int64(2)},
+			{Key: "tags", Repeat: true, Optional: true},
+		},
+		Modules: []string{"json", "math", "time"},
+	}, `
+def run(args):
+    if args["text"] == "fail":
+        fail("failed on purpose")
+    if args["text"] == "bad":
+        return {"set": set([1])}
+    return {
+        "text": args["text"] * args["count"],
+        "tags": sorted(args.get("tags", [])),
+        "json": json.decode('{"a": [1, 2.5, null, true]}'),
+        "floor": math.floor(2.7),
+        "year_ok": time.now().year > 2000,
+        "tuple": (1, "two"),
+    }
+`)
+	require.Equal("script_toy", tool.Name())
+	require.Equal("Toy script tool.", tool.Description())
+	require.Contains(tool.Help(), "Starlark Script: inline")
+	require.Contains(tool.Help(), `"count"`)
+	require.Equal("script_toy", tools.Define(tool).Name)
+	require.False(tools.Define(tool).Strict)
+
+	res, err := tool.Exec(context.Background(), `{"text":"-ab","tags":["z","a"]}`)
+	require.NoError(err)
+	require.Equal(map[string]any{
+		"text":    "-ab-ab",
+		"tags":    []any{"a", "z"},
+		"json":    map[string]any{"a": []any{int64(1), 2.5, nil, true}},
+		"floor":   int64(2),
+		"year_ok": true,
+		"tuple":   []any{int64(1), "two"},
+	}, res)
+
+	_, err = tool.Exec(context.Background(), `{"count":1}`)
+	require.ErrorIs(err, tools.ErrInvalidInput)
+
+	_, err = tool.Exec(context.Background(), `{"text":"fail"}`)
+	require.ErrorIs(err, tools.ErrScriptToolFailed)
+	require.ErrorContains(err, "failed on purpose")
+
+	_, err = tool.Exec(context.Background(), `{"text":"bad"}`)
+	require.ErrorIs(err, tools.ErrScriptToolFailed)
+	require.ErrorContains(err, "not JSON-able: set")
+
+}
+
+func TestScriptToolFile(t *testing.T) {
+
+	require := require.New(t)
+
+	file := filepath.Join(t.TempDir(), "upper.star")
+	require.NoError(os.WriteFile(file, []byte("def run(args):\n    return args['s'].upper()\n"), 0644))
+	tool, err := tools.NewScriptTool(&tools.ScriptToolConfig{
+		Name:        "upper",
+		Description: "Upper-case a string.",
+		ScriptFile:  file,
+		Args:        []*tools.ExternalToolArg{{Key: "s"}},
+	})
+	require.NoError(err)
+	require.Contains(tool.Help(), "Starlark Script: "+file)
+	require.True(tools.Define(tool).Strict)
+
+	res, err := tool.Exec(context.Background(), `{"s":"hi"}`)
+	require.NoError(err)
+	require.Equal("HI", res)
+
+}
+
+func TestScriptToolLimits(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := scriptTool(t, &tools.ScriptToolConfig{
+		MaxSteps: 10_000,
+		Args:     []*tools.ExternalToolArg{{Key: "n", Type: "integer"}},
+	}, `
+def run(args):
+    total = 0
+    for i in range(args["n"]):
+        total += i
+    return total
+`)
+	res, err := tool.Exec(context.Background(), `{"n":10}`)
+	require.NoError(err)
+	require.Equal(int64(45), res)
+	_, err = tool.Exec(context.Background(), `{"n":1000000}`)
+	require.ErrorIs(err, tools.ErrScriptToolFailed)
+	require.ErrorContains(err, "too many steps")
+
+	tool = scriptTool(t, &tools.ScriptToolConfig{
+		MaxSteps: 1 << 40,
+		Timeout:  100 * time.Millisecond,
+	}, `
+def run(args):
+    while True:
+        pass
+`)
+	start := time.Now()
+	_, err = tool.Exec(context.Background(), `{}`)
+	require.ErrorIs(err, tools.ErrScriptToolFailed)
+	require.ErrorIs(err, context.DeadlineExceeded)
+	require.Less(time.Since(start), 5*time.Second)
+
+	// Loading the script is limited too.
+	_, err = tools.NewScriptTool(&tools.ScriptToolConfig{
+		Name:        "loop",
+		Description: "Loops.",
+		MaxSteps:    1000,
+		Script:      "x = [i for i in range(100000)]\ndef run(args): return 1",
+	})
+	require.ErrorIs(err, tools.ErrScriptToolConfigInvalid)
+	require.ErrorContains(err, "too many steps")
+
+}
+
+func TestScriptToolHttp(t *testing.T) {
+
+	require := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Reply", "yes")
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("X-Test"), body)
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 200)))
+		case "/away":
+			http.Redirect(w, r, "http://elsewhere.invalid/", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	tool := scriptTool(t, &tools.ScriptToolConfig{
+		Modules:      []string{"http"},
+		HttpHosts:    []string{host},
+		MaxHttpBytes: 100,
+		Args:         []*tools.ExternalToolArg{{Key: "url"}, {Key: "post", Type: "boolean", Optional: true}},
+	}, `
+def run(args):
+    if args.get("post"):
+        return http.post(args["url"], body="data", headers={"X-Test": "t"})
+    return http.get(args["url"], headers={"X-Test": "t"})
+`)
+
+	res, err := tool.Exec(context.Background(), `{"url":"`+srv.URL+`/echo"}`)
+	require.NoError(err)
+	m := res.(map[string]any)
+	require.Equal(int64(200), m["status"])
+	require.Equal("GET t ", m["body"])
+	require.Equal("yes", m["headers"].(map[string]any)["X-Reply"])
+
+	res, err = tool.Exec(context.Background(), `{"url":"`+srv.URL+`/echo","post":true}`)
+	require.NoError(err)
+	require.Equal("POST t data", res.(map[string]any)["body"])
+
+	res, err = tool.Exec(context.Background(), `{"url":"`+srv.URL+`/nope"}`)
+	require.NoError(err)
+	require.Equal(int64(404), res.(map[string]any)["status"])
+
+	for exp, u := range map[string]string{
+		"exceeds 100 bytes":  srv.URL + "/big",
+		"host not allowed":   srv.URL + "/away",
+		"unsupported scheme": "file:///etc/passwd",
+	} {
+		_, err = tool.Exec(context.Background(), `{"url":"`+u+`"}`)
+		require.ErrorIs(err, tools.ErrScriptToolFailed, exp)
+		require.ErrorContains(err, exp)
+	}
+	_, err = tool.Exec(context.Background(), `{"url":"http://example.com/"}`)
+	require.ErrorContains(err, `host not allowed: "example.com"`)
+
+}
@@ -0,0 +1,349 @@
+// tools/search/index.go
+
+package search
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// IndexVersion is the version of the index file format.  Indexes of other
+// versions must be rebuilt.
+const IndexVersion = 1
+
+// BM25 parameters, the usual ones.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// FileEntry is an indexed file.
+type FileEntry struct {
+	Path    string    // As shown in citations: the configured dir and the path within it.
+	ModTime time.Time // For detecting changes.
+	Size    int64     // For detecting changes.
+}
+
+// Chunk is an indexed passage of a file.
+type Chunk struct {
+	File      int    // Index into Files.
+	StartLine int    // First line, from 1.
+	EndLine   int    // Last line, inclusive.
+	Heading   string // The heading the passage falls under, if any.
+	Text      string // The passage itself.
+	Length    int    // Number of terms, for BM25.
+}
+
+// Posting is the frequency of a term in a chunk.
+type Posting struct {
+	Chunk int32
+	Freq  int32
+}
+
+// Index is an inverted index of documents, for BM25 search.  It is saved
+// to and loaded from disk as a whole.
+type Index struct {
+	Version    int
+	Built      time.Time
+	Dirs       []string
+	Extensions []string
+	ChunkLines int
+	Files      []*FileEntry
+	Chunks     []*Chunk
+	Postings   map[string][]Posting
+	AvgLength  float64
+}
+
+// walk calls fn for each document file under the configured dirs, in
+// lexical order, with its display path.  Hidden files and dirs, symlinks
+// and files of other extensions are skipped, as are files seen through an
+// earlier dir.
+func walk(cfg *Config, fn func(path, display string, info iofs.FileInfo) error) error {
+	seen := map[string]bool{}
+	for _, dir := range cfg.Dirs {
+		dir = filepath.Clean(dir)
+		err := filepath.WalkDir(dir, func(path string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if !slices.Contains(cfg.Extensions, strings.ToLower(filepath.Ext(path))) {
+				return nil
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return fn(path, filepath.ToSlash(path), info)
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+		}
+	}
+	return nil
+}
+
+// Build indexes the documents in cfg.Dirs.  Files that are too large, or do
+// not look like text, are skipped.
+func Build(cfg *Config) (*Index, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	idx := &Index{
+		Version:    IndexVersion,
+		Built:      time.Now(),
+		Dirs:       cfg.Dirs,
+		Extensions: cfg.Extensions,
+		ChunkLines: cfg.ChunkLines,
+		Files:      []*FileEntry{},
+		Chunks:     []*Chunk{},
+		Postings:   map[string][]Posting{},
+	}
+	total := 0
+	err := walk(cfg, func(path, display string, info iofs.FileInfo) error {
+		// Skipped files are listed too, so they are not seen as new.
+		file := len(idx.Files)
+		idx.Files = append(idx.Files, &FileEntry{
+			Path:    display,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+		if info.Size() > cfg.MaxFileBytes {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(b, 0) != -1 || !utf8.Valid(b) {
+			return nil
+		}
+		for _, p := range chunk(string(b), cfg.ChunkLines) {
+			words := terms(p.text)
+			if p.heading != "" && !strings.Contains(p.text, p.heading) {
+				words = append(words, terms(p.heading)...)
+			}
+			if len(words) == 0 {
+				continue
+			}
+			id := int32(len(idx.Chunks))
+			idx.Chunks = append(idx.Chunks, &Chunk{
+				File:      file,
+				StartLine: p.start,
+				EndLine:   p.end,
+				Heading:   p.heading,
+				Text:      p.text,
+				Length:    len(words),
+			})
+			total += len(words)
+			freqs := map[string]int32{}
+			for _, w := range words {
+				freqs[w]++
+			}
+			for w, n := range freqs {
+				idx.Postings[w] = append(idx.Postings[w], Posting{Chunk: id, Freq: n})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.Chunks) > 0 {
+		idx.AvgLength = float64(total) / float64(len(idx.Chunks))
+	}
+	return idx, nil
+}
+
+// Save writes the index to path, replacing any file there only once the
+// index is completely written.
+func (idx *Index) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+	}
+	defer os.Remove(f.Name()) // no-op after rename
+	if err := gob.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+	}
+	return nil
+}
+
+// Load reads an index from path.
+func Load(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNoIndex, path)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	idx := &Index{}
+	if err := gob.NewDecoder(f).Decode(idx); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrIndexInvalid, path, err)
+	}
+	if idx.Version != IndexVersion {
+		return nil, fmt.Errorf("%w: %s: version %d, not %d",
+			ErrIndexInvalid, path, idx.Version, IndexVersion)
+	}
+	return idx, nil
+}
+
+// Terms returns the number of distinct terms in the index.
+func (idx *Index) Terms() int {
+	return len(idx.Postings)
+}
+
+// Match is a chunk matching a search, with its BM25 score.
+type Match struct {
+	Chunk *Chunk
+	File  *FileEntry
+	Score float64
+}
+
+// Search returns the best n chunks for the query, by BM25 score.
+func (idx *Index) Search(query string, n int) []*Match {
+	scores := map[int32]float64{}
+	seen := map[string]bool{}
+	total := float64(len(idx.Chunks))
+	for _, term := range terms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.Postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.Freq)
+			norm := 1 - bm25B + bm25B*float64(idx.Chunks[p.Chunk].Length)/idx.AvgLength
+			scores[p.Chunk] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	ids := make([]int32, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	matches := make([]*Match, len(ids))
+	for i, id := range ids {
+		c := idx.Chunks[id]
+		matches[i] = &Match{Chunk: c, File: idx.Files[c.File], Score: scores[id]}
+	}
+	return matches
+}
+
+// Status describes an index and how it differs from the documents.
+type Status struct {
+	Index         string    // Path of the index file.
+	Built         time.Time // When the index was built.
+	Files         int       // Number of files indexed.
+	Chunks        int       // Number of passages indexed.
+	Terms         int       // Number of distinct terms.
+	ConfigChanged bool      // Dirs, extensions or chunking changed since the build.
+	Added         []string  // Files not in the index.
+	Changed       []string  // Files changed since they were indexed.
+	Removed       []string  // Files indexed but no longer there.
+}
+
+// Stale returns true if the index should be rebuilt.
+func (s *Status) Stale() bool {
+	return s.ConfigChanged || len(s.Added) > 0 || len(s.Changed) > 0 || len(s.Removed) > 0
+}
+
+// GetStatus loads the index for cfg and compares it with the documents.
+func GetStatus(cfg *Config) (*Status, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	idx, err := Load(cfg.Index)
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{
+		Index:  cfg.Index,
+		Built:  idx.Built,
+		Files:  len(idx.Files),
+		Chunks: len(idx.Chunks),
+		Terms:  idx.Terms(),
+		ConfigChanged: !slices.Equal(idx.Dirs, cfg.Dirs) ||
+			!slices.Equal(idx.Extensions, cfg.Extensions) ||
+			idx.ChunkLines != cfg.ChunkLines,
+		Added:   []string{},
+		Changed: []string{},
+		Removed: []string{},
+	}
+	indexed := map[string]*FileEntry{}
+	for _, f := range idx.Files {
+		indexed[f.Path] = f
+	}
+	err = walk(cfg, func(path, display string, info iofs.FileInfo) error {
+		f := indexed[display]
+		switch {
+		case f == nil:
+			s.Added = append(s.Added, display)
+		case !f.ModTime.Equal(info.ModTime()) || f.Size != info.Size():
+			s.Changed = append(s.Changed, display)
+		}
+		delete(indexed, display)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range idx.Files {
+		if indexed[f.Path] != nil {
+			s.Removed = append(s.Removed, f.Path)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,212 @@
+package search_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools/search"
+)
+
+const testGuide = `# Guide
+
+Greenhead runs agents with tools.
+
+## Installing
+
+Install with go install, then run the ghd command.
+Agents are configured in TOML files.
+
+## Tools
+
+Tools are registered in a registry.  Each tool has a name,
+a description and an input schema.
+
+` + "```" + `
+# Not a heading, in code.
+ghd tools list
+` + "```" + `
+`
+
+// testDocs makes some documents in a new working dir, returning a config
+// for indexing them from there.
+func testDocs(t *testing.T) *search.Config {
+	t.Helper()
+	base := t.TempDir()
+	files := map[string]string{
+		"docs/guide.md":          testGuide,
+		"docs/notes/apples.txt":  "Apples are red or green.\nApples grow on trees.\n",
+		"docs/notes/pears.TXT":   "Pears are green.\n",
+		"docs/notes/skip.go":     "package skip // agents tools\n",
+		"docs/.hidden/secret.md": "agents secret\n",
+		"docs/binary.md":         "agents\x00tools",
+		"docs/big.md":            strings.Repeat("agents tools ", 100),
+	}
+	for name, content := range files {
+		p := filepath.Join(base, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	t.Chdir(base)
+	return &search.Config{
+		Index:        filepath.Join("index", "docs.index"),
+		Dirs:         []string{"docs"},
+		MaxFileBytes: 1000,
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &search.Config{Index: "x", Dirs: []string{"docs"}}
+	require.NoError(cfg.Validate())
+	require.Equal(search.DefaultExtensions, cfg.Extensions)
+	require.Equal(search.DefaultChunkLines, cfg.ChunkLines)
+	require.Equal(search.DefaultMaxResults, cfg.MaxResults)
+	require.EqualValues(search.DefaultMaxFileBytes, cfg.MaxFileBytes)
+
+	cfg = &search.Config{Index: "x", Dirs: []string{"docs"}, Extensions: []string{"RST", ".Md"}}
+	require.NoError(cfg.Validate())
+	require.Equal([]string{".rst", ".md"}, cfg.Extensions)
+
+	for _, tc := range []struct {
+		cfg *search.Config
+		msg string
+	}{
+		{&search.Config{Dirs: []string{"docs"}}, "no index"},
+		{&search.Config{Index: "x"}, "no dirs"},
+		{&search.Config{Index: "x", Dirs: []string{""}}, "empty dir"},
+		{&search.Config{Index: "x", Dirs: []string{"docs"}, MaxResults: -1}, "negative limit"},
+	} {
+		err := tc.cfg.Validate()
+		require.ErrorIs(err, search.ErrConfigInvalid, tc.msg)
+		require.ErrorContains(err, tc.msg)
+	}
+
+}
+
+func TestBuildIndex(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := testDocs(t)
+	idx, err := cfg.BuildIndex()
+	require.NoError(err)
+
+	paths := []string{}
+	for _, f := range idx.Files {
+		paths = append(paths, f.Path)
+	}
+	require.Equal([]string{
+		"docs/big.md", "docs/binary.md", "docs/guide.md", "docs/notes/apples.txt", "docs/notes/pears.TXT",
+	}, paths, "skipped files are listed but not hidden or other files")
+
+	type span struct {
+		path       string
+		start, end int
+		heading    string
+	}
+	spans := []span{}
+	for _, c := range idx.Chunks {
+		spans = append(spans, span{idx.Files[c.File].Path, c.StartLine, c.EndLine, c.Heading})
+	}
+	require.Equal([]span{
+		{"docs/guide.md", 1, 3, "Guide"},
+		{"docs/guide.md", 5, 8, "Installing"},
+		{"docs/guide.md", 10, 18, "Tools"},
+		{"docs/notes/apples.txt", 1, 2, ""},
+		{"docs/notes/pears.TXT", 1, 1, ""},
+	}, spans)
+	require.True(strings.HasPrefix(idx.Chunks[2].Text, "## Tools\n"))
+
+	loaded, err := search.Load(cfg.Index)
+	require.NoError(err)
+	require.Equal(len(idx.Chunks), len(loaded.Chunks))
+	require.Equal(idx.Terms(), loaded.Terms())
+	require.Equal(idx.Postings["apple"], loaded.Postings["apple"])
+
+	_, err = search.Load("nope.index")
+	require.ErrorIs(err, search.ErrNoIndex)
+	require.NoError(os.WriteFile("bad.index", []byte("not gob"), 0644))
+	_, err = search.Load("bad.index")
+	require.ErrorIs(err, search.ErrIndexInvalid)
+
+	_, err = search.Build(&search.Config{Index: "x", Dirs: []string{"nope"}})
+	require.ErrorIs(err, search.ErrIndexFailed)
+
+}
+
+func TestBuildIndexChunking(t *testing.T) {
+
+	require := require.New(t)
+
+	lines := []string{}
+	for i := range 25 {
+		lines = append(lines, "line about foxes")
+		if i%4 == 3 {
+			lines = append(lines, "")
+		}
+	}
+	dir := t.TempDir()
+	require.NoError(os.WriteFile(filepath.Join(dir, "long.md"), []byte(strings.Join(lines, "\n")), 0644))
+	idx, err := search.Build(&search.Config{Index: "x", Dirs: []string{dir}, ChunkLines: 10})
+	require.NoError(err)
+
+	// Passages break at the first blank line after two thirds full.
+	ends := []int{}
+	for _, c := range idx.Chunks {
+		ends = append(ends, c.EndLine)
+		require.LessOrEqual(c.EndLine-c.StartLine, 9)
+	}
+	require.Equal([]int{9, 19, 29, 31}, ends)
+
+}
+
+func TestGetStatus(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := testDocs(t)
+	_, err := search.GetStatus(cfg)
+	require.ErrorIs(err, search.ErrNoIndex)
+	_, err = search.GetStatus(&search.Config{})
+	require.ErrorIs(err, search.ErrConfigInvalid)
+
+	_, err = cfg.BuildIndex()
+	require.NoError(err)
+	s, err := search.GetStatus(cfg)
+	require.NoError(err)
+	require.False(s.Stale())
+	require.Equal(cfg.Index, s.Index)
+	require.Equal(5, s.Files)
+	require.Equal(5, s.Chunks)
+	require.Positive(s.Terms)
+	require.WithinDuration(time.Now(), s.Built, time.Minute)
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(os.Chtimes("docs/guide.md", later, later))
+	require.NoError(os.Remove("docs/notes/pears.TXT"))
+	require.NoError(os.WriteFile("docs/new.md", []byte("new\n"), 0644))
+	s, err = search.GetStatus(cfg)
+	require.NoError(err)
+	require.True(s.Stale())
+	require.False(s.ConfigChanged)
+	require.Equal([]string{"docs/new.md"}, s.Added)
+	require.Equal([]string{"docs/guide.md"}, s.Changed)
+	require.Equal([]string{"docs/notes/pears.TXT"}, s.Removed)
+
+	cfg.ChunkLines = 10
+	_, err = cfg.BuildIndex()
+	require.NoError(err)
+	cfg.ChunkLines = 20
+	s, err = search.GetStatus(cfg)
+	require.NoError(err)
+	require.True(s.ConfigChanged)
+	require.True(s.Stale())
+
+}
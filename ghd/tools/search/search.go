@@ -0,0 +1,239 @@
+// Package search provides a tool for searching local documents, for agents
+// that need grounding in your docs without an external search service.
+//
+// Markdown and text files in configured directories are split into
+// passages and indexed into an inverted index on disk, which is searched
+// with BM25 ranking.  Everything is pure Go and runs on the CPU.
+//
+// The tool is not registered on init, as it needs an index.  Register it
+// with Register, or in a runner config:
+//
+//	[search_tools]
+//	  index = "/var/lib/ghd/docs.index"
+//	  dirs = ["docs", "/srv/wiki"]
+//
+// Then build the index with "ghd index build", and check it with "ghd index
+// status".  The index is reloaded when it changes, so it can be rebuilt
+// while agents are running.
+//
+// The tool is:
+//
+//	search_docs  search the documents, returning passages with citations
+package search
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrConfigInvalid = fmt.Errorf("invalid search tools config")
+
+var ErrNoIndex = fmt.Errorf("index not built")
+
+var ErrIndexInvalid = fmt.Errorf("invalid index")
+
+var ErrIndexFailed = fmt.Errorf("indexing failed")
+
+// DefaultExtensions is the default for Config.Extensions.
+var DefaultExtensions = []string{".md", ".markdown", ".txt"}
+
+// DefaultChunkLines is the default for Config.ChunkLines.
+const DefaultChunkLines = 30
+
+// DefaultMaxResults is the default for Config.MaxResults.
+const DefaultMaxResults = 5
+
+// DefaultMaxFileBytes is the default for Config.MaxFileBytes.
+const DefaultMaxFileBytes = 1024 * 1024
+
+// SnippetLines is the number of lines in the snippet of a passage.
+const SnippetLines = 3
+
+// Config describes the search tool and its index.
+type Config struct {
+	Index        string   `toml:"index"`          // Path of the index file; required.
+	Dirs         []string `toml:"dirs"`           // Directories of documents to index; required.
+	Extensions   []string `toml:"extensions"`     // File extensions to index; default .md, .markdown and .txt.
+	ChunkLines   int      `toml:"chunk_lines"`    // Max lines in a passage; default 30.
+	MaxResults   int      `toml:"max_results"`    // Max passages returned by a search; default 5.
+	MaxFileBytes int64    `toml:"max_file_bytes"` // Larger files are not indexed; default 1 MiB.
+}
+
+// Validate checks c, setting defaults as needed.
+func (c *Config) Validate() error {
+	if c.Index == "" {
+		return fmt.Errorf("%w: no index", ErrConfigInvalid)
+	}
+	if len(c.Dirs) == 0 {
+		return fmt.Errorf("%w: no dirs", ErrConfigInvalid)
+	}
+	for _, dir := range c.Dirs {
+		if dir == "" {
+			return fmt.Errorf("%w: empty dir", ErrConfigInvalid)
+		}
+	}
+	if len(c.Extensions) == 0 {
+		c.Extensions = append([]string{}, DefaultExtensions...)
+	}
+	for i, ext := range c.Extensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		c.Extensions[i] = ext
+	}
+	if c.ChunkLines < 0 || c.MaxResults < 0 || c.MaxFileBytes < 0 {
+		return fmt.Errorf("%w: negative limit", ErrConfigInvalid)
+	}
+	if c.ChunkLines == 0 {
+		c.ChunkLines = DefaultChunkLines
+	}
+	if c.MaxResults == 0 {
+		c.MaxResults = DefaultMaxResults
+	}
+	if c.MaxFileBytes == 0 {
+		c.MaxFileBytes = DefaultMaxFileBytes
+	}
+	return nil
+}
+
+// BuildIndex builds the index for c and saves it, returning it.
+func (c *Config) BuildIndex() (*Index, error) {
+	idx, err := Build(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.Save(c.Index); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Searcher searches the index for a config, loading it as needed.
+type Searcher struct {
+	config  *Config
+	mutex   sync.Mutex
+	index   *Index
+	modTime time.Time
+}
+
+// New returns a Searcher for cfg.  The index is not loaded until needed,
+// and need not exist yet.
+func New(cfg *Config) (*Searcher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Searcher{config: cfg}, nil
+}
+
+// load returns the index, loading it if it is not yet loaded or the file
+// has changed.
+func (s *Searcher) load() (*Index, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info, err := os.Stat(s.config.Index)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s (run: ghd index build)", ErrNoIndex, s.config.Index)
+		}
+		return nil, err
+	}
+	if s.index == nil || !info.ModTime().Equal(s.modTime) {
+		idx, err := Load(s.config.Index)
+		if err != nil {
+			return nil, err
+		}
+		s.index = idx
+		s.modTime = info.ModTime()
+	}
+	return s.index, nil
+}
+
+// SearchInput is the input to search_docs.
+type SearchInput struct {
+	Query      string `json:"query" description:"Words to search for; results have as many as possible."`
+	MaxResults int    `json:"max_results,omitempty" jsonschema:"minimum=1" description:"Max passages to return; default is the configured max."`
+}
+
+// Passage is a passage found by search_docs.
+type Passage struct {
+	Citation  string  `json:"citation"` // path:start-end
+	Path      string  `json:"path"`
+	StartLine int     `json:"start_line"`
+	EndLine   int     `json:"end_line"`
+	Heading   string  `json:"heading,omitempty"`
+	Score     float64 `json:"score"`
+	Snippet   string  `json:"snippet"` // best lines, with matches in bold
+	Text      string  `json:"text"`
+}
+
+// SearchResult is the output of search_docs.
+type SearchResult struct {
+	Query    string     `json:"query"`
+	Passages []*Passage `json:"passages"`
+}
+
+// Search returns the passages best matching the query, best first.
+func (s *Searcher) Search(ctx context.Context, in SearchInput) (*SearchResult, error) {
+	idx, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	n := s.config.MaxResults
+	if in.MaxResults > 0 && in.MaxResults < n {
+		n = in.MaxResults
+	}
+	query := map[string]bool{}
+	for _, t := range terms(in.Query) {
+		query[t] = true
+	}
+	res := &SearchResult{Query: in.Query, Passages: []*Passage{}}
+	for _, m := range idx.Search(in.Query, n) {
+		c := m.Chunk
+		res.Passages = append(res.Passages, &Passage{
+			Citation:  fmt.Sprintf("%s:%d-%d", m.File.Path, c.StartLine, c.EndLine),
+			Path:      m.File.Path,
+			StartLine: c.StartLine,
+			EndLine:   c.EndLine,
+			Heading:   c.Heading,
+			Score:     math.Round(m.Score*1000) / 1000,
+			Snippet:   snippet(c.Text, query, SnippetLines),
+			Text:      c.Text,
+		})
+	}
+	return res, nil
+}
+
+// Tool returns the search_docs tool for s.
+func (s *Searcher) Tool() tools.Tooler {
+	return tools.NewTool[SearchInput, *SearchResult](
+		"search_docs",
+		"Searches the local documentation for passages matching the query, "+
+			"best first.  Each passage has a citation (path:start-end lines) "+
+			"to use when quoting it.  Searches match words, not phrases, so "+
+			"use distinctive words and try other words if nothing useful is "+
+			"found.  Documents: "+strings.Join(s.config.Dirs, ", "),
+		s.Search,
+	).WithMetadata(&tools.Metadata{Tags: []string{"search"}, Risk: tools.RiskReadOnly})
+}
+
+// Register registers the search_docs tool for cfg in reg.
+func Register(reg *registry.Registry, cfg *Config) error {
+	s, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	tool := s.Tool()
+	if err := reg.Register(tool); err != nil {
+		return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+	}
+	return nil
+}
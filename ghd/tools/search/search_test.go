@@ -0,0 +1,106 @@
+package search_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/search"
+)
+
+func TestSearch(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	cfg := testDocs(t)
+	s, err := search.New(cfg)
+	require.NoError(err)
+
+	_, err = s.Search(ctx, search.SearchInput{Query: "tools"})
+	require.ErrorIs(err, search.ErrNoIndex)
+	require.ErrorContains(err, "run: ghd index build")
+
+	_, err = cfg.BuildIndex()
+	require.NoError(err)
+
+	res, err := s.Search(ctx, search.SearchInput{Query: "Which tool registry?"})
+	require.NoError(err)
+	require.Equal("Which tool registry?", res.Query)
+	require.Len(res.Passages, 2)
+	p := res.Passages[0]
+	require.Equal("docs/guide.md:10-18", p.Citation)
+	require.Equal("docs/guide.md", p.Path)
+	require.Equal(10, p.StartLine)
+	require.Equal(18, p.EndLine)
+	require.Equal("Tools", p.Heading)
+	require.Positive(p.Score)
+	require.Equal("## **Tools**\n\n**Tools** are registered in a **registry**.  Each **tool** has a name,", p.Snippet)
+	require.Contains(p.Text, "ghd tools list")
+	require.Equal("docs/guide.md:1-3", res.Passages[1].Citation)
+	require.Less(res.Passages[1].Score, p.Score)
+
+	// Plurals match singulars, and rarer words count for more.
+	res, err = s.Search(ctx, search.SearchInput{Query: "green apple"})
+	require.NoError(err)
+	require.Len(res.Passages, 2)
+	require.Equal("docs/notes/apples.txt:1-2", res.Passages[0].Citation)
+	require.Equal("**Apples** are red or **green**.\n**Apples** grow on trees.", res.Passages[0].Snippet)
+	require.Equal("docs/notes/pears.TXT:1-1", res.Passages[1].Citation)
+
+	res, err = s.Search(ctx, search.SearchInput{Query: "green", MaxResults: 1})
+	require.NoError(err)
+	require.Len(res.Passages, 1)
+
+	res, err = s.Search(ctx, search.SearchInput{Query: "the of and"})
+	require.NoError(err)
+	require.Empty(res.Passages)
+
+	// Rebuilt indexes are reloaded.
+	require.NoError(os.WriteFile("docs/new.md", []byte("Zebras!\n"), 0644))
+	_, err = cfg.BuildIndex()
+	require.NoError(err)
+	later := time.Now().Add(time.Second)
+	require.NoError(os.Chtimes(cfg.Index, later, later))
+	res, err = s.Search(ctx, search.SearchInput{Query: "zebra"})
+	require.NoError(err)
+	require.Len(res.Passages, 1)
+
+	require.NoError(os.WriteFile(cfg.Index, []byte("junk"), 0644))
+	_, err = s.Search(ctx, search.SearchInput{Query: "zebra"})
+	require.ErrorIs(err, search.ErrIndexInvalid)
+
+}
+
+func TestRegister(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := testDocs(t)
+	_, err := cfg.BuildIndex()
+	require.NoError(err)
+
+	reg := registry.New()
+	require.NoError(search.Register(reg, cfg))
+	require.Equal([]string{"search_docs"}, reg.Names())
+	tool, err := reg.Get("search_docs")
+	require.NoError(err)
+	meta := tools.MetadataOf(tool)
+	require.True(meta.HasTag("search"))
+	require.Equal(tools.RiskReadOnly, meta.Risk)
+	require.Contains(tool.Description(), "Documents: docs")
+
+	res, err := tool.Exec(context.Background(), `{"query":"install","max_results":3}`)
+	require.NoError(err)
+	require.Equal("docs/guide.md:5-8", res.(*search.SearchResult).Passages[0].Citation)
+
+	require.ErrorIs(search.Register(registry.New(), &search.Config{}), search.ErrConfigInvalid)
+	reg.Lock()
+	require.ErrorContains(search.Register(reg, cfg), `failed to register "search_docs"`)
+
+}
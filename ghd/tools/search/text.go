@@ -0,0 +1,177 @@
+// tools/search/text.go
+
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// stopWords are too common to be worth indexing.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true,
+	"has": true, "have": true, "if": true, "in": true, "into": true,
+	"is": true, "it": true, "its": true, "not": true, "of": true, "on": true,
+	"or": true, "so": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "this": true, "to": true,
+	"was": true, "were": true, "will": true, "with": true,
+}
+
+var headingRegexp = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+
+// token is a term found in text, with its byte offsets.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokens returns the indexable terms in s: words and numbers in lower case,
+// lightly stemmed, leaving out stop words and single letters.
+func tokens(s string) []token {
+	toks := []token{}
+	start := -1
+	for i, r := range s + " " {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start == -1 {
+			continue
+		}
+		word := strings.ToLower(s[start:i])
+		if utf8.RuneCountInString(word) > 1 && !stopWords[word] {
+			toks = append(toks, token{term: stem(word), start: start, end: i})
+		}
+		start = -1
+	}
+	return toks
+}
+
+// terms returns the terms of tokens(s).
+func terms(s string) []string {
+	toks := tokens(s)
+	res := make([]string, len(toks))
+	for i, t := range toks {
+		res[i] = t.term
+	}
+	return res
+}
+
+// stem reduces plural words to their singular, so that e.g. "tools" finds
+// "tool".  It is deliberately simple; anything more needs a real stemmer.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") &&
+		!strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// passage is a chunk of a document.
+type passage struct {
+	start, end int // line numbers, from 1
+	heading    string
+	text       string
+}
+
+// chunk splits a document into passages of at most max lines, preferring
+// to break at headings and blank lines, and never breaking at a heading
+// inside a fenced code block.  Each passage has the heading it falls under.
+func chunk(doc string, max int) []*passage {
+	lines := strings.Split(strings.ReplaceAll(doc, "\r\n", "\n"), "\n")
+	passages := []*passage{}
+	heading := ""
+	start := 0 // index of the first line of the current passage
+	flush := func(end int) {
+		for start < end && strings.TrimSpace(lines[start]) == "" {
+			start++
+		}
+		last := end
+		for last > start && strings.TrimSpace(lines[last-1]) == "" {
+			last--
+		}
+		if last > start {
+			passages = append(passages, &passage{
+				start:   start + 1,
+				end:     last,
+				heading: heading,
+				text:    strings.Join(lines[start:last], "\n"),
+			})
+		}
+		start = end
+	}
+	fenced := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+		if !fenced {
+			if m := headingRegexp.FindStringSubmatch(line); m != nil {
+				flush(i)
+				heading = m[1]
+			}
+		}
+		n := i + 1 - start
+		if n >= max || (trimmed == "" && !fenced && n >= max*2/3) {
+			flush(i + 1)
+		}
+	}
+	flush(len(lines))
+	return passages
+}
+
+// snippet returns up to n lines of text with the most matches of the query
+// terms, with the matches in bold.
+func snippet(text string, query map[string]bool, n int) string {
+	lines := strings.Split(text, "\n")
+	hits := make([]int, len(lines))
+	for i, line := range lines {
+		for _, t := range tokens(line) {
+			if query[t.term] {
+				hits[i]++
+			}
+		}
+	}
+	best, best_hits := 0, -1
+	for i := 0; i+n <= len(lines) || i == 0; i++ {
+		sum := 0
+		for j := i; j < i+n && j < len(lines); j++ {
+			sum += hits[j]
+		}
+		if sum > best_hits {
+			best, best_hits = i, sum
+		}
+	}
+	end := min(best+n, len(lines))
+	res := make([]string, 0, end-best)
+	for _, line := range lines[best:end] {
+		res = append(res, highlight(line, query))
+	}
+	return strings.TrimSpace(strings.Join(res, "\n"))
+}
+
+// highlight returns line with the query terms in bold.
+func highlight(line string, query map[string]bool) string {
+	var b strings.Builder
+	pos := 0
+	for _, t := range tokens(line) {
+		if !query[t.term] {
+			continue
+		}
+		b.WriteString(line[pos:t.start])
+		b.WriteString("**" + line[t.start:t.end] + "**")
+		pos = t.end
+	}
+	b.WriteString(line[pos:])
+	return b.String()
+}
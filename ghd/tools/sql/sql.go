@@ -0,0 +1,619 @@
+// Package sql provides tools for querying configured databases, for agents
+// answering questions from data.
+//
+// SQLite is supported with a pure-Go driver, so no cgo is needed.  Other
+// databases work with any database/sql driver imported by the program, if
+// they support information_schema.
+//
+// The tools are not registered on init, as they need databases.  Register
+// them with Register, or in a runner config:
+//
+//	[[sql_tools.databases]]
+//	  name = "sales"
+//	  description = "Orders and customers, updated nightly."
+//	  dsn = "/srv/data/sales.db"
+//
+//	[[sql_tools.databases]]
+//	  name = "scratch"
+//	  dsn = "${SCRATCH_DB}"
+//	  read_write = true
+//
+// The tools, which take the name of a database, are:
+//
+//	sql_list_tables     list the tables and views
+//	sql_describe_table  describe the columns of a table
+//	sql_query           run one SQL statement, returning columns and rows
+//
+// Databases are read-only unless configured with ReadWrite.  Read-only
+// queries must be of an allowed statement type, are run in a read-only
+// transaction, and for SQLite on a read-only, query-only connection.  All
+// queries are limited in time, rows and bytes.
+package sql
+
+import (
+	"context"
+	stdsql "database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrConfigInvalid = fmt.Errorf("invalid sql tools config")
+
+var ErrNotAllowed = fmt.Errorf("not allowed")
+
+var ErrNoDatabase = fmt.Errorf("no such database")
+
+var ErrNoTable = fmt.Errorf("no such table")
+
+// DefaultDriver is the default for DatabaseConfig.Driver.
+const DefaultDriver = "sqlite"
+
+// DefaultMaxRows is the default for DatabaseConfig.MaxRows.
+const DefaultMaxRows = 100
+
+// DefaultMaxBytes is the default for DatabaseConfig.MaxBytes.
+const DefaultMaxBytes = 256 * 1024
+
+// DefaultTimeout is the default for DatabaseConfig.Timeout.
+const DefaultTimeout = 30 * time.Second
+
+// ReadOnlyStatements are the statement types allowed by default for
+// read-only databases.
+var ReadOnlyStatements = []string{"select", "with", "explain"}
+
+// ReadWriteStatements are the statement types allowed by default for
+// read-write databases.
+var ReadWriteStatements = []string{"select", "with", "explain", "insert", "update", "delete"}
+
+// queryStatements are the statement types run as queries returning rows;
+// all others are run for the number of rows affected.
+var queryStatements = []string{"select", "with", "explain", "values", "pragma", "show"}
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// DatabaseConfig describes a database for the sql tools.
+type DatabaseConfig struct {
+	Name        string        `toml:"name"`        // Name by which the LLM chooses the database; required.
+	Description string        `toml:"description"` // Description of the data, for the LLM.
+	Driver      string        `toml:"driver"`      // The database/sql driver; default is "sqlite".
+	DSN         string        `toml:"dsn"`         // Data source, expanding "$VAR" and "${VAR}" from the environment; required.
+	ReadWrite   bool          `toml:"read_write"`  // Allow changes; default is read-only.
+	Statements  []string      `toml:"statements"`  // Allowed statement types, e.g. "select"; default depends on ReadWrite.
+	MaxRows     int           `toml:"max_rows"`    // Max rows returned by a query; default 100.
+	MaxBytes    int           `toml:"max_bytes"`   // Max bytes of rows returned, as JSON; default 256 KiB.
+	Timeout     time.Duration `toml:"timeout"`     // Max time for a query; default 30s.
+}
+
+// Validate checks c, setting defaults as needed.
+func (c *DatabaseConfig) Validate() error {
+	if !nameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("%w: bad database name %q", ErrConfigInvalid, c.Name)
+	}
+	if c.DSN == "" {
+		return fmt.Errorf("%w: no dsn for %q", ErrConfigInvalid, c.Name)
+	}
+	if c.Driver == "" {
+		c.Driver = DefaultDriver
+	}
+	if !slices.Contains(stdsql.Drivers(), c.Driver) {
+		return fmt.Errorf("%w: driver %q for %q is not available", ErrConfigInvalid, c.Driver, c.Name)
+	}
+	if len(c.Statements) == 0 {
+		c.Statements = slices.Clone(ReadOnlyStatements)
+		if c.ReadWrite {
+			c.Statements = slices.Clone(ReadWriteStatements)
+		}
+	}
+	for i, s := range c.Statements {
+		c.Statements[i] = strings.ToLower(strings.TrimSpace(s))
+		if !nameRegexp.MatchString(c.Statements[i]) {
+			return fmt.Errorf("%w: bad statement type %q for %q", ErrConfigInvalid, s, c.Name)
+		}
+	}
+	if c.MaxRows < 0 || c.MaxBytes < 0 || c.Timeout < 0 {
+		return fmt.Errorf("%w: negative limit for %q", ErrConfigInvalid, c.Name)
+	}
+	if c.MaxRows == 0 {
+		c.MaxRows = DefaultMaxRows
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = DefaultMaxBytes
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	return nil
+}
+
+// isSqlite returns true if the database uses a SQLite driver.
+func (c *DatabaseConfig) isSqlite() bool {
+	return c.Driver == "sqlite" || c.Driver == "sqlite3"
+}
+
+// dataSource returns the DSN with environment variables expanded, and for
+// read-only SQLite databases opened with mode=ro, so they are never created
+// or changed.  Read-only DSNs setting another mode are an error.
+func (c *DatabaseConfig) dataSource() (string, error) {
+	dsn, missing := tools.ExpandEnv(c.DSN)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: dsn for %q references unset %s",
+			ErrConfigInvalid, c.Name, strings.Join(missing, ", "))
+	}
+	if c.isSqlite() && !c.ReadWrite {
+		path, query, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", fmt.Errorf("%w: dsn for %q: %w", ErrConfigInvalid, c.Name, err)
+		}
+		for _, mode := range values["mode"] {
+			if mode != "ro" {
+				return "", fmt.Errorf("%w: dsn for %q sets mode=%s but is read-only",
+					ErrConfigInvalid, c.Name, mode)
+			}
+		}
+		values.Set("mode", "ro")
+		dsn = "file:" + path + "?" + values.Encode()
+	}
+	return dsn, nil
+}
+
+// Config describes the sql tools.
+type Config struct {
+	Databases []*DatabaseConfig `toml:"databases"` // Databases to use; at least one is required.
+}
+
+// Validate checks that c has at least one valid database, and that their
+// names are unique.
+func (c *Config) Validate() error {
+	if len(c.Databases) == 0 {
+		return fmt.Errorf("%w: no databases", ErrConfigInvalid)
+	}
+	have := map[string]bool{}
+	for _, db := range c.Databases {
+		if err := db.Validate(); err != nil {
+			return err
+		}
+		if have[db.Name] {
+			return fmt.Errorf("%w: duplicate database name %q", ErrConfigInvalid, db.Name)
+		}
+		have[db.Name] = true
+	}
+	return nil
+}
+
+// database is an open database.
+type database struct {
+	config *DatabaseConfig
+	db     *stdsql.DB
+}
+
+// Databases provides the sql tools for a set of databases.
+type Databases struct {
+	databases []*database
+}
+
+// New returns the Databases for cfg, opening but not connecting to each.
+func New(cfg *Config) (*Databases, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	d := &Databases{}
+	for _, c := range cfg.Databases {
+		dsn, err := c.dataSource()
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		db, err := stdsql.Open(c.Driver, dsn)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("%w: %q: %w", ErrConfigInvalid, c.Name, err)
+		}
+		db.SetConnMaxIdleTime(time.Minute)
+		d.databases = append(d.databases, &database{config: c, db: db})
+	}
+	return d, nil
+}
+
+// Close closes all the databases.
+func (d *Databases) Close() error {
+	var errs []error
+	for _, db := range d.databases {
+		if err := db.db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// get returns the named database, or the first if name is empty.
+func (d *Databases) get(name string) (*database, error) {
+	if name == "" {
+		return d.databases[0], nil
+	}
+	for _, db := range d.databases {
+		if db.config.Name == name {
+			return db, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoDatabase, name)
+}
+
+// ListTablesInput is the input to sql_list_tables.
+type ListTablesInput struct {
+	Database string `json:"database,omitempty" description:"Name of the database; default is the first."`
+}
+
+// Table is a table or view listed by sql_list_tables.
+type Table struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // table or view
+}
+
+// ListTablesResult is the output of sql_list_tables.
+type ListTablesResult struct {
+	Database string   `json:"database"`
+	Tables   []*Table `json:"tables"`
+}
+
+// ListTables lists the tables and views in a database.
+func (d *Databases) ListTables(ctx context.Context, in ListTablesInput) (*ListTablesResult, error) {
+	db, err := d.get(in.Database)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := db.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ListTablesResult{Database: db.config.Name, Tables: tables}, nil
+}
+
+func (db *database) tables(ctx context.Context) ([]*Table, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.config.Timeout)
+	defer cancel()
+	q := `SELECT table_name, table_type FROM information_schema.tables
+WHERE table_schema NOT IN ('information_schema', 'pg_catalog', 'mysql', 'performance_schema', 'sys')
+ORDER BY table_name`
+	if db.config.isSqlite() {
+		q = `SELECT name, type FROM sqlite_master
+WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+ORDER BY name`
+	}
+	rows, err := db.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := []*Table{}
+	for rows.Next() {
+		var name, kind any
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, err
+		}
+		t := &Table{Name: asString(name), Type: strings.ToLower(asString(kind))}
+		if strings.Contains(t.Type, "view") {
+			t.Type = "view"
+		} else {
+			t.Type = "table"
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// DescribeTableInput is the input to sql_describe_table.
+type DescribeTableInput struct {
+	Database string `json:"database,omitempty" description:"Name of the database; default is the first."`
+	Table    string `json:"table" description:"Name of the table or view."`
+}
+
+// Column is a column described by sql_describe_table.
+type Column struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Nullable   bool   `json:"nullable"`
+	Default    string `json:"default,omitempty"`
+	PrimaryKey bool   `json:"primary_key,omitempty"`
+}
+
+// DescribeTableResult is the output of sql_describe_table.
+type DescribeTableResult struct {
+	Database string    `json:"database"`
+	Table    string    `json:"table"`
+	Columns  []*Column `json:"columns"`
+}
+
+// DescribeTable describes the columns of a table or view, which must be
+// one listed by ListTables.
+func (d *Databases) DescribeTable(ctx context.Context, in DescribeTableInput) (*DescribeTableResult, error) {
+	db, err := d.get(in.Database)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := db.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(tables, func(t *Table) bool { return t.Name == in.Table }) {
+		return nil, fmt.Errorf("%w: %q", ErrNoTable, in.Table)
+	}
+	columns, err := db.columns(ctx, in.Table)
+	if err != nil {
+		return nil, err
+	}
+	return &DescribeTableResult{Database: db.config.Name, Table: in.Table, Columns: columns}, nil
+}
+
+// columns returns the columns of table, which must be a known table name,
+// as it is quoted into the query.
+func (db *database) columns(ctx context.Context, table string) ([]*Column, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.config.Timeout)
+	defer cancel()
+	q := `SELECT column_name, data_type, is_nullable, column_default, 0
+FROM information_schema.columns WHERE table_name = '` + strings.ReplaceAll(table, "'", "''") + `'
+ORDER BY ordinal_position`
+	if db.config.isSqlite() {
+		q = `SELECT name, type, "notnull" = 0, dflt_value, pk
+FROM pragma_table_info('` + strings.ReplaceAll(table, "'", "''") + `') ORDER BY cid`
+	}
+	rows, err := db.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := []*Column{}
+	for rows.Next() {
+		var name, kind, nullable, dflt, pk any
+		if err := rows.Scan(&name, &kind, &nullable, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		col := &Column{
+			Name:       asString(name),
+			Type:       asString(kind),
+			Default:    asString(dflt),
+			PrimaryKey: asString(pk) != "0",
+		}
+		switch v := nullable.(type) {
+		case int64:
+			col.Nullable = v != 0
+		default:
+			col.Nullable = strings.EqualFold(asString(v), "yes")
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// asString returns v, as scanned from a database, as a string.
+func asString(v any) string {
+	switch tv := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(tv)
+	case string:
+		return tv
+	default:
+		return fmt.Sprint(tv)
+	}
+}
+
+// QueryInput is the input to sql_query.
+type QueryInput struct {
+	Database string `json:"database,omitempty" description:"Name of the database; default is the first."`
+	Sql      string `json:"sql" description:"One SQL statement, with any values written inline."`
+}
+
+// QueryResult is the output of sql_query.  Statements that do not return
+// rows have RowsAffected instead.
+type QueryResult struct {
+	Database     string   `json:"database"`
+	Columns      []string `json:"columns"`
+	Rows         [][]any  `json:"rows"`
+	RowsAffected *int64   `json:"rows_affected,omitempty"`
+	Truncated    bool     `json:"truncated,omitempty"`
+}
+
+// Query runs one SQL statement, which must be of an allowed type, within
+// the time, row and byte limits of the database.  The limits are not
+// errors: rows past them are left out and Truncated is set.
+func (d *Databases) Query(ctx context.Context, in QueryInput) (*QueryResult, error) {
+	db, err := d.get(in.Database)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := checkStatement(in.Sql, db.config.Statements)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, db.config.Timeout)
+	defer cancel()
+
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if !db.config.ReadWrite && db.config.isSqlite() {
+		// Harmless to repeat, and the connection stays query-only.
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := conn.BeginTx(ctx, &stdsql.TxOptions{ReadOnly: !db.config.ReadWrite})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // no-op after Commit
+
+	res := &QueryResult{Database: db.config.Name, Columns: []string{}, Rows: [][]any{}}
+	if !slices.Contains(queryStatements, stmt) {
+		r, err := tx.ExecContext(ctx, in.Sql)
+		if err != nil {
+			return nil, err
+		}
+		n, err := r.RowsAffected()
+		if err == nil {
+			res.RowsAffected = &n
+		}
+		return res, tx.Commit()
+	}
+
+	rows, err := tx.QueryContext(ctx, in.Sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if res.Columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	size := 0
+	for rows.Next() {
+		if len(res.Rows) >= db.config.MaxRows {
+			res.Truncated = true
+			break
+		}
+		values := make([]any, len(res.Columns))
+		ptrs := make([]any, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			values[i] = jsonValue(v)
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		if size+len(b) > db.config.MaxBytes {
+			res.Truncated = true
+			break
+		}
+		size += len(b)
+		res.Rows = append(res.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return res, tx.Commit()
+}
+
+// jsonValue returns v, as scanned from a database, as a JSON-friendly
+// value.  Binary data is described rather than returned.
+func jsonValue(v any) any {
+	if b, ok := v.([]byte); ok {
+		if utf8.Valid(b) {
+			return string(b)
+		}
+		return fmt.Sprintf("(%d bytes of binary data)", len(b))
+	}
+	return v
+}
+
+// describe returns a description of the databases for tool descriptions.
+func (d *Databases) describe() string {
+	lines := []string{}
+	for _, db := range d.databases {
+		c := db.config
+		mode := "read-only"
+		if c.ReadWrite {
+			mode = "read-write"
+		}
+		line := fmt.Sprintf("- %s (%s, %s)", c.Name, c.Driver, mode)
+		if c.Description != "" {
+			line += ": " + c.Description
+		}
+		lines = append(lines, line)
+	}
+	return "Databases:\n" + strings.Join(lines, "\n")
+}
+
+// risk returns the risk of reading and of querying the databases: local
+// SQLite reads are read-only, other reads talk to the network, and any
+// read-write database can have its data destroyed.
+func (d *Databases) risk() (tools.Risk, tools.Risk) {
+	read := tools.RiskReadOnly
+	query := tools.RiskReadOnly
+	for _, db := range d.databases {
+		if !db.config.isSqlite() {
+			read = tools.RiskNetwork
+		}
+		if db.config.ReadWrite {
+			query = tools.RiskDestructive
+		}
+	}
+	if read.Level() > query.Level() {
+		query = read
+	}
+	return read, query
+}
+
+// Tools returns the sql tools for d.
+func (d *Databases) Tools() []tools.Tooler {
+	read_risk, query_risk := d.risk()
+	read_meta := &tools.Metadata{Tags: []string{"sql"}, Risk: read_risk}
+	query_meta := &tools.Metadata{Tags: []string{"sql"}, Risk: query_risk}
+	dbs := d.describe()
+	statements := map[string]bool{}
+	for _, db := range d.databases {
+		for _, s := range db.config.Statements {
+			statements[strings.ToUpper(s)] = true
+		}
+	}
+	allowed := strings.Join(slices.Sorted(maps.Keys(statements)), ", ")
+	return []tools.Tooler{
+		tools.NewTool[ListTablesInput, *ListTablesResult](
+			"sql_list_tables",
+			"Lists the tables and views in a database.\n\n"+dbs,
+			d.ListTables,
+		).WithMetadata(read_meta),
+		tools.NewTool[DescribeTableInput, *DescribeTableResult](
+			"sql_describe_table",
+			"Describes the columns of a table or view in a database.\n\n"+dbs,
+			d.DescribeTable,
+		).WithMetadata(read_meta),
+		tools.NewTool[QueryInput, *QueryResult](
+			"sql_query",
+			"Runs one SQL statement against a database, returning the columns and rows.  "+
+				"Results are limited in size, and truncated is set if rows were left out.  "+
+				"Allowed statements: "+allowed+".  Read-write databases can be changed, "+
+				"and data there can be lost.\n\n"+dbs,
+			d.Query,
+		).WithMetadata(query_meta),
+	}
+}
+
+// Register registers the sql tools for cfg in reg, returning the Databases,
+// which must be closed when the tools are no longer needed.  Idle
+// connections are closed after a minute.
+func Register(reg *registry.Registry, cfg *Config) (*Databases, error) {
+	d, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range d.Tools() {
+		if err := reg.Register(tool); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,377 @@
+package sql_test
+
+import (
+	"context"
+	stdsql "database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/sql"
+)
+
+// testDatabase returns the path of a new SQLite database with some data.
+func testDatabase(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := stdsql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE animals (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			legs INTEGER DEFAULT 4,
+			photo BLOB
+		)`,
+		`INSERT INTO animals (name, legs, photo) VALUES
+			('fox', 4, x'00ff'), ('hen', 2, NULL), ('snake', 0, 'not binary')`,
+		`CREATE VIEW bipeds AS SELECT name FROM animals WHERE legs = 2`,
+	} {
+		_, err := db.Exec(stmt)
+		require.NoError(t, err)
+	}
+	return path
+}
+
+func testDatabases(t *testing.T, cfgs ...*sql.DatabaseConfig) *sql.Databases {
+	t.Helper()
+	d, err := sql.New(&sql.Config{Databases: cfgs})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a", DSN: "a.db"}}}
+	require.NoError(cfg.Validate())
+	db := cfg.Databases[0]
+	require.Equal("sqlite", db.Driver)
+	require.Equal(sql.ReadOnlyStatements, db.Statements)
+	require.Equal(sql.DefaultMaxRows, db.MaxRows)
+	require.Equal(sql.DefaultMaxBytes, db.MaxBytes)
+	require.Equal(sql.DefaultTimeout, db.Timeout)
+
+	db = &sql.DatabaseConfig{Name: "b", DSN: "b.db", ReadWrite: true, Statements: []string{" SELECT", "Insert"}}
+	require.NoError(db.Validate())
+	require.Equal([]string{"select", "insert"}, db.Statements)
+	db = &sql.DatabaseConfig{Name: "b", DSN: "b.db", ReadWrite: true}
+	require.NoError(db.Validate())
+	require.Equal(sql.ReadWriteStatements, db.Statements)
+
+	for _, tc := range []struct {
+		cfg *sql.Config
+		msg string
+	}{
+		{&sql.Config{}, "no databases"},
+		{&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a b", DSN: "x"}}}, `bad database name "a b"`},
+		{&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a"}}}, `no dsn for "a"`},
+		{&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a", DSN: "x", Driver: "nope"}}},
+			`driver "nope" for "a" is not available`},
+		{&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a", DSN: "x", Statements: []string{"drop table"}}}},
+			`bad statement type "drop table" for "a"`},
+		{&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a", DSN: "x", MaxRows: -1}}},
+			`negative limit for "a"`},
+		{&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "a", DSN: "x"}, {Name: "a", DSN: "y"}}},
+			`duplicate database name "a"`},
+	} {
+		err := tc.cfg.Validate()
+		require.ErrorIs(err, sql.ErrConfigInvalid, tc.msg)
+		require.ErrorContains(err, tc.msg)
+	}
+
+}
+
+func TestNewExpandsDSN(t *testing.T) {
+
+	require := require.New(t)
+
+	path := testDatabase(t)
+	t.Setenv("TEST_SQL_DIR", filepath.Dir(path))
+	d := testDatabases(t, &sql.DatabaseConfig{Name: "test", DSN: "${TEST_SQL_DIR}/test.db"})
+	res, err := d.ListTables(context.Background(), sql.ListTablesInput{})
+	require.NoError(err)
+	require.Len(res.Tables, 2)
+
+	_, err = sql.New(&sql.Config{Databases: []*sql.DatabaseConfig{
+		{Name: "test", DSN: "$TEST_SQL_NOPE/$TEST_SQL_NADA"},
+	}})
+	require.ErrorIs(err, sql.ErrConfigInvalid)
+	require.ErrorContains(err, `dsn for "test" references unset TEST_SQL_NOPE, TEST_SQL_NADA`)
+
+}
+
+func TestListTablesAndDescribeTable(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	d := testDatabases(t,
+		&sql.DatabaseConfig{Name: "first", DSN: testDatabase(t)},
+		&sql.DatabaseConfig{Name: "second", DSN: testDatabase(t)},
+	)
+
+	res, err := d.ListTables(ctx, sql.ListTablesInput{Database: "second"})
+	require.NoError(err)
+	require.Equal("second", res.Database)
+	require.Equal([]*sql.Table{{Name: "animals", Type: "table"}, {Name: "bipeds", Type: "view"}}, res.Tables)
+
+	_, err = d.ListTables(ctx, sql.ListTablesInput{Database: "third"})
+	require.ErrorIs(err, sql.ErrNoDatabase)
+
+	desc, err := d.DescribeTable(ctx, sql.DescribeTableInput{Table: "animals"})
+	require.NoError(err)
+	require.Equal("first", desc.Database)
+	require.Equal([]*sql.Column{
+		{Name: "id", Type: "INTEGER", Nullable: true, PrimaryKey: true},
+		{Name: "name", Type: "TEXT", Nullable: false},
+		{Name: "legs", Type: "INTEGER", Nullable: true, Default: "4"},
+		{Name: "photo", Type: "BLOB", Nullable: true},
+	}, desc.Columns)
+
+	desc, err = d.DescribeTable(ctx, sql.DescribeTableInput{Table: "bipeds"})
+	require.NoError(err)
+	require.Len(desc.Columns, 1)
+
+	_, err = d.DescribeTable(ctx, sql.DescribeTableInput{Table: "animals') --"})
+	require.ErrorIs(err, sql.ErrNoTable)
+	_, err = d.DescribeTable(ctx, sql.DescribeTableInput{Database: "nope", Table: "animals"})
+	require.ErrorIs(err, sql.ErrNoDatabase)
+
+}
+
+func TestQuery(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	d := testDatabases(t, &sql.DatabaseConfig{Name: "test", DSN: testDatabase(t)})
+
+	res, err := d.Query(ctx, sql.QueryInput{Sql: "-- the animals\nSELECT name, legs, photo FROM animals ORDER BY id;"})
+	require.NoError(err)
+	require.Equal([]string{"name", "legs", "photo"}, res.Columns)
+	require.Equal([][]any{
+		{"fox", int64(4), "(2 bytes of binary data)"},
+		{"hen", int64(2), nil},
+		{"snake", int64(0), "not binary"},
+	}, res.Rows)
+	require.False(res.Truncated)
+	require.Nil(res.RowsAffected)
+
+	res, err = d.Query(ctx, sql.QueryInput{Database: "test", Sql: "WITH x AS (SELECT 1 AS n) SELECT n FROM x WHERE n > 1"})
+	require.NoError(err)
+	require.Equal([]string{"n"}, res.Columns)
+	require.Equal([][]any{}, res.Rows)
+
+	_, err = d.Query(ctx, sql.QueryInput{Sql: "SELECT nope FROM animals"})
+	require.ErrorContains(err, "no such column")
+	_, err = d.Query(ctx, sql.QueryInput{Database: "nope", Sql: "SELECT 1"})
+	require.ErrorIs(err, sql.ErrNoDatabase)
+
+}
+
+func TestQueryReadOnly(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	path := testDatabase(t)
+	d := testDatabases(t, &sql.DatabaseConfig{
+		Name:       "test",
+		DSN:        path,
+		Statements: []string{"select", "with", "insert"},
+	})
+
+	for _, tc := range []struct {
+		sql string
+		msg string
+	}{
+		{"DELETE FROM animals", "DELETE statements (allowed: SELECT, WITH, INSERT)"},
+		{"/* sneaky */ drop table animals", "DROP statements"},
+		{"SELECT 1; DELETE FROM animals", "more than one statement"},
+		{"SELECT 1;;", "no statement"},
+		{"  -- nothing", "no statement"},
+		{"SELECT 'oops", "unterminated quote"},
+		{"SELECT 1 /* oops", "unterminated comment"},
+	} {
+		_, err := d.Query(ctx, sql.QueryInput{Sql: tc.sql})
+		require.ErrorIs(err, sql.ErrNotAllowed, tc.sql)
+		require.ErrorContains(err, tc.msg, tc.sql)
+	}
+
+	// Semicolons in strings and comments are not statement ends.
+	res, err := d.Query(ctx, sql.QueryInput{Sql: `SELECT 'a;b' AS "x;y" -- ; DELETE`})
+	require.NoError(err)
+	require.Equal([][]any{{"a;b"}}, res.Rows)
+
+	// Allowed statements still cannot write.
+	_, err = d.Query(ctx, sql.QueryInput{Sql: "INSERT INTO animals (name) VALUES ('cat')"})
+	require.Error(err)
+	_, err = d.Query(ctx, sql.QueryInput{Sql: "WITH x AS (SELECT 1) DELETE FROM animals"})
+	require.Error(err)
+	res, err = d.Query(ctx, sql.QueryInput{Sql: "SELECT count(*) FROM animals"})
+	require.NoError(err)
+	require.Equal([][]any{{int64(3)}}, res.Rows)
+
+	// Nor are missing files created.
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	d = testDatabases(t, &sql.DatabaseConfig{Name: "missing", DSN: missing})
+	_, err = d.Query(ctx, sql.QueryInput{Sql: "SELECT 1"})
+	require.Error(err)
+	require.NoFileExists(missing)
+
+}
+
+func TestQueryReadOnlyDsnQuery(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	for _, dsn := range []string{"file:%s?cache=shared", "%s?_txlock=deferred"} {
+
+		// Even turning off query_only does not allow writes.
+		path := testDatabase(t)
+		d := testDatabases(t, &sql.DatabaseConfig{
+			Name:       "test",
+			DSN:        fmt.Sprintf(dsn, path),
+			Statements: []string{"select", "pragma", "insert"},
+		})
+		_, err := d.Query(ctx, sql.QueryInput{Sql: "PRAGMA query_only=0"})
+		require.NoError(err, dsn)
+		_, err = d.Query(ctx, sql.QueryInput{Sql: "INSERT INTO animals (name) VALUES ('cat')"})
+		require.ErrorContains(err, "readonly", dsn)
+		res, err := d.Query(ctx, sql.QueryInput{Sql: "SELECT count(*) FROM animals"})
+		require.NoError(err, dsn)
+		require.Equal([][]any{{int64(3)}}, res.Rows, dsn)
+
+		missing := filepath.Join(t.TempDir(), "missing.db")
+		d = testDatabases(t, &sql.DatabaseConfig{Name: "missing", DSN: fmt.Sprintf(dsn, missing)})
+		_, err = d.Query(ctx, sql.QueryInput{Sql: "SELECT 1"})
+		require.Error(err, dsn)
+		require.NoFileExists(missing, dsn)
+	}
+
+	// Other modes are not read-only.
+	for _, dsn := range []string{"file:x.db?mode=rwc", "x.db?mode=ro&mode=rw"} {
+		_, err := sql.New(&sql.Config{Databases: []*sql.DatabaseConfig{{Name: "test", DSN: dsn}}})
+		require.ErrorIs(err, sql.ErrConfigInvalid, dsn)
+		require.ErrorContains(err, `dsn for "test" sets mode=rw`, dsn)
+	}
+
+}
+
+func TestQueryReadWrite(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	d := testDatabases(t, &sql.DatabaseConfig{Name: "test", DSN: testDatabase(t), ReadWrite: true})
+
+	res, err := d.Query(ctx, sql.QueryInput{Sql: "UPDATE animals SET legs = legs + 1 WHERE legs < 4"})
+	require.NoError(err)
+	require.NotNil(res.RowsAffected)
+	require.EqualValues(2, *res.RowsAffected)
+	require.Equal([]string{}, res.Columns)
+
+	res, err = d.Query(ctx, sql.QueryInput{Sql: "SELECT sum(legs) FROM animals"})
+	require.NoError(err)
+	require.Equal([][]any{{int64(8)}}, res.Rows)
+
+	_, err = d.Query(ctx, sql.QueryInput{Sql: "DROP TABLE animals"})
+	require.ErrorIs(err, sql.ErrNotAllowed)
+
+}
+
+func TestQueryLimits(t *testing.T) {
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	path := testDatabase(t)
+	d := testDatabases(t,
+		&sql.DatabaseConfig{Name: "rows", DSN: path, MaxRows: 2},
+		&sql.DatabaseConfig{Name: "bytes", DSN: path, MaxBytes: 25},
+		&sql.DatabaseConfig{Name: "time", DSN: path, Timeout: time.Millisecond},
+	)
+
+	res, err := d.Query(ctx, sql.QueryInput{Database: "rows", Sql: "SELECT name FROM animals ORDER BY id"})
+	require.NoError(err)
+	require.Equal([][]any{{"fox"}, {"hen"}}, res.Rows)
+	require.True(res.Truncated)
+
+	res, err = d.Query(ctx, sql.QueryInput{Database: "rows", Sql: "SELECT name FROM animals LIMIT 2"})
+	require.NoError(err)
+	require.Len(res.Rows, 2)
+	require.False(res.Truncated)
+
+	// The rows are 9, 9 and 11 bytes as JSON.
+	res, err = d.Query(ctx, sql.QueryInput{Database: "bytes", Sql: "SELECT name, legs FROM animals ORDER BY id"})
+	require.NoError(err)
+	require.Equal([][]any{{"fox", int64(4)}, {"hen", int64(2)}}, res.Rows)
+	require.True(res.Truncated)
+
+	_, err = d.Query(ctx, sql.QueryInput{Database: "time", Sql: `WITH RECURSIVE n(i) AS
+		(SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n`})
+	require.Error(err)
+
+}
+
+func TestRegister(t *testing.T) {
+
+	require := require.New(t)
+
+	path := testDatabase(t)
+	reg := registry.New()
+	d, err := sql.Register(reg, &sql.Config{Databases: []*sql.DatabaseConfig{
+		{Name: "zoo", Description: "Animals and legs.", DSN: path},
+	}})
+	require.NoError(err)
+	defer d.Close()
+	require.Equal([]string{"sql_list_tables", "sql_describe_table", "sql_query"}, reg.Names())
+	for _, name := range reg.Names() {
+		tool, err := reg.Get(name)
+		require.NoError(err)
+		meta := tools.MetadataOf(tool)
+		require.True(meta.HasTag("sql"), name)
+		require.Equal(tools.RiskReadOnly, meta.Risk, name)
+		require.Contains(tool.Description(), "- zoo (sqlite, read-only): Animals and legs.", name)
+	}
+
+	tool, err := reg.Get("sql_query")
+	require.NoError(err)
+	require.Contains(tool.Description(), "Allowed statements: EXPLAIN, SELECT, WITH.")
+	res, err := tool.Exec(context.Background(), `{"sql":"SELECT name FROM bipeds"}`)
+	require.NoError(err)
+	require.Equal([][]any{{"hen"}}, res.(*sql.QueryResult).Rows)
+
+	reg = registry.New()
+	d, err = sql.Register(reg, &sql.Config{Databases: []*sql.DatabaseConfig{
+		{Name: "zoo", DSN: path},
+		{Name: "scratch", DSN: filepath.Join(t.TempDir(), "scratch.db"), ReadWrite: true},
+	}})
+	require.NoError(err)
+	require.NoError(d.Close())
+	tool, err = reg.Get("sql_list_tables")
+	require.NoError(err)
+	require.Equal(tools.RiskReadOnly, tools.MetadataOf(tool).Risk)
+	tool, err = reg.Get("sql_query")
+	require.NoError(err)
+	require.Equal(tools.RiskDestructive, tools.MetadataOf(tool).Risk)
+	require.True(strings.Contains(tool.Description(), "- scratch (sqlite, read-write)"))
+
+	_, err = sql.Register(registry.New(), &sql.Config{})
+	require.ErrorIs(err, sql.ErrConfigInvalid)
+	reg.Lock()
+	_, err = sql.Register(reg, &sql.Config{Databases: []*sql.DatabaseConfig{{Name: "zoo", DSN: path}}})
+	require.ErrorContains(err, `failed to register "sql_list_tables"`)
+
+}
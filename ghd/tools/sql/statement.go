@@ -0,0 +1,93 @@
+// tools/sql/statement.go
+
+package sql
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// checkStatement returns the type of stmt, being its first keyword in lower
+// case, if it is a single statement of an allowed type.  A trailing
+// semicolon is allowed.
+func checkStatement(stmt string, allowed []string) (string, error) {
+	body, err := statementBody(stmt)
+	if err != nil {
+		return "", err
+	}
+	end := strings.IndexFunc(body, func(r rune) bool {
+		return !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'))
+	})
+	if end == -1 {
+		end = len(body)
+	}
+	kind := strings.ToLower(body[:end])
+	if kind == "" {
+		return "", fmt.Errorf("%w: no statement", ErrNotAllowed)
+	}
+	if !slices.Contains(allowed, kind) {
+		return "", fmt.Errorf("%w: %s statements (allowed: %s)",
+			ErrNotAllowed, strings.ToUpper(kind), strings.ToUpper(strings.Join(allowed, ", ")))
+	}
+	return kind, nil
+}
+
+// statementBody returns stmt from its first keyword, after any comments
+// and whitespace, or an error if stmt has more than one statement or an
+// unterminated string or comment.
+func statementBody(stmt string) (string, error) {
+	start := -1
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			continue
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end == -1 {
+				i = len(stmt)
+			} else {
+				i += end
+			}
+			continue
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end == -1 {
+				return "", fmt.Errorf("%w: unterminated comment", ErrNotAllowed)
+			}
+			i += end + 3
+			continue
+		case c == ';':
+			if start == -1 {
+				return "", fmt.Errorf("%w: no statement", ErrNotAllowed)
+			}
+			rest, err := statementBody(stmt[i+1:])
+			if err != nil {
+				return "", err
+			}
+			if rest != "" {
+				return "", fmt.Errorf("%w: more than one statement", ErrNotAllowed)
+			}
+			return stmt[start:i], nil
+		}
+		if start == -1 {
+			start = i
+		}
+		if c == '\'' || c == '"' || c == '`' || c == '[' {
+			close := c
+			if c == '[' {
+				close = ']'
+			}
+			end := strings.IndexByte(stmt[i+1:], close)
+			if end == -1 {
+				return "", fmt.Errorf("%w: unterminated quote", ErrNotAllowed)
+			}
+			i += end + 1 // doubled quotes are simply two strings
+		}
+	}
+	if start == -1 {
+		return "", nil
+	}
+	return stmt[start:], nil
+}
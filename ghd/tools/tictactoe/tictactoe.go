@@ -194,6 +194,12 @@ func init() {
 		},
 	)
 
+	// Games are only kept in memory, so nothing outside is touched.
+	meta := &tools.Metadata{Tags: []string{"game"}, Risk: tools.RiskReadOnly}
+	new.WithMetadata(meta)
+	state.WithMetadata(meta)
+	move.WithMetadata(meta)
+
 	if err := registry.Register(new); err != nil {
 		panic(err)
 	}
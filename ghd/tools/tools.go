@@ -1,10 +1,10 @@
 // Package tools defines the types for tools (functions) available to the
 // LLMs.
 //
-// NOTE: this currently uses go-openai's jsonschema which is minimalistic and
-// *might* break for more complex input types.  If so we can fall back to
-// github.com/invopop/jsonschema, however that package gives such complete
-// schemas that the LLMs might choke on them.
+// NOTE: input schemas are generated by GenerateSchema, which deliberately
+// supports only the parts of JSON Schema that LLMs handle well.  Packages
+// such as github.com/invopop/jsonschema give such complete schemas that the
+// LLMs might choke on them.
 //
 // NOTE: as of this writing, ChatGPT does *not* support JSON schemas for tool
 // output.  It expects a string, which could be JSON -- and if it is, the
@@ -16,9 +16,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
 
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/biztos/greenhead/ghd/schema"
+	"github.com/biztos/greenhead/ghd/utils"
 )
 
 // Tooler defines the interface to which Tools conform.
@@ -27,6 +29,10 @@ import (
 // Tool[T, R] altogether and define your own type.
 //
 // For simple use-cases, just use NewTool.
+//
+// API clients define tools to the LLM from their Definition; see Define.
+// Toolers need no API-specific methods, though an OpenAiTool method is
+// still honored by the OpenAI client for older tools.
 type Tooler interface {
 
 	// Name returns the immutable name of the tool, which will be used both
@@ -48,32 +54,50 @@ type Tooler interface {
 
 	// Help returns information on the tool for a human user of the CLI.
 	Help() string
-
-	// OpenAiTool returns a valid openai.Tool.
-	//
-	// TODO: move this!  Since it's derived from the above things, no sense in
-	// making someone implement it.  Put in apis maybe?  But we may have our
-	// own API at some point...
-	OpenAiTool() openai.Tool
 }
 
-// We always need to send properties in the JSON schema!
-type propertiedSchema struct {
-	jsonschema.Definition
-	Properties map[string]any `json:"properties"`
-	Required   []string       `json:"required"`
+// OutputTooler may be implemented by Toolers that declare the shape of their
+// output, which is the Data of the Result sent to the LLM.
+type OutputTooler interface {
+	Tooler
+
+	// OutputSchema returns a JSON schema describing the non-error output of
+	// Exec, or nil if it is not known.
+	OutputSchema() any
 }
 
-// Override the jsonschema.Definition's MarshalJSON which eats Properties.
-func (p *propertiedSchema) MarshalJSON() ([]byte, error) {
+// OutputSchema returns the output schema of t, or nil if it does not declare
+// one.
+func OutputSchema(t Tooler) any {
+	if ot, ok := t.(OutputTooler); ok {
+		return ot.OutputSchema()
+	}
+	return nil
+}
 
-	type Alias propertiedSchema
-	return json.Marshal(struct {
-		Alias
-	}{
-		Alias: (Alias)(*p),
+// schemaHelp returns the Help section for schema s, which should not be
+// nil.
+// ExpandEnv expands "$VAR" and "${VAR}" in s from the environment, as
+// os.ExpandEnv does, also returning the names of any unset variables, which
+// configs should treat as an error rather than expanding to nothing.
+func ExpandEnv(s string) (string, []string) {
+	missing := []string{}
+	v := os.Expand(s, func(name string) string {
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return val
 	})
+	return v, missing
+}
 
+func schemaHelp(label string, s any) string {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(err.Error())
+	}
+	return fmt.Sprintf("%s Schema:\n\n%s\n\n", label, string(b))
 }
 
 // Tool is a tool which can be called by LLMs once registered.
@@ -83,30 +107,35 @@ func (p *propertiedSchema) MarshalJSON() ([]byte, error) {
 // entirety; R must be serializable as a property; otherwise runtime errors
 // will occur when the tool is called by an Agent.
 type Tool[T any, R any] struct {
-	name    string
-	desc    string
-	f       func(context.Context, T) (R, error)
-	zeroT   T // arguably only need the schemas but keep around for now.
-	zeroR   R // ...because perhaps useful for error messages etc.
-	schemaT *propertiedSchema
+	name      string
+	desc      string
+	f         func(context.Context, T) (R, error)
+	zeroT     T // arguably only need the schemas but keep around for now.
+	zeroR     R // ...because perhaps useful for error messages etc.
+	schemaT   *JsonSchema
+	schemaR   *JsonSchema
+	validator *schema.Schema
+	meta      *Metadata
 }
 
 // NewTool returns a Tool for input type T and output type R.
+//
+// The input schema is generated from T by GenerateSchema, so struct fields
+// can carry descriptions and constraints in their tags.  NewTool panics if
+// the schema can not be generated.  The output schema is generated from R,
+// if possible.
 func NewTool[T any, R any](name, desc string, f func(context.Context, T) (R, error)) *Tool[T, R] {
 	var zeroT T
 	var zeroR R
-	// Hackaround for the problem of schema Properties being set to omitempty.
-	schema, err := jsonschema.GenerateSchemaForType(zeroT)
+	schemaT, err := GenerateSchema(reflect.TypeFor[T]())
 	if err != nil {
 		panic(fmt.Sprintf("Input Schema for %s %T: %s", name, zeroT, err))
 	}
-	schemaT := &propertiedSchema{*schema, map[string]any{}, schema.Required}
-	for k, v := range schema.Properties {
-		schemaT.Properties[k] = v
-	}
-	if schema.Required == nil {
-		schemaT.Required = []string{}
+	validator, err := schema.Compile(utils.MustJsonString(schemaT))
+	if err != nil {
+		panic(fmt.Sprintf("Input Schema for %s %T: %s", name, zeroT, err))
 	}
+	schemaR, _ := GenerateSchema(reflect.TypeFor[R]()) // nil if not possible.
 	return &Tool[T, R]{
 		name:  name,
 		desc:  desc,
@@ -115,7 +144,9 @@ func NewTool[T any, R any](name, desc string, f func(context.Context, T) (R, err
 		zeroR: zeroR,
 		// Reflect once, we will be handing these out like candy later.
 		// (Although that also involves reflection, so... bench it someday.)
-		schemaT: schemaT,
+		schemaT:   schemaT,
+		schemaR:   schemaR,
+		validator: validator,
 	}
 }
 
@@ -134,13 +165,27 @@ func (t *Tool[T, R]) InputSchema() any {
 	return t.schemaT
 }
 
+var ErrInvalidInput = fmt.Errorf("tool input invalid")
+
 // Exec implements Tooler by calling its function with args as a JSON string.
+//
+// The args are validated against the input schema first, and defaults from
+// the schema are set for missing properties.  Validation errors wrap
+// ErrInvalidInput and describe each problem, for the LLM to correct.
 func (t *Tool[T, R]) Exec(ctx context.Context, args string) (any, error) {
 	var input T
-	err := json.Unmarshal([]byte(args), &input)
+	v, err := t.validator.ValidateJson(args)
 	if err != nil {
 		// This could be programmer/prompter error or a hallucination; at
 		// least openAI docs *say* the JSON schema should be respected.
+		return nil, fmt.Errorf("%w for %T: %w", ErrInvalidInput, input, err)
+	}
+	applyDefaults(t.schemaT, v)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %T: %w", ErrInvalidInput, input, err)
+	}
+	if err := json.Unmarshal(b, &input); err != nil {
 		return nil, fmt.Errorf("error parsing json for %T: %w", input, err)
 	}
 	return t.f(ctx, input)
@@ -150,29 +195,47 @@ func (t *Tool[T, R]) Exec(ctx context.Context, args string) (any, error) {
 // Tool.
 func (t *Tool[T, R]) Help() string {
 	s := fmt.Sprintf("%s\n\n%s\n\n", t.name, t.desc)
-	b, err := json.MarshalIndent(t.schemaT, "", "  ")
-	if err != nil {
-		panic(err.Error())
+	s += schemaHelp("Input", t.schemaT)
+	if t.schemaR != nil {
+		s += schemaHelp("Output", t.schemaR)
 	}
-	s += fmt.Sprintf("Input Schema:\n\n%s\n\n", string(b))
 	s += fmt.Sprintf("Return Type: %T, error\n", t.zeroR)
 
 	return s
 }
 
-// OpenAiTool implements Tooler and returns an OpenAI-compatible definition
-// of t.
+// OutputSchema implements OutputTooler, returning the schema generated from
+// R, or nil if none could be generated.
+func (t *Tool[T, R]) OutputSchema() any {
+	if t.schemaR == nil {
+		return nil
+	}
+	return t.schemaR
+}
+
+// WithMetadata sets the Metadata of t and returns t, for use in
+// registration:
 //
-// "Strict" is assumed and the ToolType is always "Function."
-func (t *Tool[T, R]) OpenAiTool() openai.Tool {
-	return openai.Tool{
-		Type: openai.ToolTypeFunction,
-		Function: &openai.FunctionDefinition{
-			Name:        t.name,
-			Description: t.desc,
-			Strict:      true,
-			// TODO: prove this works, it *should* be good to go.
-			Parameters: t.schemaT,
-		},
+//	registry.Register(tools.NewTool(...).WithMetadata(&tools.Metadata{...}))
+//
+// It panics if m is not valid.
+func (t *Tool[T, R]) WithMetadata(m *Metadata) *Tool[T, R] {
+	if err := m.Validate(); err != nil {
+		panic(fmt.Sprintf("Metadata for %s: %s", t.name, err))
 	}
+	t.meta = m
+	return t
+}
+
+// Metadata implements MetadataTooler.
+func (t *Tool[T, R]) Metadata() *Metadata {
+	return t.meta
+}
+
+// Definition implements Definer, being Strict if all the properties of T
+// are required.
+func (t *Tool[T, R]) Definition() *Definition {
+	d := DefaultDefinition(t)
+	d.Strict = isStrict(t.schemaT)
+	return d
 }
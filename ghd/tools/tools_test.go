@@ -0,0 +1,104 @@
+package tools_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+type PaintInput struct {
+	Color string   `json:"color" jsonschema:"enum=red,green;default=red;optional"`
+	Coats int      `json:"coats" jsonschema:"minimum=1;maximum=3"`
+	Walls []string `json:"walls,omitempty" jsonschema:"minLength=1"`
+}
+
+func paintTool() *tools.Tool[PaintInput, PaintInput] {
+	return tools.NewTool("paint", "Paint walls.",
+		func(ctx context.Context, in PaintInput) (PaintInput, error) {
+			return in, nil
+		})
+}
+
+func TestToolExec(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := paintTool()
+	res, err := tool.Exec(context.Background(), `{"coats":2,"walls":["north"]}`)
+	require.NoError(err)
+	require.Equal(PaintInput{Color: "red", Coats: 2, Walls: []string{"north"}}, res,
+		"default applied")
+
+	res, err = tool.Exec(context.Background(), `{"coats":1,"color":"green"}`)
+	require.NoError(err)
+	require.Equal(PaintInput{Color: "green", Coats: 1}, res)
+
+	for exp, input := range map[string]string{
+		"invalid JSON":                    `{`,
+		"at /: missing property 'coats'":  `{}`,
+		"at /coats: maximum: got 4, want": `{"coats":4}`,
+		"at /color: value must be one of": `{"coats":1,"color":"blue"}`,
+		"at /walls/0: minLength":          `{"coats":1,"walls":[""]}`,
+		"additional properties 'extra'":   `{"coats":1,"extra":true}`,
+	} {
+		_, err := tool.Exec(context.Background(), input)
+		require.ErrorIs(err, tools.ErrInvalidInput, exp)
+		require.ErrorContains(err, exp)
+		require.ErrorContains(err, "tools_test.PaintInput")
+	}
+
+}
+
+func TestToolSchema(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := paintTool()
+	require.Equal([]string{"coats"}, tool.InputSchema().(*tools.JsonSchema).Required)
+	require.Contains(tool.Help(), `"enum": [`)
+	require.Equal(tool.InputSchema(), tools.Define(tool).InputSchema)
+	require.False(tools.Define(tool).Strict, "not strict with optionals")
+
+	require.PanicsWithValue(
+		"Input Schema for bad chan int: schema generation failed: chan int: unsupported type: chan int",
+		func() {
+			tools.NewTool("bad", "Bad.", func(ctx context.Context, in chan int) (bool, error) {
+				return true, nil
+			})
+		})
+
+}
+
+func TestToolOutputSchema(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := paintTool()
+	out, ok := tool.OutputSchema().(*tools.JsonSchema)
+	require.True(ok)
+	require.Equal("object", out.Type)
+	require.Contains(out.Properties, "coats")
+	require.Equal(out, tools.OutputSchema(tool))
+	require.Contains(tool.Help(), "Output Schema:")
+
+}
+
+func TestExpandEnv(t *testing.T) {
+
+	require := require.New(t)
+
+	t.Setenv("GHD_TEST_EXPAND", "x")
+	t.Setenv("GHD_TEST_EXPAND_EMPTY", "")
+
+	v, missing := tools.ExpandEnv("a-$GHD_TEST_EXPAND-${GHD_TEST_EXPAND_EMPTY}-b")
+	require.Equal("a-x--b", v)
+	require.Empty(missing, "set but empty is not missing")
+
+	v, missing = tools.ExpandEnv("$GHD_TEST_NOPE:${GHD_TEST_EXPAND}:$GHD_TEST_NADA")
+	require.Equal(":x:", v)
+	require.Equal([]string{"GHD_TEST_NOPE", "GHD_TEST_NADA"}, missing)
+
+}
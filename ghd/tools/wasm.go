@@ -0,0 +1,359 @@
+// tools/wasm.go
+
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/biztos/greenhead/ghd/schema"
+)
+
+// Defaults for WasmToolConfig limits.
+var (
+	DefaultWasmMaxMemoryBytes int64 = 256 << 20
+	DefaultWasmTimeout              = 30 * time.Second
+)
+
+// wasmPageSize is the size of a WebAssembly memory page.
+const wasmPageSize = 65536
+
+// WasmToolConfig represents the configuration of a WasmTool.
+//
+// This is used within a Runner config.
+type WasmToolConfig struct {
+	Module         string            `toml:"module"`           // Path to the .wasm module, required.
+	Name           string            `toml:"name"`             // Name, overriding the module's.
+	Description    string            `toml:"description"`      // Description, overriding the module's.
+	MaxMemoryBytes int64             `toml:"max_memory_bytes"` // Memory limit per call.
+	Timeout        time.Duration     `toml:"timeout"`          // Time limit per call.
+	ReadDirs       []string          `toml:"read_dirs"`        // Host dirs the module may read, at the same paths.
+	WriteDirs      []string          `toml:"write_dirs"`       // Host dirs the module may read and write, at the same paths.
+	Env            map[string]string `toml:"env"`              // Module environment, expanding "$VAR" and "${VAR}" from ours.
+	CacheDir       string            `toml:"cache_dir"`        // Dir to cache compiled code, for faster startup.
+	Metadata       *Metadata         `toml:"metadata"`         // Optional metadata for selecting the tool.
+}
+
+var ErrWasmToolConfigInvalid = fmt.Errorf("invalid wasm tool config")
+
+// Validate checks that c has correct values, setting default limits:
+//
+// - Module must be a readable file.
+// - MaxMemoryBytes must not be negative, and if set must be at least one
+// page (64KiB).
+// - Timeout must not be negative.
+// - ReadDirs and WriteDirs must be directories.
+// - Metadata, if set, must be valid.
+func (c *WasmToolConfig) Validate() error {
+	if strings.TrimSpace(c.Module) == "" {
+		return fmt.Errorf("%w: empty module", ErrWasmToolConfigInvalid)
+	}
+	if info, err := os.Stat(c.Module); err != nil {
+		return fmt.Errorf("%w: module error: %w", ErrWasmToolConfigInvalid, err)
+	} else if info.IsDir() {
+		return fmt.Errorf("%w: module is a directory: %s", ErrWasmToolConfigInvalid, c.Module)
+	}
+	if c.MaxMemoryBytes < 0 {
+		return fmt.Errorf("%w: negative max_memory_bytes for %s",
+			ErrWasmToolConfigInvalid, c.Module)
+	}
+	if c.MaxMemoryBytes == 0 {
+		c.MaxMemoryBytes = DefaultWasmMaxMemoryBytes
+	}
+	if c.MaxMemoryBytes < wasmPageSize {
+		return fmt.Errorf("%w: max_memory_bytes less than one page for %s",
+			ErrWasmToolConfigInvalid, c.Module)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("%w: negative timeout for %s",
+			ErrWasmToolConfigInvalid, c.Module)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultWasmTimeout
+	}
+	for _, dir := range slices.Concat(c.ReadDirs, c.WriteDirs) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("%w: dir error for %s: %w",
+				ErrWasmToolConfigInvalid, c.Module, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: dir not a directory for %s: %s",
+				ErrWasmToolConfigInvalid, c.Module, dir)
+		}
+	}
+	if c.Metadata != nil {
+		if err := c.Metadata.Validate(); err != nil {
+			return fmt.Errorf("%w: metadata for %s: %w",
+				ErrWasmToolConfigInvalid, c.Module, err)
+		}
+	}
+	return nil
+}
+
+// WasmTool is a Tooler implemented by a WebAssembly module, which may be
+// written in any language that compiles to a WASI (preview 1) "reactor"
+// module, e.g. Go with -buildmode=c-shared.  It runs in a pure-Go runtime,
+// wazero.
+//
+// The module must export its memory and these functions, where a "packed"
+// value is a pointer to a buffer in the upper 32 bits and its length in the
+// lower 32:
+//
+//	ghd_alloc(size u32) u32          - allocate a buffer for input
+//	ghd_info() u64                   - packed JSON info, see below
+//	ghd_exec(ptr u32, size u32) u64  - packed JSON result, see below
+//
+// The info is an object with name, description and input_schema (a JSON
+// schema object).  The result of ghd_exec, which receives the input JSON, is
+// an object with a result, or an error message:
+//
+//	{"result": "any JSON value"}
+//	{"error": "what went wrong"}
+//
+// Each call runs in a new instance of the module, with limited memory and
+// time, and no access to the host filesystem or environment unless granted.
+// There is no network access.
+type WasmTool struct {
+	cfg       *WasmToolConfig
+	runtime   wazero.Runtime
+	compiled  wazero.CompiledModule
+	name      string
+	desc      string
+	schema    json.RawMessage
+	validator *schema.Schema
+}
+
+var ErrWasmToolFailed = fmt.Errorf("wasm tool failed")
+
+// NewWasmTool compiles the module of cfg and reads its info, creating a
+// WasmTool.
+func NewWasmTool(cfg *WasmToolConfig) (*WasmTool, error) {
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(cfg.Module)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWasmToolConfigInvalid, err)
+	}
+
+	ctx := context.Background()
+	rt_cfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(cfg.MaxMemoryBytes / wasmPageSize)).
+		WithCloseOnContextDone(true)
+	if cfg.CacheDir != "" {
+		cache, err := wazero.NewCompilationCacheWithDir(cfg.CacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cache_dir: %w", ErrWasmToolConfigInvalid, err)
+		}
+		rt_cfg = rt_cfg.WithCompilationCache(cache)
+	}
+	t := &WasmTool{
+		cfg:     cfg,
+		runtime: wazero.NewRuntimeWithConfig(ctx, rt_cfg),
+	}
+	fail := func(err error) (*WasmTool, error) {
+		t.Close()
+		return nil, fmt.Errorf("%w: %s: %w", ErrWasmToolConfigInvalid, cfg.Module, err)
+	}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, t.runtime); err != nil {
+		return fail(err)
+	}
+	t.compiled, err = t.runtime.CompileModule(ctx, b)
+	if err != nil {
+		return fail(err)
+	}
+	for _, name := range []string{"ghd_alloc", "ghd_info", "ghd_exec"} {
+		if t.compiled.ExportedFunctions()[name] == nil {
+			return fail(fmt.Errorf("missing export %s", name))
+		}
+	}
+
+	raw, err := t.call(ctx, "ghd_info", nil)
+	if err != nil {
+		return fail(err)
+	}
+	var info struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return fail(fmt.Errorf("bad info: %w", err))
+	}
+	t.name = info.Name
+	if cfg.Name != "" {
+		t.name = cfg.Name
+	}
+	t.desc = info.Description
+	if cfg.Description != "" {
+		t.desc = cfg.Description
+	}
+	if strings.TrimSpace(t.name) == "" || strings.TrimSpace(t.desc) == "" {
+		return fail(fmt.Errorf("tool needs name and description"))
+	}
+	t.schema = info.InputSchema
+	if len(t.schema) == 0 || string(t.schema) == "null" {
+		t.schema = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	t.validator, err = schema.Compile(string(t.schema))
+	if err != nil {
+		return fail(err)
+	}
+	return t, nil
+}
+
+// Close releases the runtime of t, after which it can not be used.
+func (t *WasmTool) Close() error {
+	return t.runtime.Close(context.Background())
+}
+
+// moduleConfig returns the module config for a call, with stderr captured,
+// or an error if the environment references unset variables.
+func (t *WasmTool) moduleConfig(stderr *bytes.Buffer) (wazero.ModuleConfig, error) {
+	fs_cfg := wazero.NewFSConfig()
+	for _, dir := range t.cfg.ReadDirs {
+		fs_cfg = fs_cfg.WithReadOnlyDirMount(dir, dir)
+	}
+	for _, dir := range t.cfg.WriteDirs {
+		fs_cfg = fs_cfg.WithDirMount(dir, dir)
+	}
+	mod_cfg := wazero.NewModuleConfig().
+		WithName(""). // anonymous, so calls can be concurrent.
+		WithStartFunctions("_initialize").
+		WithStderr(stderr).
+		WithFSConfig(fs_cfg).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	for _, k := range slices.Sorted(maps.Keys(t.cfg.Env)) {
+		v, missing := ExpandEnv(t.cfg.Env[k])
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%s references unset %s", k, strings.Join(missing, ", "))
+		}
+		mod_cfg = mod_cfg.WithEnv(k, v)
+	}
+	return mod_cfg, nil
+}
+
+// call calls function name in a new instance of the module, with input if
+// not nil, returning the packed output.
+func (t *WasmTool) call(ctx context.Context, name string, input []byte) ([]byte, error) {
+
+	stderr := &bytes.Buffer{}
+	mod_cfg, err := t.moduleConfig(stderr)
+	if err != nil {
+		return nil, err
+	}
+	mod, err := t.runtime.InstantiateModule(ctx, t.compiled, mod_cfg)
+	if err != nil {
+		return nil, wasmError(err, stderr)
+	}
+	defer mod.Close(ctx)
+
+	args := []uint64{}
+	if input != nil {
+		res, err := mod.ExportedFunction("ghd_alloc").Call(ctx, uint64(len(input)))
+		if err != nil {
+			return nil, wasmError(err, stderr)
+		}
+		ptr := uint32(res[0])
+		if !mod.Memory().Write(ptr, input) {
+			return nil, fmt.Errorf("input out of range: %d bytes at %d", len(input), ptr)
+		}
+		args = []uint64{uint64(ptr), uint64(len(input))}
+	}
+	res, err := mod.ExportedFunction(name).Call(ctx, args...)
+	if err != nil {
+		return nil, wasmError(err, stderr)
+	}
+	return readPacked(mod.Memory(), res[0])
+}
+
+// readPacked returns a copy of the buffer in mem at packed.
+func readPacked(mem api.Memory, packed uint64) ([]byte, error) {
+	ptr, size := uint32(packed>>32), uint32(packed)
+	b, ok := mem.Read(ptr, size)
+	if !ok {
+		return nil, fmt.Errorf("output out of range: %d bytes at %d", size, ptr)
+	}
+	return bytes.Clone(b), nil
+}
+
+// wasmError returns err with any stderr output appended.
+func wasmError(err error, stderr *bytes.Buffer) error {
+	if out := strings.TrimSpace(stderr.String()); out != "" {
+		return fmt.Errorf("%w: %s", err, TruncateHeadTail(out, 1000))
+	}
+	return err
+}
+
+// Name implements Tooler.
+func (t *WasmTool) Name() string {
+	return t.name
+}
+
+// Description implements Tooler.
+func (t *WasmTool) Description() string {
+	return t.desc
+}
+
+// Metadata implements MetadataTooler.
+func (t *WasmTool) Metadata() *Metadata {
+	return t.cfg.Metadata
+}
+
+// Help implements Tooler.
+func (t *WasmTool) Help() string {
+	var buf bytes.Buffer
+	json.Indent(&buf, t.schema, "", "  ")
+	return fmt.Sprintf("%s\n\n%s\n\nWASM Module: %s\n\nInput Schema:\n\n%s\n",
+		t.name, t.desc, t.cfg.Module, buf.String())
+}
+
+// InputSchema implements Tooler.
+func (t *WasmTool) InputSchema() any {
+	return t.schema
+}
+
+// Exec implements Tooler by validating the input and calling ghd_exec in a
+// new instance of the module, within the configured Timeout.
+func (t *WasmTool) Exec(ctx context.Context, input string) (any, error) {
+
+	if _, err := t.validator.ValidateJson(input); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.cfg.Timeout)
+	defer cancel()
+	raw, err := t.call(ctx, "ghd_exec", []byte(input))
+	if err != nil {
+		if ctx_err := ctx.Err(); ctx_err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWasmToolFailed, ctx_err)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrWasmToolFailed, err)
+	}
+	var res struct {
+		Result any     `json:"result"`
+		Error  *string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("%w: bad result: %w", ErrWasmToolFailed, err)
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("%w: %s", ErrWasmToolFailed, *res.Error)
+	}
+	return res.Result, nil
+}
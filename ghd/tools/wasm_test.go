@@ -0,0 +1,217 @@
+package tools_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var wasmModuleOnce sync.Once
+var wasmModulePath string
+var wasmModuleErr error
+
+// Return the path to the toy WASM module, building it if necessary, or skip
+// if it can not be built.  The build is cached by Go, but compiling the
+// module is still slow, so use it sparingly.
+func wasmModule(t *testing.T) string {
+	wasmModuleOnce.Do(func() {
+		src, _ := filepath.Abs(filepath.Join("..", "testdata", "wasm_tool"))
+		path := filepath.Join(os.TempDir(), "ghd-test-wasm_tool.wasm")
+		cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"),
+			"build", "-buildmode=c-shared", "-o", path, ".")
+		cmd.Dir = src
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			wasmModuleErr = fmt.Errorf("%w: %s", err, out)
+			return
+		}
+		wasmModulePath = path
+	})
+	if wasmModuleErr != nil {
+		t.Skip("can not build wasm module: " + wasmModuleErr.Error())
+	}
+	return wasmModulePath
+}
+
+// Return a WasmTool for cfg with the toy module, sharing a compilation
+// cache.
+func wasmTool(t *testing.T, cfg *tools.WasmToolConfig) *tools.WasmTool {
+	cfg.Module = wasmModule(t)
+	cfg.CacheDir = filepath.Join(os.TempDir(), "ghd-test-wasm-cache")
+	tool, err := tools.NewWasmTool(cfg)
+	require.NoError(t, err, "NewWasmTool")
+	t.Cleanup(func() { tool.Close() })
+	return tool
+}
+
+func TestWasmToolConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	file := filepath.Join(t.TempDir(), "file.wasm")
+	require.NoError(os.WriteFile(file, []byte{}, 0644))
+
+	cfg := &tools.WasmToolConfig{Module: file}
+	require.NoError(cfg.Validate())
+	require.Equal(tools.DefaultWasmMaxMemoryBytes, cfg.MaxMemoryBytes)
+	require.Equal(tools.DefaultWasmTimeout, cfg.Timeout)
+
+	for exp, mod := range map[string]func(*tools.WasmToolConfig){
+		"empty module":                   func(c *tools.WasmToolConfig) { c.Module = "" },
+		"module error":                   func(c *tools.WasmToolConfig) { c.Module += "-nope" },
+		"module is a directory":          func(c *tools.WasmToolConfig) { c.Module = filepath.Dir(file) },
+		"negative max_memory_bytes":      func(c *tools.WasmToolConfig) { c.MaxMemoryBytes = -1 },
+		"max_memory_bytes less than one": func(c *tools.WasmToolConfig) { c.MaxMemoryBytes = 1000 },
+		"negative timeout":               func(c *tools.WasmToolConfig) { c.Timeout = -1 },
+		"dir error for":                  func(c *tools.WasmToolConfig) { c.ReadDirs = []string{file + "-nope"} },
+		"dir not a directory for":        func(c *tools.WasmToolConfig) { c.WriteDirs = []string{file} },
+	} {
+		cfg := &tools.WasmToolConfig{Module: file}
+		mod(cfg)
+		err := cfg.Validate()
+		require.ErrorIs(err, tools.ErrWasmToolConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+	_, err := tools.NewWasmTool(&tools.WasmToolConfig{Module: file})
+	require.ErrorIs(err, tools.ErrWasmToolConfigInvalid, "not a module")
+
+}
+
+func TestWasmTool(t *testing.T) {
+
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(os.WriteFile(filepath.Join(dir, "in.txt"), []byte("hello"), 0644))
+	t.Setenv("GHD_TEST_WASM", "expanded")
+
+	tool := wasmTool(t, &tools.WasmToolConfig{
+		ReadDirs: []string{dir},
+		Env:      map[string]string{"FOO": "${GHD_TEST_WASM}"},
+	})
+	require.Equal("wasm_toy", tool.Name())
+	require.Equal("Toy WASM tool.", tool.Description())
+	require.Contains(tool.Help(), "WASM Module: ")
+	require.Equal("wasm_toy", tools.Define(tool).Name)
+	schema := map[string]any{}
+	require.NoError(json.Unmarshal(tool.InputSchema().(json.RawMessage), &schema))
+	require.Equal([]any{"action"}, schema["required"])
+
+	ctx := context.Background()
+	res, err := tool.Exec(ctx, `{"action":"echo","text":"hi"}`)
+	require.NoError(err)
+	require.Equal("hi", res)
+
+	_, err = tool.Exec(ctx, `{"text":"hi"}`)
+	require.ErrorIs(err, tools.ErrInvalidInput)
+
+	_, err = tool.Exec(ctx, `{"action":"fail","text":"oops"}`)
+	require.ErrorIs(err, tools.ErrWasmToolFailed)
+	require.ErrorContains(err, "oops")
+
+	res, err = tool.Exec(ctx, `{"action":"env","text":"FOO"}`)
+	require.NoError(err)
+	require.Equal("expanded", res)
+	res, err = tool.Exec(ctx, `{"action":"env","text":"HOME"}`)
+	require.NoError(err)
+	require.Equal("", res, "environment not inherited")
+
+	// Read-only dirs are readable, but not writable; others are not there.
+	in_file := filepath.Join(dir, "in.txt")
+	res, err = tool.Exec(ctx, `{"action":"read","path":"`+in_file+`"}`)
+	require.NoError(err)
+	require.Equal("hello", res)
+	_, err = tool.Exec(ctx, `{"action":"write","path":"`+in_file+`","text":"x"}`)
+	require.ErrorIs(err, tools.ErrWasmToolFailed)
+	other, err := filepath.Abs("wasm_test.go")
+	require.NoError(err)
+	_, err = tool.Exec(ctx, `{"action":"read","path":"`+other+`"}`)
+	require.ErrorIs(err, tools.ErrWasmToolFailed)
+
+}
+
+func TestWasmToolWriteDirs(t *testing.T) {
+
+	require := require.New(t)
+
+	dir := t.TempDir()
+	tool := wasmTool(t, &tools.WasmToolConfig{
+		Name:        "writer",
+		Description: "Writes.",
+		WriteDirs:   []string{dir},
+	})
+	require.Equal("writer", tool.Name())
+	require.Equal("Writes.", tool.Description())
+
+	out_file := filepath.Join(dir, "out.txt")
+	res, err := tool.Exec(context.Background(),
+		`{"action":"write","path":"`+out_file+`","text":"written"}`)
+	require.NoError(err)
+	require.Equal("ok", res)
+	b, err := os.ReadFile(out_file)
+	require.NoError(err)
+	require.Equal("written", string(b))
+
+}
+
+func TestWasmToolLimits(t *testing.T) {
+
+	require := require.New(t)
+
+	tool := wasmTool(t, &tools.WasmToolConfig{
+		Timeout:        200 * time.Millisecond,
+		MaxMemoryBytes: 64 << 20,
+	})
+
+	start := time.Now()
+	_, err := tool.Exec(context.Background(), `{"action":"spin"}`)
+	require.ErrorIs(err, tools.ErrWasmToolFailed)
+	require.ErrorIs(err, context.DeadlineExceeded)
+	require.Less(time.Since(start), 5*time.Second)
+
+	_, err = tool.Exec(context.Background(), `{"action":"grow"}`)
+	require.ErrorIs(err, tools.ErrWasmToolFailed)
+
+	// Still usable after all that.
+	res, err := tool.Exec(context.Background(), `{"action":"echo","text":"ok"}`)
+	require.NoError(err)
+	require.Equal("ok", res)
+
+}
+
+func TestWasmToolEnvUnset(t *testing.T) {
+
+	require := require.New(t)
+
+	// Set when the tool is made, but unset when it is called.
+	t.Setenv("GHD_TEST_WASM_ENV", "set")
+	tool := wasmTool(t, &tools.WasmToolConfig{
+		Env: map[string]string{"FOO": "${GHD_TEST_WASM_ENV}"},
+	})
+	require.NoError(os.Unsetenv("GHD_TEST_WASM_ENV"))
+
+	_, err := tool.Exec(context.Background(), `{"action":"env","text":"FOO"}`)
+	require.ErrorIs(err, tools.ErrWasmToolFailed)
+	require.ErrorContains(err, "FOO references unset GHD_TEST_WASM_ENV")
+
+	_, err = tools.NewWasmTool(&tools.WasmToolConfig{
+		Module: wasmModule(t),
+		Env:    map[string]string{"FOO": "$GHD_TEST_WASM_ENV"},
+	})
+	require.ErrorIs(err, tools.ErrWasmToolConfigInvalid)
+	require.ErrorContains(err, "FOO references unset GHD_TEST_WASM_ENV")
+
+}
@@ -0,0 +1,334 @@
+// tools/web/html.go
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skipElements are never converted, as they have no readable text.
+var skipElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Canvas:   true,
+	atom.Object:   true,
+}
+
+// blockElements are set apart from their surroundings by blank lines.
+var blockElements = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.Div:        true,
+	atom.Section:    true,
+	atom.Article:    true,
+	atom.Main:       true,
+	atom.Header:     true,
+	atom.Footer:     true,
+	atom.Nav:        true,
+	atom.Aside:      true,
+	atom.Figure:     true,
+	atom.Figcaption: true,
+	atom.Address:    true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Dd:         true,
+	atom.Form:       true,
+	atom.Fieldset:   true,
+	atom.Details:    true,
+	atom.Summary:    true,
+}
+
+var (
+	spaceRegexp    = regexp.MustCompile(`\s+`)
+	blankRunRegexp = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToMarkdown returns the title of an HTML document and its readable
+// text as simple Markdown, with links resolved against base.
+func htmlToMarkdown(b []byte, base *url.URL) (string, string, error) {
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: bad HTML: %w", ErrUnsupportedContent, err)
+	}
+	w := &mdWriter{base: base}
+	w.walk(doc)
+	return strings.TrimSpace(w.title), w.String(), nil
+}
+
+// mdWriter writes Markdown for HTML nodes.
+type mdWriter struct {
+	base  *url.URL
+	title string
+	b     strings.Builder
+	pre   int
+	lists []*mdList
+}
+
+type mdList struct {
+	ordered bool
+	n       int
+}
+
+// String returns the Markdown written, tidied up.
+func (w *mdWriter) String() string {
+	lines := strings.Split(w.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	s := blankRunRegexp.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.Trim(s, "\n")
+}
+
+// sub returns a new writer for rendering part of a document on its own.
+func (w *mdWriter) sub() *mdWriter {
+	return &mdWriter{base: w.base, pre: w.pre}
+}
+
+func (w *mdWriter) atLineStart() bool {
+	s := w.b.String()
+	return s == "" || strings.HasSuffix(s, "\n")
+}
+
+// newline ends the current line, if any.
+func (w *mdWriter) newline() {
+	if !w.atLineStart() {
+		w.b.WriteString("\n")
+	}
+}
+
+// block separates what follows with a blank line.
+func (w *mdWriter) block() {
+	s := w.b.String()
+	if s == "" || strings.HasSuffix(s, "\n\n") {
+		return
+	}
+	w.newline()
+	w.b.WriteString("\n")
+}
+
+func (w *mdWriter) text(s string) {
+	if w.pre > 0 {
+		w.b.WriteString(s)
+		return
+	}
+	s = spaceRegexp.ReplaceAllString(s, " ")
+	if w.atLineStart() || strings.HasSuffix(w.b.String(), " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	w.b.WriteString(s)
+}
+
+func (w *mdWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+// inline writes the children of n between marks, if they have any text.
+func (w *mdWriter) inline(n *html.Node, open, close string) {
+	sub := w.sub()
+	sub.children(n)
+	s := strings.TrimSpace(sub.String())
+	if s == "" {
+		return
+	}
+	w.text(" ")
+	w.b.WriteString(open + s + close)
+}
+
+func (w *mdWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.DocumentNode:
+		w.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	if n.DataAtom == atom.Title && w.title == "" {
+		if n.FirstChild != nil {
+			w.title = spaceRegexp.ReplaceAllString(n.FirstChild.Data, " ")
+		}
+		return
+	}
+	if n.DataAtom == atom.Head {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Title {
+				w.walk(c)
+			}
+		}
+		return
+	}
+	if skipElements[n.DataAtom] {
+		return
+	}
+	if blockElements[n.DataAtom] {
+		w.block()
+		w.children(n)
+		w.block()
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block()
+		w.b.WriteString(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		w.children(n)
+		w.block()
+	case atom.Br:
+		w.b.WriteString("\n")
+	case atom.Hr:
+		w.block()
+		w.b.WriteString("---")
+		w.block()
+	case atom.Ul, atom.Ol:
+		if len(w.lists) == 0 {
+			w.block()
+		}
+		w.lists = append(w.lists, &mdList{ordered: n.DataAtom == atom.Ol})
+		w.children(n)
+		w.lists = w.lists[:len(w.lists)-1]
+		w.newline()
+		if len(w.lists) == 0 {
+			w.block()
+		}
+	case atom.Li:
+		w.newline()
+		marker := "- "
+		if len(w.lists) > 0 {
+			list := w.lists[len(w.lists)-1]
+			list.n++
+			if list.ordered {
+				marker = fmt.Sprintf("%d. ", list.n)
+			}
+			w.b.WriteString(strings.Repeat("  ", len(w.lists)-1))
+		}
+		w.b.WriteString(marker)
+		w.children(n)
+		w.newline()
+	case atom.Pre:
+		w.block()
+		w.b.WriteString("```\n")
+		w.pre++
+		w.children(n)
+		w.pre--
+		w.newline()
+		w.b.WriteString("```")
+		w.block()
+	case atom.Code:
+		if w.pre > 0 {
+			w.children(n)
+		} else {
+			w.inline(n, "`", "`")
+		}
+	case atom.Strong, atom.B:
+		w.inline(n, "**", "**")
+	case atom.Em, atom.I:
+		w.inline(n, "*", "*")
+	case atom.A:
+		href := w.link(attr(n, "href"))
+		if href == "" {
+			w.children(n)
+		} else {
+			w.inline(n, "[", "]("+href+")")
+		}
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			w.text(" ")
+			w.b.WriteString("![" + alt + "](" + w.link(attr(n, "src")) + ")")
+		}
+	case atom.Blockquote:
+		sub := w.sub()
+		sub.children(n)
+		w.block()
+		for _, line := range strings.Split(sub.String(), "\n") {
+			w.b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+		w.block()
+	case atom.Table:
+		w.block()
+		w.table(n)
+		w.block()
+	default:
+		w.children(n)
+	}
+}
+
+// table writes the rows of a table, with a header separator after the
+// first row if it has th cells.
+func (w *mdWriter) table(n *html.Node) {
+	first := true
+	var rows func(*html.Node)
+	rows = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom != atom.Tr {
+				rows(c)
+				continue
+			}
+			cells := []string{}
+			header := false
+			for td := c.FirstChild; td != nil; td = td.NextSibling {
+				if td.DataAtom != atom.Td && td.DataAtom != atom.Th {
+					continue
+				}
+				header = header || td.DataAtom == atom.Th
+				sub := w.sub()
+				sub.children(td)
+				cell := spaceRegexp.ReplaceAllString(sub.String(), " ")
+				cells = append(cells, strings.ReplaceAll(cell, "|", `\|`))
+			}
+			if len(cells) == 0 {
+				continue
+			}
+			w.newline()
+			w.b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			if first && header {
+				w.b.WriteString(strings.Repeat("| --- ", len(cells)) + "|\n")
+			}
+			first = false
+		}
+	}
+	rows(n)
+}
+
+// link returns href resolved against the base URL, or an empty string if it
+// is not a useful link.
+func (w *mdWriter) link(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") ||
+		strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if w.base != nil {
+		u = w.base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
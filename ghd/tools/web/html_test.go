@@ -0,0 +1,101 @@
+package web_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/tools/web"
+)
+
+const testHtmlFull = `<html>
+<head><title>
+  Full
+  Page</title><script>var x = 1;</script></head>
+<body>
+<nav><a href="/">Home</a> <a href="#top">Top</a> <a href="javascript:void(0)">JS</a></nav>
+<main>
+<h2>Lists</h2>
+<ul>
+  <li>one <em>emphasis</em></li>
+  <li>two
+    <ol><li>inner a</li><li>inner b</li></ol>
+  </li>
+</ul>
+<h3>Code</h3>
+<p>Use <code>go test</code> often.<br>New line.</p>
+<pre><code>func main() {
+    fmt.Println("hi")
+}</code></pre>
+<blockquote><p>Quoted.</p><p>Twice.</p></blockquote>
+<hr>
+<table>
+  <thead><tr><th>Name</th><th>Value</th></tr></thead>
+  <tbody><tr><td>a|b</td><td><b>1</b></td></tr><tr></tr></tbody>
+</table>
+<p><img src="pic.png" alt="A picture"> <img src="deco.png"></p>
+<p><a href="docs/page.html"><strong></strong></a> <a href="https://example.com/x">Example</a></p>
+<noscript>Enable JS</noscript>
+</main>
+</body>
+</html>`
+
+const testMarkdownFull = "[Home](http://HOST/) Top JS\n" +
+	"\n" +
+	"## Lists\n" +
+	"\n" +
+	"- one *emphasis*\n" +
+	"- two\n" +
+	"  1. inner a\n" +
+	"  2. inner b\n" +
+	"\n" +
+	"### Code\n" +
+	"\n" +
+	"Use `go test` often.\n" +
+	"New line.\n" +
+	"\n" +
+	"```\n" +
+	"func main() {\n" +
+	"    fmt.Println(\"hi\")\n" +
+	"}\n" +
+	"```\n" +
+	"\n" +
+	"> Quoted.\n" +
+	">\n" +
+	"> Twice.\n" +
+	"\n" +
+	"---\n" +
+	"\n" +
+	"| Name | Value |\n" +
+	"| --- | --- |\n" +
+	"| a\\|b | **1** |\n" +
+	"\n" +
+	"![A picture](http://HOST/pages/pic.png)\n" +
+	"\n" +
+	"[Example](https://example.com/x)"
+
+func TestFetchHtmlToMarkdown(t *testing.T) {
+
+	require := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testHtmlFull))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(err)
+
+	f, err := web.New(&web.Config{Allow: []string{u.Host}})
+	require.NoError(err)
+	res, err := f.Fetch(context.Background(), web.FetchInput{Url: srv.URL + "/pages/full.html"})
+	require.NoError(err)
+	require.Equal("Full Page", res.Title)
+	require.Equal(strings.ReplaceAll(testMarkdownFull, "HOST", u.Host), res.Text)
+
+}
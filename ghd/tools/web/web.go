@@ -0,0 +1,457 @@
+// Package web provides an HTTP fetch tool limited to configured hosts and
+// URLs, for agents using internal services and documentation.
+//
+// The tool is not registered on init, as it needs an allowlist.  Register it
+// with Register, or in a runner config:
+//
+//	[web_tools]
+//	  allow = ["docs.example.com", "*.internal.example.com", "https://api.example.com/v1/"]
+//	  methods = ["GET", "POST"]
+//	  timeout = "10s"
+//	  [[web_tools.headers]]
+//	    name = "Authorization"
+//	    value = "Bearer ${EXAMPLE_API_TOKEN}"
+//	    allow = ["https://api.example.com/v1/"]
+//
+// The tool, web_http_fetch, returns JSON responses decoded, HTML converted to
+// Markdown, and other text as it is.  Responses are cut at MaxBytes, and
+// requests time out after Timeout.  Redirects are followed only to allowed
+// URLs, and configured headers are only sent where they are allowed.
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+)
+
+var ErrConfigInvalid = fmt.Errorf("invalid web tools config")
+
+var ErrNotAllowed = fmt.Errorf("not allowed")
+
+var ErrHeaderEnv = fmt.Errorf("header environment error")
+
+var ErrUnsupportedContent = fmt.Errorf("unsupported content")
+
+// DefaultTimeout is the default for Config.Timeout.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultMaxBytes is the default for Config.MaxBytes.
+const DefaultMaxBytes = 1 << 20
+
+// DefaultMaxRedirects is the default for Config.MaxRedirects.
+const DefaultMaxRedirects = 5
+
+// HeaderConfig describes a header added to requests.
+type HeaderConfig struct {
+	Name  string   `toml:"name"`  // Header name; required.
+	Value string   `toml:"value"` // Value, expanding "$VAR" and "${VAR}" from the environment.
+	Allow []string `toml:"allow"` // Only send to these hosts or URL prefixes; default is everywhere allowed.
+
+	allow []*allowRule
+}
+
+// Config describes the web tools.
+type Config struct {
+	Allow        []string        `toml:"allow"`         // Hosts or URL prefixes that may be fetched; required.
+	Methods      []string        `toml:"methods"`       // Allowed HTTP methods; default is GET only.
+	Headers      []*HeaderConfig `toml:"headers"`       // Headers added to requests, overriding any from the LLM.
+	Timeout      time.Duration   `toml:"timeout"`       // Max time for a request and its response; default 30s.
+	MaxBytes     int64           `toml:"max_bytes"`     // Max response body returned; default 1 MiB.
+	MaxRedirects int             `toml:"max_redirects"` // Max redirects followed; default 5, -1 for none.
+}
+
+// Validate checks c, setting defaults as needed.
+//
+// Allow entries are either URL prefixes, e.g. "https://example.com/docs/",
+// or hosts, e.g. "example.com" or "example.com:8080", optionally with a
+// wildcard for subdomains, e.g. "*.example.com".  Hosts without a port are
+// only allowed on the default ports for http and https.
+func (c *Config) Validate() error {
+	if len(c.Allow) == 0 {
+		return fmt.Errorf("%w: nothing allowed", ErrConfigInvalid)
+	}
+	for _, a := range c.Allow {
+		if _, err := parseAllowRule(a); err != nil {
+			return fmt.Errorf("%w: %w", ErrConfigInvalid, err)
+		}
+	}
+	if len(c.Methods) == 0 {
+		c.Methods = []string{http.MethodGet}
+	}
+	for i, m := range c.Methods {
+		if !httpguts.ValidHeaderFieldName(m) {
+			return fmt.Errorf("%w: bad method %q", ErrConfigInvalid, m)
+		}
+		c.Methods[i] = strings.ToUpper(m)
+	}
+	for _, h := range c.Headers {
+		if !httpguts.ValidHeaderFieldName(h.Name) {
+			return fmt.Errorf("%w: bad header name %q", ErrConfigInvalid, h.Name)
+		}
+		h.allow = nil
+		for _, a := range h.Allow {
+			rule, err := parseAllowRule(a)
+			if err != nil {
+				return fmt.Errorf("%w: header %s: %w", ErrConfigInvalid, h.Name, err)
+			}
+			h.allow = append(h.allow, rule)
+		}
+	}
+	if c.Timeout < 0 || c.MaxBytes < 0 || c.MaxRedirects < -1 {
+		return fmt.Errorf("%w: negative limit", ErrConfigInvalid)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = DefaultMaxBytes
+	}
+	if c.MaxRedirects == 0 {
+		c.MaxRedirects = DefaultMaxRedirects
+	}
+	return nil
+}
+
+// allowRule is a parsed Allow entry.
+type allowRule struct {
+	scheme string // empty for any
+	host   string // lower case; may start with "*."
+	port   string // empty for the default port
+	path   string // empty for any
+}
+
+func parseAllowRule(src string) (*allowRule, error) {
+	s := strings.TrimSpace(src)
+	if s == "" {
+		return nil, fmt.Errorf("empty allow entry")
+	}
+	rule := &allowRule{}
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad allow URL %q: %w", src, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("bad allow URL %q: scheme must be http or https", src)
+		}
+		if u.Hostname() == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("bad allow URL %q: must be scheme, host and path only", src)
+		}
+		rule.scheme = u.Scheme
+		rule.host = strings.ToLower(u.Hostname())
+		rule.port = u.Port()
+		rule.path = u.Path
+		if rule.path == "" {
+			rule.path = "/"
+		}
+		return rule, nil
+	}
+	host, port := s, ""
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		host, port = s[:i], s[i+1:]
+		if port == "" || strings.Trim(port, "0123456789") != "" {
+			return nil, fmt.Errorf("bad allow host %q: bad port", src)
+		}
+	}
+	if host == "" || strings.ContainsAny(host, "/?#@ ") || strings.Contains(host[1:], "*") ||
+		(strings.HasPrefix(host, "*") && !strings.HasPrefix(host, "*.")) {
+		return nil, fmt.Errorf("bad allow host %q", src)
+	}
+	rule.host = strings.ToLower(host)
+	rule.port = port
+	return rule, nil
+}
+
+// matches returns true if u is allowed by r.  The path of u must already be
+// free of dot segments.
+func (r *allowRule) matches(u *url.URL) bool {
+	if r.scheme != "" && u.Scheme != r.scheme {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if suffix, ok := strings.CutPrefix(r.host, "*"); ok {
+		if !strings.HasSuffix(host, suffix) {
+			return false
+		}
+	} else if host != r.host {
+		return false
+	}
+	port := defaultPort(u.Scheme, u.Port())
+	switch {
+	case r.scheme != "":
+		if port != defaultPort(r.scheme, r.port) {
+			return false
+		}
+	case r.port == "":
+		if port != defaultPort(u.Scheme, "") {
+			return false
+		}
+	case port != r.port:
+		return false
+	}
+	if r.path == "" {
+		return true
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	if strings.HasSuffix(r.path, "/") {
+		return strings.HasPrefix(p, r.path)
+	}
+	return p == r.path || strings.HasPrefix(p, r.path+"/")
+}
+
+// defaultPort returns port, or the default port for scheme if it is empty.
+func defaultPort(scheme, port string) string {
+	if port != "" {
+		return port
+	}
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func matchesAny(rules []*allowRule, u *url.URL) bool {
+	return slices.ContainsFunc(rules, func(r *allowRule) bool { return r.matches(u) })
+}
+
+// Fetcher fetches allowed URLs for the web_http_fetch tool.
+type Fetcher struct {
+	config *Config
+	allow  []*allowRule
+	client *http.Client
+}
+
+// New returns a Fetcher for cfg.
+func New(cfg *Config) (*Fetcher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	f := &Fetcher{config: cfg}
+	for _, a := range cfg.Allow {
+		rule, _ := parseAllowRule(a) // validated above
+		f.allow = append(f.allow, rule)
+	}
+	f.client = &http.Client{
+		Timeout:       cfg.Timeout,
+		CheckRedirect: f.checkRedirect,
+	}
+	return f, nil
+}
+
+// checkURL returns an error unless u is an allowed http or https URL.
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", ErrNotAllowed, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: user info in URL", ErrNotAllowed)
+	}
+	if slices.ContainsFunc(strings.Split(u.Path, "/"), func(seg string) bool {
+		return seg == "." || seg == ".."
+	}) {
+		return fmt.Errorf("%w: dot segments in path %q", ErrNotAllowed, u.Path)
+	}
+	if !matchesAny(f.allow, u) {
+		return fmt.Errorf("%w: URL %s", ErrNotAllowed, u.Redacted())
+	}
+	return nil
+}
+
+// checkRedirect allows redirects to allowed URLs, and sets the configured
+// headers for the new URL.
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if f.config.MaxRedirects < 0 || len(via) > f.config.MaxRedirects {
+		return fmt.Errorf("%w: more than %d redirects", ErrNotAllowed, max(f.config.MaxRedirects, 0))
+	}
+	if err := f.checkURL(req.URL); err != nil {
+		return fmt.Errorf("redirect: %w", err)
+	}
+	return f.setHeaders(req)
+}
+
+// setHeaders sets the configured headers allowed for the request URL, and
+// removes the others.
+func (f *Fetcher) setHeaders(req *http.Request) error {
+	for _, h := range f.config.Headers {
+		if h.allow != nil && !matchesAny(h.allow, req.URL) {
+			req.Header.Del(h.Name)
+			continue
+		}
+		v, missing := tools.ExpandEnv(h.Value)
+		if len(missing) > 0 {
+			return fmt.Errorf("%w: %s references unset %s",
+				ErrHeaderEnv, h.Name, strings.Join(missing, ", "))
+		}
+		req.Header.Set(h.Name, v)
+	}
+	return nil
+}
+
+// FetchInput is the input to web_http_fetch.
+type FetchInput struct {
+	Url     string            `json:"url" description:"The http or https URL to fetch."`
+	Method  string            `json:"method,omitempty" description:"HTTP method; default is GET."`
+	Headers map[string]string `json:"headers,omitempty" description:"Extra request headers."`
+	Body    string            `json:"body,omitempty" description:"Request body, e.g. JSON for a POST."`
+}
+
+// FetchResult is the output of web_http_fetch.  JSON responses are in Json,
+// and all others in Text, with HTML converted to Markdown.
+type FetchResult struct {
+	Url         string `json:"url"` // after any redirects
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Title       string `json:"title,omitempty"` // of HTML pages
+	Json        any    `json:"json,omitempty"`
+	Text        string `json:"text,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+}
+
+// Fetch makes the request described by in, if it is allowed, and returns
+// the response.  Responses with error statuses are returned, not errors.
+func (f *Fetcher) Fetch(ctx context.Context, in FetchInput) (*FetchResult, error) {
+	u, err := url.Parse(in.Url)
+	if err != nil {
+		return nil, fmt.Errorf("bad URL: %w", err)
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+	method := strings.ToUpper(in.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !slices.Contains(f.config.Methods, method) {
+		return nil, fmt.Errorf("%w: method %s", ErrNotAllowed, method)
+	}
+	var body io.Reader
+	if in.Body != "" {
+		if method == http.MethodGet || method == http.MethodHead {
+			return nil, fmt.Errorf("body not allowed with %s", method)
+		}
+		body = strings.NewReader(in.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range in.Headers {
+		if !httpguts.ValidHeaderFieldName(k) || !httpguts.ValidHeaderFieldValue(v) {
+			return nil, fmt.Errorf("bad header %q", k)
+		}
+		req.Header.Set(k, v)
+	}
+	if err := f.setHeaders(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	res := &FetchResult{
+		Url:         resp.Request.URL.Redacted(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if int64(len(b)) > f.config.MaxBytes {
+		b = b[:f.config.MaxBytes]
+		res.Truncated = true
+	}
+	if err := res.setContent(b, resp.Request.URL); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// setContent sets the Json or Text of r from body, by content type.
+func (r *FetchResult) setContent(body []byte, base *url.URL) error {
+	if len(body) == 0 {
+		return nil
+	}
+	media, _, _ := mime.ParseMediaType(r.ContentType)
+	switch {
+	case isJson(media):
+		if !r.Truncated && json.Unmarshal(body, &r.Json) == nil {
+			return nil
+		}
+		r.Json = nil
+		r.Text = strings.ToValidUTF8(string(body), "�")
+	case media == "text/html" || media == "application/xhtml+xml":
+		title, text, err := htmlToMarkdown(body, base)
+		if err != nil {
+			return err
+		}
+		r.Title = title
+		r.Text = text
+	case strings.HasPrefix(media, "text/") || strings.HasSuffix(media, "xml") ||
+		media == "application/javascript":
+		r.Text = strings.ToValidUTF8(string(body), "�")
+	case looksLikeText(body):
+		r.Text = string(body)
+	default:
+		return fmt.Errorf("%w: %q is not text", ErrUnsupportedContent, r.ContentType)
+	}
+	return nil
+}
+
+func isJson(media string) bool {
+	return media == "application/json" || strings.HasSuffix(media, "+json")
+}
+
+// looksLikeText returns true if b is valid UTF-8 without NUL bytes, allowing
+// for a rune cut off at the end.
+func looksLikeText(b []byte) bool {
+	for i := 1; i < utf8.UTFMax && len(b) > 0 && !utf8.Valid(b); i++ {
+		b = b[:len(b)-1]
+	}
+	return utf8.Valid(b) && !slices.Contains(b, 0)
+}
+
+// Tool returns the web_http_fetch tool for f.
+func (f *Fetcher) Tool() tools.Tooler {
+	return tools.NewTool[FetchInput, *FetchResult](
+		"web_http_fetch",
+		"Fetches a web page or API response over HTTP, from allowed sites only.  "+
+			"JSON is returned as data, and web pages as readable text.  "+
+			"This sends requests to other systems; methods other than GET may change data there.",
+		func(ctx context.Context, in FetchInput) (*FetchResult, error) {
+			return f.Fetch(ctx, in)
+		},
+	).WithMetadata(&tools.Metadata{Tags: []string{"web"}, Risk: tools.RiskNetwork})
+}
+
+// Register registers the web_http_fetch tool for cfg in reg.
+func Register(reg *registry.Registry, cfg *Config) error {
+	f, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	tool := f.Tool()
+	if err := reg.Register(tool); err != nil {
+		return fmt.Errorf("failed to register %q: %s", tool.Name(), err)
+	}
+	return nil
+}
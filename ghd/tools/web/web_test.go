@@ -0,0 +1,320 @@
+package web_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/tools/web"
+)
+
+const testHtml = `<!DOCTYPE html>
+<html><head><title>Test  Page</title><style>p { color: red }</style></head>
+<body><h1>Hello</h1><p>Some <b>bold</b> text.</p><script>alert(1)</script></body></html>`
+
+// testServer returns a server with some handlers, and its host:port.
+func testServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"a":1,"list":["x","y"]}`))
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testHtml))
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("abcdefghij", 10)))
+	})
+	mux.HandleFunc("/binary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\x00\x01"))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		b := make([]byte, r.ContentLength)
+		r.Body.Read(b)
+		w.Write([]byte(`{"method":"` + r.Method + `","token":"` + r.Header.Get("X-Token") +
+			`","extra":"` + r.Header.Get("X-Extra") + `","body":"` + string(b) + `"}`))
+	})
+	mux.HandleFunc("/api/v1/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	mux.HandleFunc("/api/v1/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("token=" + r.Header.Get("X-Token")))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("late"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not here", http.StatusNotFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return srv, u.Host
+}
+
+func testFetcher(t *testing.T, cfg *web.Config) (*web.Fetcher, *httptest.Server) {
+	t.Helper()
+	srv, host := testServer(t)
+	if cfg == nil {
+		cfg = &web.Config{}
+	}
+	if cfg.Allow == nil {
+		cfg.Allow = []string{host}
+	}
+	f, err := web.New(cfg)
+	require.NoError(t, err)
+	return f, srv
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg := &web.Config{Allow: []string{"example.com"}, Methods: []string{"get", "post"}}
+	require.NoError(cfg.Validate())
+	require.Equal([]string{"GET", "POST"}, cfg.Methods)
+	require.Equal(web.DefaultTimeout, cfg.Timeout)
+	require.EqualValues(web.DefaultMaxBytes, cfg.MaxBytes)
+	require.Equal(web.DefaultMaxRedirects, cfg.MaxRedirects)
+
+	cfg = &web.Config{Allow: []string{"example.com"}}
+	require.NoError(cfg.Validate())
+	require.Equal([]string{"GET"}, cfg.Methods)
+
+	for exp, cfg := range map[string]*web.Config{
+		"nothing allowed":               {},
+		"empty allow entry":             {Allow: []string{" "}},
+		"scheme must be http":           {Allow: []string{"ftp://example.com/"}},
+		"must be scheme, host":          {Allow: []string{"https://example.com/?q=1"}},
+		`bad allow host "x:y": bad`:     {Allow: []string{"x:y"}},
+		`bad allow host "a/b"`:          {Allow: []string{"a/b"}},
+		`bad allow host "a.*.com"`:      {Allow: []string{"a.*.com"}},
+		`bad allow host "*x.com"`:       {Allow: []string{"*x.com"}},
+		`bad method "G T"`:              {Allow: []string{"x.com"}, Methods: []string{"G T"}},
+		`bad header name "X Y"`:         {Allow: []string{"x.com"}, Headers: []*web.HeaderConfig{{Name: "X Y"}}},
+		`header X: bad allow host ":1"`: {Allow: []string{"x.com"}, Headers: []*web.HeaderConfig{{Name: "X", Allow: []string{":1"}}}},
+		"negative limit":                {Allow: []string{"x.com"}, MaxBytes: -1},
+	} {
+		err := cfg.Validate()
+		require.ErrorIs(err, web.ErrConfigInvalid, exp)
+		require.ErrorContains(err, exp)
+	}
+
+}
+
+func TestFetchAllowlist(t *testing.T) {
+
+	require := require.New(t)
+
+	f, err := web.New(&web.Config{
+		Allow: []string{
+			"example.com",
+			"*.example.org",
+			"api.example.net:8443",
+			"https://docs.example.net/v1/",
+			"http://docs.example.net/exact",
+		},
+	})
+	require.NoError(err)
+
+	// Only denials, so nothing is actually fetched.
+	for _, denied := range []string{
+		"ftp://example.com/",
+		"http://user:pw@example.com/",
+		"http://example.com:8080/",
+		"https://example.com.evil.com/",
+		"http://example.org/",
+		"http://api.example.net/",
+		"http://docs.example.net/v1/",
+		"https://docs.example.net/v2/",
+		"https://docs.example.net/v1/../admin",
+		"https://docs.example.net/v1/%2e%2e/admin",
+		"http://docs.example.net/exactly",
+		"http://other.com/",
+	} {
+		_, err := f.Fetch(context.Background(), web.FetchInput{Url: denied})
+		require.ErrorIs(err, web.ErrNotAllowed, denied)
+	}
+	_, err = f.Fetch(context.Background(), web.FetchInput{Url: "http://example.com/", Method: "POST"})
+	require.ErrorIs(err, web.ErrNotAllowed)
+	require.ErrorContains(err, "method POST")
+	_, err = f.Fetch(context.Background(), web.FetchInput{Url: "http://example.com/", Body: "x"})
+	require.ErrorContains(err, "body not allowed with GET")
+	_, err = f.Fetch(context.Background(), web.FetchInput{Url: "%"})
+	require.ErrorContains(err, "bad URL")
+
+}
+
+func TestFetchContent(t *testing.T) {
+
+	require := require.New(t)
+
+	f, srv := testFetcher(t, &web.Config{MaxBytes: 50})
+	ctx := context.Background()
+
+	res, err := f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/json"})
+	require.NoError(err)
+	require.Equal(200, res.Status)
+	require.Equal("application/json; charset=utf-8", res.ContentType)
+	require.Equal(map[string]any{"a": 1.0, "list": []any{"x", "y"}}, res.Json)
+	require.Empty(res.Text)
+
+	res, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/text"})
+	require.NoError(err)
+	require.Equal(strings.Repeat("abcdefghij", 5), res.Text)
+	require.True(res.Truncated)
+
+	res, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/missing"})
+	require.NoError(err, "error statuses are results")
+	require.Equal(404, res.Status)
+	require.Equal("not here\n", res.Text)
+
+	_, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/binary"})
+	require.ErrorIs(err, web.ErrUnsupportedContent)
+
+	f, srv = testFetcher(t, nil)
+	res, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/html"})
+	require.NoError(err)
+	require.Equal("Test Page", res.Title)
+	require.Equal("# Hello\n\nSome **bold** text.", res.Text)
+
+}
+
+func TestFetchMethodsAndHeaders(t *testing.T) {
+
+	require := require.New(t)
+
+	t.Setenv("TEST_WEB_TOKEN", "s3cret")
+	f, srv := testFetcher(t, &web.Config{
+		Methods: []string{"GET", "POST"},
+		Headers: []*web.HeaderConfig{{Name: "X-Token", Value: "tok-${TEST_WEB_TOKEN}"}},
+	})
+	res, err := f.Fetch(context.Background(), web.FetchInput{
+		Url:     srv.URL + "/echo",
+		Method:  "post",
+		Body:    "hi",
+		Headers: map[string]string{"X-Extra": "more", "X-Token": "from-llm"},
+	})
+	require.NoError(err)
+	require.Equal(map[string]any{
+		"method": "POST",
+		"token":  "tok-s3cret",
+		"extra":  "more",
+		"body":   "hi",
+	}, res.Json, "configured headers win")
+
+	_, err = f.Fetch(context.Background(), web.FetchInput{
+		Url:     srv.URL + "/echo",
+		Headers: map[string]string{"X-Bad": "a\nb"},
+	})
+	require.ErrorContains(err, `bad header "X-Bad"`)
+
+	f, srv = testFetcher(t, &web.Config{
+		Headers: []*web.HeaderConfig{{Name: "X-Token", Value: "$TEST_WEB_NOPE"}},
+	})
+	_, err = f.Fetch(context.Background(), web.FetchInput{Url: srv.URL + "/echo"})
+	require.ErrorIs(err, web.ErrHeaderEnv)
+	require.ErrorContains(err, "X-Token references unset TEST_WEB_NOPE")
+
+}
+
+func TestFetchRedirects(t *testing.T) {
+
+	require := require.New(t)
+
+	t.Setenv("TEST_WEB_TOKEN", "s3cret")
+	srv, host := testServer(t)
+	_, other := testServer(t)
+	f, err := web.New(&web.Config{
+		Allow: []string{"http://" + host + "/api/v1/", "http://" + other + "/api/v1/"},
+		Headers: []*web.HeaderConfig{{
+			Name:  "X-Token",
+			Value: "$TEST_WEB_TOKEN",
+			Allow: []string{"http://" + host + "/api/v1/"},
+		}},
+	})
+	require.NoError(err)
+	ctx := context.Background()
+
+	res, err := f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/api/v1/echo"})
+	require.NoError(err)
+	require.Equal("token=s3cret", res.Text)
+
+	res, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/api/v1/redirect?to=/api/v1/echo"})
+	require.NoError(err)
+	require.Equal("token=s3cret", res.Text)
+	require.Equal(srv.URL+"/api/v1/echo", res.Url)
+
+	res, err = f.Fetch(ctx, web.FetchInput{
+		Url: srv.URL + "/api/v1/redirect?to=http://" + other + "/api/v1/echo",
+	})
+	require.NoError(err)
+	require.Equal("token=", res.Text, "header not sent where not allowed")
+
+	_, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/api/v1/redirect?to=/json"})
+	require.ErrorIs(err, web.ErrNotAllowed)
+	require.ErrorContains(err, "redirect: not allowed: URL")
+
+	f, err = web.New(&web.Config{Allow: []string{host}, MaxRedirects: -1})
+	require.NoError(err)
+	_, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/api/v1/redirect?to=/api/v1/echo"})
+	require.ErrorIs(err, web.ErrNotAllowed)
+	require.ErrorContains(err, "more than 0 redirects")
+
+}
+
+func TestFetchTimeout(t *testing.T) {
+
+	require := require.New(t)
+
+	f, srv := testFetcher(t, &web.Config{Timeout: 50 * time.Millisecond})
+	_, err := f.Fetch(context.Background(), web.FetchInput{Url: srv.URL + "/slow"})
+	require.ErrorContains(err, "Client.Timeout exceeded")
+
+	f, srv = testFetcher(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = f.Fetch(ctx, web.FetchInput{Url: srv.URL + "/slow"})
+	require.ErrorIs(err, context.DeadlineExceeded)
+
+}
+
+func TestRegister(t *testing.T) {
+
+	require := require.New(t)
+
+	srv, host := testServer(t)
+	reg := registry.New()
+	require.NoError(web.Register(reg, &web.Config{Allow: []string{host}}))
+	require.Equal([]string{"web_http_fetch"}, reg.Names())
+
+	tool, err := reg.Get("web_http_fetch")
+	require.NoError(err)
+	require.Equal(&tools.Metadata{Tags: []string{"web"}, Risk: tools.RiskNetwork},
+		tools.MetadataOf(tool))
+	res, err := tool.Exec(context.Background(), `{"url":"`+srv.URL+`/json"}`)
+	require.NoError(err)
+	require.Equal(1.0, res.(*web.FetchResult).Json.(map[string]any)["a"])
+
+	require.ErrorIs(web.Register(registry.New(), &web.Config{}), web.ErrConfigInvalid)
+	reg.Lock()
+	require.ErrorContains(web.Register(reg, &web.Config{Allow: []string{host}}),
+		`failed to register "web_http_fetch"`)
+
+}
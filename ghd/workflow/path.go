@@ -0,0 +1,49 @@
+// workflow/path.go
+
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/biztos/greenhead/ghd/jsonpath"
+)
+
+var ErrPath = fmt.Errorf("path error")
+
+// LookupPath returns the value at path within v.
+//
+// If v is a string it is first parsed as JSON.  Other values are normalized
+// by a JSON round-trip, so that structs are handled by their JSON names.
+func LookupPath(v any, path string) (any, error) {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPath, err)
+	}
+	cur, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	cur, err = p.Lookup(cur)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPath, err)
+	}
+	return cur, nil
+}
+
+func normalize(v any) (any, error) {
+	var b []byte
+	if s, ok := v.(string); ok {
+		b = []byte(s)
+	} else {
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPath, err)
+		}
+	}
+	var n any
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, fmt.Errorf("%w: value is not JSON: %w", ErrPath, err)
+	}
+	return n, nil
+}
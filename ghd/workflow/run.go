@@ -0,0 +1,317 @@
+// workflow/run.go
+
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+)
+
+var ErrLimit = fmt.Errorf("workflow limit reached")
+
+var ErrMissingVar = fmt.Errorf("missing variable")
+
+var ErrStepFailed = fmt.Errorf("workflow step failed")
+
+// AgentFunc returns an agent ready to run for name.  It is called for every
+// run of an agent step, and should normally return a fresh agent.
+type AgentFunc func(name string) (*agent.Agent, error)
+
+// RunRecord is the structured record of a workflow run.
+type RunRecord struct {
+	ULID     ulid.ULID      `json:"ulid"`
+	Workflow string         `json:"workflow"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Duration string         `json:"duration"`
+	Vars     map[string]any `json:"vars"`   // Variables at the end of the run.
+	Output   any            `json:"output"` // Final output.
+	Steps    []*StepRecord  `json:"steps"`
+	Usage    agent.Usage    `json:"usage"` // Total usage of all agent steps.
+	Error    string         `json:"error,omitempty"`
+}
+
+// StepRecord is the record of a single run of a step.
+type StepRecord struct {
+	Id       string       `json:"id"`
+	Kind     string       `json:"kind"`
+	Run      int          `json:"run"`             // Run number of this step, starting at 1.
+	Agent    string       `json:"agent,omitempty"` // Agent identifier.
+	Tool     string       `json:"tool,omitempty"`
+	Input    string       `json:"input,omitempty"` // Rendered prompt or tool input.
+	Output   any          `json:"output,omitempty"`
+	Next     string       `json:"next"`
+	Start    time.Time    `json:"start"`
+	End      time.Time    `json:"end"`
+	Duration string       `json:"duration"`
+	Usage    *agent.Usage `json:"usage,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// Run runs the workflow described by cfg, with vars overriding the defaults
+// from cfg.  Agents are provided by agents, and tools are taken from the
+// registry carried by ctx (see registry.WithRegistry) or the default one.
+//
+// The config is validated first.  Unless that fails, the RunRecord is
+// returned even on error, for troubleshooting.
+func Run(ctx context.Context, cfg *Config, agents AgentFunc, vars map[string]string) (*RunRecord, error) {
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	rec := &RunRecord{
+		ULID:     ulid.Make(),
+		Workflow: cfg.Name,
+		Start:    time.Now(),
+		Vars:     map[string]any{},
+		Steps:    []*StepRecord{},
+	}
+	for k, v := range cfg.Vars {
+		rec.Vars[k] = v
+	}
+	for k, v := range vars {
+		rec.Vars[k] = v
+	}
+	logger := slog.Default().With("workflow", cfg.Name, "run", rec.ULID.String())
+	logger.Info("workflow start")
+
+	err := run(ctx, cfg, agents, rec, logger)
+	rec.End = time.Now()
+	rec.Duration = rec.End.Sub(rec.Start).String()
+	if err != nil {
+		rec.Error = err.Error()
+		logger.Error("workflow failed", "error", err, "duration", rec.Duration)
+		return rec, err
+	}
+	logger.Info("workflow complete", "duration", rec.Duration)
+	return rec, nil
+
+}
+
+func run(ctx context.Context, cfg *Config, agents AgentFunc, rec *RunRecord, logger *slog.Logger) error {
+
+	index := map[string]int{}
+	for i, s := range cfg.Steps {
+		index[s.Id] = i
+	}
+	runs := map[string]int{}
+	last_var := ""
+
+	for i := 0; i < len(cfg.Steps); {
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := cfg.Steps[i]
+		if len(rec.Steps) >= cfg.MaxSteps {
+			return fmt.Errorf("%w: max_steps %d", ErrLimit, cfg.MaxSteps)
+		}
+		if runs[s.Id] >= s.MaxRuns {
+			return fmt.Errorf("%w: max_runs %d for step %q", ErrLimit, s.MaxRuns, s.Id)
+		}
+		runs[s.Id]++
+
+		sr := &StepRecord{
+			Id:    s.Id,
+			Kind:  s.Kind(),
+			Run:   runs[s.Id],
+			Start: time.Now(),
+		}
+		rec.Steps = append(rec.Steps, sr)
+		logger.Info("workflow step", "step", s.Id, "kind", sr.Kind, "run", sr.Run)
+
+		err := runStep(ctx, s, sr, agents, rec.Vars)
+		if err == nil {
+			if v := s.OutputVar(); v != "" {
+				rec.Vars[v] = sr.Output
+				last_var = v
+			}
+			if sr.Usage != nil {
+				rec.Usage.Add(sr.Usage)
+			}
+			sr.Next, err = s.next(rec.Vars)
+		}
+		sr.End = time.Now()
+		sr.Duration = sr.End.Sub(sr.Start).String()
+		if err != nil {
+			sr.Error = err.Error()
+			return fmt.Errorf("%w: %q: %w", ErrStepFailed, s.Id, err)
+		}
+
+		switch sr.Next {
+		case "":
+			i++
+			if i < len(cfg.Steps) {
+				sr.Next = cfg.Steps[i].Id
+			} else {
+				sr.Next = End
+			}
+		case End:
+			i = len(cfg.Steps)
+		default:
+			i = index[sr.Next]
+		}
+	}
+
+	out_var := cfg.Output
+	if out_var == "" {
+		out_var = last_var
+	}
+	if out_var != "" {
+		out, ok := rec.Vars[out_var]
+		if !ok {
+			return fmt.Errorf("%w: output %q", ErrMissingVar, out_var)
+		}
+		rec.Output = out
+	}
+	return nil
+
+}
+
+// runStep runs the agent or tool of s, setting the input, output and usage
+// in sr.
+func runStep(ctx context.Context, s *Step, sr *StepRecord, agents AgentFunc, vars map[string]any) error {
+
+	switch s.Kind() {
+	case "agent":
+		prompt, err := render(s.prompt, vars)
+		if err != nil {
+			return err
+		}
+		sr.Input = prompt
+		a, err := agents(s.Agent)
+		if err != nil {
+			return err
+		}
+		sr.Agent = a.Ident()
+		res, err := a.RunCompletion(ctx, &agent.CompletionRequest{Content: prompt})
+		if err != nil {
+			return err
+		}
+		sr.Output = res.Content
+		sr.Usage = res.Usage
+	case "tool":
+		input, err := render(s.input, vars)
+		if err != nil {
+			return err
+		}
+		sr.Input = input
+		sr.Tool = s.Tool
+		tool, err := registry.FromContext(ctx).Get(s.Tool)
+		if err != nil {
+			return err
+		}
+		out, err := tool.Exec(ctx, input)
+		if err != nil {
+			return err
+		}
+		sr.Output = out
+	}
+	return nil
+
+}
+
+// next returns the goto of the first case met, or the default goto of s.
+func (s *Step) next(vars map[string]any) (string, error) {
+	for _, c := range s.Cases {
+		met, err := c.Met(vars)
+		if err != nil {
+			return "", err
+		}
+		if met {
+			return c.Goto, nil
+		}
+	}
+	return s.Goto, nil
+}
+
+// Met returns true if the condition of c is met for vars.  It is an error if
+// the variable is not set, or the path can not be found in it.
+func (c *Case) Met(vars map[string]any) (bool, error) {
+
+	v, ok := vars[c.Var]
+	if !ok {
+		return false, fmt.Errorf("%w: %q", ErrMissingVar, c.Var)
+	}
+	if c.Path != "" {
+		var err error
+		if v, err = LookupPath(v, c.Path); err != nil {
+			return false, err
+		}
+	}
+	s := stringify(v)
+
+	var met bool
+	switch {
+	case c.Match != nil:
+		met = c.Match.MatchString(s)
+	case c.Equals != nil:
+		met = s == *c.Equals
+	default:
+		switch strings.TrimSpace(s) {
+		case "", "false", "0", "null":
+			met = false
+		default:
+			met = true
+		}
+	}
+	if c.Not {
+		return !met, nil
+	}
+	return met, nil
+
+}
+
+// stringify returns strings as they are, nil as empty, and anything else as
+// JSON.
+func stringify(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(name, src string) (*template.Template, error) {
+	t, err := template.New(name).
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+func render(t *template.Template, vars map[string]any) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, vars); err != nil {
+		return "", fmt.Errorf("error rendering %s template: %w", t.Name(), err)
+	}
+	return buf.String(), nil
+}
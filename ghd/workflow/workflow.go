@@ -0,0 +1,252 @@
+// Package workflow defines declarative, multi-step pipelines of agents and
+// tools.
+//
+// A workflow is a list of steps run in order, each of which may run an agent
+// prompt, call a tool directly, or branch to another step.  Steps store
+// their output in named variables, which are available to the templates of
+// later steps.  Loops are made by branching back to an earlier step, and
+// are limited per step and per workflow.
+//
+// Consider this simple workflow:
+//
+//	name = "summarize"
+//	description = "Summarize a topic until it is short enough."
+//	[vars]
+//	  topic = "tigers"
+//	[[steps]]
+//	  id = "draft"
+//	  agent = "chatty"
+//	  prompt = "Write a paragraph about {{.topic}}."
+//	[[steps]]
+//	  id = "shorten"
+//	  agent = "chatty"
+//	  prompt = "Make this shorter:\n\n{{.draft}}"
+//	  output = "draft"
+//	  max_runs = 3
+//	[[steps]]
+//	  id = "check"
+//	  [[steps.cases]]
+//	    var = "draft"
+//	    match = "/^.{200,}/s"
+//	    goto = "shorten"
+package workflow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/biztos/greenhead/ghd/jsonpath"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/utils"
+)
+
+// End is the goto target that ends the workflow.
+const End = "end"
+
+// DefaultMaxSteps is the default limit on steps run in a workflow.
+var DefaultMaxSteps = 100
+
+// DefaultMaxRuns is the default limit on runs of any one step.
+var DefaultMaxRuns = 10
+
+var ErrInvalid = fmt.Errorf("invalid workflow")
+
+// Config describes a workflow, and is usually supplied in a file.
+type Config struct {
+	Name         string            `toml:"name"`          // Name of the workflow.
+	Description  string            `toml:"description"`   // Description of the workflow.
+	RunnerConfig string            `toml:"runner_config"` // Runner config file, relative to the workflow file.
+	AgentConfigs []string          `toml:"agent_configs"` // Agent names or files, relative to the workflow file.
+	Vars         map[string]string `toml:"vars"`          // Default variable values.
+	Output       string            `toml:"output"`        // Variable holding the final output; defaults to the last one set.
+	MaxSteps     int               `toml:"max_steps"`     // Max steps run in total; zero means the default.
+	Steps        []*Step           `toml:"steps"`         // Steps to run, in order.
+}
+
+// Step is a single step in a workflow.  Agent and Tool steps run the agent or
+// tool and may then branch on Cases; steps with neither only branch.
+type Step struct {
+	Id      string  `toml:"id"`       // Unique identifier of the step.
+	Agent   string  `toml:"agent"`    // Agent to run, by name.
+	Prompt  string  `toml:"prompt"`   // Prompt template for the agent.
+	Tool    string  `toml:"tool"`     // Tool to call.
+	Input   string  `toml:"input"`    // Input template for the tool, producing JSON.
+	Output  string  `toml:"output"`   // Variable to set to the output; defaults to Id.
+	Cases   []*Case `toml:"cases"`    // Conditions to branch on; first match wins.
+	Goto    string  `toml:"goto"`     // Next step if no case matches; defaults to the following step.
+	MaxRuns int     `toml:"max_runs"` // Max runs of this step; zero means the default.
+
+	prompt *template.Template
+	input  *template.Template
+}
+
+// Case is a branch condition: if the (possibly JSON) value of the variable
+// Var, or the value at Path within it, satisfies the condition then the
+// workflow continues at Goto.
+//
+// If neither Match nor Equals is set, the value must be "truthy," i.e. not
+// empty, "false", "0" or "null".
+type Case struct {
+	Var    string     `toml:"var"`    // Variable to check.
+	Path   string     `toml:"path"`   // JSON path within the variable, e.g. "$.items[0].name"; no wildcards.
+	Match  *rgxp.Rgxp `toml:"match"`  // Regexp the value must match.
+	Equals *string    `toml:"equals"` // Value the value must equal.
+	Not    bool       `toml:"not"`    // Negate the condition.
+	Goto   string     `toml:"goto"`   // Next step if the condition is met.
+}
+
+// Kind returns the kind of step: "agent", "tool" or "branch".
+func (s *Step) Kind() string {
+	if s.Agent != "" {
+		return "agent"
+	}
+	if s.Tool != "" {
+		return "tool"
+	}
+	return "branch"
+}
+
+// OutputVar returns the name of the variable set by the step, or an empty
+// string for branch steps.
+func (s *Step) OutputVar() string {
+	if s.Kind() == "branch" {
+		return ""
+	}
+	if s.Output != "" {
+		return s.Output
+	}
+	return s.Id
+}
+
+// LoadConfig loads a workflow config from file, and validates it.
+//
+// Relative RunnerConfig and AgentConfigs file paths are resolved against the
+// directory of file.  Agent names without extensions are left as they are.
+func LoadConfig(file string) (*Config, error) {
+	c := &Config{}
+	if err := utils.UnmarshalFile(file, c); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(file)
+	if c.RunnerConfig != "" && !filepath.IsAbs(c.RunnerConfig) {
+		c.RunnerConfig = filepath.Join(dir, c.RunnerConfig)
+	}
+	for i, a := range c.AgentConfigs {
+		if filepath.Ext(a) != "" && !filepath.IsAbs(a) {
+			c.AgentConfigs[i] = filepath.Join(dir, a)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %q", err, file)
+	}
+	return c, nil
+}
+
+// Validate checks the config for internal consistency, setting defaults and
+// parsing templates as needed.
+func (c *Config) Validate() error {
+
+	if len(c.Steps) == 0 {
+		return fmt.Errorf("%w: no steps", ErrInvalid)
+	}
+	if c.MaxSteps < 0 {
+		return fmt.Errorf("%w: negative max_steps", ErrInvalid)
+	}
+	if c.MaxSteps == 0 {
+		c.MaxSteps = DefaultMaxSteps
+	}
+
+	ids := map[string]bool{End: true}
+	for i, s := range c.Steps {
+		if strings.TrimSpace(s.Id) == "" {
+			return fmt.Errorf("%w: step %d has no id", ErrInvalid, i+1)
+		}
+		if ids[s.Id] {
+			return fmt.Errorf("%w: duplicate step id %q", ErrInvalid, s.Id)
+		}
+		ids[s.Id] = true
+	}
+
+	for _, s := range c.Steps {
+		if err := s.validate(ids); err != nil {
+			return fmt.Errorf("%w: step %q: %w", ErrInvalid, s.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Step) validate(ids map[string]bool) error {
+
+	if s.Agent != "" && s.Tool != "" {
+		return fmt.Errorf("agent and tool both set")
+	}
+	if s.Kind() == "branch" && len(s.Cases) == 0 {
+		return fmt.Errorf("one of agent, tool or cases required")
+	}
+	if s.MaxRuns < 0 {
+		return fmt.Errorf("negative max_runs")
+	}
+	if s.MaxRuns == 0 {
+		s.MaxRuns = DefaultMaxRuns
+	}
+	if s.Goto != "" && !ids[s.Goto] {
+		return fmt.Errorf("unknown goto %q", s.Goto)
+	}
+
+	var err error
+	switch s.Kind() {
+	case "agent":
+		if strings.TrimSpace(s.Prompt) == "" {
+			return fmt.Errorf("agent step requires prompt")
+		}
+		if s.Input != "" {
+			return fmt.Errorf("input is only for tool steps")
+		}
+		if s.prompt, err = parseTemplate("prompt", s.Prompt); err != nil {
+			return err
+		}
+	case "tool":
+		if s.Prompt != "" {
+			return fmt.Errorf("prompt is only for agent steps")
+		}
+		if s.Input == "" {
+			s.Input = "{}"
+		}
+		if s.input, err = parseTemplate("input", s.Input); err != nil {
+			return err
+		}
+	case "branch":
+		if s.Prompt != "" || s.Input != "" || s.Output != "" {
+			return fmt.Errorf("branch step can not have prompt, input or output")
+		}
+	}
+
+	for i, cs := range s.Cases {
+		if cs.Var == "" {
+			return fmt.Errorf("case %d has no var", i+1)
+		}
+		if cs.Goto == "" {
+			return fmt.Errorf("case %d has no goto", i+1)
+		}
+		if !ids[cs.Goto] {
+			return fmt.Errorf("case %d has unknown goto %q", i+1, cs.Goto)
+		}
+		if cs.Match != nil && cs.Equals != nil {
+			return fmt.Errorf("case %d has both match and equals", i+1)
+		}
+		if cs.Path != "" {
+			p, err := jsonpath.Parse(cs.Path)
+			if err != nil {
+				return fmt.Errorf("case %d: %w", i+1, err)
+			}
+			if !p.Single() {
+				return fmt.Errorf("case %d: path %q has a wildcard", i+1, cs.Path)
+			}
+		}
+	}
+
+	return nil
+}
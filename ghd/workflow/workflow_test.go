@@ -0,0 +1,364 @@
+package workflow_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/biztos/greenhead/ghd/agent"
+	"github.com/biztos/greenhead/ghd/registry"
+	"github.com/biztos/greenhead/ghd/rgxp"
+	"github.com/biztos/greenhead/ghd/tools"
+	"github.com/biztos/greenhead/ghd/workflow"
+)
+
+// echoClient responds with the prompt, prefixed.
+type echoClient struct {
+	agent.BasicApiClient
+}
+
+func (c *echoClient) RunCompletion(ctx context.Context, req *agent.CompletionRequest) (*agent.CompletionResponse, error) {
+	return &agent.CompletionResponse{
+		Content: "echo: " + req.Content,
+		Usage:   &agent.Usage{Total: 5},
+	}, nil
+}
+
+func init() {
+	agent.RegisterNewApiClientFunc("fake-echo", func() (agent.ApiClient, error) {
+		return &echoClient{}, nil
+	})
+}
+
+func echoAgents(name string) (*agent.Agent, error) {
+	if name != "echo" {
+		return nil, fmt.Errorf("no agent %q", name)
+	}
+	return agent.NewAgent(&agent.Config{
+		Name:   name,
+		Type:   "fake-echo",
+		Silent: true,
+	})
+}
+
+type AddInput struct {
+	N int `json:"n"`
+}
+
+func registerTestTools() {
+	registry.Clear()
+	for _, tool := range []tools.Tooler{
+		tools.NewTool[AddInput, int]("add_one", "Add one.",
+			func(ctx context.Context, in AddInput) (int, error) {
+				return in.N + 1, nil
+			}),
+		tools.NewTool[AddInput, map[string]any]("nested", "Nest.",
+			func(ctx context.Context, in AddInput) (map[string]any, error) {
+				return map[string]any{"items": []any{map[string]any{"n": in.N}}}, nil
+			}),
+	} {
+		if err := registry.Register(tool); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestValidateErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		steps []*workflow.Step
+		exp   string
+	}{
+		{nil, "no steps"},
+		{[]*workflow.Step{{Agent: "x", Prompt: "p"}}, "step 1 has no id"},
+		{[]*workflow.Step{{Id: "a", Agent: "x", Prompt: "p"}, {Id: "a", Tool: "t"}},
+			`duplicate step id "a"`},
+		{[]*workflow.Step{{Id: "a"}}, "one of agent, tool or cases required"},
+		{[]*workflow.Step{{Id: "a", Agent: "x", Tool: "t"}}, "agent and tool both set"},
+		{[]*workflow.Step{{Id: "a", Agent: "x"}}, "agent step requires prompt"},
+		{[]*workflow.Step{{Id: "a", Agent: "x", Prompt: "{{"}}, "error parsing prompt template"},
+		{[]*workflow.Step{{Id: "a", Tool: "t", Prompt: "p"}}, "prompt is only for agent steps"},
+		{[]*workflow.Step{{Id: "a", Tool: "t", Goto: "nope"}}, `unknown goto "nope"`},
+		{[]*workflow.Step{{Id: "a", Tool: "t", MaxRuns: -1}}, "negative max_runs"},
+		{[]*workflow.Step{{Id: "a", Cases: []*workflow.Case{{Goto: "a"}}}}, "case 1 has no var"},
+		{[]*workflow.Step{{Id: "a", Cases: []*workflow.Case{{Var: "v"}}}}, "case 1 has no goto"},
+		{[]*workflow.Step{{Id: "a", Cases: []*workflow.Case{{Var: "v", Goto: "b"}}}},
+			`case 1 has unknown goto "b"`},
+		{[]*workflow.Step{{Id: "a", Cases: []*workflow.Case{{Var: "v", Goto: "end", Path: "$.["}}}},
+			"invalid JSON path"},
+		{[]*workflow.Step{{Id: "a", Cases: []*workflow.Case{{Var: "v", Goto: "end", Path: "$.a[*]"}}}},
+			`case 1: path "$.a[*]" has a wildcard`},
+		{[]*workflow.Step{{Id: "a", Output: "x", Cases: []*workflow.Case{{Var: "v", Goto: "end"}}}},
+			"branch step can not have prompt, input or output"},
+	} {
+		cfg := &workflow.Config{Steps: tc.steps}
+		err := cfg.Validate()
+		require.ErrorIs(err, workflow.ErrInvalid, tc.exp)
+		require.ErrorContains(err, tc.exp)
+	}
+
+}
+
+func TestLoadConfigOK(t *testing.T) {
+
+	require := require.New(t)
+
+	cfg, err := workflow.LoadConfig(filepath.Join("testdata", "simple.toml"))
+	require.NoError(err)
+	require.Equal("simple", cfg.Name)
+	require.Equal(filepath.Join("testdata", "runner.toml"), cfg.RunnerConfig)
+	require.Equal([]string{"chatty", filepath.Join("testdata", "agents", "helper.toml")},
+		cfg.AgentConfigs)
+	require.Equal(workflow.DefaultMaxSteps, cfg.MaxSteps)
+	require.Len(cfg.Steps, 2)
+	require.Equal("agent", cfg.Steps[0].Kind())
+	require.Equal("branch", cfg.Steps[1].Kind())
+	require.Equal(workflow.DefaultMaxRuns, cfg.Steps[1].MaxRuns)
+
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	_, err := workflow.LoadConfig(filepath.Join("testdata", "nonesuch.toml"))
+	require.ErrorContains(err, "error reading file")
+
+	_, err = workflow.LoadConfig(filepath.Join("..", "testdata", "config-host.toml"))
+	require.ErrorIs(err, workflow.ErrInvalid)
+
+}
+
+func TestLookupPath(t *testing.T) {
+
+	require := require.New(t)
+
+	v := `{"a":{"b":[{"c":1},{"c":"two"}]},"x.y":true}`
+	for path, exp := range map[string]any{
+		"$.a.b[0].c":      float64(1),
+		"a.b[1].c":        "two",
+		"$.a.b[1]":        map[string]any{"c": "two"},
+		`$["x.y"]`:        true,
+		"$":               map[string]any{"a": map[string]any{"b": []any{map[string]any{"c": float64(1)}, map[string]any{"c": "two"}}}, "x.y": true},
+		"$[\"a\"].b[0].c": float64(1),
+		"$['x.y']":        true,
+	} {
+		got, err := workflow.LookupPath(v, path)
+		require.NoError(err, path)
+		require.Equal(exp, got, path)
+	}
+
+	got, err := workflow.LookupPath(struct {
+		Name string `json:"name"`
+	}{"me"}, "name")
+	require.NoError(err)
+	require.Equal("me", got, "struct by json name")
+
+	for path, exp := range map[string]string{
+		"$.a.b.c":      `key "c" on array`,
+		"$.a[0]":       "index 0 on object",
+		"$.a.b[9]":     "index 9 out of range",
+		"$.nope":       `no key "nope"`,
+		"$.a.b[0].c.d": "can not descend into float64",
+		"$..a":         "invalid JSON path",
+	} {
+		_, err := workflow.LookupPath(v, path)
+		require.ErrorIs(err, workflow.ErrPath, path)
+		require.ErrorContains(err, exp, path)
+	}
+
+	_, err = workflow.LookupPath("not json", "$.a")
+	require.ErrorContains(err, "value is not JSON")
+
+}
+
+func TestCaseMet(t *testing.T) {
+
+	require := require.New(t)
+
+	vars := map[string]any{
+		"s":    "Hello World",
+		"n":    3,
+		"z":    "0",
+		"json": `{"ok":true,"name":"bob"}`,
+	}
+	for _, tc := range []struct {
+		c   *workflow.Case
+		exp bool
+	}{
+		{&workflow.Case{Var: "s"}, true},
+		{&workflow.Case{Var: "z"}, false},
+		{&workflow.Case{Var: "z", Not: true}, true},
+		{&workflow.Case{Var: "s", Match: rgxp.MustParse("/world/i")}, true},
+		{&workflow.Case{Var: "s", Match: rgxp.MustParse("/world/")}, false},
+		{&workflow.Case{Var: "n", Equals: strPtr("3")}, true},
+		{&workflow.Case{Var: "json", Path: "$.ok"}, true},
+		{&workflow.Case{Var: "json", Path: "$.name", Equals: strPtr("bob")}, true},
+		{&workflow.Case{Var: "json", Path: "$.name", Equals: strPtr("bob"), Not: true}, false},
+	} {
+		met, err := tc.c.Met(vars)
+		require.NoError(err)
+		require.Equal(tc.exp, met, "%+v", tc.c)
+	}
+
+	_, err := (&workflow.Case{Var: "nope"}).Met(vars)
+	require.ErrorIs(err, workflow.ErrMissingVar)
+	_, err = (&workflow.Case{Var: "s", Path: "$.a"}).Met(vars)
+	require.ErrorIs(err, workflow.ErrPath)
+
+}
+
+func TestRunLoopOK(t *testing.T) {
+
+	require := require.New(t)
+
+	registerTestTools()
+	defer registry.Clear()
+
+	cfg := &workflow.Config{
+		Name: "loop",
+		Vars: map[string]string{"n": "0", "label": "Total"},
+		Steps: []*workflow.Step{
+			{Id: "add", Tool: "add_one", Input: `{"n": {{.n}}}`, Output: "n"},
+			{Id: "check", Cases: []*workflow.Case{
+				{Var: "n", Equals: strPtr("3"), Not: true, Goto: "add"},
+			}},
+			{Id: "done", Agent: "echo", Prompt: "{{.label}}: {{.n}}"},
+		},
+	}
+	rec, err := workflow.Run(context.Background(), cfg, echoAgents,
+		map[string]string{"label": "Sum"})
+	require.NoError(err)
+	require.Equal("echo: Sum: 3", rec.Output)
+	require.Equal(3, rec.Vars["n"])
+	require.Len(rec.Steps, 7)
+	ids := []string{}
+	for _, s := range rec.Steps {
+		ids = append(ids, s.Id+">"+s.Next)
+	}
+	require.Equal([]string{
+		"add>check", "check>add", "add>check", "check>add",
+		"add>check", "check>done", "done>end"}, ids)
+	require.Equal(3, rec.Steps[4].Run)
+	require.Equal(`{"n": 2}`, rec.Steps[4].Input)
+	require.True(strings.HasSuffix(rec.Steps[6].Agent, ":fake-echo:echo"))
+	require.Equal(5, rec.Usage.Total)
+	require.Empty(rec.Error)
+
+}
+
+func TestRunBranchOnPathOK(t *testing.T) {
+
+	require := require.New(t)
+
+	registerTestTools()
+	defer registry.Clear()
+
+	cfg := &workflow.Config{
+		Output: "nested",
+		Steps: []*workflow.Step{
+			{Id: "nested", Tool: "nested", Input: `{"n": 7}`,
+				Cases: []*workflow.Case{
+					{Var: "nested", Path: "$.items[0].n", Equals: strPtr("7"), Goto: "end"},
+				}},
+			{Id: "never", Agent: "echo", Prompt: "nope"},
+		},
+	}
+	rec, err := workflow.Run(context.Background(), cfg, echoAgents, nil)
+	require.NoError(err)
+	require.Len(rec.Steps, 1)
+	require.Equal(map[string]any{"items": []any{map[string]any{"n": 7}}}, rec.Output)
+
+}
+
+func TestRunLimits(t *testing.T) {
+
+	require := require.New(t)
+
+	registerTestTools()
+	defer registry.Clear()
+
+	loop := func() []*workflow.Step {
+		return []*workflow.Step{
+			{Id: "add", Tool: "add_one", Input: `{"n": 1}`, Goto: "add"},
+		}
+	}
+
+	cfg := &workflow.Config{Steps: loop()}
+	rec, err := workflow.Run(context.Background(), cfg, echoAgents, nil)
+	require.ErrorIs(err, workflow.ErrLimit)
+	require.ErrorContains(err, `max_runs 10 for step "add"`)
+	require.Len(rec.Steps, 10)
+	require.NotEmpty(rec.Error)
+
+	cfg = &workflow.Config{Steps: loop(), MaxSteps: 4}
+	cfg.Steps[0].MaxRuns = 99
+	_, err = workflow.Run(context.Background(), cfg, echoAgents, nil)
+	require.ErrorContains(err, "max_steps 4")
+
+}
+
+func TestRunStepErrors(t *testing.T) {
+
+	require := require.New(t)
+
+	registerTestTools()
+	defer registry.Clear()
+
+	for _, tc := range []struct {
+		step *workflow.Step
+		exp  string
+	}{
+		{&workflow.Step{Id: "a", Agent: "echo", Prompt: "{{.nope}}"}, "error rendering prompt template"},
+		{&workflow.Step{Id: "a", Tool: "add_one", Input: "{{.nope}}"}, "error rendering input template"},
+		{&workflow.Step{Id: "a", Agent: "other", Prompt: "hi"}, `no agent "other"`},
+		{&workflow.Step{Id: "a", Tool: "nonesuch"}, "nonesuch"},
+		{&workflow.Step{Id: "a", Tool: "add_one", Input: "["}, "tool input invalid"},
+		{&workflow.Step{Id: "a", Tool: "add_one", Input: `{"n":1}`, Cases: []*workflow.Case{{Var: "b", Goto: "end"}}},
+			"missing variable"},
+	} {
+		cfg := &workflow.Config{Steps: []*workflow.Step{tc.step}}
+		rec, err := workflow.Run(context.Background(), cfg, echoAgents, nil)
+		require.ErrorIs(err, workflow.ErrStepFailed, tc.exp)
+		require.ErrorContains(err, tc.exp)
+		require.NotEmpty(rec.Steps[0].Error, tc.exp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := workflow.Run(ctx, &workflow.Config{Steps: []*workflow.Step{{Id: "a", Tool: "add_one"}}},
+		echoAgents, nil)
+	require.ErrorIs(err, context.Canceled)
+
+}
+
+func TestRunToolFromContextRegistry(t *testing.T) {
+
+	require := require.New(t)
+
+	registry.Clear()
+	defer registry.Clear()
+
+	reg := registry.New()
+	require.NoError(reg.Register(tools.NewTool[AddInput, int]("add_two", "Add two.",
+		func(ctx context.Context, in AddInput) (int, error) {
+			return in.N + 2, nil
+		})))
+	cfg := &workflow.Config{Steps: []*workflow.Step{{Id: "a", Tool: "add_two", Input: `{"n":1}`}}}
+
+	_, err := workflow.Run(context.Background(), cfg, echoAgents, nil)
+	require.ErrorIs(err, registry.ErrNotRegistered)
+
+	rec, err := workflow.Run(registry.WithRegistry(context.Background(), reg), cfg, echoAgents, nil)
+	require.NoError(err)
+	require.Equal(3, rec.Output)
+
+}